@@ -0,0 +1,302 @@
+package taskq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// AlertKind is the kind of the threshold that is breached.
+type AlertKind string
+
+const (
+	// AlertQueueDepth is raised when the queue has more than AlertRule.MaxLen messages.
+	AlertQueueDepth AlertKind = "queue_depth"
+	// AlertFailureRate is raised when the share of failed attempts during
+	// the check interval is more than AlertRule.MaxFailureRate.
+	AlertFailureRate AlertKind = "failure_rate"
+	// AlertConsumerDown is raised when the consumer doesn't process messages
+	// for AlertRule.MaxIdle while there are messages in the queue.
+	AlertConsumerDown AlertKind = "consumer_down"
+)
+
+// AlertRule configures the thresholds of queues. Zero thresholds are disabled.
+type AlertRule struct {
+	// Queue name. Empty matches all queues.
+	Queue string
+	// Maximum number of messages in the queue.
+	MaxLen int
+	// Maximum share of attempts that returned an error, from 0 to 1.
+	MaxFailureRate float64
+	// Minimum number of attempts during the check interval
+	// to check the failure rate. Default is 10.
+	MinAttempts uint64
+	// Maximum time the consumer doesn't process messages while
+	// there are messages in the queue.
+	MaxIdle time.Duration
+}
+
+// Alert describes a breached threshold. Handlers are called once when
+// the threshold is breached and once more with Resolved set when
+// the value is back within the threshold.
+type Alert struct {
+	Kind      AlertKind `json:"kind"`
+	Queue     string    `json:"queue"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Resolved  bool      `json:"resolved"`
+	Time      time.Time `json:"time"`
+}
+
+func (a *Alert) String() string {
+	if a.Resolved {
+		return fmt.Sprintf("taskq: resolved %s on queue=%q (value=%g threshold=%g)",
+			a.Kind, a.Queue, a.Value, a.Threshold)
+	}
+	return fmt.Sprintf("taskq: %s on queue=%q (value=%g threshold=%g)",
+		a.Kind, a.Queue, a.Value, a.Threshold)
+}
+
+// AlertHandler is notified about alerts.
+type AlertHandler interface {
+	HandleAlert(ctx context.Context, alert *Alert) error
+}
+
+// AlertHandlerFunc adapts a function to AlertHandler.
+type AlertHandlerFunc func(ctx context.Context, alert *Alert) error
+
+func (fn AlertHandlerFunc) HandleAlert(ctx context.Context, alert *Alert) error {
+	return fn(ctx, alert)
+}
+
+// AlerterOptions configures NewAlerter.
+type AlerterOptions struct {
+	// Factory with the watched queues.
+	Factory Factory
+	Rules   []AlertRule
+	// Handlers that are notified about alerts.
+	Handlers []AlertHandler
+	// Interval at which the thresholds are checked. Default is 30 seconds.
+	Interval time.Duration
+}
+
+func (opt *AlerterOptions) init() {
+	if opt.Interval == 0 {
+		opt.Interval = 30 * time.Second
+	}
+	for i := range opt.Rules {
+		if opt.Rules[i].MinAttempts == 0 {
+			opt.Rules[i].MinAttempts = 10
+		}
+	}
+}
+
+// Alerter watches queue depth, failure rate, and consumer liveness of
+// the queues registered in a factory and notifies handlers when
+// the thresholds of the rules are breached.
+type Alerter struct {
+	opt *AlerterOptions
+
+	mu     sync.Mutex
+	queues map[string]*alertState
+}
+
+type alertState struct {
+	prev       ConsumerStats
+	lastActive time.Time
+	firing     map[AlertKind]bool
+}
+
+func NewAlerter(opt *AlerterOptions) *Alerter {
+	if opt.Factory == nil {
+		panic("taskq: AlerterOptions.Factory is required")
+	}
+	opt.init()
+	return &Alerter{
+		opt:    opt,
+		queues: make(map[string]*alertState),
+	}
+}
+
+// Run checks the thresholds every Interval until the context is canceled.
+func (a *Alerter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.Check(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Check checks the thresholds once and returns the alerts
+// passed to the handlers.
+func (a *Alerter) Check(ctx context.Context) []*Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	var alerts []*Alert
+	for _, qs := range a.opt.Factory.Stats().Queues {
+		state, ok := a.queues[qs.Name]
+		if !ok {
+			state = &alertState{
+				prev:       qs.Consumer,
+				lastActive: now,
+				firing:     make(map[AlertKind]bool),
+			}
+			a.queues[qs.Name] = state
+		}
+
+		delta := qs.Consumer.Delta(&state.prev)
+		state.prev = qs.Consumer
+		attempts := delta.Processed + delta.Retries + delta.Fails
+		pending := qs.Len > 0 || qs.Consumer.Buffered > 0 || qs.Consumer.InFlight > 0
+		if attempts > 0 || !pending {
+			state.lastActive = now
+		}
+
+		for i := range a.opt.Rules {
+			rule := &a.opt.Rules[i]
+			if rule.Queue != "" && rule.Queue != qs.Name {
+				continue
+			}
+
+			if rule.MaxLen > 0 && qs.Len >= 0 {
+				alerts = state.update(alerts, AlertQueueDepth, qs.Name, now,
+					float64(qs.Len), float64(rule.MaxLen), qs.Len > rule.MaxLen)
+			}
+			if rule.MaxFailureRate > 0 && attempts >= rule.MinAttempts {
+				rate := float64(delta.Retries+delta.Fails) / float64(attempts)
+				alerts = state.update(alerts, AlertFailureRate, qs.Name, now,
+					rate, rule.MaxFailureRate, rate > rule.MaxFailureRate)
+			}
+			if rule.MaxIdle > 0 {
+				idle := now.Sub(state.lastActive)
+				alerts = state.update(alerts, AlertConsumerDown, qs.Name, now,
+					idle.Seconds(), rule.MaxIdle.Seconds(), idle > rule.MaxIdle)
+			}
+		}
+	}
+
+	for _, alert := range alerts {
+		for _, h := range a.opt.Handlers {
+			if err := h.HandleAlert(ctx, alert); err != nil {
+				internal.Logger.Printf("taskq: HandleAlert failed: %s", err)
+			}
+		}
+	}
+	return alerts
+}
+
+// update appends an alert when the threshold is breached or resolved.
+func (s *alertState) update(
+	alerts []*Alert, kind AlertKind, queue string, now time.Time,
+	value, threshold float64, breached bool,
+) []*Alert {
+	if breached == s.firing[kind] {
+		return alerts
+	}
+	s.firing[kind] = breached
+	return append(alerts, &Alert{
+		Kind:      kind,
+		Queue:     queue,
+		Value:     value,
+		Threshold: threshold,
+		Resolved:  !breached,
+		Time:      now,
+	})
+}
+
+//------------------------------------------------------------------------------
+
+// WebhookAlertHandler posts alerts as JSON to the URL. Body is called
+// to build the body; the default posts the Alert.
+type WebhookAlertHandler struct {
+	URL    string
+	Client *http.Client
+	Body   func(alert *Alert) interface{}
+}
+
+var _ AlertHandler = (*WebhookAlertHandler)(nil)
+
+// NewSlackAlertHandler returns a handler that posts alerts
+// to the Slack incoming webhook URL.
+func NewSlackAlertHandler(url string) *WebhookAlertHandler {
+	return &WebhookAlertHandler{
+		URL: url,
+		Body: func(alert *Alert) interface{} {
+			return map[string]string{"text": alert.String()}
+		},
+	}
+}
+
+// NewPagerDutyAlertHandler returns a handler that triggers and resolves
+// PagerDuty incidents using the Events API v2 integration key.
+func NewPagerDutyAlertHandler(routingKey string) *WebhookAlertHandler {
+	return &WebhookAlertHandler{
+		URL: "https://events.pagerduty.com/v2/enqueue",
+		Body: func(alert *Alert) interface{} {
+			action := "trigger"
+			if alert.Resolved {
+				action = "resolve"
+			}
+			return map[string]interface{}{
+				"routing_key":  routingKey,
+				"event_action": action,
+				"dedup_key":    "taskq:" + alert.Queue + ":" + string(alert.Kind),
+				"payload": map[string]interface{}{
+					"summary":        alert.String(),
+					"source":         alert.Queue,
+					"severity":       "error",
+					"custom_details": alert,
+				},
+			}
+		},
+	}
+}
+
+func (h *WebhookAlertHandler) HandleAlert(ctx context.Context, alert *Alert) error {
+	var body interface{} = alert
+	if h.Body != nil {
+		body = h.Body(alert)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("taskq: alert webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package taskq_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestAlerter(t *testing.T) {
+	ctx := context.Background()
+
+	factory := newTestFactory(t)
+
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+	blocking := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "blocking",
+		Handler: func() {
+			<-release
+		},
+	})
+	failing := factory.RegisterTask(&taskq.TaskOptions{
+		Name:       "failing",
+		RetryLimit: 1,
+		Handler: func() error {
+			return errors.New("failed")
+		},
+	})
+
+	slow := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:         "alert-slow",
+		MinNumWorker: 1,
+		MaxNumWorker: 1,
+	})
+	flaky := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "alert-flaky",
+	})
+
+	var notified []string
+	alerter := taskq.NewAlerter(&taskq.AlerterOptions{
+		Factory: factory,
+		Rules: []taskq.AlertRule{
+			{Queue: "alert-slow", MaxLen: 2, MaxIdle: 50 * time.Millisecond},
+			{Queue: "alert-flaky", MaxFailureRate: 0.5},
+		},
+		Handlers: []taskq.AlertHandler{
+			taskq.AlertHandlerFunc(func(ctx context.Context, alert *taskq.Alert) error {
+				notified = append(notified, alert.String())
+				return nil
+			}),
+		},
+	})
+
+	type key struct {
+		kind     taskq.AlertKind
+		queue    string
+		resolved bool
+	}
+	expectAlerts := func(want ...key) {
+		t.Helper()
+		// Queues are checked in no particular order.
+		got := make(map[key]bool)
+		for _, a := range alerter.Check(ctx) {
+			got[key{a.Kind, a.Queue, a.Resolved}] = true
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, wanted %v", got, want)
+		}
+		for _, k := range want {
+			if !got[k] {
+				t.Fatalf("got %v, wanted %v", got, want)
+			}
+		}
+	}
+
+	expectAlerts()
+
+	for i := 0; i < 5; i++ {
+		if err := slow.Add(blocking.WithArgs(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := flaky.Add(failing.WithArgs(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		return flaky.Consumer().Stats().Fails == 10
+	})
+
+	time.Sleep(60 * time.Millisecond)
+	expectAlerts(
+		key{taskq.AlertQueueDepth, "alert-slow", false},
+		key{taskq.AlertConsumerDown, "alert-slow", false},
+		key{taskq.AlertFailureRate, "alert-flaky", false},
+	)
+	// Alerts are not repeated while firing.
+	time.Sleep(60 * time.Millisecond)
+	expectAlerts()
+
+	unblock()
+	waitFor(t, func() bool {
+		return slow.Consumer().Stats().Processed == 5
+	})
+	expectAlerts(
+		key{taskq.AlertQueueDepth, "alert-slow", true},
+		key{taskq.AlertConsumerDown, "alert-slow", true},
+	)
+
+	if len(notified) != 5 {
+		t.Fatalf("got %d notifications, wanted 5", len(notified))
+	}
+}
+
+func TestSlackAlertHandler(t *testing.T) {
+	var body map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	h := taskq.NewSlackAlertHandler(srv.URL)
+	err := h.HandleAlert(context.Background(), &taskq.Alert{
+		Kind:      taskq.AlertQueueDepth,
+		Queue:     "q",
+		Value:     10,
+		Threshold: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body["text"], `queue_depth on queue="q"`) {
+		t.Fatalf("got %q", body["text"])
+	}
+}
@@ -0,0 +1,269 @@
+package taskq
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// AuditIDHeader is the message header that carries the id used by
+// the delivery audit. The id is assigned when the message is added.
+const AuditIDHeader = "taskq-audit-id"
+
+// AuditStorage is implemented by storages that can record message ids
+// for the delivery audit. Both built-in storages implement it.
+type AuditStorage interface {
+	// AuditEnqueued records that the message was added to the queue.
+	AuditEnqueued(ctx context.Context, queue string, rec AuditRecord) error
+	// AuditFinished records that the message reached a terminal state,
+	// i.e. it was processed or failed permanently.
+	AuditFinished(ctx context.Context, queue, id string) error
+	// AuditPending returns messages enqueued before the time
+	// that haven't reached a terminal state.
+	AuditPending(ctx context.Context, queue string, before time.Time) ([]AuditRecord, error)
+	// AuditLookup returns the record of the message that hasn't reached
+	// a terminal state or nil if there is no such message.
+	AuditLookup(ctx context.Context, queue, id string) (*AuditRecord, error)
+}
+
+// AuditRecord describes an audited message.
+type AuditRecord struct {
+	ID         string
+	TaskName   string
+	EnqueuedAt time.Time
+}
+
+// AuditReport lists messages that were added to the queue
+// but didn't reach a terminal state within QueueOptions.AuditTTL.
+type AuditReport struct {
+	Queue string
+	Lost  []AuditRecord
+}
+
+// Reconcile returns the delivery audit report of the queue.
+// Lost messages are reported until they reach a terminal state.
+func Reconcile(ctx context.Context, q Queue) (*AuditReport, error) {
+	opt := q.Options()
+	storage, err := auditStorage(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	lost, err := storage.AuditPending(ctx, AuditQueueName(q), time.Now().Add(-opt.AuditTTL))
+	if err != nil {
+		return nil, err
+	}
+	return &AuditReport{
+		Queue: q.Name(),
+		Lost:  lost,
+	}, nil
+}
+
+// AuditQueueName returns the name the queue is audited under.
+func AuditQueueName(q Queue) string {
+	return internal.Namespaced(q.Options().Namespace, ":", q.Name())
+}
+
+func auditStorage(opt *QueueOptions) (AuditStorage, error) {
+	if !opt.Audit {
+		return nil, fmt.Errorf("taskq: audit is disabled for queue=%q", opt.Name)
+	}
+	storage, ok := opt.Storage.(AuditStorage)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not implement AuditStorage",
+			ErrNotSupported, opt.Storage)
+	}
+	return storage, nil
+}
+
+func (c *Consumer) auditFinished(msg *Message) {
+	if !c.opt.Audit {
+		return
+	}
+	id := msg.Headers[AuditIDHeader]
+	if id == "" {
+		return
+	}
+	storage, err := auditStorage(c.opt)
+	if err != nil {
+		internal.Logger.Print(err)
+		return
+	}
+	if err := storage.AuditFinished(msgContext(msg), AuditQueueName(c.q), id); err != nil {
+		internal.Logger.Printf("taskq: AuditFinished failed: %s", err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var _ AuditStorage = (*localStorage)(nil)
+var _ AuditStorage = (*redisStorage)(nil)
+
+type localAudit struct {
+	mu     sync.Mutex
+	queues map[string]map[string]AuditRecord
+}
+
+func (s *localStorage) AuditEnqueued(_ context.Context, queue string, rec AuditRecord) error {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+
+	if s.audit.queues == nil {
+		s.audit.queues = make(map[string]map[string]AuditRecord)
+	}
+	m, ok := s.audit.queues[queue]
+	if !ok {
+		m = make(map[string]AuditRecord)
+		s.audit.queues[queue] = m
+	}
+	m[rec.ID] = rec
+	return nil
+}
+
+func (s *localStorage) AuditFinished(_ context.Context, queue, id string) error {
+	s.audit.mu.Lock()
+	delete(s.audit.queues[queue], id)
+	s.audit.mu.Unlock()
+	return nil
+}
+
+func (s *localStorage) AuditPending(
+	_ context.Context, queue string, before time.Time,
+) ([]AuditRecord, error) {
+	s.audit.mu.Lock()
+	var recs []AuditRecord
+	for _, rec := range s.audit.queues[queue] {
+		if rec.EnqueuedAt.Before(before) {
+			recs = append(recs, rec)
+		}
+	}
+	s.audit.mu.Unlock()
+
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].EnqueuedAt.Before(recs[j].EnqueuedAt)
+	})
+	return recs, nil
+}
+
+func (s *localStorage) AuditLookup(_ context.Context, queue, id string) (*AuditRecord, error) {
+	s.audit.mu.Lock()
+	rec, ok := s.audit.queues[queue][id]
+	s.audit.mu.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+// Audited ids are kept in a sorted set scored by the enqueue time in
+// milliseconds and task names in a hash.
+func auditKeys(queue string) (string, string) {
+	return "taskq:{" + queue + "}:audit", "taskq:{" + queue + "}:audit-tasks"
+}
+
+func (s *redisStorage) AuditEnqueued(ctx context.Context, queue string, rec AuditRecord) error {
+	zkey, hkey := auditKeys(queue)
+	_, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, zkey, &redis.Z{
+			Score:  float64(rec.EnqueuedAt.UnixNano() / int64(time.Millisecond)),
+			Member: rec.ID,
+		})
+		pipe.HSet(ctx, hkey, rec.ID, rec.TaskName)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) AuditFinished(ctx context.Context, queue, id string) error {
+	zkey, hkey := auditKeys(queue)
+	_, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, zkey, id)
+		pipe.HDel(ctx, hkey, id)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) AuditPending(
+	ctx context.Context, queue string, before time.Time,
+) ([]AuditRecord, error) {
+	zkey, hkey := auditKeys(queue)
+
+	var zcmd *redis.ZSliceCmd
+	_, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		zcmd = pipe.ZRangeByScoreWithScores(ctx, zkey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: "(" + strconv.FormatInt(before.UnixNano()/int64(time.Millisecond), 10),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zs := zcmd.Val()
+	if len(zs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(zs))
+	for i, z := range zs {
+		ids[i], _ = z.Member.(string)
+	}
+
+	var hcmd *redis.SliceCmd
+	_, err = s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		hcmd = pipe.HMGet(ctx, hkey, ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := hcmd.Val()
+
+	recs := make([]AuditRecord, len(zs))
+	for i, z := range zs {
+		recs[i].ID = ids[i]
+		recs[i].EnqueuedAt = time.Unix(0, int64(z.Score)*int64(time.Millisecond))
+		if i < len(names) {
+			recs[i].TaskName, _ = names[i].(string)
+		}
+	}
+	return recs, nil
+}
+
+func (s *redisStorage) AuditLookup(ctx context.Context, queue, id string) (*AuditRecord, error) {
+	zkey, hkey := auditKeys(queue)
+
+	var zcmd *redis.FloatCmd
+	var hcmd *redis.StringCmd
+	_, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		zcmd = pipe.ZScore(ctx, zkey, id)
+		hcmd = pipe.HGet(ctx, hkey, id)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	score, err := zcmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &AuditRecord{
+		ID:         id,
+		TaskName:   hcmd.Val(),
+		EnqueuedAt: time.Unix(0, int64(score)*int64(time.Millisecond)),
+	}, nil
+}
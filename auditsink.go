@@ -0,0 +1,162 @@
+package taskq
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// AuditEventType is the type of a message lifecycle event.
+type AuditEventType string
+
+const (
+	// AuditEventEnqueue is written when the message is added to the queue.
+	AuditEventEnqueue AuditEventType = "enqueue"
+	// AuditEventStart is written before the message is passed to the handler.
+	AuditEventStart AuditEventType = "start"
+	// AuditEventRetry is written when the failed message is retried.
+	AuditEventRetry AuditEventType = "retry"
+	// AuditEventDeadLetter is written when the message fails permanently
+	// and is passed to the fallback handler.
+	AuditEventDeadLetter AuditEventType = "dead_letter"
+	// AuditEventDelete is written when the processed message is deleted.
+	AuditEventDelete AuditEventType = "delete"
+)
+
+// AuditEvent is a structured record of a message lifecycle event.
+type AuditEvent struct {
+	Type  AuditEventType `json:"type"`
+	Time  time.Time      `json:"time"`
+	Queue string         `json:"queue"`
+	Task  string         `json:"task"`
+	// Value of AuditIDHeader that identifies the message across events.
+	AuditID string `json:"audit_id,omitempty"`
+	// Backend message id. It is not known yet when some backends write
+	// the enqueue event.
+	MessageID     string        `json:"message_id,omitempty"`
+	ReservedCount int           `json:"reserved_count,omitempty"`
+	Delay         time.Duration `json:"delay,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// NewAuditEvent returns the event of the message in the queue.
+func NewAuditEvent(typ AuditEventType, q Queue, msg *Message) *AuditEvent {
+	evt := &AuditEvent{
+		Type:          typ,
+		Time:          time.Now(),
+		Queue:         AuditQueueName(q),
+		Task:          msg.TaskName,
+		AuditID:       msg.Headers[AuditIDHeader],
+		MessageID:     msg.ID,
+		ReservedCount: msg.ReservedCount,
+		Delay:         msg.Delay,
+	}
+	if msg.Err != nil {
+		evt.Error = msg.Err.Error()
+	}
+	return evt
+}
+
+// AuditSink receives message lifecycle events of queues with
+// QueueOptions.AuditSink, e.g. to keep immutable processing logs.
+// WriteAuditEvent is called synchronously from producers and workers,
+// so it should be fast. Errors are logged and don't affect processing.
+type AuditSink interface {
+	WriteAuditEvent(ctx context.Context, evt *AuditEvent) error
+}
+
+func (c *Consumer) writeAuditEvent(typ AuditEventType, msg *Message) {
+	if c.opt.AuditSink == nil {
+		return
+	}
+	err := c.opt.AuditSink.WriteAuditEvent(msgContext(msg), NewAuditEvent(typ, c.q, msg))
+	if err != nil {
+		internal.Logger.Printf("taskq: WriteAuditEvent failed: %s", err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// JSONAuditSink writes events as JSON lines.
+type JSONAuditSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+var _ AuditSink = (*JSONAuditSink)(nil)
+
+// NewJSONAuditSink returns a sink that writes events to w as JSON lines.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// NewFileAuditSink returns a sink that appends events to the file
+// as JSON lines. The file is created if it does not exist.
+func NewFileAuditSink(path string) (*JSONAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditSink(f), nil
+}
+
+func (s *JSONAuditSink) WriteAuditEvent(_ context.Context, evt *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(evt)
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *JSONAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// KafkaProducer is implemented by an adapter of the Kafka client
+// the application uses, e.g. sarama or kafka-go.
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key, value []byte) error
+}
+
+// KafkaAuditSink produces events as JSON to a Kafka topic. Events are keyed
+// by the audit id, so events of a message go to the same partition in order.
+type KafkaAuditSink struct {
+	producer KafkaProducer
+	topic    string
+}
+
+var _ AuditSink = (*KafkaAuditSink)(nil)
+
+func NewKafkaAuditSink(producer KafkaProducer, topic string) *KafkaAuditSink {
+	return &KafkaAuditSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (s *KafkaAuditSink) WriteAuditEvent(ctx context.Context, evt *AuditEvent) error {
+	value, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	key := evt.AuditID
+	if key == "" {
+		key = evt.Queue
+	}
+	return s.producer.Produce(ctx, s.topic, []byte(key), value)
+}
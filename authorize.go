@@ -0,0 +1,61 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrForbidden matches errors returned by Queue.Add when
+// QueueOptions.Authorizer rejects the message.
+var ErrForbidden = errors.New("taskq: forbidden")
+
+// Identity describes the producer that adds messages, e.g. a team
+// or a service sharing the queue.
+type Identity struct {
+	Name string
+	// Optional metadata, e.g. roles or the origin of the request.
+	Metadata map[string]string
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a context that carries the identity of the
+// producer. Messages created with the context, e.g. by Task.WithArgs,
+// are authorized using the identity when they are added.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the producer identity carried by the context
+// or nil.
+func IdentityFromContext(ctx context.Context) *Identity {
+	if ctx == nil {
+		return nil
+	}
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Authorizer decides whether the producer may add the message to the queue.
+// The identity is nil when the caller didn't supply one. It should return
+// an error wrapping ErrForbidden to reject the message.
+type Authorizer func(q Queue, id *Identity, msg *Message) error
+
+// AllowTasks returns an Authorizer that allows producers to add only
+// the tasks listed for their identity name. The "*" task allows any task.
+// Producers without an identity are rejected.
+func AllowTasks(acl map[string][]string) Authorizer {
+	return func(q Queue, id *Identity, msg *Message) error {
+		if id == nil {
+			return fmt.Errorf("%w: task=%q requires an identity", ErrForbidden, msg.TaskName)
+		}
+		for _, name := range acl[id.Name] {
+			if name == msg.TaskName || name == "*" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: identity=%q may not add task=%q to queue=%q",
+			ErrForbidden, id.Name, msg.TaskName, q.Name())
+	}
+}
@@ -2,10 +2,14 @@ package azsqs
 
 import (
 	"context"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
 	"github.com/frain-dev/taskq/v3/internal/base"
 )
 
@@ -14,35 +18,142 @@ type factory struct {
 
 	sqs       *sqs.SQS
 	accountID string
+	opt       *Options
 }
 
 var _ taskq.Factory = (*factory)(nil)
 
 func NewFactory(sqs *sqs.SQS, accountID string) taskq.Factory {
+	return NewFactoryWithOptions(sqs, accountID, new(Options))
+}
+
+// NewFactoryWithOptions returns a factory that registers queues
+// with the SQS specific options.
+func NewFactoryWithOptions(sqs *sqs.SQS, accountID string, opt *Options) taskq.Factory {
 	return &factory{
 		sqs:       sqs,
 		accountID: accountID,
+		opt:       opt,
 	}
 }
 
 func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
-	q := NewQueue(f.sqs, f.accountID, opt)
+	f.base.InitOptions(opt)
+	azopt := *f.opt
+	q := NewQueueWithOptions(f.sqs, f.accountID, opt, &azopt)
 	if err := f.base.Register(q); err != nil {
 		panic(err)
 	}
 	return q
 }
 
+func (f *factory) UnregisterQueue(name string) error {
+	q, err := f.base.Unregister(name)
+	if err != nil {
+		return err
+	}
+	_ = q.Close()
+	return q.(*Queue).deleteQueue()
+}
+
+// Discover returns names of the SQS queues in the account and region.
+// Only the queues in the namespace of the registered queues are returned
+// and dead-letter queues are skipped.
+func (f *factory) Discover(
+	ctx context.Context, register func(name string) *taskq.QueueOptions,
+) ([]string, error) {
+	namespace := f.base.Namespace()
+	prefix := internal.Namespaced(namespace, "-", "")
+
+	deadLetter := f.deadLetterQueues()
+	var names []string
+	in := &sqs.ListQueuesInput{
+		MaxResults: aws.Int64(1000),
+	}
+	if prefix != "" {
+		in.QueueNamePrefix = aws.String(prefix)
+	}
+	err := f.sqs.ListQueuesPagesWithContext(ctx, in, func(out *sqs.ListQueuesOutput, _ bool) bool {
+		for _, u := range out.QueueUrls {
+			s := aws.StringValue(u)
+			name := s[strings.LastIndexByte(s, '/')+1:]
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			name = name[len(prefix):]
+			if _, ok := deadLetter[name]; ok {
+				continue
+			}
+			names = append(names, name)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.base.RegisterDiscovered(namespace, names, register, f.RegisterQueue)
+	return names, nil
+}
+
+// deadLetterQueues returns names of the dead-letter queues
+// of the factory and the registered queues.
+func (f *factory) deadLetterQueues() map[string]struct{} {
+	m := make(map[string]struct{})
+	add := func(name string) {
+		if name != "" {
+			m[name] = struct{}{}
+			m[name+fifoSuffix] = struct{}{}
+		}
+	}
+	add(f.opt.DeadLetterQueue)
+	f.base.Range(func(q taskq.Queue) bool {
+		if q, ok := q.(*Queue); ok {
+			add(q.azopt.DeadLetterQueue)
+		}
+		return true
+	})
+	return m
+}
+
 func (f *factory) Range(fn func(queue taskq.Queue) bool) {
 	f.base.Range(fn)
 }
 
+func (f *factory) SetRegistry(r *taskq.TaskMap) {
+	f.base.SetRegistry(r)
+}
+
+func (f *factory) Stats() *taskq.FactoryStats {
+	return f.base.Stats()
+}
+
+func (f *factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.base.OnQueueRegister(fn)
+}
+
+func (f *factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.base.OnConsumerStart(fn)
+}
+
+func (f *factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.base.OnConsumerStop(fn)
+}
+
 func (f *factory) StartConsumers(ctx context.Context) error {
 	return f.base.StartConsumers(ctx)
 }
 
-func (f *factory) StopConsumers() error {
-	return f.base.StopConsumers()
+func (f *factory) StopConsumers(ctx context.Context) error {
+	return f.base.StopConsumers(ctx)
+}
+
+func (f *factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.base.PauseConsumers(ctx, d)
+}
+
+func (f *factory) ResumeConsumers(ctx context.Context) error {
+	return f.base.ResumeConsumers(ctx)
 }
 
 func (f *factory) Close() error {
@@ -0,0 +1,78 @@
+package azsqs
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// SQS allows at most 10 message attributes per message.
+const maxMessageAttrs = 10
+
+// setHeaderAttrs maps message headers onto SQS message attributes so they are
+// visible in the AWS console. Headers are also stored in the message body,
+// so headers that don't fit or have invalid names are only skipped here.
+func setHeaderAttrs(entry *sqs.SendMessageBatchRequestEntry, headers map[string]string) {
+	for name, value := range headers {
+		if len(entry.MessageAttributes) >= maxMessageAttrs {
+			return
+		}
+		if !isValidAttrName(name) || value == "" {
+			continue
+		}
+		if _, ok := entry.MessageAttributes[name]; ok {
+			continue
+		}
+		setAttr(entry, name, &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		})
+	}
+}
+
+// headersFromAttrs returns headers set from SQS message attributes
+// including the ones added by producers that don't use taskq.
+func headersFromAttrs(
+	headers map[string]string, attrs map[string]*sqs.MessageAttributeValue,
+) map[string]string {
+	for name, attr := range attrs {
+		if isInternalAttr(name) || attr.StringValue == nil {
+			continue
+		}
+		if _, ok := headers[name]; ok {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(attrs))
+		}
+		headers[name] = *attr.StringValue
+	}
+	return headers
+}
+
+func isInternalAttr(name string) bool {
+	return name == delayUntilAttr || name == extendedPayloadSizeAttr
+}
+
+func isValidAttrName(name string) bool {
+	if name == "" || len(name) > 256 || isInternalAttr(name) {
+		return false
+	}
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+		return false
+	}
+	if name[0] == '.' || name[len(name)-1] == '.' || strings.Contains(name, "..") {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package azsqs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const minIdleInterval = time.Second
+
+// idlePoller lengthens intervals between polls while the queue is empty
+// and resets them as soon as the queue becomes active again.
+type idlePoller struct {
+	max time.Duration
+
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	wakeCh chan struct{}
+}
+
+func newIdlePoller(max time.Duration) *idlePoller {
+	return &idlePoller{
+		max:    max,
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+// Wait waits until the next poll is due. It returns false if the timeout
+// elapses or the context is done before that.
+func (p *idlePoller) Wait(ctx context.Context, timeout time.Duration) bool {
+	p.mu.Lock()
+	d := time.Until(p.next)
+	p.mu.Unlock()
+
+	if d <= 0 {
+		return true
+	}
+
+	// Don't spin when long polling is disabled.
+	if timeout < minIdleInterval {
+		timeout = minIdleInterval
+	}
+
+	poll := d <= timeout
+	if !poll {
+		d = timeout
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return poll
+	case <-p.wakeCh:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Idle doubles the interval after an empty receive.
+func (p *idlePoller) Idle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.interval == 0 {
+		p.interval = minIdleInterval
+	} else {
+		p.interval *= 2
+	}
+	if p.interval > p.max {
+		p.interval = p.max
+	}
+	p.next = time.Now().Add(p.interval)
+}
+
+// Active resets the interval and wakes up waiting pollers.
+func (p *idlePoller) Active() {
+	p.mu.Lock()
+	wasIdle := p.interval > 0
+	p.interval = 0
+	p.next = time.Time{}
+	p.mu.Unlock()
+
+	if wasIdle {
+		select {
+		case p.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
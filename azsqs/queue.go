@@ -2,6 +2,7 @@ package azsqs
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
@@ -11,7 +12,9 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
 
 	"github.com/frain-dev/taskq/v3"
 	"github.com/frain-dev/taskq/v3/internal"
@@ -24,12 +27,117 @@ const msgSizeLimit = 262144
 
 const delayUntilAttr = "TaskqDelayUntil"
 
+const fifoSuffix = ".fifo"
+
+// Options are SQS specific queue options.
+type Options struct {
+	// Optional S3 client. When set, messages larger than LargeMessageThreshold
+	// are stored in S3Bucket and the SQS message only references them.
+	S3       s3iface.S3API
+	S3Bucket string
+	// Default is 256KB (SQS message size limit).
+	LargeMessageThreshold int
+
+	// Long polling wait time. Overrides QueueOptions.WaitTimeout.
+	// Maximum is 20 seconds. -1 disables long polling.
+	WaitTime time.Duration
+	// Maximum number of messages received in one request. The consumer
+	// may request fewer messages, e.g. when the rate limit or the free
+	// buffer space allows less. Maximum is 10 messages.
+	MaxNumberOfMessages int
+	// Additional system attributes requested when receiving messages.
+	// Message attributes are always requested and mapped onto headers.
+	AttributeNames []string
+
+	// Maximum interval between polls of an empty queue. While the queue
+	// is empty, intervals grow exponentially starting from 1 second and are
+	// reset when messages are received or added by this process.
+	// Default is 0 (adaptive polling is disabled).
+	MaxIdleInterval time.Duration
+
+	// Automatically extend visibility timeout of messages which handlers run
+	// longer than half of QueueOptions.ReservationTimeout using Message.Touch.
+	// Ignored when QueueOptions.TouchInterval is set.
+	AutoExtendVisibility bool
+
+	// Optional name of the dead-letter queue. The queue is created and attached
+	// using a redrive policy when the queue is first used. FIFO queues use
+	// FIFO dead-letter queues with the same name and ".fifo" suffix.
+	DeadLetterQueue string
+	// Number of receives after which SQS moves the message to the dead-letter
+	// queue. Messages are received once per attempt and ReservedCount is
+	// set from ApproximateReceiveCount, so with MaxReceiveCount less than
+	// TaskOptions.RetryLimit SQS moves failing messages before taskq passes
+	// them to the FallbackHandler.
+	// Default is the largest RetryLimit of the tasks in the queue registry
+	// when the dead-letter queue is attached, so only messages that taskq
+	// never finished, e.g. because the worker crashed, are moved.
+	MaxReceiveCount int
+
+	// Deduplicate messages in FIFO queues using SHA-256 hash of the body
+	// instead of generating deduplication ids. Named messages always use
+	// the name as deduplication id.
+	ContentBasedDeduplication bool
+
+	// Attributes that are set when the queue is created. SQS does not
+	// change attributes of existing queues.
+	//
+	// Optional KMS key used for server-side encryption, e.g. "alias/aws/sqs".
+	KMSMasterKeyID string
+	// Time SQS reuses a data key before calling KMS again.
+	// Default is 0 (SQS default of 5 minutes).
+	KMSDataKeyReusePeriod time.Duration
+	// Enables server-side encryption with SQS owned keys.
+	// Ignored when KMSMasterKeyID is set.
+	SQSManagedSSE bool
+	// Time SQS retains a message.
+	// Default is 0 (SQS default of 4 days).
+	MessageRetentionPeriod time.Duration
+	// Optional cost allocation tags.
+	Tags map[string]string
+
+	// Maximum number of messages sent with a single SendMessageBatch.
+	// Maximum and default is 10 messages.
+	AddBatchSize int
+	// Time after which a partially filled batch is sent.
+	// Default is 3 seconds.
+	AddBatchTimeout time.Duration
+}
+
+func (opt *Options) init() {
+	const maxWaitTime = 20 * time.Second
+	const maxBatchSize = 10
+
+	if opt.LargeMessageThreshold == 0 {
+		opt.LargeMessageThreshold = msgSizeLimit
+	}
+
+	if opt.WaitTime > maxWaitTime {
+		opt.WaitTime = maxWaitTime
+	}
+	if opt.MaxNumberOfMessages > maxBatchSize {
+		opt.MaxNumberOfMessages = maxBatchSize
+	}
+
+	if opt.AddBatchSize == 0 || opt.AddBatchSize > maxBatchSize {
+		opt.AddBatchSize = maxBatchSize
+	}
+	if opt.AddBatchTimeout == 0 {
+		opt.AddBatchTimeout = 3 * time.Second
+	}
+}
+
 type Queue struct {
-	opt *taskq.QueueOptions
+	opt   *taskq.QueueOptions
+	azopt *Options
 
 	sqs       *sqs.SQS
 	accountID string
 
+	// Registry of the internal tasks so queues with the same name
+	// in different factories don't collide.
+	tasks *taskq.TaskMap
+
 	addQueue   *memqueue.Queue
 	addTask    *taskq.Task
 	addBatcher *base.Batcher
@@ -38,6 +146,9 @@ type Queue struct {
 	delTask    *taskq.Task
 	delBatcher *base.Batcher
 
+	fifo   bool
+	poller *idlePoller
+
 	mu        sync.RWMutex
 	_queueURL string
 
@@ -45,16 +156,34 @@ type Queue struct {
 }
 
 var _ taskq.Queue = (*Queue)(nil)
+var _ taskq.Toucher = (*Queue)(nil)
 
 func NewQueue(sqs *sqs.SQS, accountID string, opt *taskq.QueueOptions) *Queue {
+	return NewQueueWithOptions(sqs, accountID, opt, new(Options))
+}
+
+func NewQueueWithOptions(
+	sqs *sqs.SQS, accountID string, opt *taskq.QueueOptions, azopt *Options,
+) *Queue {
 	opt.Init()
+	azopt.init()
 
 	q := &Queue{
 		sqs:       sqs,
 		accountID: accountID,
 		opt:       opt,
+		azopt:     azopt,
+
+		fifo: strings.HasSuffix(opt.Name, fifoSuffix),
+	}
+	if azopt.MaxIdleInterval > 0 {
+		q.poller = newIdlePoller(azopt.MaxIdleInterval)
+	}
+	if azopt.AutoExtendVisibility && opt.TouchInterval == 0 {
+		opt.TouchInterval = opt.ReservationTimeout / 2
 	}
 
+	q.tasks = taskq.NewRegistry()
 	q.initAddQueue()
 	q.initDelQueue()
 
@@ -62,13 +191,14 @@ func NewQueue(sqs *sqs.SQS, accountID string, opt *taskq.QueueOptions) *Queue {
 }
 
 func (q *Queue) initAddQueue() {
-	queueName := "azsqs:" + q.opt.Name + ":add"
+	queueName := "azsqs:" + strings.TrimSuffix(q.opt.Name, fifoSuffix) + ":add"
 	q.addQueue = memqueue.NewQueue(&taskq.QueueOptions{
 		Name:       queueName,
 		BufferSize: 100,
 		Redis:      q.opt.Redis,
+		Handler:    q.tasks,
 	})
-	q.addTask = taskq.RegisterTask(&taskq.TaskOptions{
+	q.addTask = q.tasks.RegisterTask(&taskq.TaskOptions{
 		Name:            queueName + ":add-message",
 		Handler:         taskq.HandlerFunc(q.addBatcherAdd),
 		FallbackHandler: msgutil.UnwrapMessageHandler(q.opt.Handler.HandleMessage),
@@ -78,32 +208,53 @@ func (q *Queue) initAddQueue() {
 	q.addBatcher = base.NewBatcher(q.addQueue.Consumer(), &base.BatcherOptions{
 		Handler:     q.addBatch,
 		ShouldBatch: q.shouldBatchAdd,
+		Timeout:     q.azopt.AddBatchTimeout,
 	})
 }
 
 func (q *Queue) initDelQueue() {
-	queueName := "azsqs:" + q.opt.Name + ":delete"
+	queueName := "azsqs:" + strings.TrimSuffix(q.opt.Name, fifoSuffix) + ":delete"
 	q.delQueue = memqueue.NewQueue(&taskq.QueueOptions{
 		Name:       queueName,
 		BufferSize: 100,
 		Redis:      q.opt.Redis,
+		Handler:    q.tasks,
 	})
-	q.delTask = taskq.RegisterTask(&taskq.TaskOptions{
+	q.delTask = q.tasks.RegisterTask(&taskq.TaskOptions{
 		Name:       queueName + ":delete-message",
 		Handler:    taskq.HandlerFunc(q.delBatcherAdd),
 		RetryLimit: 3,
 		MinBackoff: time.Second,
 	})
 	q.delBatcher = base.NewBatcher(q.delQueue.Consumer(), &base.BatcherOptions{
-		Handler:     q.deleteBatch,
-		ShouldBatch: q.shouldBatchDelete,
+		Handler: q.deleteBatch,
+		MaxSize: q.deleteBatchSize(),
+		Timeout: q.opt.DeleteBatchTimeout,
 	})
 }
 
+func (q *Queue) deleteBatchSize() int {
+	const maxBatchSize = 10
+	if q.opt.DeleteBatchSize > maxBatchSize {
+		return maxBatchSize
+	}
+	return q.opt.DeleteBatchSize
+}
+
+// DeleteBatchStats returns stats of batches of deleted messages.
+func (q *Queue) DeleteBatchStats() *taskq.BatchStats {
+	return q.delBatcher.Stats()
+}
+
 func (q *Queue) Name() string {
 	return q.opt.Name
 }
 
+// sqsName returns the SQS queue name prefixed with the namespace.
+func (q *Queue) sqsName() string {
+	return internal.Namespaced(q.opt.Namespace, "-", q.opt.Name)
+}
+
 func (q *Queue) String() string {
 	return fmt.Sprintf("queue=%q", q.Name())
 }
@@ -138,13 +289,135 @@ func (q *Queue) Add(msg *taskq.Message) error {
 	if msg.TaskName == "" {
 		return internal.ErrTaskNameRequired
 	}
+	if err := msgutil.BeforeAdd(q, msg); err != nil {
+		return err
+	}
 	if q.isDuplicate(msg) {
 		msg.Err = taskq.ErrDuplicate
 		return nil
 	}
-	msg = msgutil.WrapMessage(msg)
-	msg.TaskName = q.addTask.Name()
-	return q.addQueue.Add(msg)
+	acquired, err := msgutil.AcquireQuota(q, msg)
+	if err != nil {
+		return err
+	}
+	// Quotas with MaxDelay may delay the message.
+	if err := q.checkDelay(msg); err != nil {
+		if acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		return err
+	}
+	audit := msgutil.SetAuditID(q, msg)
+	if q.poller != nil {
+		q.poller.Active()
+	}
+	wrapped := msgutil.WrapMessage(msg)
+	wrapped.TaskName = q.addTask.Name()
+	err = q.addQueue.Add(wrapped)
+	if err != nil {
+		if acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		return err
+	}
+	if audit {
+		msgutil.AuditEnqueued(q, msg)
+	}
+	return nil
+}
+
+// checkDelay rejects delayed messages in FIFO queues. SQS FIFO queues
+// don't support per-message delays, and delivering the message early to
+// re-add it later would move it to the tail of its message group.
+func (q *Queue) checkDelay(msg *taskq.Message) error {
+	if q.fifo && msg.Delay > 0 {
+		return fmt.Errorf("%w: azsqs: FIFO queue=%q does not support message delays",
+			taskq.ErrNotSupported, q.opt.Name)
+	}
+	return nil
+}
+
+// AddN adds messages to the queue using SendMessageBatch. Unlike Add it
+// waits for messages to be sent. Duplicate messages are skipped and have
+// Err set to ErrDuplicate; messages rejected by SQS have Err set too.
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	for _, msg := range msgs {
+		if msg.TaskName == "" {
+			return internal.ErrTaskNameRequired
+		}
+	}
+	if q.poller != nil {
+		q.poller.Active()
+	}
+
+	var firstErr error
+	var batch, orig []*taskq.Message
+	var acquired, audited []bool
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := q.addBatchContext(ctx, batch)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for i, msg := range batch {
+			orig[i].Err = msg.Err
+			if orig[i].Err == nil {
+				orig[i].Err = err
+			}
+			switch {
+			case orig[i].Err != nil && acquired[i]:
+				msgutil.ReleaseQuota(q, orig[i])
+			case orig[i].Err == nil && audited[i]:
+				msgutil.AuditEnqueued(q, orig[i])
+			}
+		}
+		batch = batch[:0]
+		orig = orig[:0]
+		acquired = acquired[:0]
+		audited = audited[:0]
+	}
+
+	for _, msg := range msgs {
+		if err := msgutil.BeforeAdd(q, msg); err != nil {
+			msg.Err = err
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if q.isDuplicate(msg) {
+			msg.Err = taskq.ErrDuplicate
+			continue
+		}
+		ok, err := msgutil.AcquireQuota(q, msg)
+		if err == nil {
+			err = q.checkDelay(msg)
+			if err != nil && ok {
+				msgutil.ReleaseQuota(q, msg)
+			}
+		}
+		if err != nil {
+			msg.Err = err
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		audit := msgutil.SetAuditID(q, msg)
+		wrapped := msgutil.WrapMessage(msg)
+		if len(batch) > 0 && !q.shouldBatchAdd(batch, wrapped) {
+			flush()
+		}
+		batch = append(batch, wrapped)
+		orig = append(orig, msg)
+		acquired = append(acquired, ok)
+		audited = append(audited, audit)
+	}
+	flush()
+
+	return firstErr
 }
 
 func (q *Queue) queueURL() string {
@@ -161,6 +434,12 @@ func (q *Queue) queueURL() string {
 	queueURL, err := q.getQueueURL()
 	if err == nil {
 		q._queueURL = queueURL
+
+		if q.azopt.DeadLetterQueue != "" {
+			if err := q.attachDeadLetterQueue(queueURL); err != nil {
+				internal.Logger.Printf("azsqs: attaching dead-letter queue failed: %s", err)
+			}
+		}
 	}
 	q.mu.Unlock()
 
@@ -170,11 +449,17 @@ func (q *Queue) queueURL() string {
 func (q *Queue) createQueue() (string, error) {
 	visTimeout := strconv.Itoa(int(q.opt.ReservationTimeout / time.Second))
 	in := &sqs.CreateQueueInput{
-		QueueName: aws.String(q.Name()),
+		QueueName: aws.String(q.sqsName()),
 		Attributes: map[string]*string{
 			"VisibilityTimeout": &visTimeout,
 		},
 	}
+	if q.fifo {
+		in.Attributes["FifoQueue"] = aws.String("true")
+		in.Attributes["ContentBasedDeduplication"] = aws.String(
+			strconv.FormatBool(q.azopt.ContentBasedDeduplication))
+	}
+	q.setQueueAttrs(in)
 	out, err := q.sqs.CreateQueue(in)
 	if err != nil {
 		return "", err
@@ -182,9 +467,32 @@ func (q *Queue) createQueue() (string, error) {
 	return *out.QueueUrl, nil
 }
 
+func (q *Queue) setQueueAttrs(in *sqs.CreateQueueInput) {
+	seconds := func(d time.Duration) *string {
+		return aws.String(strconv.Itoa(int(d / time.Second)))
+	}
+
+	switch {
+	case q.azopt.KMSMasterKeyID != "":
+		in.Attributes["KmsMasterKeyId"] = aws.String(q.azopt.KMSMasterKeyID)
+		if q.azopt.KMSDataKeyReusePeriod > 0 {
+			in.Attributes["KmsDataKeyReusePeriodSeconds"] = seconds(q.azopt.KMSDataKeyReusePeriod)
+		}
+	case q.azopt.SQSManagedSSE:
+		in.Attributes["SqsManagedSseEnabled"] = aws.String("true")
+	}
+
+	if q.azopt.MessageRetentionPeriod > 0 {
+		in.Attributes["MessageRetentionPeriod"] = seconds(q.azopt.MessageRetentionPeriod)
+	}
+	if len(q.azopt.Tags) > 0 {
+		in.Tags = aws.StringMap(q.azopt.Tags)
+	}
+}
+
 func (q *Queue) getQueueURL() (string, error) {
 	in := &sqs.GetQueueUrlInput{
-		QueueName:              aws.String(q.Name()),
+		QueueName:              aws.String(q.sqsName()),
 		QueueOwnerAWSAccountId: &q.accountID,
 	}
 	out, err := q.sqs.GetQueueUrl(in)
@@ -197,27 +505,67 @@ func (q *Queue) getQueueURL() (string, error) {
 func (q *Queue) ReserveN(
 	ctx context.Context, n int, waitTimeout time.Duration,
 ) ([]taskq.Message, error) {
+	if m := q.azopt.MaxNumberOfMessages; m > 0 && m < n {
+		n = m
+	}
 	if n > 10 {
 		n = 10
 	}
+	switch {
+	case q.azopt.WaitTime == -1:
+		waitTimeout = 0
+	case q.azopt.WaitTime > 0:
+		waitTimeout = q.azopt.WaitTime
+	}
+	if waitTimeout > 20*time.Second {
+		waitTimeout = 20 * time.Second
+	}
+
+	if q.poller != nil && !q.poller.Wait(ctx, waitTimeout) {
+		return nil, nil
+	}
+
 	in := &sqs.ReceiveMessageInput{
-		QueueUrl:              aws.String(q.queueURL()),
-		MaxNumberOfMessages:   aws.Int64(int64(n)),
-		WaitTimeSeconds:       aws.Int64(int64(waitTimeout / time.Second)),
-		AttributeNames:        []*string{aws.String("ApproximateReceiveCount")},
-		MessageAttributeNames: []*string{aws.String(delayUntilAttr)},
+		QueueUrl:            aws.String(q.queueURL()),
+		MaxNumberOfMessages: aws.Int64(int64(n)),
+		WaitTimeSeconds:     aws.Int64(int64(waitTimeout / time.Second)),
+		AttributeNames: []*string{
+			aws.String("ApproximateReceiveCount"),
+			aws.String("MessageGroupId"),
+		},
+		// All message attributes are requested to map them onto headers.
+		MessageAttributeNames: []*string{aws.String("All")},
 	}
+	in.AttributeNames = append(in.AttributeNames,
+		aws.StringSlice(q.azopt.AttributeNames)...)
+
 	out, err := q.sqs.ReceiveMessage(in)
 	if err != nil {
 		return nil, err
 	}
 
+	if q.poller != nil {
+		if len(out.Messages) == 0 {
+			q.poller.Idle()
+		} else {
+			q.poller.Active()
+		}
+	}
+
 	msgs := make([]taskq.Message, len(out.Messages))
 	for i, sqsMsg := range out.Messages {
 		msg := &msgs[i]
 
-		if *sqsMsg.Body != "_" {
-			b, err := internal.DecodeString(*sqsMsg.Body)
+		body := *sqsMsg.Body
+		if _, ok := sqsMsg.MessageAttributes[extendedPayloadSizeAttr]; ok {
+			body, err = q.getPayload(ctx, sqsMsg)
+			if err != nil {
+				msg.Err = err
+			}
+		}
+
+		if body != "_" && msg.Err == nil {
+			b, err := internal.DecodeString(body)
 			if err != nil {
 				msg.Err = err
 			} else {
@@ -229,6 +577,11 @@ func (q *Queue) ReserveN(
 		}
 
 		msg.ReservationID = *sqsMsg.ReceiptHandle
+		msg.Headers = headersFromAttrs(msg.Headers, sqsMsg.MessageAttributes)
+
+		if v, ok := sqsMsg.Attributes["MessageGroupId"]; ok {
+			msg.OrderingKey = *v
+		}
 
 		if v, ok := sqsMsg.Attributes["ApproximateReceiveCount"]; ok {
 			var err error
@@ -257,7 +610,7 @@ func (q *Queue) ReserveN(
 func (q *Queue) Release(msg *taskq.Message) error {
 	in := &sqs.ChangeMessageVisibilityInput{
 		QueueUrl:          aws.String(q.queueURL()),
-		ReceiptHandle:     &msg.ReservationID,
+		ReceiptHandle:     receiptHandle(msg.ReservationID),
 		VisibilityTimeout: aws.Int64(int64(msg.Delay / time.Second)),
 	}
 	var err error
@@ -277,6 +630,25 @@ func (q *Queue) Release(msg *taskq.Message) error {
 	return err
 }
 
+// Touch extends visibility timeout of the message by ReservationTimeout.
+// SQS limits the total visibility timeout of a message to 12 hours.
+func (q *Queue) Touch(ctx context.Context, msg *taskq.Message) error {
+	const maxVisibilityTimeout = 12 * time.Hour
+
+	timeout := q.opt.ReservationTimeout
+	if timeout > maxVisibilityTimeout {
+		timeout = maxVisibilityTimeout
+	}
+
+	in := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.queueURL()),
+		ReceiptHandle:     receiptHandle(msg.ReservationID),
+		VisibilityTimeout: aws.Int64(int64(timeout / time.Second)),
+	}
+	_, err := q.sqs.ChangeMessageVisibilityWithContext(ctx, in)
+	return err
+}
+
 // Delete deletes the message from the queue.
 func (q *Queue) Delete(msg *taskq.Message) error {
 	msg = msgutil.WrapMessage(msg)
@@ -293,6 +665,15 @@ func (q *Queue) Purge() error {
 	return err
 }
 
+// deleteQueue deletes the SQS queue. The dead-letter queue is kept.
+func (q *Queue) deleteQueue() error {
+	in := &sqs.DeleteQueueInput{
+		QueueUrl: aws.String(q.queueURL()),
+	}
+	_, err := q.sqs.DeleteQueue(in)
+	return err
+}
+
 // Close is like CloseTimeout with 30 seconds timeout.
 func (q *Queue) Close() error {
 	return q.CloseTimeout(30 * time.Second)
@@ -301,7 +682,9 @@ func (q *Queue) Close() error {
 // CloseTimeout closes the queue waiting for pending messages to be processed.
 func (q *Queue) CloseTimeout(timeout time.Duration) error {
 	if q.consumer != nil {
-		_ = q.consumer.StopTimeout(timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_ = q.consumer.Stop(ctx)
+		cancel()
 	}
 
 	firstErr := q.addBatcher.Close()
@@ -329,6 +712,10 @@ func (q *Queue) addBatcherAdd(msg *taskq.Message) error {
 }
 
 func (q *Queue) addBatch(msgs []*taskq.Message) error {
+	return q.addBatchContext(context.Background(), msgs)
+}
+
+func (q *Queue) addBatchContext(ctx context.Context, msgs []*taskq.Message) error {
 	const maxDelay = 15 * time.Minute
 
 	if len(msgs) == 0 {
@@ -357,32 +744,41 @@ func (q *Queue) addBatch(msgs []*taskq.Message) error {
 			str = "_" // SQS requires body.
 		}
 
-		if len(str) > msgSizeLimit {
-			internal.Logger.Printf("task=%q: str=%d bytes=%d is larger than %d",
-				msg.TaskName, len(str), len(b), msgSizeLimit)
-		}
-
 		entry := &sqs.SendMessageBatchRequestEntry{
 			Id:          aws.String(strconv.Itoa(i)),
 			MessageBody: aws.String(str),
 		}
-		if msg.Delay <= maxDelay {
+
+		if q.largeMessages() && len(str) > q.azopt.LargeMessageThreshold {
+			if err := q.putPayload(msg.Ctx, entry, str); err != nil {
+				internal.Logger.Printf("azsqs: PutObject failed: %s", err)
+				return err
+			}
+		} else if len(str) > msgSizeLimit {
+			internal.Logger.Printf("task=%q: str=%d bytes=%d is larger than %d",
+				msg.TaskName, len(str), len(b), msgSizeLimit)
+		}
+		if q.fifo {
+			q.setFIFOAttrs(entry, msg)
+		}
+
+		switch {
+		case q.fifo:
+			// Delays are rejected by Add.
+		case msg.Delay <= maxDelay:
 			entry.DelaySeconds = aws.Int64(int64(msg.Delay / time.Second))
-		} else {
+		default:
 			entry.DelaySeconds = aws.Int64(int64(maxDelay / time.Second))
-			delayUntil := time.Now().Add(msg.Delay - maxDelay)
-			entry.MessageAttributes = map[string]*sqs.MessageAttributeValue{
-				delayUntilAttr: {
-					DataType:    aws.String("String"),
-					StringValue: aws.String(delayUntil.Format(time.RFC3339)),
-				},
-			}
+			setDelayUntil(entry, time.Now().Add(msg.Delay-maxDelay))
 		}
 
+		// Headers are set last so they don't take slots of internal attributes.
+		setHeaderAttrs(entry, msg.Headers)
+
 		in.Entries = append(in.Entries, entry)
 	}
 
-	out, err := q.sqs.SendMessageBatch(in)
+	out, err := q.sqs.SendMessageBatchWithContext(ctx, in)
 	if err != nil {
 		awsErr, ok := err.(awserr.Error)
 		if ok && awsErr.Code() == "ErrCodeBatchRequestTooLong" && len(msgs) == 1 {
@@ -414,6 +810,31 @@ func (q *Queue) addBatch(msgs []*taskq.Message) error {
 	return nil
 }
 
+func setDelayUntil(entry *sqs.SendMessageBatchRequestEntry, tm time.Time) {
+	setAttr(entry, delayUntilAttr, &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(tm.Format(time.RFC3339)),
+	})
+}
+
+// setFIFOAttrs sets message group and deduplication ids required by FIFO queues.
+// Messages without OrderingKey are grouped by the task name.
+func (q *Queue) setFIFOAttrs(entry *sqs.SendMessageBatchRequestEntry, msg *taskq.Message) {
+	groupID := msg.OrderingKey
+	if groupID == "" {
+		groupID = msg.TaskName
+	}
+	entry.MessageGroupId = aws.String(groupID)
+
+	switch {
+	case msg.Name != "":
+		name := msgutil.FullMessageName(q, msg)
+		entry.MessageDeduplicationId = aws.String(hex.EncodeToString([]byte(name)))
+	case !q.azopt.ContentBasedDeduplication:
+		entry.MessageDeduplicationId = aws.String(uuid.New().String())
+	}
+}
+
 func (q *Queue) shouldBatchAdd(batch []*taskq.Message, msg *taskq.Message) bool {
 	batch = append(batch, msg)
 
@@ -422,8 +843,7 @@ func (q *Queue) shouldBatchAdd(batch []*taskq.Message, msg *taskq.Message) bool
 		return false
 	}
 
-	const messagesLimit = 10
-	return len(batch) < messagesLimit
+	return len(batch) < q.azopt.AddBatchSize
 }
 
 func (q *Queue) batchSize(batch []*taskq.Message) int {
@@ -441,7 +861,11 @@ func (q *Queue) batchSize(batch []*taskq.Message) int {
 			continue
 		}
 
-		size += internal.MaxEncodedLen(len(b))
+		n := internal.MaxEncodedLen(len(b))
+		if q.largeMessages() && n > q.azopt.LargeMessageThreshold {
+			n = 1024 // S3 pointer
+		}
+		size += n
 	}
 	return size
 }
@@ -464,7 +888,7 @@ func (q *Queue) deleteBatch(msgs []*taskq.Message) error {
 
 		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
 			Id:            aws.String(strconv.Itoa(i)),
-			ReceiptHandle: &msg.ReservationID,
+			ReceiptHandle: receiptHandle(msg.ReservationID),
 		}
 	}
 
@@ -478,6 +902,10 @@ func (q *Queue) deleteBatch(msgs []*taskq.Message) error {
 		return err
 	}
 
+	if q.largeMessages() {
+		q.deletePayloads(msgs, out)
+	}
+
 	for _, entry := range out.Failed {
 		if entry.SenderFault != nil && *entry.SenderFault {
 			internal.Logger.Printf(
@@ -497,9 +925,21 @@ func (q *Queue) deleteBatch(msgs []*taskq.Message) error {
 	return nil
 }
 
-func (q *Queue) shouldBatchDelete(batch []*taskq.Message, msg *taskq.Message) bool {
-	const messagesLimit = 10
-	return len(batch)+1 < messagesLimit
+// deletePayloads deletes S3 payloads of the successfully deleted messages.
+func (q *Queue) deletePayloads(msgs []*taskq.Message, out *sqs.DeleteMessageBatchOutput) {
+	for _, entry := range out.Successful {
+		msg := findMessageByID(msgs, tos(entry.Id))
+		if msg == nil {
+			continue
+		}
+		msg, err := msgutil.UnwrapMessage(msg)
+		if err != nil {
+			continue
+		}
+		if err := q.deletePayload(msg.Ctx, msg.ReservationID); err != nil {
+			internal.Logger.Printf("azsqs: DeleteObject failed: %s", err)
+		}
+	}
 }
 
 func (q *Queue) GetAddQueue() *memqueue.Queue {
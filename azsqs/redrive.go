@@ -0,0 +1,105 @@
+package azsqs
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+type redrivePolicy struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	MaxReceiveCount     string `json:"maxReceiveCount"`
+}
+
+// attachDeadLetterQueue creates the dead-letter queue and attaches it
+// to the queue using a redrive policy.
+func (q *Queue) attachDeadLetterQueue(queueURL string) error {
+	name := internal.Namespaced(q.opt.Namespace, "-", q.azopt.DeadLetterQueue)
+	if q.fifo {
+		name += fifoSuffix
+	}
+
+	in := &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: map[string]*string{},
+	}
+	if q.fifo {
+		in.Attributes["FifoQueue"] = aws.String("true")
+	}
+	out, err := q.sqs.CreateQueue(in)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := q.sqs.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       out.QueueUrl,
+		AttributeNames: []*string{aws.String("QueueArn")},
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&redrivePolicy{
+		DeadLetterTargetArn: tos(attrs.Attributes["QueueArn"]),
+		MaxReceiveCount:     strconv.Itoa(q.maxReceiveCount()),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.sqs.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+		Attributes: map[string]*string{
+			"RedrivePolicy": aws.String(string(b)),
+		},
+	})
+	return err
+}
+
+// SQS limits maxReceiveCount to 1000.
+const maxReceiveCountLimit = 1000
+
+// maxReceiveCount returns Options.MaxReceiveCount or the largest RetryLimit
+// of the tasks handled by the queue.
+func (q *Queue) maxReceiveCount() int {
+	retryLimit := q.retryLimit()
+	if n := q.azopt.MaxReceiveCount; n > 0 {
+		if n < retryLimit {
+			internal.Logger.Printf(
+				"azsqs: %s: MaxReceiveCount=%d is less than RetryLimit=%d "+
+					"(failing messages are moved to the dead-letter queue "+
+					"before the FallbackHandler is called)",
+				q, n, retryLimit)
+		}
+		return n
+	}
+	if retryLimit > maxReceiveCountLimit {
+		return maxReceiveCountLimit
+	}
+	return retryLimit
+}
+
+// retryLimit returns the largest RetryLimit of the tasks in the queue
+// registry or the default RetryLimit when the handler is not a registry.
+func (q *Queue) retryLimit() int {
+	const defaultRetryLimit = 64 // see TaskOptions.RetryLimit
+
+	var limit int
+	if tasks, ok := q.opt.Handler.(*taskq.TaskMap); ok {
+		tasks.Range(func(_ string, task *taskq.Task) bool {
+			if n := task.Options().RetryLimit; n > limit {
+				limit = n
+			}
+			return true
+		})
+	}
+	if limit == 0 {
+		return defaultRetryLimit
+	}
+	return limit
+}
@@ -0,0 +1,172 @@
+package azsqs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
+)
+
+// The format is compatible with the Amazon SQS Extended Client Library.
+const (
+	extendedPayloadSizeAttr = "ExtendedPayloadSize"
+	s3PointerClass          = "software.amazon.payloadoffloading.PayloadS3Pointer"
+	s3BucketMarker          = "-..s3BucketName..-"
+	s3KeyMarker             = "-..s3Key..-"
+)
+
+type s3Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+func (p *s3Pointer) MarshalJSON() ([]byte, error) {
+	type pointer s3Pointer
+	return json.Marshal([]interface{}{s3PointerClass, (*pointer)(p)})
+}
+
+func (p *s3Pointer) UnmarshalJSON(b []byte) error {
+	type pointer s3Pointer
+	var class string
+	v := []interface{}{&class, (*pointer)(p)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if class != s3PointerClass {
+		return fmt.Errorf("azsqs: unsupported payload pointer: %q", class)
+	}
+	return nil
+}
+
+func (q *Queue) largeMessages() bool {
+	return q.azopt.S3 != nil
+}
+
+// putPayload stores the message body in S3 and replaces the entry body
+// with a reference to the S3 object.
+func (q *Queue) putPayload(
+	ctx context.Context, entry *sqs.SendMessageBatchRequestEntry, body string,
+) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ptr := &s3Pointer{
+		Bucket: q.azopt.S3Bucket,
+		Key:    uuid.New().String(),
+	}
+	_, err := q.azopt.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ptr.Bucket),
+		Key:    aws.String(ptr.Key),
+		Body:   strings.NewReader(body),
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(ptr)
+	if err != nil {
+		return err
+	}
+
+	entry.MessageBody = aws.String(string(b))
+	setAttr(entry, extendedPayloadSizeAttr, &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	})
+	return nil
+}
+
+// getPayload resolves the message body stored in S3. The S3 location is
+// embedded in the receipt handle so the object can be deleted with the message.
+func (q *Queue) getPayload(ctx context.Context, sqsMsg *sqs.Message) (string, error) {
+	if !q.largeMessages() {
+		return "", fmt.Errorf("azsqs: message is stored in S3, but S3 is not configured")
+	}
+
+	var ptr s3Pointer
+	if err := json.Unmarshal([]byte(*sqsMsg.Body), &ptr); err != nil {
+		return "", err
+	}
+
+	out, err := q.azopt.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ptr.Bucket),
+		Key:    aws.String(ptr.Key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return "", err
+	}
+
+	sqsMsg.ReceiptHandle = aws.String(s3BucketMarker + ptr.Bucket + s3BucketMarker +
+		s3KeyMarker + ptr.Key + s3KeyMarker + *sqsMsg.ReceiptHandle)
+	return string(b), nil
+}
+
+func (q *Queue) deletePayload(ctx context.Context, reservationID string) error {
+	ptr, _ := parseReceiptHandle(reservationID)
+	if ptr == nil || !q.largeMessages() {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := q.azopt.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ptr.Bucket),
+		Key:    aws.String(ptr.Key),
+	})
+	return err
+}
+
+// parseReceiptHandle extracts the S3 location of the payload
+// and the original SQS receipt handle.
+func parseReceiptHandle(s string) (*s3Pointer, string) {
+	bucket, rest, ok := cutMarker(s, s3BucketMarker)
+	if !ok {
+		return nil, s
+	}
+	key, rest, ok := cutMarker(rest, s3KeyMarker)
+	if !ok {
+		return nil, s
+	}
+	return &s3Pointer{Bucket: bucket, Key: key}, rest
+}
+
+func cutMarker(s, marker string) (value, rest string, ok bool) {
+	if !strings.HasPrefix(s, marker) {
+		return "", "", false
+	}
+	s = s[len(marker):]
+	i := strings.Index(s, marker)
+	if i == -1 {
+		return "", "", false
+	}
+	return s[:i], s[i+len(marker):], true
+}
+
+// receiptHandle returns the SQS receipt handle of the message.
+func receiptHandle(reservationID string) *string {
+	_, handle := parseReceiptHandle(reservationID)
+	return aws.String(handle)
+}
+
+func setAttr(
+	entry *sqs.SendMessageBatchRequestEntry, name string, value *sqs.MessageAttributeValue,
+) {
+	if entry.MessageAttributes == nil {
+		entry.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+	}
+	entry.MessageAttributes[name] = value
+}
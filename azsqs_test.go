@@ -1,10 +1,20 @@
 package taskq_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 
 	"github.com/frain-dev/taskq/v3"
@@ -137,3 +147,262 @@ func TestSQSBatchConsumerLarge(t *testing.T) {
 		Name: queueName("sqs-batch-processor-large-message"),
 	}, 64000)
 }
+
+func TestSQSFIFOConsumer(t *testing.T) {
+	t.Skip()
+
+	testConsumer(t, azsqsFactory(), &taskq.QueueOptions{
+		Name: queueName("sqs-fifo-consumer") + ".fifo",
+	})
+}
+
+func TestSQSFIFONamedMessage(t *testing.T) {
+	t.Skip()
+
+	testNamedMessage(t, azsqsFactory(), &taskq.QueueOptions{
+		Name: queueName("sqs-fifo-named-message") + ".fifo",
+	})
+}
+
+func TestAzsqsFIFODelay(t *testing.T) {
+	ctx := context.Background()
+
+	q := azsqs.NewFactory(newFakeSQS(), "fake").RegisterQueue(&taskq.QueueOptions{
+		Name:    "fifo-delay.fifo",
+		Storage: taskq.NewLocalStorage(),
+	})
+	defer q.Close()
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    "TestAzsqsFIFODelay",
+		Handler: func() {},
+	})
+
+	msg := task.WithArgs(ctx)
+	msg.Delay = time.Minute
+	if err := q.Add(msg); !errors.Is(err, taskq.ErrNotSupported) {
+		t.Fatalf("got %v, wanted ErrNotSupported", err)
+	}
+
+	msg = task.WithArgs(ctx)
+	msg.Delay = time.Minute
+	if err := q.AddN(ctx, msg); !errors.Is(err, taskq.ErrNotSupported) {
+		t.Fatalf("got %v, wanted ErrNotSupported", err)
+	}
+	if !errors.Is(msg.Err, taskq.ErrNotSupported) {
+		t.Fatalf("got %v, wanted ErrNotSupported", msg.Err)
+	}
+}
+
+func TestAzsqsDiscoverNamespace(t *testing.T) {
+	ctx := context.Background()
+
+	client := newFakeSQS()
+	for _, name := range []string{"app-registered", "app-found", "app-dlq", "app-dlq.fifo", "other-queue"} {
+		if _, err := client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	factory := azsqs.NewFactoryWithOptions(client, "fake", &azsqs.Options{
+		DeadLetterQueue: "dlq",
+	})
+	defer factory.Close()
+
+	factory.RegisterQueue(&taskq.QueueOptions{
+		Name:      "registered",
+		Namespace: "app",
+		Storage:   taskq.NewLocalStorage(),
+	})
+
+	var registered []string
+	names, err := factory.Discover(ctx, func(name string) *taskq.QueueOptions {
+		registered = append(registered, name)
+		return &taskq.QueueOptions{
+			Name:    name,
+			Storage: taskq.NewLocalStorage(),
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "found" || names[1] != "registered" {
+		t.Fatalf("got %v, wanted [found registered]", names)
+	}
+	if len(registered) != 1 || registered[0] != "found" {
+		t.Fatalf("got %v, wanted [found] to be registered", registered)
+	}
+
+	var namespace string
+	factory.Range(func(q taskq.Queue) bool {
+		if q.Name() == "found" {
+			namespace = q.Options().Namespace
+		}
+		return true
+	})
+	if namespace != "app" {
+		t.Fatalf("got namespace %q, wanted %q", namespace, "app")
+	}
+}
+
+func TestAzsqsMaxNumberOfMessages(t *testing.T) {
+	ctx := context.Background()
+
+	client := newFakeSQS()
+	var requested []int64
+	client.Handlers.Send.PushFront(func(r *request.Request) {
+		if in, ok := r.Params.(*sqs.ReceiveMessageInput); ok {
+			requested = append(requested, aws.Int64Value(in.MaxNumberOfMessages))
+		}
+	})
+
+	factory := azsqs.NewFactoryWithOptions(client, "fake", &azsqs.Options{
+		MaxNumberOfMessages: 5,
+	})
+	defer factory.Close()
+
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:    "max-number-of-messages",
+		Storage: taskq.NewLocalStorage(),
+	})
+
+	// MaxNumberOfMessages only caps the number of requested messages.
+	for _, n := range []int{3, 10} {
+		if _, err := q.ReserveN(ctx, n, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requested) != 2 || requested[0] != 3 || requested[1] != 5 {
+		t.Fatalf("got %v, wanted [3 5]", requested)
+	}
+}
+
+func TestAzsqsMaxReceiveCount(t *testing.T) {
+	client := newFakeSQS()
+	factory := azsqs.NewFactoryWithOptions(client, "fake", &azsqs.Options{
+		DeadLetterQueue: "dlq",
+	})
+	defer factory.Close()
+
+	registry := taskq.NewRegistry()
+	for _, retryLimit := range []int{5, 20} {
+		registry.RegisterTask(&taskq.TaskOptions{
+			Name:       "retry-" + strconv.Itoa(retryLimit),
+			Handler:    func() {},
+			RetryLimit: retryLimit,
+		})
+	}
+
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:    "max-receive-count",
+		Storage: taskq.NewLocalStorage(),
+		Handler: registry,
+	})
+	// The dead-letter queue is attached when the queue is first used.
+	if _, err := q.Len(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String("max-receive-count"),
+		AttributeNames: []*string{aws.String("RedrivePolicy")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+		MaxReceiveCount     string `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(out.Attributes["RedrivePolicy"])), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(policy.DeadLetterTargetArn, ":dlq") {
+		t.Fatalf("got dead-letter queue %q, wanted dlq", policy.DeadLetterTargetArn)
+	}
+	if policy.MaxReceiveCount != "20" {
+		t.Fatalf("got maxReceiveCount %s, wanted the largest RetryLimit 20", policy.MaxReceiveCount)
+	}
+}
+
+func TestSQSLargeMessage(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		S3:       s3.New(session.New()),
+		S3Bucket: os.Getenv("AWS_S3_BUCKET"),
+	})
+	testBatchConsumer(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-large-message"),
+	}, 300000)
+}
+
+func TestSQSShortPolling(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		WaitTime:            -1,
+		MaxNumberOfMessages: 5,
+		AddBatchSize:        5,
+	})
+	testConsumer(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-short-polling"),
+	})
+}
+
+func TestSQSDeadLetterQueue(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		DeadLetterQueue: queueName("sqs-dead-letter-queue-dlq"),
+		MaxReceiveCount: 3,
+	})
+	testRetry(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-dead-letter-queue"),
+	})
+}
+
+func TestSQSHeaders(t *testing.T) {
+	t.Skip()
+
+	testHeaders(t, azsqsFactory(), &taskq.QueueOptions{
+		Name: queueName("sqs-headers"),
+	})
+}
+
+func TestSQSAdaptivePolling(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		MaxIdleInterval: time.Minute,
+	})
+	testDelay(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-adaptive-polling"),
+	})
+}
+
+func TestSQSTouch(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		AutoExtendVisibility: true,
+	})
+	testTouch(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-touch"),
+	})
+}
+
+func TestSQSEncryptedQueue(t *testing.T) {
+	t.Skip()
+
+	factory := azsqs.NewFactoryWithOptions(awsSQS(), accountID, &azsqs.Options{
+		KMSMasterKeyID:         "alias/aws/sqs",
+		MessageRetentionPeriod: 24 * time.Hour,
+		Tags:                   map[string]string{"team": "taskq"},
+	})
+	testConsumer(t, factory, &taskq.QueueOptions{
+		Name: queueName("sqs-encrypted-queue"),
+	})
+}
@@ -2,55 +2,289 @@ package taskq_test
 
 import (
 	"context"
-	"sync"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/azsqs"
 	"github.com/frain-dev/taskq/v3/memqueue"
 	"github.com/frain-dev/taskq/v3/redisq"
 )
 
-func BenchmarkConsumerMemq(b *testing.B) {
-	benchmarkConsumer(b, memqueue.NewFactory())
+// Backends used by the end-to-end benchmarks. redisq benchmarks are skipped
+// when Redis is not running on :6379 and azsqs uses an in-process SQS fake.
+var benchBackends = []struct {
+	name       string
+	newFactory func(b *testing.B, opt *taskq.QueueOptions) taskq.Factory
+}{
+	{"memqueue", func(b *testing.B, opt *taskq.QueueOptions) taskq.Factory {
+		return memqueue.NewFactory()
+	}},
+	{"redisq", func(b *testing.B, opt *taskq.QueueOptions) taskq.Factory {
+		ring := redisRing()
+		if err := ring.Ping(context.Background()).Err(); err != nil {
+			b.Skipf("redis is not available: %s", err)
+		}
+		opt.Redis = ring
+		return redisq.NewFactory()
+	}},
+	{"azsqs", func(b *testing.B, opt *taskq.QueueOptions) taskq.Factory {
+		// Shorter long polling so the consumer stops faster between runs.
+		opt.WaitTimeout = time.Second
+		return azsqs.NewFactory(newFakeSQS(), "fake")
+	}},
 }
 
-func BenchmarkConsumerRedisq(b *testing.B) {
-	benchmarkConsumer(b, redisq.NewFactory())
+var benchQueueID uint32
+
+type benchQueue struct {
+	factory taskq.Factory
+	q       taskq.Queue
+	task    *taskq.Task
+
+	handled uint32
+	want    uint32
+	done    chan struct{}
 }
 
-var (
-	once sync.Once
-	q    taskq.Queue
-	task *taskq.Task
-	wg   sync.WaitGroup
-)
+func newBenchQueue(
+	b *testing.B, newFactory func(*testing.B, *taskq.QueueOptions) taskq.Factory,
+) *benchQueue {
+	bq := &benchQueue{
+		done: make(chan struct{}),
+	}
+
+	registry := taskq.NewRegistry()
+	bq.task = registry.RegisterTask(&taskq.TaskOptions{
+		Name: "bench",
+		Handler: func(n int) {
+			if atomic.AddUint32(&bq.handled, 1) == atomic.LoadUint32(&bq.want) {
+				close(bq.done)
+			}
+		},
+	})
+
+	id := atomic.AddUint32(&benchQueueID, 1)
+	opt := &taskq.QueueOptions{
+		Name:    queueName("bench-" + strconv.Itoa(int(id))),
+		Handler: registry,
+		Storage: taskq.NewLocalStorage(),
+	}
+	bq.factory = newFactory(b, opt)
+	bq.q = bq.factory.RegisterQueue(opt)
+	return bq
+}
+
+// start starts the consumer unless the queue starts it itself.
+func (bq *benchQueue) start(b *testing.B) {
+	if _, ok := bq.q.(*memqueue.Queue); ok {
+		return
+	}
+	if err := bq.q.Consumer().Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// wait waits until n messages are handled.
+func (bq *benchQueue) wait(b *testing.B, n int) {
+	atomic.StoreUint32(&bq.want, uint32(n))
+	if atomic.LoadUint32(&bq.handled) >= uint32(n) {
+		return
+	}
+	select {
+	case <-bq.done:
+	case <-time.After(time.Minute):
+		b.Fatalf("handled %d messages, wanted %d", atomic.LoadUint32(&bq.handled), n)
+	}
+}
+
+func (bq *benchQueue) close(b *testing.B) {
+	if err := bq.factory.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkEnqueue measures latency and allocations of Queue.Add.
+// Messages are consumed in the background.
+func BenchmarkEnqueue(b *testing.B) {
+	for _, backend := range benchBackends {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			ctx := context.Background()
+			bq := newBenchQueue(b, backend.newFactory)
+			defer bq.close(b)
+			bq.start(b)
 
-func benchmarkConsumer(b *testing.B, factory taskq.Factory) {
-	c := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
 
-	once.Do(func() {
-		q = factory.RegisterQueue(&taskq.QueueOptions{
-			Name:  "bench",
-			Redis: redisRing(),
+			for i := 0; i < b.N; i++ {
+				if err := bq.q.Add(bq.task.WithArgs(ctx, i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+			bq.wait(b, b.N)
 		})
+	}
+}
+
+// BenchmarkEnqueueBatch measures latency and allocations of Queue.AddN
+// with batches of 100 messages. ns/op is per batch.
+func BenchmarkEnqueueBatch(b *testing.B) {
+	const batchSize = 100
+
+	for _, backend := range benchBackends {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			ctx := context.Background()
+			bq := newBenchQueue(b, backend.newFactory)
+			defer bq.close(b)
+			bq.start(b)
+
+			msgs := make([]*taskq.Message, batchSize)
 
-		task = taskq.RegisterTask(&taskq.TaskOptions{
-			Name: "bench",
-			Handler: func() {
-				wg.Done()
-			},
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for j := range msgs {
+					msgs[j] = bq.task.WithArgs(ctx, j)
+				}
+				if err := bq.q.AddN(ctx, msgs...); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+			bq.wait(b, b.N*batchSize)
 		})
+	}
+}
 
-		_ = q.Consumer().Start(c)
-	})
+// BenchmarkConsume measures throughput of adding and consuming messages.
+// One op is one message handled by the consumer.
+func BenchmarkConsume(b *testing.B) {
+	const batchSize = 100
+
+	for _, backend := range benchBackends {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			ctx := context.Background()
+			bq := newBenchQueue(b, backend.newFactory)
+			defer bq.close(b)
+			atomic.StoreUint32(&bq.want, uint32(b.N))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+
+			bq.start(b)
+			msgs := make([]*taskq.Message, 0, batchSize)
+			for i := 0; i < b.N; i++ {
+				msgs = append(msgs, bq.task.WithArgs(ctx, i))
+				if len(msgs) == batchSize || i == b.N-1 {
+					if err := bq.q.AddN(ctx, msgs...); err != nil {
+						b.Fatal(err)
+					}
+					msgs = msgs[:0]
+				}
+			}
+			bq.wait(b, b.N)
+
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+		})
+	}
+}
 
+// BenchmarkConsumeReserved measures throughput and allocations of the
+// consumer alone: messages are added before the timer starts, so one op
+// is one message reserved, handled and deleted. memqueue is skipped,
+// because its consumer handles the messages added by the producer.
+func BenchmarkConsumeReserved(b *testing.B) {
+	const batchSize = 10
+
+	for _, backend := range benchBackends {
+		backend := backend
+		if backend.name == "memqueue" {
+			continue
+		}
+		b.Run(backend.name, func(b *testing.B) {
+			ctx := context.Background()
+			bq := newBenchQueue(b, backend.newFactory)
+			defer bq.close(b)
+
+			msgs := make([]*taskq.Message, 0, batchSize)
+			for i := 0; i < b.N; i++ {
+				msgs = append(msgs, bq.task.WithArgs(ctx, i))
+				if len(msgs) == batchSize || i == b.N-1 {
+					if err := bq.q.AddN(ctx, msgs...); err != nil {
+						b.Fatal(err)
+					}
+					msgs = msgs[:0]
+				}
+			}
+			atomic.StoreUint32(&bq.want, uint32(b.N))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+
+			bq.start(b)
+			bq.wait(b, b.N)
+
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+		})
+	}
+}
+
+func BenchmarkMessageMarshal(b *testing.B) {
+	ctx := context.Background()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		msg := taskq.NewMessage(ctx, "hello", i)
+		msg.TaskName = "bench"
+		if _, err := msg.MarshalBinary(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMessageMarshalPooled(b *testing.B) {
+	ctx := context.Background()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		msg := taskq.AcquireMessage(ctx, "hello", i)
+		msg.TaskName = "bench"
+		if _, err := msg.MarshalBinary(); err != nil {
+			b.Fatal(err)
+		}
+		taskq.ReleaseMessage(msg)
+	}
+}
+
+func BenchmarkMessageUnmarshal(b *testing.B) {
+	ctx := context.Background()
+	msg := taskq.NewMessage(ctx, "hello", 42)
+	msg.TaskName = "bench"
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		for j := 0; j < 100; j++ {
-			wg.Add(1)
-			_ = q.Add(task.WithArgs(c))
+		msg := new(taskq.Message)
+		if err := msg.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
 		}
-		wg.Wait()
 	}
 }
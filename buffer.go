@@ -0,0 +1,223 @@
+package taskq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// msgBuffer is a bounded multi-producer multi-consumer FIFO queue that hands
+// reserved messages from fetchers over to workers. Unlike a buffered channel
+// it does not take a lock when there are messages to get or room to put,
+// so fetchers and workers don't contend on it at high worker counts.
+// The ring is based on Dmitry Vyukov's bounded MPMC queue.
+//
+// The ring size is a power of two, so the number of messages is limited
+// separately to the requested size.
+type msgBuffer struct {
+	_    cacheLinePad
+	head uint64 // atomic, next position to get
+	_    cacheLinePad
+	tail uint64 // atomic, next position to put
+	_    cacheLinePad
+
+	size  int64
+	len   int64 // atomic, messages that are put or being put
+	mask  uint64
+	cells []bufferCell
+
+	// Getters and putters only block on channels when the buffer
+	// is empty or full.
+	getWaiters int32 // atomic
+	putWaiters int32 // atomic
+	notEmpty   chan struct{}
+	notFull    chan struct{}
+}
+
+type cacheLinePad [64]byte
+
+type bufferCell struct {
+	seq uint64 // atomic
+	msg *Message
+}
+
+// newMsgBuffer returns a buffer that holds up to size messages.
+// The ring is rounded up to a power of two and is at least 2 cells,
+// because a single cell can't tell an empty buffer from a full one.
+func newMsgBuffer(size int) *msgBuffer {
+	if size < 1 {
+		size = 1
+	}
+	n := 2
+	for n < size {
+		n <<= 1
+	}
+
+	b := &msgBuffer{
+		size:     int64(size),
+		mask:     uint64(n - 1),
+		cells:    make([]bufferCell, n),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+	for i := range b.cells {
+		b.cells[i].seq = uint64(i)
+	}
+	return b
+}
+
+// Len returns the approximate number of buffered messages.
+func (b *msgBuffer) Len() int {
+	return int(atomic.LoadInt64(&b.len))
+}
+
+func (b *msgBuffer) Cap() int {
+	return int(b.size)
+}
+
+// TryPut adds the message unless the buffer is full.
+func (b *msgBuffer) TryPut(msg *Message) bool {
+	if !b.tryReserve() {
+		return false
+	}
+	b.put(msg)
+	return true
+}
+
+// tryReserve takes room for one message unless the buffer is full.
+// The message must be added with put.
+func (b *msgBuffer) tryReserve() bool {
+	for {
+		n := atomic.LoadInt64(&b.len)
+		if n >= b.size {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.len, n, n+1) {
+			return true
+		}
+	}
+}
+
+// put adds the message to the room taken with tryReserve. The ring has
+// at least as many cells as reserved messages, and a cell is freed before
+// its room is given back, so the cell at the tail is always free.
+func (b *msgBuffer) put(msg *Message) {
+	pos := atomic.LoadUint64(&b.tail)
+	for {
+		cell := &b.cells[pos&b.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		if seq == pos && atomic.CompareAndSwapUint64(&b.tail, pos, pos+1) {
+			cell.msg = msg
+			atomic.StoreUint64(&cell.seq, pos+1)
+			b.signal(&b.getWaiters, b.notEmpty)
+			if b.Len() < b.Cap() {
+				b.signal(&b.putWaiters, b.notFull)
+			}
+			return
+		}
+		pos = atomic.LoadUint64(&b.tail)
+	}
+}
+
+// TryGet returns the next message or nil if the buffer is empty.
+func (b *msgBuffer) TryGet() *Message {
+	pos := atomic.LoadUint64(&b.head)
+	for {
+		cell := &b.cells[pos&b.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch dif := int64(seq - (pos + 1)); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&b.head, pos, pos+1) {
+				msg := cell.msg
+				cell.msg = nil
+				atomic.StoreUint64(&cell.seq, pos+b.mask+1)
+				atomic.AddInt64(&b.len, -1)
+				b.signal(&b.putWaiters, b.notFull)
+				// Signals are coalesced so pass the wake up
+				// to the next getter if there are more messages.
+				if b.Len() > 0 {
+					b.signal(&b.getWaiters, b.notEmpty)
+				}
+				return msg
+			}
+			pos = atomic.LoadUint64(&b.head)
+		case dif < 0:
+			return nil
+		default:
+			pos = atomic.LoadUint64(&b.head)
+		}
+	}
+}
+
+// Put adds the message waiting for room until timeout fires or done
+// is closed. A nil timeout and done wait forever.
+func (b *msgBuffer) Put(msg *Message, timeout <-chan time.Time, done <-chan struct{}) bool {
+	if !b.reserve(timeout, done) {
+		return false
+	}
+	b.put(msg)
+	return true
+}
+
+// reserve is like tryReserve, but waits for room until timeout fires
+// or done is closed.
+func (b *msgBuffer) reserve(timeout <-chan time.Time, done <-chan struct{}) bool {
+	for {
+		if b.tryReserve() {
+			return true
+		}
+
+		atomic.AddInt32(&b.putWaiters, 1)
+		if b.tryReserve() {
+			atomic.AddInt32(&b.putWaiters, -1)
+			return true
+		}
+
+		select {
+		case <-b.notFull:
+			atomic.AddInt32(&b.putWaiters, -1)
+		case <-timeout:
+			atomic.AddInt32(&b.putWaiters, -1)
+			return false
+		case <-done:
+			atomic.AddInt32(&b.putWaiters, -1)
+			return false
+		}
+	}
+}
+
+// Get returns the next message waiting until timeout fires or stop
+// is closed. It reports whether it returned because of the timeout.
+func (b *msgBuffer) Get(timeout <-chan time.Time, stop <-chan struct{}) (*Message, bool) {
+	for {
+		if msg := b.TryGet(); msg != nil {
+			return msg, false
+		}
+
+		atomic.AddInt32(&b.getWaiters, 1)
+		if msg := b.TryGet(); msg != nil {
+			atomic.AddInt32(&b.getWaiters, -1)
+			return msg, false
+		}
+
+		select {
+		case <-b.notEmpty:
+			atomic.AddInt32(&b.getWaiters, -1)
+		case <-timeout:
+			atomic.AddInt32(&b.getWaiters, -1)
+			return nil, true
+		case <-stop:
+			atomic.AddInt32(&b.getWaiters, -1)
+			return nil, false
+		}
+	}
+}
+
+func (b *msgBuffer) signal(waiters *int32, ch chan struct{}) {
+	if atomic.LoadInt32(waiters) == 0 {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package chaos_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/chaos"
+	"github.com/frain-dev/taskq/v3/taskqtest"
+)
+
+// sliceQueue is a queue that keeps messages in memory.
+type sliceQueue struct {
+	opt *taskq.QueueOptions
+
+	mu      sync.Mutex
+	msgs    []taskq.Message
+	deleted int
+}
+
+var _ taskq.Queue = (*sliceQueue)(nil)
+
+func newSliceQueue(opt *taskq.QueueOptions) *sliceQueue {
+	opt.Init()
+	return &sliceQueue{opt: opt}
+}
+
+func (q *sliceQueue) String() string                   { return q.opt.Name }
+func (q *sliceQueue) Name() string                     { return q.opt.Name }
+func (q *sliceQueue) Options() *taskq.QueueOptions     { return q.opt }
+func (q *sliceQueue) Consumer() taskq.QueueConsumer    { return nil }
+func (q *sliceQueue) Release(*taskq.Message) error     { return nil }
+func (q *sliceQueue) Purge() error                     { return nil }
+func (q *sliceQueue) Close() error                     { return nil }
+func (q *sliceQueue) CloseTimeout(time.Duration) error { return nil }
+
+func (q *sliceQueue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs), nil
+}
+
+func (q *sliceQueue) Add(msg *taskq.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.msgs = append(q.msgs, *msg)
+	return nil
+}
+
+func (q *sliceQueue) AddN(_ context.Context, msgs ...*taskq.Message) error {
+	for _, msg := range msgs {
+		_ = q.Add(msg)
+	}
+	return nil
+}
+
+func (q *sliceQueue) ReserveN(_ context.Context, n int, _ time.Duration) ([]taskq.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > len(q.msgs) {
+		n = len(q.msgs)
+	}
+	msgs := q.msgs[:n]
+	q.msgs = q.msgs[n:]
+	return msgs, nil
+}
+
+func (q *sliceQueue) Delete(*taskq.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deleted++
+	return nil
+}
+
+func (q *sliceQueue) numDeleted() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.deleted
+}
+
+func addMessages(t *testing.T, q taskq.Queue, n int) {
+	for i := 0; i < n; i++ {
+		msg := taskq.NewMessage(context.Background(), i)
+		msg.TaskName = "test"
+		if err := q.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDropReserve(t *testing.T) {
+	q := chaos.NewQueue(newSliceQueue(&taskq.QueueOptions{Name: "test"}), &chaos.Options{
+		DropReserve: 1,
+	})
+	addMessages(t, q, 3)
+
+	msgs, err := q.ReserveN(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("got %d messages, wanted 0", len(msgs))
+	}
+	if got := q.Stats().DroppedReserves; got != 3 {
+		t.Fatalf("got %d dropped reserves, wanted 3", got)
+	}
+}
+
+func TestDuplicateDelivery(t *testing.T) {
+	q := chaos.NewQueue(newSliceQueue(&taskq.QueueOptions{Name: "test"}), &chaos.Options{
+		DuplicateDelivery: 1,
+	})
+	addMessages(t, q, 2)
+
+	msgs, err := q.ReserveN(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, wanted 4", len(msgs))
+	}
+	if got := q.Stats().DuplicatedMessages; got != 2 {
+		t.Fatalf("got %d duplicated messages, wanted 2", got)
+	}
+
+	q.SetEnabled(false)
+	addMessages(t, q, 2)
+	msgs, _ = q.ReserveN(context.Background(), 10, 0)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages with faults disabled, wanted 2", len(msgs))
+	}
+}
+
+func TestDeleteDelay(t *testing.T) {
+	clock := taskqtest.NewClock(time.Now())
+	sq := newSliceQueue(&taskq.QueueOptions{Name: "test", Clock: clock})
+	q := chaos.NewQueue(sq, &chaos.Options{
+		DeleteDelay: time.Minute,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		_ = q.Delete(taskq.NewMessage(context.Background()))
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	if sq.numDeleted() != 0 {
+		t.Fatal("message is deleted before the delay")
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Delete did not return")
+	}
+	if sq.numDeleted() != 1 {
+		t.Fatal("message is not deleted")
+	}
+}
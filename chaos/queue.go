@@ -0,0 +1,210 @@
+// Package chaos injects faults into queues to validate that handlers
+// are idempotent and retry-safe, e.g. in tests and in staging.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+	"github.com/frain-dev/taskq/v3/internal/msgutil"
+)
+
+type Options struct {
+	// Fraction of reserved messages that are dropped instead of delivered,
+	// e.g. 0.1 drops 10% of messages. Dropped messages are redelivered by
+	// the backend after ReservationTimeout like messages of a crashed consumer.
+	DropReserve float64
+	// Fraction of reserved messages that are delivered twice.
+	DuplicateDelivery float64
+	// Duration by which deleting processed messages is delayed.
+	DeleteDelay time.Duration
+	// Seed of the random source. The default is the current time.
+	Seed int64
+}
+
+func (opt *Options) init() {
+	if opt.Seed == 0 {
+		opt.Seed = time.Now().UnixNano()
+	}
+}
+
+// Stats counts injected faults.
+type Stats struct {
+	DroppedReserves    uint32
+	DuplicatedMessages uint32
+	DelayedDeletes     uint32
+}
+
+// Queue wraps a queue and injects faults into messages reserved and deleted
+// by its consumer. Faults are only injected for backends that support
+// ReserveN, i.e. redisq, azsqs and ironmq, and not memqueue.
+type Queue struct {
+	opt      *Options
+	q        taskq.Queue
+	consumer *taskq.Consumer
+
+	disabled uint32
+	stats    Stats
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+var (
+	_ taskq.Queue   = (*Queue)(nil)
+	_ taskq.Toucher = (*Queue)(nil)
+)
+
+// NewQueue returns a queue that injects faults into the queue. Messages must
+// be consumed using the consumer of the returned queue.
+func NewQueue(q taskq.Queue, opt *Options) *Queue {
+	if opt == nil {
+		opt = new(Options)
+	}
+	opt.init()
+
+	cq := &Queue{
+		opt:  opt,
+		q:    q,
+		rand: rand.New(rand.NewSource(opt.Seed)),
+	}
+	cq.consumer = taskq.NewConsumer(cq)
+	return cq
+}
+
+func (q *Queue) Name() string {
+	return q.q.Name()
+}
+
+func (q *Queue) String() string {
+	return fmt.Sprintf("queue=%q", q.Name())
+}
+
+func (q *Queue) Options() *taskq.QueueOptions {
+	return q.q.Options()
+}
+
+func (q *Queue) Consumer() taskq.QueueConsumer {
+	return q.consumer
+}
+
+// Unwrap returns the wrapped queue.
+func (q *Queue) Unwrap() taskq.Queue {
+	return q.q
+}
+
+// SetEnabled enables or disables fault injection, e.g. to turn it off
+// in staging without redeploying. Faults are enabled by default.
+func (q *Queue) SetEnabled(enabled bool) {
+	var disabled uint32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreUint32(&q.disabled, disabled)
+}
+
+func (q *Queue) enabled() bool {
+	return atomic.LoadUint32(&q.disabled) == 0
+}
+
+// Stats returns the number of injected faults.
+func (q *Queue) Stats() *Stats {
+	return &Stats{
+		DroppedReserves:    atomic.LoadUint32(&q.stats.DroppedReserves),
+		DuplicatedMessages: atomic.LoadUint32(&q.stats.DuplicatedMessages),
+		DelayedDeletes:     atomic.LoadUint32(&q.stats.DelayedDeletes),
+	}
+}
+
+func (q *Queue) Len() (int, error) {
+	return q.q.Len()
+}
+
+func (q *Queue) Add(msg *taskq.Message) error {
+	return q.q.Add(msg)
+}
+
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	return q.q.AddN(ctx, msgs...)
+}
+
+// ReserveN reserves messages in the wrapped queue and then
+// drops and duplicates some of them.
+func (q *Queue) ReserveN(
+	ctx context.Context, n int, waitTimeout time.Duration,
+) ([]taskq.Message, error) {
+	msgs, err := q.q.ReserveN(ctx, n, waitTimeout)
+	if err != nil || !q.enabled() {
+		return msgs, err
+	}
+
+	out := msgs[:0:0]
+	for i := range msgs {
+		if q.chance(q.opt.DropReserve) {
+			atomic.AddUint32(&q.stats.DroppedReserves, 1)
+			internal.Logger.Printf("chaos: %s: dropped %s", q, msgutil.LogString(q, &msgs[i]))
+			continue
+		}
+		out = append(out, msgs[i])
+		if q.chance(q.opt.DuplicateDelivery) {
+			atomic.AddUint32(&q.stats.DuplicatedMessages, 1)
+			out = append(out, msgs[i])
+		}
+	}
+	return out, nil
+}
+
+func (q *Queue) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rand.Float64() < p
+}
+
+func (q *Queue) Release(msg *taskq.Message) error {
+	return q.q.Release(msg)
+}
+
+// Delete deletes the message from the wrapped queue after DeleteDelay.
+func (q *Queue) Delete(msg *taskq.Message) error {
+	if q.opt.DeleteDelay > 0 && q.enabled() {
+		atomic.AddUint32(&q.stats.DelayedDeletes, 1)
+		clock := q.Options().Clock
+		if clock == nil {
+			clock = taskq.SystemClock
+		}
+		clock.Sleep(q.opt.DeleteDelay)
+	}
+	return q.q.Delete(msg)
+}
+
+func (q *Queue) Touch(ctx context.Context, msg *taskq.Message) error {
+	if toucher, ok := q.q.(taskq.Toucher); ok {
+		return toucher.Touch(ctx, msg)
+	}
+	return taskq.ErrNotSupported
+}
+
+func (q *Queue) Purge() error {
+	return q.q.Purge()
+}
+
+func (q *Queue) Close() error {
+	return q.CloseTimeout(30 * time.Second)
+}
+
+// CloseTimeout stops the consumer and closes the wrapped queue.
+func (q *Queue) CloseTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	_ = q.consumer.Stop(ctx)
+	cancel()
+	return q.q.CloseTimeout(timeout)
+}
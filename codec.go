@@ -0,0 +1,67 @@
+package taskq
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoders and decoders are reused across messages because allocating
+// them and growing their buffers dominates the cost of small messages.
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return newMessageBuffers()
+	},
+}
+
+// marshal is like msgpack.Marshal, but reuses the encoder and its buffer
+// so the only allocation is the returned slice.
+func marshal(v interface{}) ([]byte, error) {
+	bufs := encoderPool.Get().(*messageBuffers)
+	b, err := bufs.encode(&bufs.msg, v)
+	if err == nil {
+		b = append(make([]byte, 0, len(b)), b...)
+	}
+	if bufs.msg.Cap() <= maxPooledBufferSize {
+		encoderPool.Put(bufs)
+	}
+	return b, err
+}
+
+type decoder struct {
+	r   bytes.Reader
+	dec *msgpack.Decoder
+}
+
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return &decoder{
+			dec: msgpack.NewDecoder(nil),
+		}
+	},
+}
+
+// getDecoder returns a decoder that reads b. The decoder must be returned
+// with putDecoder.
+func getDecoder(b []byte) *decoder {
+	d := decoderPool.Get().(*decoder)
+	d.r.Reset(b)
+	d.dec.Reset(&d.r)
+	return d
+}
+
+func putDecoder(d *decoder) {
+	d.r.Reset(nil)
+	d.dec.Reset(&d.r)
+	decoderPool.Put(d)
+}
+
+// unmarshal is like msgpack.Unmarshal, but reuses the decoder.
+func unmarshal(b []byte, v interface{}) error {
+	d := getDecoder(b)
+	err := d.dec.Decode(v)
+	putDecoder(d)
+	return err
+}
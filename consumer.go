@@ -10,15 +10,21 @@ import (
 
 	"github.com/go-redis/redis_rate/v9"
 
-	"github.com/bsm/redislock"
-
 	"github.com/frain-dev/taskq/v3/internal"
+	"github.com/frain-dev/taskq/v3/internal/lease"
 )
 
 const stopTimeout = 30 * time.Second
 
 var ErrAsyncTask = errors.New("taskq: async task")
 
+// ErrStopped is returned when stopping the consumer that is not started.
+var ErrStopped = errors.New("taskq: consumer is stopped")
+
+// ErrStarted is returned when starting the consumer that is already started,
+// e.g. memqueue consumers that are started when the queue is registered.
+var ErrStarted = errors.New("taskq: consumer is already started")
+
 type Delayer interface {
 	Delay() time.Duration
 }
@@ -30,11 +36,44 @@ type ConsumerStats struct {
 	BufferSize uint32
 	Buffered   uint32
 
-	InFlight  uint32
-	Processed uint32
-	Retries   uint32
-	Fails     uint32
-	Timing    time.Duration
+	InFlight uint32
+
+	// Counters since the consumer was created or ResetStats was called.
+	Processed uint64
+	Retries   uint64
+	Fails     uint64
+	// Number of processed messages that were already processed before,
+	// e.g. redelivered after a crash or an expired reservation.
+	// Counted only with QueueOptions.DetectDuplicateDeliveries.
+	Duplicates uint64
+
+	Timing time.Duration
+}
+
+// Delta returns the stats with counters that changed since prev was taken,
+// e.g. to report rates. Other fields are copied from s. If the counters were
+// reset after prev was taken, they are returned as is.
+func (s *ConsumerStats) Delta(prev *ConsumerStats) *ConsumerStats {
+	d := *s
+	if prev == nil ||
+		s.Processed < prev.Processed ||
+		s.Retries < prev.Retries ||
+		s.Fails < prev.Fails ||
+		s.Duplicates < prev.Duplicates {
+		return &d
+	}
+	d.Processed -= prev.Processed
+	d.Retries -= prev.Retries
+	d.Fails -= prev.Fails
+	d.Duplicates -= prev.Duplicates
+	return &d
+}
+
+type consumerCounters struct {
+	processed  uint64
+	retries    uint64
+	fails      uint64
+	duplicates uint64
 }
 
 //------------------------------------------------------------------------------
@@ -49,15 +88,42 @@ const (
 // Consumer reserves messages from the queue, processes them,
 // and then either releases or deletes messages from the queue.
 type Consumer struct {
+	// 64-bit atomic counters are first so they are aligned on 32-bit platforms.
+	counters consumerCounters
+	// Counters at the time of the last ResetStats.
+	resetAt consumerCounters
+
 	q   Queue
 	opt *QueueOptions
 
-	buffer  chan *Message // never closed
+	buffer  *msgBuffer
 	limiter *limiter
+	pacer   *pacer
+	workers *lease.Semaphore
+	fleet   fleetPause
+
+	// Id registered by the heartbeat.
+	instanceID string
+	sticky     stickyRouter
+
+	// Worker lease held by fifoWorker.
+	fifoLease *lease.Lease
 
 	startStopMu sync.Mutex
 	state       int32 // atomic
 	stopCh      chan struct{}
+	// Context passed to Start that is used by workers added
+	// with UpdateOptions.
+	startCtx context.Context
+
+	updateMu   sync.Mutex
+	autotuneCh chan struct{}
+	retry      atomic.Value // *retryOptions
+
+	// Worker range that is changed by UpdateOptions without
+	// changing the queue options. Guarded by updateMu.
+	minNumWorker int32
+	maxNumWorker int32
 
 	cfgs       *configRoulette
 	numWorker  int32 // atomic
@@ -69,11 +135,8 @@ type Consumer struct {
 	consecutiveNumErr uint32
 	queueEmptyVote    int32
 
-	inFlight  uint32
-	processed uint32
-	fails     uint32
-	retries   uint32
-	timings   sync.Map
+	inFlight uint32
+	timings  sync.Map
 
 	hooks []ConsumerHook
 }
@@ -82,16 +145,33 @@ type Consumer struct {
 func NewConsumer(q Queue) *Consumer {
 	opt := q.Options()
 	c := &Consumer{
-		q:   q,
-		opt: opt,
+		q:          q,
+		instanceID: newInstanceID(),
+		opt:        opt,
+
+		buffer: newMsgBuffer(opt.BufferSize),
+
+		limiter: newLimiter(
+			internal.Namespaced(opt.Namespace, ":", q.Name()),
+			opt.RateLimiter,
+			opt.RateLimit,
+		),
+		pacer: &pacer{
+			clock:    opt.Clock,
+			interval: opt.PaceInterval,
+		},
 
-		buffer: make(chan *Message, opt.BufferSize),
+		minNumWorker: opt.MinNumWorker,
+		maxNumWorker: opt.MaxNumWorker,
 
-		limiter: &limiter{
-			bucket:  q.Name(),
-			limiter: opt.RateLimiter,
-			limit:   opt.RateLimit,
-		},
+		autotuneCh: make(chan struct{}, 1),
+	}
+	if opt.WorkerLimit > 0 && opt.Redis != nil {
+		key := internal.Namespaced(opt.Namespace, ":", fmt.Sprintf("taskq:{%s}:workers", q.Name()))
+		c.workers = lease.NewSemaphore(opt.Redis, key, &lease.Options{
+			Limit: int(opt.WorkerLimit),
+			TTL:   opt.ReservationTimeout + 10*time.Second,
+		})
 	}
 	return c
 }
@@ -119,7 +199,7 @@ func (c *Consumer) Options() *QueueOptions {
 }
 
 func (c *Consumer) Len() int {
-	return len(c.buffer)
+	return c.buffer.Len()
 }
 
 // Stats returns processor stats.
@@ -128,35 +208,100 @@ func (c *Consumer) Stats() *ConsumerStats {
 		NumWorker:  uint32(atomic.LoadInt32(&c.numWorker)),
 		NumFetcher: uint32(atomic.LoadInt32(&c.numFetcher)),
 
-		BufferSize: uint32(cap(c.buffer)),
-		Buffered:   uint32(len(c.buffer)),
+		BufferSize: uint32(c.buffer.Cap()),
+		Buffered:   uint32(c.buffer.Len()),
+
+		InFlight: atomic.LoadUint32(&c.inFlight),
 
-		InFlight:  atomic.LoadUint32(&c.inFlight),
-		Processed: atomic.LoadUint32(&c.processed),
-		Retries:   atomic.LoadUint32(&c.retries),
-		Fails:     atomic.LoadUint32(&c.fails),
+		Processed: atomic.LoadUint64(&c.counters.processed) - atomic.LoadUint64(&c.resetAt.processed),
+		Retries:   atomic.LoadUint64(&c.counters.retries) - atomic.LoadUint64(&c.resetAt.retries),
+		Fails:     atomic.LoadUint64(&c.counters.fails) - atomic.LoadUint64(&c.resetAt.fails),
+		Duplicates: atomic.LoadUint64(&c.counters.duplicates) -
+			atomic.LoadUint64(&c.resetAt.duplicates),
 
 		Timing: c.timing(),
 	}
 }
 
+// ResetStats resets the counters returned by Stats. It does not affect
+// the autotuning that relies on the totals.
+func (c *Consumer) ResetStats() {
+	atomic.StoreUint64(&c.resetAt.processed, atomic.LoadUint64(&c.counters.processed))
+	atomic.StoreUint64(&c.resetAt.retries, atomic.LoadUint64(&c.counters.retries))
+	atomic.StoreUint64(&c.resetAt.fails, atomic.LoadUint64(&c.counters.fails))
+	atomic.StoreUint64(&c.resetAt.duplicates, atomic.LoadUint64(&c.counters.duplicates))
+}
+
 func (c *Consumer) Add(msg *Message) error {
 	_ = c.limiter.Reserve(msg.Ctx, 1)
-	c.buffer <- msg
+	c.buffer.Put(msg, nil, nil)
+	return nil
+}
+
+// AddContext is like Add, but stops waiting for room in the buffer
+// when the context is done.
+func (c *Consumer) AddContext(ctx context.Context, msg *Message) error {
+	if ctx == nil {
+		return c.Add(msg)
+	}
+	return c.AddContextFunc(ctx, msg, nil)
+}
+
+// AddContextFunc is like AddContext, but calls fn once there is room
+// for the message in the buffer and before workers can get it.
+// fn is not called when the message is not added.
+func (c *Consumer) AddContextFunc(ctx context.Context, msg *Message, fn func()) error {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	_ = c.limiter.Reserve(ctx, 1)
+	if !c.buffer.reserve(nil, done) {
+		c.limiter.Cancel(1)
+		return ctx.Err()
+	}
+	if fn != nil {
+		fn()
+	}
+	c.buffer.put(msg)
 	return nil
 }
 
 // Start starts consuming messages in the queue.
 func (c *Consumer) Start(ctx context.Context) error {
-	if err := c.start(); err != nil {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	if err := c.start(ctx); err != nil {
 		return err
 	}
+	c.cfgs = nil
 
-	if c.opt.MinNumWorker < c.opt.MaxNumWorker {
-		c.cfgs = newConfigRoulette(c.opt)
+	if c.opt.Redis != nil {
+		stopCh := c.stopCh
+		c.fetchersWG.Add(1)
+		go func() {
+			defer c.fetchersWG.Done()
+			c.heartbeat(ctx, stopCh)
+		}()
+	}
+
+	if c.opt.StrictFIFO {
+		atomic.StoreInt32(&c.numWorker, 1)
+		c.workersWG.Add(1)
+		go func() {
+			defer c.workersWG.Done()
+			c.fifoWorker(ctx)
+		}()
+		return nil
+	}
+
+	if c.minNumWorker < c.maxNumWorker {
+		c.cfgs = newConfigRoulette(c.opt, c.minNumWorker, c.maxNumWorker)
 		cfg := c.cfgs.Select(&consumerConfig{
 			NumFetcher: c.opt.MaxNumFetcher,
-			NumWorker:  c.opt.MaxNumWorker})
+			NumWorker:  c.maxNumWorker})
 		c.replaceConfig(ctx, cfg)
 
 		c.fetchersWG.Add(1)
@@ -167,14 +312,14 @@ func (c *Consumer) Start(ctx context.Context) error {
 	} else {
 		c.replaceConfig(ctx, &consumerConfig{
 			NumFetcher: 0, // fetcher is automatically started when needed
-			NumWorker:  c.opt.MinNumWorker,
+			NumWorker:  c.minNumWorker,
 		})
 	}
 
 	return nil
 }
 
-func (c *Consumer) start() error {
+func (c *Consumer) start(ctx context.Context) error {
 	c.startStopMu.Lock()
 	defer c.startStopMu.Unlock()
 
@@ -182,8 +327,9 @@ func (c *Consumer) start() error {
 	case stateInit:
 		atomic.StoreInt32(&c.state, stateStarted)
 		c.stopCh = make(chan struct{})
+		c.startCtx = ctx
 	case stateStarted:
-		return fmt.Errorf("taskq: Consumer is already started")
+		return ErrStarted
 	case stateStoppingFetchers, stateStoppingWorkers:
 		return fmt.Errorf("taskq: Consumer is stopping")
 	}
@@ -194,20 +340,16 @@ func (c *Consumer) start() error {
 	return nil
 }
 
-// Stop is StopTimeout with 30 seconds timeout.
-func (c *Consumer) Stop() error {
-	return c.StopTimeout(stopTimeout)
-}
-
-// StopTimeout waits workers for timeout duration to finish processing current
-// messages and stops workers.
-func (c *Consumer) StopTimeout(timeout time.Duration) error {
+// Stop stops fetchers and waits for workers to finish processing current
+// messages until the context is done. Use context.WithTimeout to limit
+// the time spent waiting.
+func (c *Consumer) Stop(ctx context.Context) error {
 	c.startStopMu.Lock()
 	defer c.startStopMu.Unlock()
 
 	switch atomic.LoadInt32(&c.state) {
 	case stateInit:
-		return fmt.Errorf("taskq: Consumer is not started")
+		return ErrStopped
 	case stateStarted:
 		atomic.StoreInt32(&c.state, stateStoppingFetchers)
 		close(c.stopCh)
@@ -222,9 +364,6 @@ func (c *Consumer) StopTimeout(timeout time.Duration) error {
 		atomic.StoreInt32(&c.state, stateInit)
 	}()
 
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
-
 	done := make(chan struct{}, 1)
 	go func() {
 		c.fetchersWG.Wait()
@@ -234,8 +373,8 @@ func (c *Consumer) StopTimeout(timeout time.Duration) error {
 	var firstErr error
 	select {
 	case <-done:
-	case <-timer.C:
-		firstErr = fmt.Errorf("taskq: %s: fetchers are not stopped after %s", c, timeout)
+	case <-ctx.Done():
+		firstErr = fmt.Errorf("taskq: %s: fetchers are not stopped: %w", c, ctx.Err())
 	}
 
 	if !atomic.CompareAndSwapInt32(&c.state, stateStoppingFetchers, stateStoppingWorkers) {
@@ -252,8 +391,8 @@ func (c *Consumer) StopTimeout(timeout time.Duration) error {
 
 	select {
 	case <-done:
-	case <-timer.C:
-		return fmt.Errorf("taskq: %s: workers are not stopped after %s", c, timeout)
+	case <-ctx.Done():
+		return fmt.Errorf("taskq: %s: workers are not stopped: %w", c, ctx.Err())
 	}
 
 	return nil
@@ -267,6 +406,17 @@ func (c *Consumer) paused() time.Duration {
 	return time.Minute
 }
 
+// sleep waits for the duration or until the consumer is stopped.
+func (c *Consumer) sleep(d time.Duration) {
+	timer := c.opt.Clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-c.stopCh:
+	}
+}
+
 func (c *Consumer) addWorker(ctx context.Context, id int32) bool {
 	c.startStopMu.Lock()
 	defer c.startStopMu.Unlock()
@@ -333,7 +483,9 @@ func (c *Consumer) ProcessAll(ctx context.Context) error {
 		time.Sleep(time.Second)
 	}
 
-	return c.Stop()
+	ctx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+	return c.Stop(ctx)
 }
 
 // ProcessOne processes at most one message in the queue.
@@ -349,19 +501,17 @@ func (c *Consumer) ProcessOne(ctx context.Context) error {
 }
 
 func (c *Consumer) reserveOne(ctx context.Context) (*Message, error) {
-	select {
-	case msg := <-c.buffer:
+	if msg := c.buffer.TryGet(); msg != nil {
 		return msg, nil
-	default:
 	}
 
 	msgs, err := c.q.ReserveN(ctx, 1, c.opt.WaitTimeout)
-	if err != nil && err != internal.ErrNotSupported {
+	if err != nil && !errors.Is(err, ErrNotSupported) {
 		return nil, err
 	}
 
 	if len(msgs) == 0 {
-		return nil, errors.New("taskq: queue is empty")
+		return nil, ErrQueueEmpty
 	}
 	if len(msgs) != 1 {
 		return nil, fmt.Errorf("taskq: queue returned %d messages", len(msgs))
@@ -371,7 +521,7 @@ func (c *Consumer) reserveOne(ctx context.Context) (*Message, error) {
 }
 
 func (c *Consumer) fetcher(ctx context.Context, fetcherID int32) {
-	timer := time.NewTimer(time.Minute)
+	timer := c.opt.Clock.NewTimer(time.Minute)
 	timer.Stop()
 
 	fetchTimeout := c.opt.ReservationTimeout
@@ -384,14 +534,19 @@ func (c *Consumer) fetcher(ctx context.Context, fetcherID int32) {
 
 		if pauseTime := c.paused(); pauseTime > 0 {
 			internal.Logger.Printf("%s is automatically paused for dur=%s", c, pauseTime)
-			time.Sleep(pauseTime)
+			c.opt.Clock.Sleep(pauseTime)
 			c.resetPause()
 			continue
 		}
 
+		if c.fleetPaused(ctx) {
+			c.sleep(pauseCheckInterval)
+			continue
+		}
+
 		timeout, err := c.fetchMessages(ctx, timer, fetchTimeout)
 		if err != nil {
-			if err == internal.ErrNotSupported {
+			if errors.Is(err, ErrNotSupported) {
 				atomic.StoreInt32(&c.numFetcher, -1)
 				continue
 			}
@@ -400,7 +555,7 @@ func (c *Consumer) fetcher(ctx context.Context, fetcherID int32) {
 			internal.Logger.Printf(
 				"%s fetchMessages failed: %s (sleeping for dur=%s)",
 				c, err, backoff)
-			time.Sleep(backoff)
+			c.opt.Clock.Sleep(backoff)
 			continue
 		}
 		if timeout {
@@ -410,9 +565,15 @@ func (c *Consumer) fetcher(ctx context.Context, fetcherID int32) {
 }
 
 func (c *Consumer) fetchMessages(
-	ctx context.Context, timer *time.Timer, timeout time.Duration,
+	ctx context.Context, timer Timer, timeout time.Duration,
 ) (bool, error) {
-	size := c.limiter.Reserve(ctx, c.opt.ReservationSize)
+	n := c.fetchSize()
+	if n <= 0 {
+		// Workers can't process more messages before reservation expires.
+		c.sleep(fetchBackoff(c.timing()))
+		return false, nil
+	}
+	size := c.limiter.Reserve(ctx, n)
 
 	msgs, err := c.q.ReserveN(ctx, size, c.opt.WaitTimeout)
 	if err != nil {
@@ -430,9 +591,7 @@ func (c *Consumer) fetchMessages(
 	for i := range msgs {
 		msg := &msgs[i]
 
-		select {
-		case c.buffer <- msg:
-		case <-timer.C:
+		if !c.buffer.Put(msg, timer.C(), nil) {
 			for i := range msgs[i:] {
 				_ = c.q.Release(&msgs[i])
 			}
@@ -441,29 +600,68 @@ func (c *Consumer) fetchMessages(
 	}
 
 	if !timer.Stop() {
-		<-timer.C
+		<-timer.C()
 	}
 
 	return false, nil
 }
 
+// fetchSize returns the number of messages to reserve. It is limited by
+// the number of messages workers can process within ReservationTimeout
+// given the average handler duration and the messages that are already
+// buffered or being processed. Idle workers always get a message.
+// Until the handler duration is known only idle workers get messages.
+func (c *Consumer) fetchSize() int {
+	size := c.opt.ReservationSize
+
+	numWorker := int(atomic.LoadInt32(&c.numWorker))
+	if numWorker <= 0 {
+		return size
+	}
+
+	busy := c.buffer.Len() + int(atomic.LoadUint32(&c.inFlight))
+	capacity := numWorker - busy
+	if timing := c.timing(); timing > 0 {
+		if n := int(c.opt.ReservationTimeout/timing)*numWorker - busy; n > capacity {
+			capacity = n
+		}
+	}
+	if capacity < size {
+		return capacity
+	}
+	return size
+}
+
+func fetchBackoff(timing time.Duration) time.Duration {
+	const min = 10 * time.Millisecond
+	const max = time.Second
+
+	if timing < min {
+		return min
+	}
+	if timing > max {
+		return max
+	}
+	return timing
+}
+
 func (c *Consumer) worker(ctx context.Context, workerID int32) {
-	var lock *redislock.Lock
+	var l *lease.Lease
 	defer func() {
-		if lock != nil {
-			_ = lock.Release(ctx)
+		if l != nil {
+			_ = l.Release(ctx)
 		}
 	}()
 
-	timer := time.NewTimer(time.Minute)
+	timer := c.opt.Clock.NewTimer(time.Minute)
 	timer.Stop()
 
 	for {
 		if workerID >= atomic.LoadInt32(&c.numWorker) {
 			return
 		}
-		if c.opt.WorkerLimit > 0 {
-			lock = c.lockWorker(ctx, lock, workerID)
+		if c.workers != nil {
+			l = c.leaseWorker(ctx, l)
 		}
 
 		msg := c.waitMessage(ctx, timer)
@@ -474,34 +672,50 @@ func (c *Consumer) worker(ctx context.Context, workerID int32) {
 			continue
 		}
 
+		c.pacer.Wait(c.stopCh)
+
 		msg.Ctx = ctx
 		_ = c.Process(msg)
 	}
 }
 
-func (c *Consumer) waitMessage(ctx context.Context, timer *time.Timer) *Message {
+func (c *Consumer) waitMessage(ctx context.Context, timer Timer) *Message {
 	const workerIdleTimeout = time.Second
 
-	select {
-	case msg := <-c.buffer:
+	if msg := c.buffer.TryGet(); msg != nil {
 		return msg
-	default:
 	}
 
 	c.ensureFetcher(ctx)
 
 	timer.Reset(workerIdleTimeout)
-	select {
-	case msg := <-c.buffer:
+	msg, timeout := c.buffer.Get(timer.C(), c.stopCh)
+	switch {
+	case msg != nil:
 		if !timer.Stop() {
-			<-timer.C
+			<-timer.C()
 		}
 		return msg
-	case <-timer.C:
+	case timeout:
 		c.voteQueueEmpty()
-		return nil
-	case <-c.stopCh:
-		return nil
+	}
+	return nil
+}
+
+// withHandlerTimeout limits msg.Ctx to QueueOptions.HandlerTimeout
+// while the handler runs. The returned func cancels the context and
+// restores the original one.
+func (c *Consumer) withHandlerTimeout(msg *Message) func() {
+	if c.opt.HandlerTimeout <= 0 {
+		return func() {}
+	}
+
+	parent := msg.Ctx
+	ctx, cancel := context.WithTimeout(msgContext(msg), c.opt.HandlerTimeout)
+	msg.Ctx = ctx
+	return func() {
+		cancel()
+		msg.Ctx = parent
 	}
 }
 
@@ -524,6 +738,27 @@ func (c *Consumer) Process(msg *Message) error {
 		return msg.Err
 	}
 
+	if c.redirectSticky(msg) {
+		return nil
+	}
+
+	if c.debounceSuperseded(msg) {
+		c.finished(msg)
+		c.delete(msg)
+		return nil
+	}
+
+	c.extractContext(msg)
+	if err := c.checkPayload(msg); err != nil {
+		msg.Err = err
+		c.Put(msg)
+		return err
+	}
+
+	if c.opt.Deps != nil {
+		msg.Ctx = ContextWithDeps(msgContext(msg), c.opt.Deps)
+	}
+
 	evt, err := c.beforeProcessMessage(msg)
 	if err != nil {
 		msg.Err = err
@@ -532,14 +767,23 @@ func (c *Consumer) Process(msg *Message) error {
 	}
 
 	msg.evt = evt
-
-	start := time.Now()
-	msgErr := c.opt.Handler.HandleMessage(msg)
+	msg.retry = c.retryOptions()
+	if toucher, ok := c.q.(Toucher); ok {
+		msg.toucher = toucher
+	}
+
+	c.writeAuditEvent(AuditEventStart, msg)
+	start := c.opt.Clock.Now()
+	stopTouch := c.autoTouch(msg)
+	restoreCtx := c.withHandlerTimeout(msg)
+	msgErr := c.handleMessage(msg)
+	restoreCtx()
+	stopTouch()
 	if msgErr == ErrAsyncTask {
 		return ErrAsyncTask
 	}
 
-	c.updateTiming(msg.TaskName, time.Since(start))
+	c.updateTiming(msg.TaskName, c.opt.Clock.Now().Sub(start))
 
 	msg.Err = msgErr
 	c.Put(msg)
@@ -547,6 +791,46 @@ func (c *Consumer) Process(msg *Message) error {
 	return msg.Err
 }
 
+// autoTouch periodically extends reservation of the message until
+// the returned function is called.
+func (c *Consumer) autoTouch(msg *Message) func() {
+	if c.opt.TouchInterval <= 0 || msg.toucher == nil {
+		return func() {}
+	}
+
+	ctx := msg.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := c.opt.Clock.NewTicker(c.opt.TouchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if err := msg.Touch(ctx); err != nil {
+					internal.Logger.Printf("task=%q Touch failed: %s", msg.TaskName, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
 func (c *Consumer) updateTiming(taskName string, x time.Duration) {
 	const decay = float64(1) / 10
 
@@ -593,26 +877,74 @@ func (c *Consumer) Put(msg *Message) {
 
 	if msg.Err == nil {
 		c.resetPause()
-		atomic.AddUint32(&c.processed, 1)
+		c.detectDuplicate(msg)
+		atomic.AddUint64(&c.counters.processed, 1)
+		c.finished(msg)
 		c.delete(msg)
 		return
 	}
 
+	if c.opt.ErrorHistorySize > 0 {
+		msg.addError(msg.Err, c.opt.ErrorHistorySize)
+	}
+
 	atomic.AddUint32(&c.consecutiveNumErr, 1)
 	if msg.Delay <= 0 {
-		atomic.AddUint32(&c.fails, 1)
+		atomic.AddUint64(&c.counters.fails, 1)
+		c.finished(msg)
 		c.delete(msg)
 		return
 	}
 
-	atomic.AddUint32(&c.retries, 1)
+	atomic.AddUint64(&c.counters.retries, 1)
+	c.writeAuditEvent(AuditEventRetry, msg)
+	if c.opt.StrictFIFO {
+		c.retryInPlace(msg)
+		return
+	}
 	c.release(msg)
 }
 
+// finished records that the message reached a terminal state,
+// i.e. it was processed or failed permanently.
+func (c *Consumer) finished(msg *Message) {
+	c.auditFinished(msg)
+	c.quotaFinished(msg)
+	if msg.Err != nil {
+		c.writeAuditEvent(AuditEventDeadLetter, msg)
+	} else {
+		c.writeAuditEvent(AuditEventDelete, msg)
+	}
+}
+
+// detectDuplicate records the id of the processed message in the Storage
+// and counts the message as a duplicate if the id was already recorded.
+func (c *Consumer) detectDuplicate(msg *Message) {
+	if !c.opt.DetectDuplicateDeliveries || msg.ID == "" {
+		return
+	}
+	key := "taskq:" + internal.Namespaced(c.opt.Namespace, ":", c.q.Name()) +
+		":processed:" + msg.ID
+	if c.opt.Storage.Exists(msgContext(msg), key) {
+		atomic.AddUint64(&c.counters.duplicates, 1)
+		internal.Logger.Printf("taskq: %s: message id=%q of task=%q was processed more than once",
+			c.q, msg.ID, msg.TaskName)
+	}
+}
+
+// logPayload returns the message payload for logs as returned by
+// QueueOptions.RedactPayload. Payloads are not logged by default.
+func (c *Consumer) logPayload(msg *Message) string {
+	if c.opt.RedactPayload == nil {
+		return ""
+	}
+	return fmt.Sprintf(" payload=%q", c.opt.RedactPayload(msg))
+}
+
 func (c *Consumer) release(msg *Message) {
 	if msg.Err != nil {
-		internal.Logger.Printf("task=%q failed (will retry=%d in dur=%s): %s",
-			msg.TaskName, msg.ReservedCount, msg.Delay, msg.Err)
+		internal.Logger.Printf("task=%q%s failed (will retry=%d in dur=%s): %s",
+			msg.TaskName, c.logPayload(msg), msg.ReservedCount, msg.Delay, msg.Err)
 	}
 
 	err := c.q.Release(msg)
@@ -624,8 +956,8 @@ func (c *Consumer) release(msg *Message) {
 
 func (c *Consumer) delete(msg *Message) {
 	if msg.Err != nil {
-		internal.Logger.Printf("task=%q handler failed after retry=%d: %s",
-			msg.TaskName, msg.ReservedCount, msg.Err)
+		internal.Logger.Printf("task=%q%s handler failed after retry=%d: %s",
+			msg.TaskName, c.logPayload(msg), msg.ReservedCount, msg.Err)
 
 		err := c.opt.Handler.HandleMessage(msg)
 		if err != nil {
@@ -643,12 +975,24 @@ func (c *Consumer) delete(msg *Message) {
 // Purge discards messages from the internal queue.
 func (c *Consumer) Purge() error {
 	for {
-		select {
-		case msg := <-c.buffer:
-			c.delete(msg)
-		default:
+		msg := c.buffer.TryGet()
+		if msg == nil {
 			return nil
 		}
+		c.delete(msg)
+	}
+}
+
+// TakeBuffered removes messages from the internal queue without processing
+// or deleting them, e.g. so the queue can persist them.
+func (c *Consumer) TakeBuffered() []*Message {
+	var msgs []*Message
+	for {
+		msg := c.buffer.TryGet()
+		if msg == nil {
+			return msgs
+		}
+		msgs = append(msgs, msg)
 	}
 }
 
@@ -671,7 +1015,7 @@ func (c *Consumer) beforeProcessMessage(msg *Message) (*ProcessMessageEvent, err
 
 	evt := &ProcessMessageEvent{
 		Message:   msg,
-		StartTime: time.Now(),
+		StartTime: c.opt.Clock.Now(),
 	}
 
 	for _, hook := range c.hooks {
@@ -701,62 +1045,52 @@ func (c *Consumer) resetPause() {
 	atomic.StoreUint32(&c.consecutiveNumErr, 0)
 }
 
-func (c *Consumer) lockWorker(
-	ctx context.Context,
-	lock *redislock.Lock,
-	workerID int32,
-) *redislock.Lock {
-	lockTimeout := c.opt.ReservationTimeout + 10*time.Second
-
-	timer := time.NewTimer(time.Minute)
-	timer.Stop()
-
-	for {
-		var err error
-		if lock == nil {
-			key := fmt.Sprintf("%s:worker:lock:%d", c.q.Name(), workerID)
-			lock, err = redislock.Obtain(ctx, c.opt.Redis, key, lockTimeout, nil)
-		} else {
-			err = lock.Refresh(ctx, lockTimeout, nil)
-		}
+// leaseWorker renews the worker lease or waits until a new one is acquired.
+// It returns nil when the consumer is stopped.
+func (c *Consumer) leaseWorker(ctx context.Context, l *lease.Lease) *lease.Lease {
+	if l != nil {
+		err := l.Renew(ctx)
 		if err == nil {
-			return lock
-		}
-
-		if err != redislock.ErrNotObtained {
-			internal.Logger.Printf("redislock.Lock failed: %s", err)
+			return l
 		}
-		if lock != nil {
-			_ = lock.Release(ctx)
-			lock = nil
+		if err != lease.ErrLost {
+			internal.Logger.Printf("lease.Renew failed: %s", err)
 		}
+		_ = l.Release(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		timer.Reset(500 * time.Millisecond)
+	go func() {
 		select {
-		case <-timer.C:
 		case <-c.stopCh:
-			if lock != nil {
-				_ = lock.Release(ctx)
-			}
-			return nil
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	l, err := c.workers.Acquire(ctx)
+	if err != nil {
+		return nil
 	}
+	return l
 }
 
 func (c *Consumer) String() string {
 	fnum := atomic.LoadInt32(&c.numFetcher)
 	wnum := atomic.LoadInt32(&c.numWorker)
 	inFlight := atomic.LoadUint32(&c.inFlight)
-	processed := atomic.LoadUint32(&c.processed)
-	retries := atomic.LoadUint32(&c.retries)
-	fails := atomic.LoadUint32(&c.fails)
+	processed := atomic.LoadUint64(&c.counters.processed)
+	retries := atomic.LoadUint64(&c.counters.retries)
+	fails := atomic.LoadUint64(&c.counters.fails)
 	timing := c.timing()
 
 	return fmt.Sprintf(
 		"%s %d/%d %d/%d/%d %d/%d/%d %s",
 		c.q.Name(),
 		fnum, wnum,
-		inFlight, len(c.buffer), cap(c.buffer),
+		inFlight, c.buffer.Len(), c.buffer.Cap(),
 		processed, retries, fails,
 		timing)
 }
@@ -789,14 +1123,17 @@ func (c *Consumer) queueEmpty() bool {
 }
 
 func (c *Consumer) autotune(ctx context.Context, cfg *consumerConfig) {
-	timer := time.NewTimer(time.Hour)
+	timer := c.opt.Clock.NewTimer(time.Hour)
 	defer timer.Stop()
 
 	for c.timing() == 0 {
 		timer.Reset(250 * time.Millisecond)
 		select {
-		case <-timer.C:
+		case <-timer.C():
 			// continue
+		case <-c.autotuneCh:
+			timer.Stop()
+			cfg = c.autotuneTick(ctx, cfg)
 		case <-c.stopCh:
 			return
 		}
@@ -805,7 +1142,11 @@ func (c *Consumer) autotune(ctx context.Context, cfg *consumerConfig) {
 	for {
 		timer.Reset(c.autotuneInterval())
 		select {
-		case <-timer.C:
+		case <-timer.C():
+			cfg = c.autotuneTick(ctx, cfg)
+		case <-c.autotuneCh:
+			// The worker range is updated.
+			timer.Stop()
 			cfg = c.autotuneTick(ctx, cfg)
 		case <-c.stopCh:
 			return
@@ -828,9 +1169,9 @@ func (c *Consumer) autotuneInterval() time.Duration {
 }
 
 func (c *Consumer) autotuneTick(ctx context.Context, cfg *consumerConfig) *consumerConfig {
-	processed := int(atomic.LoadUint32(&c.processed))
-	retries := int(atomic.LoadUint32(&c.retries))
-	cfg.Update(processed, retries, c.timing())
+	processed := int(atomic.LoadUint64(&c.counters.processed))
+	retries := int(atomic.LoadUint64(&c.counters.retries))
+	cfg.Update(c.opt.Clock.Now(), processed, retries, c.timing())
 
 	if newCfg := c.cfgs.Select(cfg); newCfg != nil {
 		cfg = newCfg
@@ -867,23 +1208,48 @@ func (c *Consumer) replaceConfig(ctx context.Context, cfg *consumerConfig) {
 	}
 
 	cfg.Reset(
-		int(atomic.LoadUint32(&c.processed)),
-		int(atomic.LoadUint32(&c.retries)))
+		c.opt.Clock.Now(),
+		int(atomic.LoadUint64(&c.counters.processed)),
+		int(atomic.LoadUint64(&c.counters.retries)))
 }
 
 //------------------------------------------------------------------------------
 
 type limiter struct {
-	bucket  string
-	limiter *redis_rate.Limiter
-	limit   redis_rate.Limit
+	bucket string
+	cfg    atomic.Value // limiterConfig
 
 	allowedCount uint32 // atomic
 	cancelled    uint32 // atomic
 }
 
+// limiterConfig is replaced as a whole when the rate limit is updated.
+type limiterConfig struct {
+	limiter *redis_rate.Limiter
+	limit   redis_rate.Limit
+}
+
+func newLimiter(bucket string, rl *redis_rate.Limiter, limit redis_rate.Limit) *limiter {
+	l := &limiter{
+		bucket: bucket,
+	}
+	l.setLimit(rl, limit)
+	return l
+}
+
+func (l *limiter) config() limiterConfig {
+	return l.cfg.Load().(limiterConfig)
+}
+
+func (l *limiter) setLimit(rl *redis_rate.Limiter, limit redis_rate.Limit) {
+	l.cfg.Store(limiterConfig{
+		limiter: rl,
+		limit:   limit,
+	})
+}
+
 func (l *limiter) Reserve(ctx context.Context, max int) int {
-	if l.limiter == nil || l.limit.IsZero() {
+	if cfg := l.config(); cfg.limiter == nil || cfg.limit.IsZero() {
 		return max
 	}
 
@@ -906,7 +1272,13 @@ func (l *limiter) Reserve(ctx context.Context, max int) int {
 	}
 
 	for {
-		res, err := l.limiter.AllowAtMost(ctx, l.bucket, l.limit, max)
+		// The limit is reloaded so updates apply to waiting callers.
+		cfg := l.config()
+		if cfg.limiter == nil || cfg.limit.IsZero() {
+			return max
+		}
+
+		res, err := cfg.limiter.AllowAtMost(ctx, l.bucket, cfg.limit, max)
 		if err != nil {
 			time.Sleep(100 * time.Millisecond)
 			continue
@@ -923,14 +1295,55 @@ func (l *limiter) Reserve(ctx context.Context, max int) int {
 }
 
 func (l *limiter) Cancel(n int) {
-	if l.limiter == nil {
+	if l.config().limiter == nil {
 		return
 	}
 	atomic.AddUint32(&l.cancelled, uint32(n))
 }
 
 func (l *limiter) Limited() bool {
-	return l.limiter != nil && atomic.LoadUint32(&l.allowedCount) < 3
+	return l.config().limiter != nil && atomic.LoadUint32(&l.allowedCount) < 3
+}
+
+//------------------------------------------------------------------------------
+
+// pacer spaces message starts evenly using leaky bucket algorithm.
+type pacer struct {
+	clock    Clock
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Wait blocks until the next slot is available. It returns immediately
+// when the stop channel is closed so pending messages can be processed.
+func (p *pacer) Wait(stopCh <-chan struct{}) {
+	if p.interval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	now := p.clock.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	slot := p.next
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	d := slot.Sub(now)
+	if d <= 0 {
+		return
+	}
+
+	timer := p.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-stopCh:
+	}
 }
 
 //------------------------------------------------------------------------------
@@ -2,6 +2,7 @@ package taskq
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,17 +16,17 @@ type perfProfile struct {
 	errorRate float64
 }
 
-func (p *perfProfile) Reset(processed, retries int) {
-	p.start = time.Now()
+func (p *perfProfile) Reset(now time.Time, processed, retries int) {
+	p.start = now
 	p.processed = processed
 	p.retries = retries
 }
 
-func (p *perfProfile) Update(processed, retries int, timing time.Duration) {
+func (p *perfProfile) Update(now time.Time, processed, retries int, timing time.Duration) {
 	processedDiff := processed - p.processed
 	retriesDiff := retries - p.retries
 	total := processedDiff + retriesDiff
-	elapsed := time.Since(p.start)
+	elapsed := now.Sub(p.start)
 
 	elapsedMS := float64(elapsed) / float64(time.Millisecond)
 	p.tps = float64(processedDiff) / elapsedMS
@@ -102,25 +103,79 @@ func (cfg *consumerConfig) Clone() *consumerConfig {
 type configRoulette struct {
 	opt *QueueOptions
 
+	// Worker range that can be updated while the consumer is running.
+	minNumWorker int32 // atomic
+	maxNumWorker int32 // atomic
+
 	maxTPS    float64
 	maxTiming time.Duration
 	currCfg   *consumerConfig
 }
 
-func newConfigRoulette(opt *QueueOptions) *configRoulette {
+func newConfigRoulette(opt *QueueOptions, minNumWorker, maxNumWorker int32) *configRoulette {
 	r := &configRoulette{
 		opt: opt,
 	}
+	r.setWorkerRange(minNumWorker, maxNumWorker)
 
 	r.resetConfig()
 	return r
 }
 
+func (r *configRoulette) setWorkerRange(min, max int32) {
+	atomic.StoreInt32(&r.minNumWorker, min)
+	atomic.StoreInt32(&r.maxNumWorker, max)
+}
+
+// Select returns the config to use next. It removes a quarter of workers
+// when the system is out of resources and adds them back when resources
+// are freed.
 func (r *configRoulette) Select(currCfg *consumerConfig) *consumerConfig {
 	r.currCfg = currCfg
+
+	min := atomic.LoadInt32(&r.minNumWorker)
+	max := atomic.LoadInt32(&r.maxNumWorker)
+
+	// The range is checked first in case it was updated.
+	if currCfg.NumWorker < min || currCfg.NumWorker > max {
+		cfg := currCfg.Clone()
+		if cfg.NumWorker < min {
+			cfg.NumWorker = min
+		} else {
+			cfg.NumWorker = max
+		}
+		r.currCfg = cfg
+		return r.currCfg
+	}
+
+	free := hasFreeSystemResources(r.opt.ResourceProbes)
+	switch {
+	case !free && currCfg.NumWorker > min:
+		cfg := currCfg.Clone()
+		cfg.NumWorker -= workerStep(cfg.NumWorker)
+		if cfg.NumWorker < min {
+			cfg.NumWorker = min
+		}
+		r.currCfg = cfg
+	case free && currCfg.NumWorker < max:
+		cfg := currCfg.Clone()
+		cfg.NumWorker += workerStep(cfg.NumWorker)
+		if cfg.NumWorker > max {
+			cfg.NumWorker = max
+		}
+		r.currCfg = cfg
+	}
+
 	return r.currCfg
 }
 
+func workerStep(numWorker int32) int32 {
+	if step := numWorker / 4; step > 0 {
+		return step
+	}
+	return 1
+}
+
 func (r *configRoulette) resetConfig() {
 	r.maxTPS = 0
 	r.maxTiming = 0
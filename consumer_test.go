@@ -18,6 +18,7 @@ import (
 	"github.com/go-redis/redis_rate/v9"
 
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/memqueue"
 	"github.com/frain-dev/taskq/v3/redisq"
 )
 
@@ -48,7 +49,9 @@ func redisRing() *redis.Ring {
 func testConsumer(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -85,7 +88,7 @@ func testConsumer(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 		t.Fatalf("message was not processed")
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -94,10 +97,61 @@ func testConsumer(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 	}
 }
 
+func testRuntimeQueue(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
+	c := context.Background()
+	opt.WaitTimeout = waitTimeout
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
+
+	if err := factory.StartConsumers(c); err != nil {
+		t.Fatal(err)
+	}
+	defer factory.Close()
+
+	q := factory.RegisterQueue(opt)
+	purge(t, q)
+
+	ch := make(chan time.Time)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func() {
+			ch <- time.Now()
+		},
+	})
+
+	err := q.Add(task.WithArgs(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed")
+	}
+
+	if err := factory.UnregisterQueue(opt.Name); err != nil {
+		t.Fatal(err)
+	}
+	factory.Range(func(q taskq.Queue) bool {
+		if q.Name() == opt.Name {
+			t.Fatalf("queue=%q is still registered", opt.Name)
+		}
+		return true
+	})
+
+	if err := factory.UnregisterQueue(opt.Name); err == nil {
+		t.Fatal("got nil, wanted an error")
+	}
+}
+
 func testConsumerDelete(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	red, ok := opt.Redis.(redisq.RedisStreamClient)
 	if !ok {
@@ -152,7 +206,7 @@ func testConsumerDelete(t *testing.T, factory taskq.Factory, opt *taskq.QueueOpt
 		t.Fatal("task not acknowledged and still exists in pending list.")
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -164,7 +218,9 @@ func testConsumerDelete(t *testing.T, factory taskq.Factory, opt *taskq.QueueOpt
 func testUnknownTask(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -192,7 +248,7 @@ func testUnknownTask(t *testing.T, factory taskq.Factory, opt *taskq.QueueOption
 		t.Fatal(err)
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -204,7 +260,9 @@ func testUnknownTask(t *testing.T, factory taskq.Factory, opt *taskq.QueueOption
 func testFallback(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -243,7 +301,7 @@ func testFallback(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 		t.Fatalf("message was not processed")
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -255,7 +313,9 @@ func testFallback(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 func testDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -293,7 +353,7 @@ func testDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 		t.Fatalf("message was delayed by %s, wanted %s", sub, msg.Delay)
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -305,7 +365,9 @@ func testDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 func testRetry(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -343,7 +405,7 @@ func testRetry(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	timings := []time.Duration{0, time.Second, 3 * time.Second, 3 * time.Second}
 	testTimings(t, handlerCh, timings)
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -355,7 +417,9 @@ func testRetry(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 func testNamedMessage(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -404,7 +468,7 @@ func testNamedMessage(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptio
 	default:
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -416,7 +480,9 @@ func testNamedMessage(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptio
 func testCallOnce(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -464,7 +530,7 @@ func testCallOnce(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 	case <-time.After(time.Second):
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -478,7 +544,9 @@ func testLen(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -513,11 +581,49 @@ func testLen(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	}
 }
 
+func testMaxLen(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
+	const N = 3
+
+	c := context.Background()
+	opt.WaitTimeout = waitTimeout
+	opt.MaxLen = N
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
+
+	q := factory.RegisterQueue(opt)
+	defer q.Close()
+	purge(t, q)
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    nextTaskID(),
+		Handler: func() {},
+	})
+
+	for i := 0; i < N; i++ {
+		err := q.Add(task.WithArgs(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.Add(task.WithArgs(c))
+	if err != taskq.ErrQueueFull {
+		t.Fatalf("got %v, wanted ErrQueueFull", err)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testRateLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
 	opt.RateLimit = redis_rate.PerSecond(1)
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -554,7 +660,7 @@ func testRateLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 		t.Fatalf("processed %d messages, wanted 5", n)
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -566,7 +672,9 @@ func testRateLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions)
 func testErrorDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	c := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -594,7 +702,7 @@ func testErrorDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions
 	timings := []time.Duration{0, 3 * time.Second, 3 * time.Second}
 	testTimings(t, handlerCh, timings)
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(c); err != nil {
 		t.Fatal(err)
 	}
 
@@ -606,7 +714,9 @@ func testErrorDelay(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions
 func testWorkerLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	ctx := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 	opt.WorkerLimit = 1
 
 	q := factory.RegisterQueue(opt)
@@ -635,10 +745,57 @@ func testWorkerLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOption
 	timings := []time.Duration{0, time.Second, 2 * time.Second}
 	testTimings(t, ch, timings)
 
-	if err := p1.Stop(); err != nil {
+	if err := p1.Stop(ctx); err != nil {
 		t.Fatal(err)
 	}
-	if err := p2.Stop(); err != nil {
+	if err := p2.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTouch(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
+	ctx := context.Background()
+	opt.WaitTimeout = waitTimeout
+	opt.ReservationTimeout = 2 * time.Second
+	opt.TouchInterval = 500 * time.Millisecond
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
+
+	q := factory.RegisterQueue(opt)
+	defer q.Close()
+	purge(t, q)
+
+	var count int64
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func() {
+			atomic.AddInt64(&count, 1)
+			time.Sleep(3 * opt.ReservationTimeout)
+		},
+	})
+
+	err := q.Add(task.WithArgs(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := q.Consumer()
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(4 * opt.ReservationTimeout)
+
+	if n := atomic.LoadInt64(&count); n != 1 {
+		t.Fatalf("message was processed %d times, wanted 1", n)
+	}
+
+	if err := p.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Close(); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -646,7 +803,9 @@ func testWorkerLimit(t *testing.T, factory taskq.Factory, opt *taskq.QueueOption
 func testInvalidCredentials(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
 	ctx := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	q := factory.RegisterQueue(opt)
 	defer q.Close()
@@ -686,7 +845,9 @@ func testBatchConsumer(
 
 	ctx := context.Background()
 	opt.WaitTimeout = waitTimeout
-	opt.Redis = redisRing()
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
 
 	payload := make([]byte, messageSize)
 	_, err := rand.Read(payload)
@@ -736,7 +897,7 @@ func testBatchConsumer(
 		t.Fatalf("messages were not processed")
 	}
 
-	if err := p.Stop(); err != nil {
+	if err := p.Stop(ctx); err != nil {
 		t.Fatal(err)
 	}
 
@@ -825,6 +986,52 @@ func eventually(fn func() error, timeout time.Duration) error {
 	}
 }
 
+// waitFor polls fn until it returns true and fails the test after 5 seconds.
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testFactory is a memqueue factory with its own task registry
+// for tests of features built on top of queues.
+type testFactory struct {
+	taskq.Factory
+	registry *taskq.TaskMap
+}
+
+// newTestFactory returns a memqueue factory that is closed when the test ends.
+func newTestFactory(t *testing.T) *testFactory {
+	f := &testFactory{
+		Factory:  memqueue.NewFactory(),
+		registry: taskq.NewRegistry(),
+	}
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+	return f
+}
+
+// RegisterTask registers the task in the factory registry.
+func (f *testFactory) RegisterTask(opt *taskq.TaskOptions) *taskq.Task {
+	return f.registry.RegisterTask(opt)
+}
+
+// RegisterQueue registers a queue that handles tasks of the factory registry.
+// Messages are deduplicated using the local storage unless Storage is set.
+func (f *testFactory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
+	if opt.Storage == nil {
+		opt.Storage = taskq.NewLocalStorage()
+	}
+	opt.Handler = f.registry
+	return f.Factory.RegisterQueue(opt)
+}
+
 var taskID int
 
 func nextTaskID() string {
@@ -836,3 +1043,55 @@ func nextTaskID() string {
 func unixMs(tm time.Time) int64 {
 	return tm.UnixNano() / int64(time.Millisecond)
 }
+
+func testHeaders(t *testing.T, factory taskq.Factory, opt *taskq.QueueOptions) {
+	c := context.Background()
+	opt.WaitTimeout = waitTimeout
+	if opt.Redis == nil {
+		opt.Redis = redisRing()
+	}
+
+	q := factory.RegisterQueue(opt)
+	defer q.Close()
+	purge(t, q)
+
+	ch := make(chan map[string]string, 1)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(msg *taskq.Message) error {
+			ch <- msg.Headers
+			return nil
+		},
+	})
+
+	msg := task.WithArgs(c)
+	msg.Headers = map[string]string{
+		"tenant-id":      "42",
+		"schema.version": "2",
+	}
+	if err := q.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	p := q.Consumer()
+	if err := p.Start(c); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case headers := <-ch:
+		if headers["tenant-id"] != "42" || headers["schema.version"] != "2" {
+			t.Fatalf("got %v, wanted tenant-id and schema.version headers", headers)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed")
+	}
+
+	if err := p.Stop(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
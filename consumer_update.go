@@ -0,0 +1,180 @@
+package taskq
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis_rate/v9"
+)
+
+// ConsumerUpdate holds the options that can be changed on a running
+// consumer with Consumer.UpdateOptions. Zero values leave the options
+// unchanged.
+type ConsumerUpdate struct {
+	// Override QueueOptions.MinNumWorker and QueueOptions.MaxNumWorker.
+	// Not supported by consumers with WorkerLimit or StrictFIFO.
+	MinNumWorker int32
+	MaxNumWorker int32
+
+	// Override QueueOptions.RateLimit. Use a pointer to the zero Limit
+	// to disable rate limiting. Requires RateLimiter or Redis.
+	RateLimit *redis_rate.Limit
+
+	// Override TaskOptions.RetryLimit, MinBackoff, and MaxBackoff of tasks
+	// in the TaskMap that handles messages of the consumer.
+	RetryLimit int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type retryOptions struct {
+	limit      int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func (c *Consumer) retryOptions() *retryOptions {
+	retry, _ := c.retry.Load().(*retryOptions)
+	return retry
+}
+
+// UpdateOptions applies the update to the consumer. Removed workers finish
+// processing their current messages before exiting, so in-flight messages
+// are not dropped. Retry options apply to messages that fail after the update.
+// The queue options are shared with the queue and are not changed.
+func (c *Consumer) UpdateOptions(upd *ConsumerUpdate) error {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	if err := c.validateUpdate(upd); err != nil {
+		return err
+	}
+
+	if upd.RateLimit != nil {
+		rl := c.limiter.config().limiter
+		if !upd.RateLimit.IsZero() && rl == nil {
+			rl = redis_rate.NewLimiter(c.opt.Redis)
+		}
+		c.limiter.setLimit(rl, *upd.RateLimit)
+	}
+
+	if upd.RetryLimit > 0 || upd.MinBackoff > 0 || upd.MaxBackoff > 0 {
+		retry := new(retryOptions)
+		if prev := c.retryOptions(); prev != nil {
+			*retry = *prev
+		}
+		if upd.RetryLimit > 0 {
+			retry.limit = upd.RetryLimit
+		}
+		if upd.MinBackoff > 0 {
+			retry.minBackoff = upd.MinBackoff
+		}
+		if upd.MaxBackoff > 0 {
+			retry.maxBackoff = upd.MaxBackoff
+		}
+		c.retry.Store(retry)
+	}
+
+	if upd.MinNumWorker > 0 || upd.MaxNumWorker > 0 {
+		c.updateWorkers(upd)
+	}
+	return nil
+}
+
+func (c *Consumer) validateUpdate(upd *ConsumerUpdate) error {
+	if upd.MinNumWorker < 0 || upd.MaxNumWorker < 0 || upd.RetryLimit < 0 ||
+		upd.MinBackoff < 0 || upd.MaxBackoff < 0 {
+		return errors.New("taskq: ConsumerUpdate values must not be negative")
+	}
+
+	if upd.MinNumWorker > 0 || upd.MaxNumWorker > 0 {
+		if c.opt.StrictFIFO || c.opt.WorkerLimit > 0 {
+			return fmt.Errorf("taskq: %s: workers can't be updated with StrictFIFO or WorkerLimit", c)
+		}
+		min, max := c.workerRange(upd)
+		if min > max {
+			return fmt.Errorf("taskq: ConsumerUpdate.MinNumWorker=%d is greater than MaxNumWorker=%d",
+				min, max)
+		}
+	}
+
+	if upd.RateLimit != nil && !upd.RateLimit.IsZero() &&
+		c.opt.RateLimiter == nil && c.opt.Redis == nil {
+		return errors.New("taskq: ConsumerUpdate.RateLimit requires RateLimiter or Redis")
+	}
+
+	minBackoff, maxBackoff := upd.MinBackoff, upd.MaxBackoff
+	if retry := c.retryOptions(); retry != nil {
+		if minBackoff == 0 {
+			minBackoff = retry.minBackoff
+		}
+		if maxBackoff == 0 {
+			maxBackoff = retry.maxBackoff
+		}
+	}
+	if minBackoff > 0 && maxBackoff > 0 && minBackoff > maxBackoff {
+		return fmt.Errorf("taskq: ConsumerUpdate.MinBackoff=%s is greater than MaxBackoff=%s",
+			minBackoff, maxBackoff)
+	}
+	return nil
+}
+
+// workerRange returns the worker range after the update.
+func (c *Consumer) workerRange(upd *ConsumerUpdate) (int32, int32) {
+	min, max := c.minNumWorker, c.maxNumWorker
+	if upd.MinNumWorker > 0 {
+		min = upd.MinNumWorker
+	}
+	if upd.MaxNumWorker > 0 {
+		max = upd.MaxNumWorker
+	}
+	return min, max
+}
+
+func (c *Consumer) updateWorkers(upd *ConsumerUpdate) {
+	min, max := c.workerRange(upd)
+	c.minNumWorker = min
+	c.maxNumWorker = max
+
+	if c.cfgs != nil {
+		// The autotuner applies the range.
+		c.cfgs.setWorkerRange(min, max)
+		select {
+		case c.autotuneCh <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	c.startStopMu.Lock()
+	started := atomic.LoadInt32(&c.state) == stateStarted
+	ctx := c.startCtx
+	if started && min < max {
+		c.fetchersWG.Add(1)
+	}
+	c.startStopMu.Unlock()
+
+	if !started {
+		// Start uses the updated options.
+		return
+	}
+
+	cfg := &consumerConfig{
+		NumFetcher: atomic.LoadInt32(&c.numFetcher),
+		NumWorker:  max,
+	}
+	if min == max {
+		c.replaceConfig(ctx, cfg)
+		return
+	}
+
+	// The number of workers was fixed, so start autotuning.
+	c.cfgs = newConfigRoulette(c.opt, min, max)
+	c.replaceConfig(ctx, cfg)
+	go func() {
+		defer c.fetchersWG.Done()
+		c.autotune(ctx, cfg)
+	}()
+}
@@ -0,0 +1,176 @@
+package taskq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+const (
+	// DebounceKeyHeader is the message header that holds the storage key
+	// of a message added with Debounce.
+	DebounceKeyHeader = "taskq-debounce-key"
+	// DebounceIDHeader is the message header that holds the id of
+	// the Debounce call that added the message.
+	DebounceIDHeader = "taskq-debounce-id"
+)
+
+// Debounce keys are kept longer than the window in case the queue
+// is backlogged and the message is processed late.
+const debounceTTL = 24 * time.Hour
+
+// DebounceStorage is implemented by storages that can remember the latest
+// Debounce call for a key. Both built-in storages implement it.
+type DebounceStorage interface {
+	// DebounceCall records id as the latest call for the key.
+	DebounceCall(ctx context.Context, key, id string, ttl time.Duration) error
+	// DebounceLatest reports whether id is the latest call for the key
+	// and forgets the key if it is. Unknown keys are reported as latest.
+	DebounceLatest(ctx context.Context, key, id string) (bool, error)
+}
+
+// Debounce coalesces repeated calls with the same key into a single
+// execution of the task. The task runs once the window passes without
+// another call for the key and uses the args of the last call:
+//
+//	// Reindex the document once it hasn't been edited for 10 seconds.
+//	err := taskq.Debounce(ctx, queue, ReindexTask, "doc:"+id, 10*time.Second, id)
+//
+// Every call adds a message delayed by the window. When a message is
+// processed, messages superseded by a later call are deleted
+// without running the handler.
+func Debounce(
+	ctx context.Context, q Queue, task *Task, key string, window time.Duration, args ...interface{},
+) error {
+	storage, ok := q.Options().Storage.(DebounceStorage)
+	if !ok {
+		return fmt.Errorf("%w: %T does not implement DebounceStorage",
+			ErrNotSupported, q.Options().Storage)
+	}
+
+	key = debounceKey(q, task, key)
+	id := uuid.New().String()
+	if err := storage.DebounceCall(ctx, key, id, window+debounceTTL); err != nil {
+		return err
+	}
+
+	msg := task.WithArgs(ctx, args...)
+	msg.Delay = window
+	msg.Headers = map[string]string{
+		DebounceKeyHeader: key,
+		DebounceIDHeader:  id,
+	}
+	return q.Add(msg)
+}
+
+func debounceKey(q Queue, task *Task, key string) string {
+	return "taskq:" + internal.Namespaced(q.Options().Namespace, ":", q.Name()) +
+		":debounce:" + task.Name() + ":" + key
+}
+
+// debounceSuperseded reports whether the message was added with Debounce
+// and a later call for the same key was made since.
+func (c *Consumer) debounceSuperseded(msg *Message) bool {
+	key, id := msg.Headers[DebounceKeyHeader], msg.Headers[DebounceIDHeader]
+	if key == "" || id == "" {
+		return false
+	}
+	storage, ok := c.opt.Storage.(DebounceStorage)
+	if !ok {
+		return false
+	}
+	latest, err := storage.DebounceLatest(msgContext(msg), key, id)
+	if err != nil {
+		// Rather run the task twice than not at all.
+		internal.Logger.Printf("taskq: DebounceLatest failed: %s", err)
+		return false
+	}
+	return !latest
+}
+
+//------------------------------------------------------------------------------
+
+var _ DebounceStorage = (*localStorage)(nil)
+var _ DebounceStorage = (*redisStorage)(nil)
+
+type localDebounce struct {
+	mu   sync.Mutex
+	keys map[string]debounceCall
+}
+
+type debounceCall struct {
+	id      string
+	expires time.Time
+}
+
+func (s *localStorage) DebounceCall(_ context.Context, key, id string, ttl time.Duration) error {
+	s.debounce.mu.Lock()
+	defer s.debounce.mu.Unlock()
+
+	now := time.Now()
+	if s.debounce.keys == nil {
+		s.debounce.keys = make(map[string]debounceCall)
+	}
+	for k, call := range s.debounce.keys {
+		if !now.Before(call.expires) {
+			delete(s.debounce.keys, k)
+		}
+	}
+	s.debounce.keys[key] = debounceCall{
+		id:      id,
+		expires: now.Add(ttl),
+	}
+	return nil
+}
+
+func (s *localStorage) DebounceLatest(_ context.Context, key, id string) (bool, error) {
+	s.debounce.mu.Lock()
+	defer s.debounce.mu.Unlock()
+
+	call, ok := s.debounce.keys[key]
+	if !ok || !time.Now().Before(call.expires) {
+		delete(s.debounce.keys, key)
+		return true, nil
+	}
+	if call.id != id {
+		return false, nil
+	}
+	delete(s.debounce.keys, key)
+	return true, nil
+}
+
+var debounceCallScript = redis.NewScript(`
+redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2])
+return 1
+`)
+
+var debounceLatestScript = redis.NewScript(`
+local id = redis.call("get", KEYS[1])
+if not id then
+  return 1
+end
+if id ~= ARGV[1] then
+  return 0
+end
+redis.call("del", KEYS[1])
+return 1
+`)
+
+func (s *redisStorage) DebounceCall(ctx context.Context, key, id string, ttl time.Duration) error {
+	ms := int64(ttl / time.Millisecond)
+	return debounceCallScript.Run(ctx, s.redis, []string{key}, id, ms).Err()
+}
+
+func (s *redisStorage) DebounceLatest(ctx context.Context, key, id string) (bool, error) {
+	n, err := debounceLatestScript.Run(ctx, s.redis, []string{key}, id).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,66 @@
+package taskq
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+type depsKey struct{}
+
+// ContextWithDeps returns a copy of the context that carries the handler
+// dependencies, e.g. to call Task.Invoke in tests.
+func ContextWithDeps(ctx context.Context, deps interface{}) context.Context {
+	return context.WithValue(ctx, depsKey{}, deps)
+}
+
+// DepsFromContext returns the handler dependencies set with
+// QueueOptions.Deps or ContextWithDeps, or nil.
+func DepsFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(depsKey{})
+}
+
+// depsHandler creates handlers with TaskOptions.HandlerFactory
+// for the dependencies of the processed message.
+type depsHandler struct {
+	factory  func(deps interface{}) interface{}
+	handlers sync.Map // deps -> Handler
+	mu       sync.Mutex
+}
+
+var _ Handler = (*depsHandler)(nil)
+
+// noDeps is the cache key of the handler created without dependencies.
+type noDeps struct{}
+
+func (h *depsHandler) HandleMessage(msg *Message) error {
+	return h.handler(DepsFromContext(msg.Ctx)).HandleMessage(msg)
+}
+
+func (h *depsHandler) handler(deps interface{}) Handler {
+	var key interface{} = noDeps{}
+	if deps != nil {
+		if !reflect.TypeOf(deps).Comparable() {
+			return NewHandler(h.factory(deps))
+		}
+		key = deps
+	}
+
+	if v, ok := h.handlers.Load(key); ok {
+		return v.(Handler)
+	}
+
+	// Don't call the factory concurrently for the same dependencies.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if v, ok := h.handlers.Load(key); ok {
+		return v.(Handler)
+	}
+	handler := NewHandler(h.factory(deps))
+	h.handlers.Store(key, handler)
+	return handler
+}
@@ -0,0 +1,218 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+	"github.com/frain-dev/taskq/v3/internal/lease"
+)
+
+// ElectionOptions configures NewElection.
+type ElectionOptions struct {
+	// Redis used to elect the leader across processes, e.g. the Redis
+	// of the queues. Without Redis the leader is elected among
+	// the elections with the same name in the process.
+	Redis Redis
+	// Optional namespace that prefixes the Redis key.
+	Namespace string
+	// Time after which the leadership of a crashed leader expires.
+	// The leader renews it every TTL/3. Default is 30 seconds.
+	TTL time.Duration
+}
+
+func (opt *ElectionOptions) init() {
+	if opt.TTL == 0 {
+		opt.TTL = 30 * time.Second
+	}
+}
+
+// Election elects a single leader among the elections with the same name,
+// so exactly one instance of a consumer or scheduler runs across replicas
+// and another replica takes over when the leader crashes:
+//
+//	election := taskq.NewElection("scheduler", &taskq.ElectionOptions{Redis: rdb})
+//	go election.Run(ctx, func(ctx context.Context) {
+//		// ctx is canceled when the leadership is lost.
+//		runScheduler(ctx)
+//	})
+//
+// It uses the same Redis leases as QueueOptions.WorkerLimit.
+type Election struct {
+	name string
+	opt  *ElectionOptions
+
+	sem   *lease.Semaphore
+	local *localElection
+
+	token int64
+}
+
+// NewElection creates an election. Options may be nil.
+func NewElection(name string, opt *ElectionOptions) *Election {
+	if opt == nil {
+		opt = new(ElectionOptions)
+	}
+	opt.init()
+
+	e := &Election{
+		name: name,
+		opt:  opt,
+	}
+	if opt.Redis != nil {
+		key := internal.Namespaced(opt.Namespace, ":", fmt.Sprintf("taskq:{%s}:election", name))
+		e.sem = lease.NewSemaphore(opt.Redis, key, &lease.Options{
+			Limit: 1,
+			TTL:   opt.TTL,
+		})
+	} else {
+		e.local = getLocalElection(name)
+	}
+	return e
+}
+
+// IsLeader reports whether the election holds the leadership.
+func (e *Election) IsLeader() bool {
+	return e.Token() != 0
+}
+
+// Token returns the fencing token of the leadership or 0 if the election
+// is not the leader. Tokens of newer leaderships are always greater.
+func (e *Election) Token() int64 {
+	return atomic.LoadInt64(&e.token)
+}
+
+// Run waits for the leadership and calls fn with a context that is
+// canceled when the leadership is lost. The leadership is released when
+// fn returns and Run campaigns again until ctx is done.
+func (e *Election) Run(ctx context.Context, fn func(ctx context.Context)) error {
+	for {
+		l, err := e.acquire(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		e.lead(ctx, l, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (e *Election) lead(ctx context.Context, l leadership, fn func(ctx context.Context)) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	atomic.StoreInt64(&e.token, l.Token())
+	defer atomic.StoreInt64(&e.token, 0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(e.opt.TTL / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-done:
+			_ = l.Release(context.Background())
+			return
+		case <-ticker.C:
+		}
+
+		err := l.Renew(ctx)
+		if err == nil {
+			renewed = time.Now()
+			continue
+		}
+		if !errors.Is(err, lease.ErrLost) {
+			internal.Logger.Printf("taskq: election=%q renew failed: %s", e.name, err)
+			if time.Since(renewed) < e.opt.TTL {
+				continue
+			}
+		}
+
+		// Another replica may be the leader now.
+		atomic.StoreInt64(&e.token, 0)
+		cancel()
+		<-done
+		_ = l.Release(context.Background())
+		return
+	}
+}
+
+type leadership interface {
+	Token() int64
+	Renew(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+func (e *Election) acquire(ctx context.Context) (leadership, error) {
+	if e.sem != nil {
+		return e.sem.Acquire(ctx)
+	}
+	return e.local.acquire(ctx)
+}
+
+//------------------------------------------------------------------------------
+
+var localElections sync.Map
+
+type localElection struct {
+	sem   chan struct{}
+	token int64
+}
+
+func getLocalElection(name string) *localElection {
+	v, _ := localElections.LoadOrStore(name, &localElection{
+		sem: make(chan struct{}, 1),
+	})
+	return v.(*localElection)
+}
+
+func (e *localElection) acquire(ctx context.Context) (leadership, error) {
+	select {
+	case e.sem <- struct{}{}:
+		return &localLeadership{
+			e:     e,
+			token: atomic.AddInt64(&e.token, 1),
+		}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type localLeadership struct {
+	e        *localElection
+	token    int64
+	released int32
+}
+
+func (l *localLeadership) Token() int64 {
+	return l.token
+}
+
+func (l *localLeadership) Renew(ctx context.Context) error {
+	if atomic.LoadInt32(&l.released) == 1 {
+		return lease.ErrLost
+	}
+	return nil
+}
+
+func (l *localLeadership) Release(ctx context.Context) error {
+	if atomic.CompareAndSwapInt32(&l.released, 0, 1) {
+		<-l.e.sem
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package taskq_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var leaders int32
+	stepDown := make(chan struct{})
+	led := make(chan *taskq.Election, 2)
+
+	elections := []*taskq.Election{
+		taskq.NewElection("test-election", nil),
+		taskq.NewElection("test-election", nil),
+	}
+
+	var wg sync.WaitGroup
+	for _, e := range elections {
+		e := e
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = e.Run(ctx, func(ctx context.Context) {
+				if n := atomic.AddInt32(&leaders, 1); n != 1 {
+					t.Errorf("got %d leaders", n)
+				}
+				defer atomic.AddInt32(&leaders, -1)
+
+				led <- e
+				select {
+				case <-stepDown:
+				case <-ctx.Done():
+				}
+			})
+		}()
+	}
+
+	var first *taskq.Election
+	select {
+	case first = <-led:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no leader is elected")
+	}
+	if !first.IsLeader() {
+		t.Fatal("leader is not IsLeader")
+	}
+	token := first.Token()
+
+	// The other election takes over when the leader steps down.
+	stepDown <- struct{}{}
+	var second *taskq.Election
+	select {
+	case second = <-led:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no leader is elected after the leader stepped down")
+	}
+	if second.Token() <= token {
+		t.Fatalf("got token %d, wanted more than %d", second.Token(), token)
+	}
+
+	cancel()
+	wg.Wait()
+	for _, e := range elections {
+		if e.IsLeader() {
+			t.Fatal("election is still the leader")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package taskq
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+)
+
+// ErrorReport describes a handler error or panic reported to
+// QueueOptions.ErrorReporter.
+type ErrorReport struct {
+	Queue     string
+	Task      string
+	MessageID string
+	// Attempt is the number of times the message was reserved.
+	Attempt int
+	// Fingerprint of the encoded args that groups reports of messages with
+	// the same args without exposing them.
+	ArgsFingerprint string
+	// Error returned by the handler or a *PanicError.
+	Err error
+	// Final is true when the message failed permanently
+	// and is passed to the fallback handler.
+	Final bool
+}
+
+// Tags returns the report fields as tags for Sentry-compatible reporters.
+func (r *ErrorReport) Tags() map[string]string {
+	return map[string]string{
+		"queue":            r.Queue,
+		"task":             r.Task,
+		"message_id":       r.MessageID,
+		"attempt":          strconv.Itoa(r.Attempt),
+		"args_fingerprint": r.ArgsFingerprint,
+		"final":            strconv.FormatBool(r.Final),
+	}
+}
+
+// PanicError is reported when the handler panics.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("taskq: handler panicked: %v", e.Value)
+}
+
+// ErrorReporter reports handler errors and panics, e.g. to Sentry or Rollbar.
+// Panics are reported and then re-raised. Panics recovered by
+// TaskOptions.DeferFunc are not reported, because they don't reach
+// the consumer.
+type ErrorReporter interface {
+	ReportError(ctx context.Context, report *ErrorReport)
+}
+
+// ErrorReporterFunc adapts a function to ErrorReporter:
+//
+//	taskq.ErrorReporterFunc(func(ctx context.Context, r *taskq.ErrorReport) {
+//		hub := sentry.CurrentHub().Clone()
+//		hub.Scope().SetTags(r.Tags())
+//		hub.CaptureException(r.Err)
+//	})
+type ErrorReporterFunc func(ctx context.Context, report *ErrorReport)
+
+func (fn ErrorReporterFunc) ReportError(ctx context.Context, report *ErrorReport) {
+	fn(ctx, report)
+}
+
+// handleMessage calls the handler and reports its errors and panics.
+func (c *Consumer) handleMessage(msg *Message) error {
+	if c.opt.ErrorReporter == nil {
+		return c.opt.Handler.HandleMessage(msg)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			c.reportError(msg, &PanicError{
+				Value: v,
+				Stack: debug.Stack(),
+			}, false)
+			panic(v)
+		}
+	}()
+
+	err := c.opt.Handler.HandleMessage(msg)
+	if err != nil && err != ErrAsyncTask {
+		c.reportError(msg, err, msg.Delay <= 0)
+	}
+	return err
+}
+
+func (c *Consumer) reportError(msg *Message, err error, final bool) {
+	c.opt.ErrorReporter.ReportError(msgContext(msg), &ErrorReport{
+		Queue:           c.q.Name(),
+		Task:            msg.TaskName,
+		MessageID:       msg.ID,
+		Attempt:         msg.ReservedCount,
+		ArgsFingerprint: argsFingerprint(msg),
+		Err:             err,
+		Final:           final,
+	})
+}
+
+func argsFingerprint(msg *Message) string {
+	b, err := msg.MarshalArgs()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
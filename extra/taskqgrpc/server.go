@@ -0,0 +1,205 @@
+package taskqgrpc
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/vmihailenco/taskq/extra/taskqgrpc/v3/taskqpb"
+)
+
+// ServerOptions configures NewServer.
+type ServerOptions struct {
+	// Optional function that returns the identity of the caller, e.g. from
+	// the peer certificate or gRPC metadata. The identity is passed to
+	// QueueOptions.Authorizer when messages are added.
+	Identity func(ctx context.Context) *taskq.Identity
+}
+
+// Server implements the taskq gRPC service backed by the queues
+// registered in a factory:
+//
+//	srv := grpc.NewServer()
+//	taskqgrpc.NewServer(factory, nil).Register(srv)
+type Server struct {
+	taskqpb.UnimplementedTaskqServer
+
+	factory taskq.Factory
+	opt     ServerOptions
+}
+
+var _ taskqpb.TaskqServer = (*Server)(nil)
+
+func NewServer(factory taskq.Factory, opt *ServerOptions) *Server {
+	srv := &Server{factory: factory}
+	if opt != nil {
+		srv.opt = *opt
+	}
+	return srv
+}
+
+// Register registers the service in the gRPC server.
+func (s *Server) Register(srv *grpc.Server) {
+	taskqpb.RegisterTaskqServer(srv, s)
+}
+
+func (s *Server) Enqueue(
+	ctx context.Context, req *taskqpb.EnqueueRequest,
+) (*taskqpb.EnqueueResponse, error) {
+	q, err := s.queue(req.GetQueue())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetTask() == "" {
+		return nil, status.Error(codes.InvalidArgument, "taskq: task is required")
+	}
+
+	// The message is added with the request context, so the request
+	// deadline and cancellation apply to adding it.
+	if s.opt.Identity != nil {
+		if id := s.opt.Identity(ctx); id != nil {
+			ctx = taskq.ContextWithIdentity(ctx, id)
+		}
+	}
+
+	msg := taskq.NewMessage(ctx)
+	msg.TaskName = req.GetTask()
+	msg.ArgsBin = req.GetArgs()
+	msg.Name = req.GetName()
+	msg.Delay = req.GetDelay().AsDuration()
+	if headers := req.GetHeaders(); len(headers) > 0 {
+		msg.Headers = make(map[string]string, len(headers))
+		for k, v := range headers {
+			msg.Headers[k] = v
+		}
+	}
+
+	if err := q.Add(msg); err != nil {
+		return nil, addError(err)
+	}
+	if msg.Err == taskq.ErrDuplicate {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"taskq: message with name=%q already exists", msg.Name)
+	}
+	return &taskqpb.EnqueueResponse{
+		MessageId: msg.ID,
+		AuditId:   msg.Headers[taskq.AuditIDHeader],
+	}, nil
+}
+
+func (s *Server) GetStatus(
+	ctx context.Context, req *taskqpb.GetStatusRequest,
+) (*taskqpb.GetStatusResponse, error) {
+	q, err := s.queue(req.GetQueue())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetAuditId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "taskq: audit_id is required")
+	}
+
+	storage, ok := q.Options().Storage.(taskq.AuditStorage)
+	if !ok || !q.Options().Audit {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"taskq: audit is disabled for queue=%q", q.Name())
+	}
+
+	rec, err := storage.AuditLookup(ctx, taskq.AuditQueueName(q), req.GetAuditId())
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if rec != nil {
+		return &taskqpb.GetStatusResponse{Status: taskqpb.Status_STATUS_PENDING}, nil
+	}
+	return &taskqpb.GetStatusResponse{Status: taskqpb.Status_STATUS_NOT_PENDING}, nil
+}
+
+// Cancel returns Unimplemented, because none of the backends can cancel
+// messages by id. Use memqueue.Queue.AddCancelable in process instead.
+func (s *Server) Cancel(
+	ctx context.Context, req *taskqpb.CancelRequest,
+) (*taskqpb.CancelResponse, error) {
+	if _, err := s.queue(req.GetQueue()); err != nil {
+		return nil, err
+	}
+	return nil, status.Error(codes.Unimplemented, "taskq: canceling messages is not supported")
+}
+
+func (s *Server) Stats(
+	ctx context.Context, req *taskqpb.StatsRequest,
+) (*taskqpb.StatsResponse, error) {
+	names := make(map[string]bool, len(req.GetQueues()))
+	for _, name := range req.GetQueues() {
+		names[name] = true
+	}
+
+	stats := s.factory.Stats()
+	resp := &taskqpb.StatsResponse{
+		Queues: make([]*taskqpb.QueueStats, 0, len(stats.Queues)),
+	}
+	for i := range stats.Queues {
+		qs := &stats.Queues[i]
+		if len(names) > 0 && !names[qs.Name] {
+			continue
+		}
+		c := &qs.Consumer
+		resp.Queues = append(resp.Queues, &taskqpb.QueueStats{
+			Name: qs.Name,
+			Len:  int64(qs.Len),
+			Consumer: &taskqpb.ConsumerStats{
+				NumWorker:  c.NumWorker,
+				NumFetcher: c.NumFetcher,
+				BufferSize: c.BufferSize,
+				Buffered:   c.Buffered,
+				InFlight:   c.InFlight,
+				Processed:  c.Processed,
+				Retries:    c.Retries,
+				Fails:      c.Fails,
+				Duplicates: c.Duplicates,
+				Timing:     durationpb.New(c.Timing),
+			},
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) queue(name string) (taskq.Queue, error) {
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "taskq: queue is required")
+	}
+	var found taskq.Queue
+	s.factory.Range(func(q taskq.Queue) bool {
+		if q.Name() == name {
+			found = q
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return nil, status.Errorf(codes.NotFound, "taskq: queue=%q not found", name)
+	}
+	return found, nil
+}
+
+func addError(err error) error {
+	var quotaErr *taskq.QuotaExceededError
+	switch {
+	case errors.As(err, &quotaErr):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, taskq.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, taskq.ErrPolicyViolation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, taskq.ErrQueueFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, taskq.ErrQueueClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
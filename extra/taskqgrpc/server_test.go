@@ -0,0 +1,176 @@
+package taskqgrpc_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/memqueue"
+	"github.com/vmihailenco/taskq/extra/taskqgrpc/v3"
+	"github.com/vmihailenco/taskq/extra/taskqgrpc/v3/taskqpb"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	factory := memqueue.NewFactory()
+	defer factory.Close()
+
+	registry := taskq.NewRegistry()
+	registry.RegisterTask(&taskq.TaskOptions{
+		Name:    "grpc",
+		Handler: func(s string) {},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:    "grpc-queue",
+		Storage: taskq.NewLocalStorage(),
+		Handler: registry,
+		Audit:   true,
+	})
+	// Keep the messages pending.
+	if err := q.Consumer().Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Purge()
+
+	client := serve(t, taskqgrpc.NewServer(factory, nil))
+
+	args, err := msgpack.Marshal([]interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &taskqpb.EnqueueRequest{
+		Queue: "grpc-queue",
+		Task:  "grpc",
+		Args:  args,
+		Name:  "grpc-message",
+	}
+	resp, err := client.Enqueue(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetAuditId() == "" {
+		t.Fatal("audit id is empty")
+	}
+
+	_, err = client.Enqueue(ctx, req)
+	if code := status.Code(err); code != codes.AlreadyExists {
+		t.Fatalf("got %s, wanted %s", code, codes.AlreadyExists)
+	}
+
+	_, err = client.Enqueue(ctx, &taskqpb.EnqueueRequest{Queue: "unknown", Task: "grpc"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("got %s, wanted %s", code, codes.NotFound)
+	}
+
+	for _, test := range []struct {
+		auditID string
+		status  taskqpb.Status
+	}{
+		{resp.GetAuditId(), taskqpb.Status_STATUS_PENDING},
+		{"unknown", taskqpb.Status_STATUS_NOT_PENDING},
+	} {
+		got, err := client.GetStatus(ctx, &taskqpb.GetStatusRequest{
+			Queue:   "grpc-queue",
+			AuditId: test.auditID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetStatus() != test.status {
+			t.Fatalf("audit_id=%q: got %s, wanted %s", test.auditID, got.GetStatus(), test.status)
+		}
+	}
+
+	stats, err := client.Stats(ctx, &taskqpb.StatsRequest{Queues: []string{"grpc-queue"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.GetQueues()) != 1 {
+		t.Fatalf("got %d queues, wanted 1", len(stats.GetQueues()))
+	}
+	if qs := stats.GetQueues()[0]; qs.GetName() != "grpc-queue" || qs.GetLen() != 1 {
+		t.Fatalf("got %+v", qs)
+	}
+}
+
+func TestServerRequestContext(t *testing.T) {
+	factory := memqueue.NewFactory()
+	defer factory.Close()
+
+	registry := taskq.NewRegistry()
+	registry.RegisterTask(&taskq.TaskOptions{
+		Name:    "grpc",
+		Handler: func() {},
+	})
+
+	var requestID string
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:    "grpc-queue",
+		Handler: registry,
+		Authorizer: func(q taskq.Queue, id *taskq.Identity, msg *taskq.Message) error {
+			if md, ok := metadata.FromIncomingContext(msg.Ctx); ok {
+				requestID = strings.Join(md.Get("x-request-id"), ",")
+			}
+			if id == nil || id.Name != "client" {
+				return taskq.ErrForbidden
+			}
+			return nil
+		},
+	})
+
+	// Keep the messages pending.
+	if err := q.Consumer().Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Purge()
+
+	client := serve(t, taskqgrpc.NewServer(factory, &taskqgrpc.ServerOptions{
+		Identity: func(ctx context.Context) *taskq.Identity {
+			return &taskq.Identity{Name: "client"}
+		},
+	}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "42")
+	_, err := client.Enqueue(ctx, &taskqpb.EnqueueRequest{Queue: "grpc-queue", Task: "grpc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "42" {
+		t.Fatalf("got request id %q, wanted 42", requestID)
+	}
+}
+
+// serve starts the gRPC server and returns a client connected to it.
+func serve(t *testing.T, taskqSrv *taskqgrpc.Server) taskqpb.TaskqClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	taskqSrv.Register(srv)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return taskqpb.NewTaskqClient(conn)
+}
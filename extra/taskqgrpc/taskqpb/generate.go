@@ -0,0 +1,4 @@
+// Package taskqpb contains the protobuf definitions of the taskq gRPC service.
+package taskqpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative taskq.proto
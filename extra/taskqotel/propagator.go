@@ -0,0 +1,66 @@
+package taskqotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// Propagator propagates the trace context and baggage in message headers,
+// so spans of handlers are linked to spans of producers even when they run
+// in different services. Use it with OpenTelemetryHook:
+//
+//	queue := factory.RegisterQueue(&taskq.QueueOptions{
+//		Name:       "api-worker",
+//		Propagator: taskqotel.Propagator{},
+//	})
+//	queue.Consumer().AddHook(&taskqotel.OpenTelemetryHook{})
+type Propagator struct {
+	// Optional propagator. The default uses W3C traceparent and baggage headers.
+	TextMapPropagator propagation.TextMapPropagator
+}
+
+var _ taskq.Propagator = (*Propagator)(nil)
+
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+func (p Propagator) propagator() propagation.TextMapPropagator {
+	if p.TextMapPropagator != nil {
+		return p.TextMapPropagator
+	}
+	return defaultPropagator
+}
+
+func (p Propagator) Inject(ctx context.Context, headers map[string]string) {
+	p.propagator().Inject(ctx, headerCarrier(headers))
+}
+
+func (p Propagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	return p.propagator().Extract(ctx, headerCarrier(headers))
+}
+
+// headerCarrier adapts message headers to propagation.TextMapCarrier.
+type headerCarrier map[string]string
+
+var _ propagation.TextMapCarrier = (headerCarrier)(nil)
+
+func (c headerCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
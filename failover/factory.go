@@ -0,0 +1,163 @@
+package failover
+
+import (
+	"context"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal/base"
+)
+
+// Options configures the failover factory.
+type Options struct {
+	// How often the primary backend is checked after a failover.
+	// Default is 5 seconds.
+	CheckInterval time.Duration
+
+	// Number of messages that are moved from the secondary backend
+	// at a time when the primary recovers. Default is 10.
+	ReplayBatchSize int
+
+	// IsUnavailable reports whether an error returned by the primary backend
+	// means the backend is unreachable. Default treats network errors
+	// as unavailability.
+	IsUnavailable func(error) bool
+}
+
+func (opt *Options) init() {
+	if opt.CheckInterval == 0 {
+		opt.CheckInterval = 5 * time.Second
+	}
+	if opt.ReplayBatchSize == 0 {
+		opt.ReplayBatchSize = 10
+	}
+	if opt.IsUnavailable == nil {
+		opt.IsUnavailable = isUnavailable
+	}
+}
+
+type factory struct {
+	base base.Factory
+
+	opt       *Options
+	primary   taskq.Factory
+	secondary taskq.Factory
+}
+
+var _ taskq.Factory = (*factory)(nil)
+
+// NewFactory returns a factory that adds messages to queues created by the
+// primary factory and fails over to queues created by the secondary factory
+// when the primary backend is unreachable. Messages added to the secondary
+// backend are moved back to the primary once it recovers, so only consumers
+// of the primary queues are started and the secondary backend must support
+// ReserveN, e.g. redisq or azsqs.
+func NewFactory(primary, secondary taskq.Factory, opt *Options) taskq.Factory {
+	if opt == nil {
+		opt = new(Options)
+	}
+	opt.init()
+	return &factory{
+		opt:       opt,
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
+	secondaryOpt := *opt
+
+	primary := f.primary.RegisterQueue(opt)
+	if secondaryOpt.Storage == nil {
+		// Share named messages between the backends.
+		secondaryOpt.Storage = opt.Storage
+	}
+	secondary := f.secondary.RegisterQueue(&secondaryOpt)
+
+	q := NewQueue(primary, secondary, f.opt)
+	if err := f.base.Register(q); err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (f *factory) UnregisterQueue(name string) error {
+	q, err := f.base.Unregister(name)
+	if err != nil {
+		return err
+	}
+	q.(*Queue).stopChecking()
+
+	firstErr := f.primary.UnregisterQueue(name)
+	if err := f.secondary.UnregisterQueue(name); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Discover returns queues that exist in the primary backend.
+func (f *factory) Discover(
+	ctx context.Context, register func(name string) *taskq.QueueOptions,
+) ([]string, error) {
+	names, err := f.primary.Discover(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	f.base.RegisterDiscovered(f.base.Namespace(), names, register, f.RegisterQueue)
+	return names, nil
+}
+
+func (f *factory) Range(fn func(taskq.Queue) bool) {
+	f.base.Range(fn)
+}
+
+// SetRegistry sets the task registry in both factories.
+func (f *factory) SetRegistry(r *taskq.TaskMap) {
+	f.primary.SetRegistry(r)
+	f.secondary.SetRegistry(r)
+}
+
+func (f *factory) Stats() *taskq.FactoryStats {
+	return f.base.Stats()
+}
+
+func (f *factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.base.OnQueueRegister(fn)
+}
+
+func (f *factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.base.OnConsumerStart(fn)
+}
+
+func (f *factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.base.OnConsumerStop(fn)
+}
+
+func (f *factory) StartConsumers(ctx context.Context) error {
+	return f.base.StartConsumers(ctx)
+}
+
+func (f *factory) StopConsumers(ctx context.Context) error {
+	return f.base.StopConsumers(ctx)
+}
+
+func (f *factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.base.PauseConsumers(ctx, d)
+}
+
+func (f *factory) ResumeConsumers(ctx context.Context) error {
+	return f.base.ResumeConsumers(ctx)
+}
+
+func (f *factory) Close() error {
+	f.base.Range(func(q taskq.Queue) bool {
+		q.(*Queue).stopChecking()
+		return true
+	})
+
+	firstErr := f.primary.Close()
+	if err := f.secondary.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
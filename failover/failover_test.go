@@ -0,0 +1,163 @@
+package failover_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/failover"
+)
+
+var errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// sliceQueue is a queue that keeps messages in memory
+// and can simulate an unreachable backend.
+type sliceQueue struct {
+	name string
+
+	mu   sync.Mutex
+	down bool
+	msgs []taskq.Message
+}
+
+var _ taskq.Queue = (*sliceQueue)(nil)
+
+func (q *sliceQueue) setDown(down bool) {
+	q.mu.Lock()
+	q.down = down
+	q.mu.Unlock()
+}
+
+func (q *sliceQueue) String() string                   { return q.name }
+func (q *sliceQueue) Name() string                     { return q.name }
+func (q *sliceQueue) Options() *taskq.QueueOptions     { return &taskq.QueueOptions{Name: q.name} }
+func (q *sliceQueue) Consumer() taskq.QueueConsumer    { return nil }
+func (q *sliceQueue) Release(*taskq.Message) error     { return nil }
+func (q *sliceQueue) Close() error                     { return nil }
+func (q *sliceQueue) CloseTimeout(time.Duration) error { return nil }
+
+func (q *sliceQueue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.down {
+		return 0, errRefused
+	}
+	return len(q.msgs), nil
+}
+
+func (q *sliceQueue) Add(msg *taskq.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.down {
+		return errRefused
+	}
+	q.msgs = append(q.msgs, *msg)
+	return nil
+}
+
+func (q *sliceQueue) AddN(_ context.Context, msgs ...*taskq.Message) error {
+	for _, msg := range msgs {
+		if err := q.Add(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *sliceQueue) ReserveN(_ context.Context, n int, _ time.Duration) ([]taskq.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > len(q.msgs) {
+		n = len(q.msgs)
+	}
+	msgs := q.msgs[:n:n]
+	q.msgs = q.msgs[n:]
+	return msgs, nil
+}
+
+func (q *sliceQueue) Delete(*taskq.Message) error { return nil }
+
+func (q *sliceQueue) Purge() error {
+	q.mu.Lock()
+	q.msgs = nil
+	q.mu.Unlock()
+	return nil
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+
+	primary := &sliceQueue{name: "primary"}
+	secondary := &sliceQueue{name: "secondary"}
+	q := failover.NewQueue(primary, secondary, &failover.Options{
+		CheckInterval: 10 * time.Millisecond,
+	})
+	defer q.Close()
+
+	add := func(args ...interface{}) {
+		msg := taskq.NewMessage(ctx, args...)
+		msg.TaskName = "test"
+		if err := q.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(1)
+
+	primary.setDown(true)
+	add(2)
+	add(3)
+
+	if !q.FailedOver() {
+		t.Fatal("queue is not failed over")
+	}
+	if n, _ := secondary.Len(); n != 2 {
+		t.Fatalf("secondary has %d messages, wanted 2", n)
+	}
+
+	primary.setDown(false)
+
+	deadline := time.Now().Add(time.Second)
+	for q.FailedOver() || mustLen(t, secondary) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("messages were not replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := mustLen(t, primary); n != 3 {
+		t.Fatalf("primary has %d messages, wanted 3", n)
+	}
+}
+
+func TestFailoverOtherError(t *testing.T) {
+	primary := &sliceQueue{name: "primary"}
+	secondary := &sliceQueue{name: "secondary"}
+	q := failover.NewQueue(primary, secondary, &failover.Options{
+		IsUnavailable: func(error) bool { return false },
+	})
+	defer q.Close()
+
+	primary.setDown(true)
+
+	msg := taskq.NewMessage(context.Background())
+	msg.TaskName = "test"
+	if err := q.Add(msg); err != errRefused {
+		t.Fatalf("got %v, wanted %v", err, errRefused)
+	}
+	if q.FailedOver() {
+		t.Fatal("queue is failed over")
+	}
+}
+
+func mustLen(t *testing.T, q taskq.Queue) int {
+	n, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
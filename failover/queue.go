@@ -0,0 +1,276 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// Queue adds messages to the primary queue and to the secondary queue while
+// the primary backend is unreachable. Messages are consumed from the primary.
+type Queue struct {
+	opt       *Options
+	primary   taskq.Queue
+	secondary taskq.Queue
+
+	failedOver uint32
+
+	mu       sync.Mutex
+	checking bool
+	stopCh   chan struct{}
+	_closed  uint32
+}
+
+var _ taskq.Queue = (*Queue)(nil)
+
+// NewQueue returns a queue that fails over from the primary to the secondary.
+func NewQueue(primary, secondary taskq.Queue, opt *Options) *Queue {
+	if opt == nil {
+		opt = new(Options)
+	}
+	opt.init()
+	return &Queue{
+		opt:       opt,
+		primary:   primary,
+		secondary: secondary,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (q *Queue) Name() string {
+	return q.primary.Name()
+}
+
+func (q *Queue) String() string {
+	return fmt.Sprintf("queue=%q", q.Name())
+}
+
+func (q *Queue) Options() *taskq.QueueOptions {
+	return q.primary.Options()
+}
+
+func (q *Queue) Consumer() taskq.QueueConsumer {
+	return q.primary.Consumer()
+}
+
+// Primary returns the primary queue.
+func (q *Queue) Primary() taskq.Queue {
+	return q.primary
+}
+
+// Secondary returns the queue that is used while the primary is unreachable.
+func (q *Queue) Secondary() taskq.Queue {
+	return q.secondary
+}
+
+// FailedOver reports whether messages are currently added to the secondary queue.
+func (q *Queue) FailedOver() bool {
+	return atomic.LoadUint32(&q.failedOver) == 1
+}
+
+// Len returns the number of messages in both queues.
+func (q *Queue) Len() (int, error) {
+	n, err := q.primary.Len()
+	if err != nil {
+		return 0, err
+	}
+	m, err := q.secondary.Len()
+	if err != nil {
+		return 0, err
+	}
+	return n + m, nil
+}
+
+// Add adds the message to the primary queue or to the secondary queue
+// if the primary backend is unreachable.
+func (q *Queue) Add(msg *taskq.Message) error {
+	if !q.FailedOver() {
+		err := q.primary.Add(msg)
+		if err == nil || !q.opt.IsUnavailable(err) {
+			return err
+		}
+		internal.Logger.Printf("%s: primary is unavailable: %s", q, err)
+		q.failOver()
+	}
+	return q.secondary.Add(msg)
+}
+
+// AddN adds messages to the primary queue or to the secondary queue
+// if the primary backend is unreachable.
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	if !q.FailedOver() {
+		err := q.primary.AddN(ctx, msgs...)
+		if err == nil || !q.opt.IsUnavailable(err) {
+			return err
+		}
+		internal.Logger.Printf("%s: primary is unavailable: %s", q, err)
+		q.failOver()
+	}
+	return q.secondary.AddN(ctx, msgs...)
+}
+
+func (q *Queue) ReserveN(
+	ctx context.Context, n int, waitTimeout time.Duration,
+) ([]taskq.Message, error) {
+	return q.primary.ReserveN(ctx, n, waitTimeout)
+}
+
+func (q *Queue) Release(msg *taskq.Message) error {
+	return q.primary.Release(msg)
+}
+
+func (q *Queue) Delete(msg *taskq.Message) error {
+	return q.primary.Delete(msg)
+}
+
+// Purge deletes all messages from both queues.
+func (q *Queue) Purge() error {
+	firstErr := q.primary.Purge()
+	if err := q.secondary.Purge(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (q *Queue) Close() error {
+	return q.CloseTimeout(30 * time.Second)
+}
+
+// CloseTimeout closes both queues.
+func (q *Queue) CloseTimeout(timeout time.Duration) error {
+	q.stopChecking()
+
+	firstErr := q.primary.CloseTimeout(timeout)
+	if err := q.secondary.CloseTimeout(timeout); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (q *Queue) stopChecking() {
+	if atomic.CompareAndSwapUint32(&q._closed, 0, 1) {
+		close(q.stopCh)
+	}
+}
+
+func (q *Queue) failOver() {
+	atomic.StoreUint32(&q.failedOver, 1)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.checking || atomic.LoadUint32(&q._closed) == 1 {
+		return
+	}
+	q.checking = true
+	go q.checkPrimary()
+}
+
+// checkPrimary waits until the primary backend is reachable again
+// and moves messages from the secondary queue back to the primary.
+func (q *Queue) checkPrimary() {
+	ticker := time.NewTicker(q.opt.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopCh:
+			q.mu.Lock()
+			q.checking = false
+			q.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := q.primary.Len(); err != nil {
+			continue
+		}
+
+		atomic.StoreUint32(&q.failedOver, 0)
+		internal.Logger.Printf("%s: primary is available again", q)
+
+		if err := q.replay(); err != nil {
+			internal.Logger.Printf("%s: replay failed: %s", q, err)
+			atomic.StoreUint32(&q.failedOver, 1)
+			continue
+		}
+
+		q.mu.Lock()
+		// Keep checking if Add failed over again during the replay.
+		if !q.FailedOver() {
+			q.checking = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+	}
+}
+
+// replay moves messages from the secondary queue to the primary.
+func (q *Queue) replay() error {
+	ctx := context.Background()
+	for {
+		select {
+		case <-q.stopCh:
+			return nil
+		default:
+		}
+
+		msgs, err := q.secondary.ReserveN(ctx, q.opt.ReplayBatchSize, 0)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		for i := range msgs {
+			msg := &msgs[i]
+			if err := q.primary.Add(replayMessage(ctx, msg)); err != nil {
+				_ = q.secondary.Release(msg)
+				return err
+			}
+			if err := q.secondary.Delete(msg); err != nil {
+				internal.Logger.Printf("%s: Delete failed: %s", q, err)
+			}
+		}
+	}
+}
+
+// replayMessage copies the message without the secondary backend state.
+func replayMessage(ctx context.Context, msg *taskq.Message) *taskq.Message {
+	return &taskq.Message{
+		Ctx:             ctx,
+		TaskName:        msg.TaskName,
+		ArgsCompression: msg.ArgsCompression,
+		ArgsBin:         msg.ArgsBin,
+		Priority:        msg.Priority,
+		OrderingKey:     msg.OrderingKey,
+		Headers:         msg.Headers,
+	}
+}
+
+func isUnavailable(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	s := err.Error()
+	return strings.Contains(s, "connection refused") ||
+		strings.Contains(s, "connection reset")
+}
@@ -0,0 +1,136 @@
+package taskq_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// reserveQueue is a queue that always has messages to reserve
+// and records the number of messages requested by ReserveN.
+type reserveQueue struct {
+	opt *taskq.QueueOptions
+
+	mu    sync.Mutex
+	sizes []int
+}
+
+var _ taskq.Queue = (*reserveQueue)(nil)
+
+func (q *reserveQueue) Name() string                       { return q.opt.Name }
+func (q *reserveQueue) String() string                     { return fmt.Sprintf("queue=%q", q.Name()) }
+func (q *reserveQueue) Options() *taskq.QueueOptions       { return q.opt }
+func (q *reserveQueue) Consumer() taskq.QueueConsumer      { return nil }
+func (q *reserveQueue) Len() (int, error)                  { return 0, nil }
+func (q *reserveQueue) Add(msg *taskq.Message) error       { return nil }
+func (q *reserveQueue) Release(msg *taskq.Message) error   { return nil }
+func (q *reserveQueue) Delete(msg *taskq.Message) error    { return nil }
+func (q *reserveQueue) Purge() error                       { return nil }
+func (q *reserveQueue) Close() error                       { return nil }
+func (q *reserveQueue) CloseTimeout(_ time.Duration) error { return nil }
+
+func (q *reserveQueue) AddN(_ context.Context, _ ...*taskq.Message) error {
+	return nil
+}
+
+func (q *reserveQueue) ReserveN(ctx context.Context, n int, _ time.Duration) ([]taskq.Message, error) {
+	q.mu.Lock()
+	q.sizes = append(q.sizes, n)
+	q.mu.Unlock()
+
+	msgs := make([]taskq.Message, n)
+	for i := range msgs {
+		msgs[i].Ctx = ctx
+		msgs[i].TaskName = "fetch-size"
+	}
+	return msgs, nil
+}
+
+func (q *reserveQueue) Sizes() []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]int(nil), q.sizes...)
+}
+
+func TestFetchSizeIsLimitedByReservationTimeout(t *testing.T) {
+	registry := taskq.NewRegistry()
+	registry.RegisterTask(&taskq.TaskOptions{
+		Name: "fetch-size",
+		Handler: func() {
+			time.Sleep(20 * time.Millisecond)
+		},
+	})
+
+	q := &reserveQueue{
+		opt: &taskq.QueueOptions{
+			Name:               "fetch-size",
+			Handler:            registry,
+			MinNumWorker:       1,
+			MaxNumWorker:       1,
+			ReservationSize:    100,
+			ReservationTimeout: 200 * time.Millisecond,
+		},
+	}
+	q.opt.Init()
+
+	c := taskq.NewConsumer(q)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := q.Sizes()
+	if len(sizes) < 2 {
+		t.Fatalf("got %d ReserveN calls, wanted at least 2", len(sizes))
+	}
+	for _, n := range sizes {
+		// 1 worker processes 200ms/20ms = 10 messages before reservation expires.
+		if n > 10 {
+			t.Fatalf("got ReserveN(%d), wanted at most 10 (all sizes: %v)", n, sizes)
+		}
+	}
+}
+
+func TestBufferSizeIsExact(t *testing.T) {
+	q := &reserveQueue{
+		opt: &taskq.QueueOptions{
+			Name:         "buffer-size",
+			MaxNumWorker: 1,
+			BufferSize:   10,
+		},
+	}
+	q.opt.Init()
+
+	// The consumer is not started, so the buffer is not drained.
+	c := taskq.NewConsumer(q)
+	if got := c.Stats().BufferSize; got != 10 {
+		t.Fatalf("got BufferSize=%d, wanted 10", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := taskq.NewMessage(context.Background())
+		if err := c.AddContext(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.AddContext(ctx, taskq.NewMessage(ctx))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	if got := c.Len(); got != 10 {
+		t.Fatalf("got Len=%d, wanted 10", got)
+	}
+}
@@ -0,0 +1,141 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// fifoWorker reserves and processes messages one at a time so they are
+// processed in the queue order. With Redis only the consumer that holds
+// the worker lease processes messages.
+func (c *Consumer) fifoWorker(ctx context.Context) {
+	defer func() {
+		if c.fifoLease != nil {
+			_ = c.fifoLease.Release(ctx)
+			c.fifoLease = nil
+		}
+	}()
+
+	timer := c.opt.Clock.NewTimer(time.Minute)
+	timer.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		default:
+		}
+
+		if c.workers != nil {
+			c.fifoLease = c.leaseWorker(ctx, c.fifoLease)
+			if c.fifoLease == nil {
+				continue
+			}
+		}
+
+		if c.fleetPaused(ctx) {
+			c.sleep(pauseCheckInterval)
+			continue
+		}
+
+		msg, err := c.reserveFIFO(ctx, timer)
+		if err != nil {
+			const backoff = time.Second
+			internal.Logger.Printf(
+				"%s ReserveN failed: %s (sleeping for dur=%s)",
+				c, err, backoff)
+			c.sleep(backoff)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		c.pacer.Wait(c.stopCh)
+
+		msg.Ctx = ctx
+		_ = c.Process(msg)
+	}
+}
+
+func (c *Consumer) reserveFIFO(ctx context.Context, timer Timer) (*Message, error) {
+	if msg := c.buffer.TryGet(); msg != nil {
+		return msg, nil
+	}
+
+	msgs, err := c.q.ReserveN(ctx, 1, c.opt.WaitTimeout)
+	if err != nil {
+		if !errors.Is(err, ErrNotSupported) {
+			return nil, err
+		}
+
+		// The queue adds messages directly to the buffer.
+		timer.Reset(c.opt.WaitTimeout)
+		msg, timeout := c.buffer.Get(timer.C(), c.stopCh)
+		if !timeout && !timer.Stop() {
+			<-timer.C()
+		}
+		return msg, nil
+	}
+
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	return &msgs[0], nil
+}
+
+// retryInPlace retries the failed message after msg.Delay without
+// releasing it, so messages added after it are not processed first.
+func (c *Consumer) retryInPlace(msg *Message) {
+	internal.Logger.Printf("task=%q%s failed (will retry=%d in place in dur=%s): %s",
+		msg.TaskName, c.logPayload(msg), msg.ReservedCount, msg.Delay, msg.Err)
+
+	if !c.waitRetry(msg) {
+		// The consumer is stopped.
+		c.release(msg)
+		return
+	}
+
+	atomic.AddUint32(&c.inFlight, ^uint32(0))
+	msg.ReservedCount++
+	msg.Err = nil
+	msg.Delay = 0
+	msg.evt = nil
+	_ = c.Process(msg)
+}
+
+// waitRetry waits msg.Delay keeping the message reserved and the worker
+// lease renewed. It returns false if the consumer is stopped.
+func (c *Consumer) waitRetry(msg *Message) bool {
+	step := c.opt.ReservationTimeout / 2
+	for left := msg.Delay; left > 0; left -= step {
+		if left < step {
+			step = left
+		}
+
+		timer := c.opt.Clock.NewTimer(step)
+		select {
+		case <-timer.C():
+		case <-c.stopCh:
+			timer.Stop()
+			return false
+		}
+
+		ctx := msgContext(msg)
+		if msg.toucher != nil {
+			if err := msg.toucher.Touch(ctx, msg); err != nil && !errors.Is(err, ErrNotSupported) {
+				internal.Logger.Printf("task=%q Touch failed: %s", msg.TaskName, err)
+			}
+		}
+		if c.fifoLease != nil {
+			if err := c.fifoLease.Renew(ctx); err != nil {
+				internal.Logger.Printf("%s lease.Renew failed: %s", c, err)
+			}
+		}
+	}
+	return true
+}
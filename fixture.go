@@ -0,0 +1,136 @@
+package taskq
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type fixtureRecord struct {
+	Time    time.Time `msgpack:"time"`
+	Message []byte    `msgpack:"msg"`
+}
+
+// FixtureRecorder is a ConsumerHook that records messages received by the
+// consumer in the wire format, so production incidents can be reproduced
+// locally by replaying the fixture (see taskqtest.ReplayFixture):
+//
+//	rec, err := taskq.CreateFixture("incident.fixture")
+//	q.Consumer().AddHook(rec)
+//	defer rec.Close()
+type FixtureRecorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	c   io.Closer
+	enc *msgpack.Encoder
+	err error
+}
+
+var _ ConsumerHook = (*FixtureRecorder)(nil)
+
+// NewFixtureRecorder returns a recorder that writes messages to w.
+func NewFixtureRecorder(w io.Writer) *FixtureRecorder {
+	bw := bufio.NewWriter(w)
+	return &FixtureRecorder{
+		w:   bw,
+		enc: msgpack.NewEncoder(bw),
+	}
+}
+
+// CreateFixture returns a recorder that appends messages to the file.
+func CreateFixture(path string) (*FixtureRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := NewFixtureRecorder(f)
+	r.c = f
+	return r, nil
+}
+
+func (r *FixtureRecorder) BeforeProcessMessage(evt *ProcessMessageEvent) error {
+	// Marshal a copy so the message processed by the handler is not changed.
+	clone := *evt.Message
+	clone.marshalBinaryCache = nil
+	clone.bufs = nil
+	b, err := clone.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+
+	rec := fixtureRecord{
+		Time:    evt.StartTime,
+		Message: b,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(&rec)
+	}
+	return nil
+}
+
+func (r *FixtureRecorder) AfterProcessMessage(evt *ProcessMessageEvent) error {
+	return nil
+}
+
+// Flush writes buffered messages and returns the first write error.
+func (r *FixtureRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close flushes buffered messages and closes the file
+// opened by CreateFixture.
+func (r *FixtureRecorder) Close() error {
+	err := r.Flush()
+	if r.c != nil {
+		if closeErr := r.c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ReadFixture reads messages recorded with FixtureRecorder.
+func ReadFixture(rd io.Reader) ([]*Message, error) {
+	dec := msgpack.NewDecoder(bufio.NewReader(rd))
+
+	var msgs []*Message
+	for {
+		var rec fixtureRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return msgs, nil
+			}
+			return msgs, err
+		}
+
+		msg := new(Message)
+		if err := msg.UnmarshalBinary(rec.Message); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// LoadFixture reads messages from the fixture file.
+func LoadFixture(path string) ([]*Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadFixture(f)
+}
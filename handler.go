@@ -1,13 +1,10 @@
 package taskq
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"reflect"
-
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
@@ -35,6 +32,20 @@ type reflectFunc struct {
 
 var _ Handler = (*reflectFunc)(nil)
 
+// checkHandler returns an error if NewHandler would panic.
+func checkHandler(fn interface{}) error {
+	if fn == nil {
+		return errors.New("handler func is nil")
+	}
+	if _, ok := fn.(Handler); ok {
+		return nil
+	}
+	if kind := reflect.TypeOf(fn).Kind(); kind != reflect.Func {
+		return fmt.Errorf("got %s, wanted %s", kind, reflect.Func)
+	}
+	return nil
+}
+
 func NewHandler(fn interface{}) Handler {
 	if fn == nil {
 		panic(errors.New("taskq: handler func is nil"))
@@ -69,11 +80,48 @@ func NewHandler(fn interface{}) Handler {
 	return &h
 }
 
+// newFallbackHandler is like NewHandler, but also accepts functions that
+// receive the error of the last attempt:
+//
+//	func(ctx context.Context, msg *Message, err error)
+//	func(ctx context.Context, msg *Message, err error) error
+func newFallbackHandler(fn interface{}) Handler {
+	switch fn := fn.(type) {
+	case func(context.Context, *Message, error):
+		return HandlerFunc(func(msg *Message) error {
+			fn(msgContext(msg), msg, msg.Err)
+			return nil
+		})
+	case func(context.Context, *Message, error) error:
+		return HandlerFunc(func(msg *Message) error {
+			return fn(msgContext(msg), msg, msg.Err)
+		})
+	}
+	return NewHandler(fn)
+}
+
+func msgContext(msg *Message) context.Context {
+	if msg.Ctx != nil {
+		return msg.Ctx
+	}
+	return context.Background()
+}
+
 func (h *reflectFunc) HandleMessage(msg *Message) error {
 	in, err := h.fnArgs(msg)
 	if err != nil {
 		return err
 	}
+	// Fallback handlers receive messages that already failed the policy.
+	if msg.policy != nil && msg.Err == nil {
+		args := in
+		if h.acceptsContext {
+			args = in[1:]
+		}
+		if err := msg.policy.checkDecoded(msg, args); err != nil {
+			return err
+		}
+	}
 
 	out := h.fv.Call(in)
 	if h.returnsError {
@@ -125,7 +173,10 @@ func (h *reflectFunc) fnArgs(msg *Message) ([]reflect.Value, error) {
 		return nil, err
 	}
 
-	dec := msgpack.NewDecoder(bytes.NewBuffer(b))
+	d := getDecoder(b)
+	defer putDecoder(d)
+
+	dec := d.dec
 	n, err := dec.DecodeArrayLen()
 	if err != nil {
 		return nil, err
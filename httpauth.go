@@ -0,0 +1,89 @@
+package taskq
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized is returned by authenticators when the request
+// has no valid credentials.
+var ErrUnauthorized = errors.New("taskq: unauthorized")
+
+// Authenticator authenticates requests to HTTP handlers provided by taskq,
+// e.g. Recorder. It returns ErrUnauthorized or another error when
+// the request is not allowed.
+type Authenticator func(req *http.Request) error
+
+// TokenAuth returns an Authenticator that accepts requests with any of the
+// tokens in the "Authorization: Bearer <token>" header.
+func TokenAuth(tokens ...string) Authenticator {
+	return func(req *http.Request) error {
+		const prefix = "Bearer "
+		header := req.Header.Get("Authorization")
+		if !strings.HasPrefix(header, prefix) {
+			return ErrUnauthorized
+		}
+		got := []byte(header[len(prefix):])
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return ErrUnauthorized
+	}
+}
+
+// ClientCertAuth returns an Authenticator that accepts requests with
+// a verified TLS client certificate, i.e. mTLS, whose common name is
+// one of the names. Any verified certificate is accepted when no names
+// are given. The server must be configured to verify client certificates.
+func ClientCertAuth(names ...string) Authenticator {
+	return func(req *http.Request) error {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 ||
+			len(req.TLS.VerifiedChains[0]) == 0 {
+			return ErrUnauthorized
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, name := range names {
+			if cn == name {
+				return nil
+			}
+		}
+		return ErrUnauthorized
+	}
+}
+
+// AnyAuth returns an Authenticator that accepts requests accepted
+// by any of the authenticators, e.g. tokens for scripts and client
+// certificates for services.
+func AnyAuth(auths ...Authenticator) Authenticator {
+	return func(req *http.Request) error {
+		err := ErrUnauthorized
+		for _, auth := range auths {
+			if err = auth(req); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// RequireAuth returns a handler that serves requests accepted by the
+// authenticator and responds with 401 status code otherwise:
+//
+//	rec := taskq.NewRecorder(1000)
+//	http.Handle("/debug/taskq", taskq.RequireAuth(rec, taskq.TokenAuth(token)))
+func RequireAuth(h http.Handler, auth Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := auth(req); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,65 @@
+package taskq_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestRequireAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	withCert := func(req *http.Request, cn string) *http.Request {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}
+		return req
+	}
+
+	auth := taskq.AnyAuth(
+		taskq.TokenAuth("secret"),
+		taskq.ClientCertAuth("operator"),
+	)
+	h := taskq.RequireAuth(ok, auth)
+
+	tests := []struct {
+		name   string
+		req    func(req *http.Request) *http.Request
+		status int
+	}{
+		{"no credentials", func(req *http.Request) *http.Request {
+			return req
+		}, http.StatusUnauthorized},
+		{"valid token", func(req *http.Request) *http.Request {
+			req.Header.Set("Authorization", "Bearer secret")
+			return req
+		}, http.StatusOK},
+		{"invalid token", func(req *http.Request) *http.Request {
+			req.Header.Set("Authorization", "Bearer guess")
+			return req
+		}, http.StatusUnauthorized},
+		{"valid client cert", func(req *http.Request) *http.Request {
+			return withCert(req, "operator")
+		}, http.StatusOK},
+		{"unknown client cert", func(req *http.Request) *http.Request {
+			return withCert(req, "intruder")
+		}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req := test.req(httptest.NewRequest(http.MethodGet, "/debug/taskq", nil))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("%s: got %d, wanted %d", test.name, w.Code, test.status)
+		}
+	}
+}
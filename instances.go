@@ -0,0 +1,211 @@
+package taskq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+const (
+	// How often consumers update their heartbeat.
+	heartbeatInterval = 10 * time.Second
+	// Consumers are considered dead after missing this many heartbeats.
+	heartbeatMisses = 3
+)
+
+// ConsumerInfo describes a running consumer registered in Redis.
+type ConsumerInfo struct {
+	ID string
+	// Id of the process running the consumer, shared by its consumers.
+	Instance   string
+	Hostname   string
+	PID        int
+	Queue      string
+	Group      string
+	NumWorker  uint32
+	NumFetcher uint32
+	InFlight   uint32
+	// Counters since the consumer was created or ResetStats was called.
+	Processed uint64
+	Fails     uint64
+	StartedAt time.Time
+	// Time of the last heartbeat.
+	HeartbeatAt time.Time
+}
+
+// FleetInstance describes a process running consumers.
+type FleetInstance struct {
+	ID        string
+	Hostname  string
+	PID       int
+	Consumers []ConsumerInfo
+	// Time of the latest heartbeat of the consumers.
+	HeartbeatAt time.Time
+}
+
+// FleetTopology describes which consumers of which processes
+// are attached to which queues.
+type FleetTopology struct {
+	Instances []FleetInstance
+	// Consumers by queue name.
+	Queues map[string][]ConsumerInfo
+}
+
+// processID identifies the process in ConsumerInfo.Instance.
+var processID = newInstanceID()
+
+func instancesKey(namespace string) string {
+	return internal.Namespaced(namespace, ":", "taskq:{consumers}")
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.Itoa(rand.Int())
+}
+
+// ListConsumers returns consumers that share the Redis client and
+// the namespace of the queue options and sent a heartbeat recently.
+// Consumers that stopped sending heartbeats are removed.
+func ListConsumers(ctx context.Context, opt *QueueOptions) ([]ConsumerInfo, error) {
+	if opt.Redis == nil {
+		return nil, errors.New("taskq: ListConsumers requires QueueOptions.Redis")
+	}
+	key := instancesKey(opt.Namespace)
+
+	var hgetall *redis.StringStringMapCmd
+	if _, err := opt.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		hgetall = pipe.HGetAll(ctx, key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-heartbeatMisses * heartbeatInterval)
+	var infos []ConsumerInfo
+	var dead []string
+	for id, s := range hgetall.Val() {
+		var info ConsumerInfo
+		if err := json.Unmarshal([]byte(s), &info); err != nil || info.HeartbeatAt.Before(deadline) {
+			dead = append(dead, id)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if len(dead) > 0 {
+		_, _ = opt.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, key, dead...)
+			return nil
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+// Fleet returns the consumers listed by ListConsumers grouped
+// by process and by queue, e.g. to build dashboards of multi-node
+// deployments.
+func Fleet(ctx context.Context, opt *QueueOptions) (*FleetTopology, error) {
+	infos, err := ListConsumers(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	fleet := &FleetTopology{
+		Queues: make(map[string][]ConsumerInfo),
+	}
+	index := make(map[string]int)
+	for _, info := range infos {
+		fleet.Queues[info.Queue] = append(fleet.Queues[info.Queue], info)
+
+		instance := info.Instance
+		if instance == "" {
+			// Consumers of older versions.
+			instance = info.ID
+		}
+		i, ok := index[instance]
+		if !ok {
+			i = len(fleet.Instances)
+			index[instance] = i
+			fleet.Instances = append(fleet.Instances, FleetInstance{
+				ID:       instance,
+				Hostname: info.Hostname,
+				PID:      info.PID,
+			})
+		}
+		inst := &fleet.Instances[i]
+		inst.Consumers = append(inst.Consumers, info)
+		if info.HeartbeatAt.After(inst.HeartbeatAt) {
+			inst.HeartbeatAt = info.HeartbeatAt
+		}
+	}
+
+	sort.Slice(fleet.Instances, func(i, j int) bool {
+		return fleet.Instances[i].ID < fleet.Instances[j].ID
+	})
+	return fleet, nil
+}
+
+// heartbeat registers the consumer in Redis and updates
+// its heartbeat until the consumer is stopped.
+func (c *Consumer) heartbeat(ctx context.Context, stopCh <-chan struct{}) {
+	id := c.instanceID
+	key := instancesKey(c.opt.Namespace)
+	host, _ := os.Hostname()
+	startedAt := time.Now()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := c.Stats()
+		info := ConsumerInfo{
+			ID:          id,
+			Instance:    processID,
+			Hostname:    host,
+			PID:         os.Getpid(),
+			Queue:       c.q.Name(),
+			Group:       c.opt.Group,
+			NumWorker:   stats.NumWorker,
+			NumFetcher:  stats.NumFetcher,
+			InFlight:    stats.InFlight,
+			Processed:   stats.Processed,
+			Fails:       stats.Fails,
+			StartedAt:   startedAt,
+			HeartbeatAt: time.Now(),
+		}
+		b, _ := json.Marshal(&info)
+
+		if _, err := c.opt.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, id, b)
+			return nil
+		}); err != nil {
+			internal.Logger.Printf("%s: heartbeat failed: %s", c, err)
+		}
+		if c.opt.StickyOrderingKeys {
+			c.refreshSticky(ctx)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			_, _ = c.opt.Redis.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+				pipe.HDel(context.Background(), key, id)
+				return nil
+			})
+			return
+		}
+	}
+}
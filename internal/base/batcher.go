@@ -2,15 +2,20 @@ package base
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/frain-dev/taskq/v3"
 )
 
 type BatcherOptions struct {
-	Handler     func([]*taskq.Message) error
+	Handler func([]*taskq.Message) error
+	// Optional function that reports whether the message fits in the batch.
 	ShouldBatch func([]*taskq.Message, *taskq.Message) bool
 
+	// Batch is processed as soon as it has MaxSize messages.
+	// Default is 0 (no limit).
+	MaxSize int
 	Timeout time.Duration
 }
 
@@ -22,6 +27,12 @@ func (opt *BatcherOptions) init() {
 
 // Batcher collects messages for later batch processing.
 type Batcher struct {
+	// 64-bit atomic counters are first so they are aligned on 32-bit platforms.
+	batches  uint64
+	messages uint64
+	wait     int64
+	timing   int64
+
 	consumer taskq.QueueConsumer
 	opt      *BatcherOptions
 
@@ -29,6 +40,7 @@ type Batcher struct {
 
 	mu     sync.Mutex
 	batch  []*taskq.Message
+	start  time.Time
 	closed bool
 }
 
@@ -45,13 +57,14 @@ func NewBatcher(consumer taskq.QueueConsumer, opt *BatcherOptions) *Batcher {
 
 func (b *Batcher) flush() {
 	if len(b.batch) > 0 {
-		b.process(b.batch)
+		b.process(b.batch, b.start)
 		b.batch = nil
 	}
 }
 
 func (b *Batcher) Add(msg *taskq.Message) error {
 	var batch []*taskq.Message
+	var start time.Time
 
 	b.mu.Lock()
 
@@ -60,24 +73,32 @@ func (b *Batcher) Add(msg *taskq.Message) error {
 			panic("not reached")
 		}
 		batch = []*taskq.Message{msg}
+		start = time.Now()
 	} else {
 		if len(b.batch) == 0 {
 			b.stopTimer()
 			b.timer.Reset(b.opt.Timeout)
+			b.start = time.Now()
 		}
 
-		if b.opt.ShouldBatch(b.batch, msg) {
+		if b.opt.ShouldBatch == nil || b.opt.ShouldBatch(b.batch, msg) {
 			b.batch = append(b.batch, msg)
+			if b.opt.MaxSize > 0 && len(b.batch) >= b.opt.MaxSize {
+				batch, start = b.batch, b.start
+				b.batch = nil
+				b.stopTimer()
+			}
 		} else {
-			batch = b.batch
+			batch, start = b.batch, b.start
 			b.batch = []*taskq.Message{msg}
+			b.start = time.Now()
 		}
 	}
 
 	b.mu.Unlock()
 
 	if len(batch) > 0 {
-		b.process(batch)
+		b.process(batch, start)
 	}
 
 	return taskq.ErrAsyncTask
@@ -92,8 +113,11 @@ func (b *Batcher) stopTimer() {
 	}
 }
 
-func (b *Batcher) process(batch []*taskq.Message) {
+func (b *Batcher) process(batch []*taskq.Message, start time.Time) {
+	now := time.Now()
 	err := b.opt.Handler(batch)
+	b.updateStats(len(batch), now.Sub(start), time.Since(now))
+
 	for _, msg := range batch {
 		if msg.Err == nil {
 			msg.Err = err
@@ -102,6 +126,26 @@ func (b *Batcher) process(batch []*taskq.Message) {
 	}
 }
 
+func (b *Batcher) updateStats(size int, wait, timing time.Duration) {
+	atomic.AddUint64(&b.batches, 1)
+	atomic.AddUint64(&b.messages, uint64(size))
+	atomic.AddInt64(&b.wait, int64(wait))
+	atomic.AddInt64(&b.timing, int64(timing))
+}
+
+// Stats returns stats of processed batches.
+func (b *Batcher) Stats() *taskq.BatchStats {
+	st := &taskq.BatchStats{
+		Batches:  atomic.LoadUint64(&b.batches),
+		Messages: atomic.LoadUint64(&b.messages),
+	}
+	if st.Batches > 0 {
+		st.Wait = time.Duration(atomic.LoadInt64(&b.wait) / int64(st.Batches))
+		st.Timing = time.Duration(atomic.LoadInt64(&b.timing) / int64(st.Batches))
+	}
+	return st
+}
+
 func (b *Batcher) onTimeout() {
 	b.mu.Lock()
 	b.flush()
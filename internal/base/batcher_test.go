@@ -0,0 +1,147 @@
+package base
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// fakeConsumer records messages put back by the batcher.
+type fakeConsumer struct {
+	taskq.QueueConsumer
+
+	mu   sync.Mutex
+	msgs []*taskq.Message
+}
+
+func (c *fakeConsumer) Put(msg *taskq.Message) {
+	c.mu.Lock()
+	c.msgs = append(c.msgs, msg)
+	c.mu.Unlock()
+}
+
+func (c *fakeConsumer) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.msgs)
+}
+
+func newBatcherMessages(n int) []*taskq.Message {
+	msgs := make([]*taskq.Message, n)
+	for i := range msgs {
+		msgs[i] = taskq.NewMessage(context.Background())
+	}
+	return msgs
+}
+
+func TestBatcherMaxSize(t *testing.T) {
+	consumer := new(fakeConsumer)
+	var sizes []int
+	b := NewBatcher(consumer, &BatcherOptions{
+		Handler: func(msgs []*taskq.Message) error {
+			sizes = append(sizes, len(msgs))
+			return nil
+		},
+		MaxSize: 3,
+		Timeout: time.Hour,
+	})
+
+	for _, msg := range newBatcherMessages(5) {
+		if err := b.Add(msg); err != taskq.ErrAsyncTask {
+			t.Fatalf("got %v, wanted ErrAsyncTask", err)
+		}
+	}
+
+	// The full batch is processed by Add without waiting for the timeout.
+	if len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("got batches %v, wanted [3]", sizes)
+	}
+	if n := consumer.Len(); n != 3 {
+		t.Fatalf("got %d processed messages, wanted 3", n)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[1] != 2 {
+		t.Fatalf("got batches %v, wanted [3 2]", sizes)
+	}
+	if n := consumer.Len(); n != 5 {
+		t.Fatalf("got %d processed messages, wanted 5", n)
+	}
+}
+
+func TestBatcherTimeout(t *testing.T) {
+	consumer := new(fakeConsumer)
+	processed := make(chan int, 1)
+	b := NewBatcher(consumer, &BatcherOptions{
+		Handler: func(msgs []*taskq.Message) error {
+			processed <- len(msgs)
+			return nil
+		},
+		MaxSize: 10,
+		Timeout: 50 * time.Millisecond,
+	})
+	defer b.Close()
+
+	for _, msg := range newBatcherMessages(2) {
+		_ = b.Add(msg)
+	}
+
+	select {
+	case n := <-processed:
+		if n != 2 {
+			t.Fatalf("got batch of %d messages, wanted 2", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch is not processed after the timeout")
+	}
+	if n := consumer.Len(); n != 2 {
+		t.Fatalf("got %d processed messages, wanted 2", n)
+	}
+}
+
+func TestBatcherStats(t *testing.T) {
+	const (
+		wait   = 20 * time.Millisecond
+		timing = 10 * time.Millisecond
+	)
+
+	b := NewBatcher(new(fakeConsumer), &BatcherOptions{
+		Handler: func(msgs []*taskq.Message) error {
+			time.Sleep(timing)
+			return nil
+		},
+		MaxSize: 2,
+		Timeout: time.Hour,
+	})
+	defer b.Close()
+
+	if st := b.Stats(); st.Batches != 0 || st.Wait != 0 || st.AvgSize() != 0 {
+		t.Fatalf("got %+v, wanted empty stats", st)
+	}
+
+	for i := 0; i < 2; i++ {
+		msgs := newBatcherMessages(2)
+		_ = b.Add(msgs[0])
+		time.Sleep(wait)
+		_ = b.Add(msgs[1])
+	}
+
+	st := b.Stats()
+	if st.Batches != 2 || st.Messages != 4 {
+		t.Fatalf("got batches=%d messages=%d, wanted 2 and 4", st.Batches, st.Messages)
+	}
+	if st.AvgSize() != 2 {
+		t.Fatalf("got avg size %f, wanted 2", st.AvgSize())
+	}
+	if st.Wait < wait || st.Wait > time.Second {
+		t.Fatalf("got avg wait %s, wanted about %s", st.Wait, wait)
+	}
+	if st.Timing < timing || st.Timing > time.Second {
+		t.Fatalf("got avg timing %s, wanted about %s", st.Timing, timing)
+	}
+}
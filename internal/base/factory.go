@@ -2,17 +2,108 @@ package base
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/frain-dev/taskq/v3"
 )
 
 type Factory struct {
 	m sync.Map
+
+	mu       sync.Mutex
+	startCtx context.Context // set while consumers are started
+	registry *taskq.TaskMap
+
+	onRegister []func(taskq.Queue)
+	onStart    []func(taskq.Queue)
+	onStop     []func(taskq.Queue)
+}
+
+// OnQueueRegister adds a hook that is called for every registered queue.
+func (f *Factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.mu.Lock()
+	f.onRegister = append(f.onRegister, fn)
+	f.mu.Unlock()
+}
+
+// OnConsumerStart adds a hook that is called when the factory starts
+// a queue consumer.
+func (f *Factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.mu.Lock()
+	f.onStart = append(f.onStart, fn)
+	f.mu.Unlock()
+}
+
+// OnConsumerStop adds a hook that is called when the factory stops
+// a queue consumer.
+func (f *Factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.mu.Lock()
+	f.onStop = append(f.onStop, fn)
+	f.mu.Unlock()
+}
+
+// SetRegistry sets the task registry used by queues registered
+// without a handler.
+func (f *Factory) SetRegistry(r *taskq.TaskMap) {
+	f.mu.Lock()
+	f.registry = r
+	f.mu.Unlock()
 }
 
+// InitOptions sets the factory defaults in the options of a queue
+// before the queue is created.
+func (f *Factory) InitOptions(opt *taskq.QueueOptions) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if opt.Handler == nil && f.registry != nil {
+		opt.Handler = f.registry
+	}
+}
+
+// Register adds the queue to the factory and starts its consumer
+// if StartConsumers was called.
 func (f *Factory) Register(queue taskq.Queue) error {
+	f.mu.Lock()
+	if err := f.add(queue); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	onRegister := f.onRegister
+
+	var err error
+	var onStart []func(taskq.Queue)
+	if f.startCtx != nil {
+		err = queue.Consumer().Start(f.startCtx)
+		if err == nil {
+			onStart = f.onStart
+		}
+	}
+	f.mu.Unlock()
+
+	runHooks(onRegister, queue)
+	runHooks(onStart, queue)
+	return err
+}
+
+// Add adds the queue to the factory without starting its consumer.
+func (f *Factory) Add(queue taskq.Queue) error {
+	f.mu.Lock()
+	err := f.add(queue)
+	onRegister := f.onRegister
+	f.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	runHooks(onRegister, queue)
+	return nil
+}
+
+func (f *Factory) add(queue taskq.Queue) error {
 	name := queue.Name()
 	_, loaded := f.m.LoadOrStore(name, queue)
 	if loaded {
@@ -21,8 +112,58 @@ func (f *Factory) Register(queue taskq.Queue) error {
 	return nil
 }
 
-func (f *Factory) Unregister(name string) {
-	f.m.Delete(name)
+func runHooks(hooks []func(taskq.Queue), queue taskq.Queue) {
+	for _, fn := range hooks {
+		fn(queue)
+	}
+}
+
+// Unregister removes the queue from the factory and returns it.
+func (f *Factory) Unregister(name string) (taskq.Queue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.m.LoadAndDelete(name)
+	if !ok {
+		return nil, fmt.Errorf("queue=%q does not exist", name)
+	}
+	return v.(taskq.Queue), nil
+}
+
+// RegisterDiscovered registers queues that are not registered yet using
+// options returned by fn. Queues are skipped when fn returns nil.
+// Options without a namespace get the namespace the queues were discovered in.
+func (f *Factory) RegisterDiscovered(
+	namespace string,
+	names []string,
+	fn func(name string) *taskq.QueueOptions,
+	register func(*taskq.QueueOptions) taskq.Queue,
+) {
+	if fn == nil {
+		return
+	}
+	for _, name := range names {
+		if _, ok := f.m.Load(name); ok {
+			continue
+		}
+		if opt := fn(name); opt != nil {
+			if opt.Namespace == "" {
+				opt.Namespace = namespace
+			}
+			register(opt)
+		}
+	}
+}
+
+// Namespace returns the namespace of the registered queues,
+// which is used to discover queues in the backend.
+func (f *Factory) Namespace() string {
+	var namespace string
+	f.Range(func(q taskq.Queue) bool {
+		namespace = q.Options().Namespace
+		return namespace == ""
+	})
+	return namespace
 }
 
 func (f *Factory) Reset() {
@@ -35,32 +176,130 @@ func (f *Factory) Range(fn func(queue taskq.Queue) bool) {
 	})
 }
 
+// Stats returns stats of the registered queues sorted by name.
+func (f *Factory) Stats() *taskq.FactoryStats {
+	var queues []taskq.Queue
+	f.Range(func(q taskq.Queue) bool {
+		queues = append(queues, q)
+		return true
+	})
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name() < queues[j].Name()
+	})
+
+	stats := new(taskq.FactoryStats)
+	for _, q := range queues {
+		n, err := q.Len()
+		if err != nil {
+			n = -1
+		}
+		stats.Add(taskq.QueueStats{
+			Name:     q.Name(),
+			Len:      n,
+			Consumer: *q.Consumer().Stats(),
+		})
+	}
+	return stats
+}
+
 func (f *Factory) StartConsumers(ctx context.Context) error {
-	return f.forEachQueue(func(q taskq.Queue) error {
+	f.mu.Lock()
+	f.startCtx = ctx
+	started, err := f.forEachQueue(func(q taskq.Queue) error {
 		return q.Consumer().Start(ctx)
 	})
+	onStart := f.onStart
+	f.mu.Unlock()
+
+	for _, q := range started {
+		runHooks(onStart, q)
+	}
+	return err
+}
+
+func (f *Factory) StopConsumers(ctx context.Context) error {
+	f.mu.Lock()
+	f.startCtx = nil
+	stopped, err := f.forEachQueue(func(q taskq.Queue) error {
+		return q.Consumer().Stop(ctx)
+	})
+	onStop := f.onStop
+	f.mu.Unlock()
+
+	for _, q := range stopped {
+		runHooks(onStop, q)
+	}
+	return err
 }
 
-func (f *Factory) StopConsumers() error {
-	return f.forEachQueue(func(q taskq.Queue) error {
-		return q.Consumer().Stop()
+// PauseConsumers pauses consumers of every namespace used by the queues.
+func (f *Factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.forEachNamespace(func(opt *taskq.QueueOptions) error {
+		return taskq.PauseConsumers(ctx, opt, d)
 	})
 }
 
+// ResumeConsumers resumes consumers of every namespace used by the queues.
+func (f *Factory) ResumeConsumers(ctx context.Context) error {
+	return f.forEachNamespace(func(opt *taskq.QueueOptions) error {
+		return taskq.ResumeConsumers(ctx, opt)
+	})
+}
+
+func (f *Factory) forEachNamespace(fn func(*taskq.QueueOptions) error) error {
+	type namespace struct {
+		redis taskq.Redis
+		name  string
+	}
+
+	seen := make(map[namespace]bool)
+	var firstErr error
+	f.Range(func(q taskq.Queue) bool {
+		opt := q.Options()
+		if opt.Redis == nil {
+			return true
+		}
+		ns := namespace{redis: opt.Redis, name: opt.Namespace}
+		if seen[ns] {
+			return true
+		}
+		seen[ns] = true
+
+		if err := fn(opt); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return true
+	})
+	if len(seen) == 0 && firstErr == nil {
+		return errors.New("taskq: no queues with Redis client")
+	}
+	return firstErr
+}
+
 func (f *Factory) Close() error {
-	return f.forEachQueue(func(q taskq.Queue) error {
+	_, err := f.forEachQueue(func(q taskq.Queue) error {
 		return q.Close()
 	})
+	return err
 }
 
-func (f *Factory) forEachQueue(fn func(taskq.Queue) error) error {
+// forEachQueue calls fn for every queue concurrently and returns the queues
+// for which fn succeeded and one of the errors.
+func (f *Factory) forEachQueue(fn func(taskq.Queue) error) ([]taskq.Queue, error) {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ok []taskq.Queue
 	errCh := make(chan error, 1)
 	f.Range(func(q taskq.Queue) bool {
 		wg.Add(1)
 		go func(q taskq.Queue) {
 			defer wg.Done()
 			err := fn(q)
+			if err == nil {
+				mu.Lock()
+				ok = append(ok, q)
+				mu.Unlock()
+			}
 			select {
 			case errCh <- err:
 			default:
@@ -71,8 +310,8 @@ func (f *Factory) forEachQueue(fn func(taskq.Queue) error) error {
 	wg.Wait()
 	select {
 	case err := <-errCh:
-		return err
+		return ok, err
 	default:
-		return nil
+		return ok, nil
 	}
 }
@@ -2,5 +2,5 @@ package internal
 
 import "errors"
 
-var ErrNotSupported = errors.New("not supported")
+var ErrNotSupported = errors.New("taskq: not supported")
 var ErrTaskNameRequired = errors.New("taskq: Message.TaskName is required")
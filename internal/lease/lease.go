@@ -0,0 +1,193 @@
+// Package lease implements a distributed counting semaphore on top of Redis.
+// Each acquired lease has a time-to-live, must be periodically renewed,
+// and carries a monotonically increasing fencing token.
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotAcquired is returned when all leases are held by other owners.
+	ErrNotAcquired = errors.New("lease: not acquired")
+	// ErrLost is returned when renewing a lease that has expired.
+	ErrLost = errors.New("lease: lease is lost")
+)
+
+// Redis is the subset of Redis client API used by Semaphore.
+type Redis interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+}
+
+// KEYS[1] - holders, KEYS[2] - fencing token counter,
+// ARGV[1] - now in ms, ARGV[2] - ttl in ms, ARGV[3] - limit, ARGV[4] - lease id.
+var acquireScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[3]) then
+  return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[1] + ARGV[2], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return redis.call("INCR", KEYS[2])
+`)
+
+// KEYS[1] - holders, ARGV[1] - now in ms, ARGV[2] - ttl in ms, ARGV[3] - lease id.
+var renewScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if not redis.call("ZSCORE", KEYS[1], ARGV[3]) then
+  return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[1] + ARGV[2], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
+
+type Options struct {
+	// Maximum number of leases that can be held at the same time.
+	// Default is 1.
+	Limit int
+	// Time after which a lease that is not renewed expires.
+	// Default is 1 minute.
+	TTL time.Duration
+	// Minimum backoff between attempts to acquire a lease.
+	// Default is 100 milliseconds.
+	MinBackoff time.Duration
+	// Maximum backoff between attempts to acquire a lease.
+	// Default is 1 second.
+	MaxBackoff time.Duration
+}
+
+func (opt *Options) init() {
+	if opt.Limit == 0 {
+		opt.Limit = 1
+	}
+	if opt.TTL == 0 {
+		opt.TTL = time.Minute
+	}
+	if opt.MinBackoff == 0 {
+		opt.MinBackoff = 100 * time.Millisecond
+	}
+	if opt.MaxBackoff == 0 {
+		opt.MaxBackoff = time.Second
+	}
+}
+
+// Semaphore limits the number of concurrently held leases across processes.
+// Key should contain a Redis Cluster hash tag, e.g. "{name}:workers",
+// because the fencing token counter is stored under a separate key.
+type Semaphore struct {
+	redis Redis
+	key   string
+	opt   *Options
+}
+
+func NewSemaphore(redis Redis, key string, opt *Options) *Semaphore {
+	opt.init()
+	return &Semaphore{
+		redis: redis,
+		key:   key,
+		opt:   opt,
+	}
+}
+
+// TryAcquire acquires a lease or returns ErrNotAcquired if all leases are held.
+func (s *Semaphore) TryAcquire(ctx context.Context) (*Lease, error) {
+	id := uuid.New().String()
+	token, err := acquireScript.Run(
+		ctx, s.redis, []string{s.key, s.key + ":token"},
+		unixMs(time.Now()), ttlMs(s.opt.TTL), s.opt.Limit, id,
+	).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if token == 0 {
+		return nil, ErrNotAcquired
+	}
+	return &Lease{
+		s:     s,
+		id:    id,
+		token: token,
+	}, nil
+}
+
+// Acquire acquires a lease waiting with exponential backoff until a lease
+// is available or the context is done. Redis errors are retried too.
+func (s *Semaphore) Acquire(ctx context.Context) (*Lease, error) {
+	timer := time.NewTimer(time.Minute)
+	timer.Stop()
+
+	for retry := 0; ; retry++ {
+		lease, err := s.TryAcquire(ctx)
+		if err == nil {
+			return lease, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		timer.Reset(s.backoff(retry))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Semaphore) backoff(retry int) time.Duration {
+	d := s.opt.MinBackoff << uint(retry)
+	if d <= 0 || d > s.opt.MaxBackoff {
+		return s.opt.MaxBackoff
+	}
+	return d
+}
+
+// Lease is held until it is released or expires.
+type Lease struct {
+	s     *Semaphore
+	id    string
+	token int64
+}
+
+// Token returns the fencing token. Tokens of newer leases are always greater.
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Renew extends the lease by TTL. It returns ErrLost if the lease has expired.
+func (l *Lease) Renew(ctx context.Context) error {
+	ok, err := renewScript.Run(
+		ctx, l.s.redis, []string{l.s.key},
+		unixMs(time.Now()), ttlMs(l.s.opt.TTL), l.id,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release releases the lease so it can be acquired by others.
+func (l *Lease) Release(ctx context.Context) error {
+	return l.s.redis.Eval(ctx, `return redis.call("ZREM", KEYS[1], ARGV[1])`,
+		[]string{l.s.key}, l.id).Err()
+}
+
+func unixMs(tm time.Time) int64 {
+	return tm.UnixNano() / int64(time.Millisecond)
+}
+
+func ttlMs(ttl time.Duration) int64 {
+	return int64(ttl / time.Millisecond)
+}
@@ -1,10 +1,13 @@
 package msgutil
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	"github.com/dgryski/go-farm"
+	"github.com/google/uuid"
 
 	"github.com/frain-dev/taskq/v3"
 	"github.com/frain-dev/taskq/v3/internal"
@@ -45,9 +48,10 @@ func UnwrapMessageHandler(fn interface{}) taskq.HandlerFunc {
 }
 
 func FullMessageName(q taskq.Queue, msg *taskq.Message) string {
-	ln := len(q.Name()) + len(msg.TaskName)
+	name := internal.Namespaced(q.Options().Namespace, ":", q.Name())
+	ln := len(name) + len(msg.TaskName)
 	data := make([]byte, 0, ln+len(msg.Name))
-	data = append(data, q.Name()...)
+	data = append(data, name...)
 	data = append(data, msg.TaskName...)
 	data = append(data, msg.Name...)
 
@@ -67,3 +71,250 @@ func FullMessageName(q taskq.Queue, msg *taskq.Message) string {
 
 	return internal.BytesToString(b)
 }
+
+// LogString returns the message as it is logged by backends: the result of
+// QueueOptions.RedactPayload if it is set and Message.String otherwise.
+func LogString(q taskq.Queue, msg *taskq.Message) string {
+	if redact := q.Options().RedactPayload; redact != nil {
+		return redact(msg)
+	}
+	return msg.String()
+}
+
+// SetAuditID assigns the message an audit id before it is added, so the id
+// is delivered with the message. It returns true when the message must be
+// recorded with AuditEnqueued once it is added. Messages re-added by
+// the consumer already have the id and are not audited again, so they keep
+// their enqueue time.
+func SetAuditID(q taskq.Queue, msg *taskq.Message) bool {
+	opt := q.Options()
+	if !opt.Audit && opt.AuditSink == nil {
+		return false
+	}
+	if msg.Headers[taskq.AuditIDHeader] != "" {
+		return false
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	msg.Headers[taskq.AuditIDHeader] = uuid.New().String()
+	return true
+}
+
+// AuditEnqueued records the message in the audit storage and writes
+// the enqueue event to the audit sink. It is called after the message is
+// written to the backend, so messages rejected by the backend are neither
+// reported as lost nor written to the immutable audit log.
+func AuditEnqueued(q taskq.Queue, msg *taskq.Message) {
+	AuditRecord(q, msg)
+	AuditEnqueueEvent(q, msg)
+}
+
+// AuditRecord records the message in the audit storage. Backends that
+// hand the message off to consumers before they know it is added, e.g.
+// memqueue, call it before the handoff and AuditAborted if the message
+// is not added.
+func AuditRecord(q taskq.Queue, msg *taskq.Message) {
+	opt := q.Options()
+	storage, ok := opt.Storage.(taskq.AuditStorage)
+	if !ok || !opt.Audit {
+		return
+	}
+
+	err := storage.AuditEnqueued(msgContext(msg), taskq.AuditQueueName(q), taskq.AuditRecord{
+		ID:         msg.Headers[taskq.AuditIDHeader],
+		TaskName:   msg.TaskName,
+		EnqueuedAt: time.Now(),
+	})
+	if err != nil {
+		internal.Logger.Printf("taskq: AuditEnqueued failed: %s", err)
+	}
+}
+
+// AuditEnqueueEvent writes the enqueue event to the audit sink. The sink
+// is append-only, so it is called only once the message can't be rejected.
+func AuditEnqueueEvent(q taskq.Queue, msg *taskq.Message) {
+	sink := q.Options().AuditSink
+	if sink == nil {
+		return
+	}
+
+	evt := taskq.NewAuditEvent(taskq.AuditEventEnqueue, q, msg)
+	if err := sink.WriteAuditEvent(msgContext(msg), evt); err != nil {
+		internal.Logger.Printf("taskq: WriteAuditEvent failed: %s", err)
+	}
+}
+
+// AuditAborted removes the record of the message that was recorded with
+// AuditRecord before it was handed off to consumers, but was not added.
+func AuditAborted(q taskq.Queue, msg *taskq.Message) {
+	opt := q.Options()
+	if !opt.Audit {
+		return
+	}
+	storage, ok := opt.Storage.(taskq.AuditStorage)
+	if !ok {
+		return
+	}
+
+	id := msg.Headers[taskq.AuditIDHeader]
+	if err := storage.AuditFinished(msgContext(msg), taskq.AuditQueueName(q), id); err != nil {
+		internal.Logger.Printf("taskq: AuditFinished failed: %s", err)
+	}
+}
+
+func msgContext(msg *taskq.Message) context.Context {
+	if msg.Ctx != nil {
+		return msg.Ctx
+	}
+	return context.Background()
+}
+
+// BeforeAdd prepares the message before it is added: it authorizes the
+// producer, checks the payload policy, and injects the producer context
+// into the headers. Messages that were already delivered, e.g. re-added
+// by the consumer, are left as is.
+func BeforeAdd(q taskq.Queue, msg *taskq.Message) error {
+	if msg.ReservedCount > 0 {
+		return nil
+	}
+	if err := authorize(q, msg); err != nil {
+		return err
+	}
+	if err := checkPolicy(q, msg); err != nil {
+		return err
+	}
+	injectContext(q, msg)
+	return nil
+}
+
+func injectContext(q taskq.Queue, msg *taskq.Message) {
+	propagator := q.Options().Propagator
+	if propagator == nil || msg.Ctx == nil {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	propagator.Inject(msg.Ctx, msg.Headers)
+}
+
+// authorize sets taskq.ProducerHeader to the identity carried by the message
+// context and checks the message with QueueOptions.Authorizer.
+func authorize(q taskq.Queue, msg *taskq.Message) error {
+	id := taskq.IdentityFromContext(msg.Ctx)
+	if id != nil {
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[taskq.ProducerHeader] = id.Name
+	}
+
+	auth := q.Options().Authorizer
+	if auth == nil {
+		return nil
+	}
+	return auth(q, id, msg)
+}
+
+// checkPolicy checks the message with QueueOptions.PayloadPolicy.
+func checkPolicy(q taskq.Queue, msg *taskq.Message) error {
+	policy := q.Options().PayloadPolicy
+	if policy == nil {
+		return nil
+	}
+	return policy.CheckMessage(msg)
+}
+
+// AcquireQuota checks the message against the quota of the queue and counts
+// it in the backlog of its tenant. It reports whether the message was counted,
+// so the caller can ReleaseQuota if adding the message fails. Messages that
+// are already counted, e.g. re-added by the consumer, are not checked.
+// It returns *taskq.QuotaExceededError when the quota is exceeded.
+func AcquireQuota(q taskq.Queue, msg *taskq.Message) (bool, error) {
+	opt := q.Options()
+	if opt.Quota == nil || msg.Headers[taskq.QuotaKeyHeader] != "" {
+		return false, nil
+	}
+	tenant := opt.Quota.Tenant(msg)
+	quota := opt.Quota.Quota(tenant)
+	if quota.IsZero() {
+		return false, nil
+	}
+	storage, ok := opt.Storage.(taskq.QuotaStorage)
+	if !ok {
+		return false, fmt.Errorf("%w: %T does not implement QuotaStorage",
+			taskq.ErrNotSupported, opt.Storage)
+	}
+
+	ctx := msg.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	key := taskq.QuotaKey(q, tenant)
+
+	if quota.MaxBacklog > 0 {
+		ok, err := storage.QuotaAcquire(ctx, key, 1, quota.MaxBacklog)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, &taskq.QuotaExceededError{
+				Queue:  q.Name(),
+				Tenant: tenant,
+				Limit:  taskq.QuotaBacklog,
+			}
+		}
+	}
+
+	delay, ok, err := storage.QuotaReserve(ctx, key, quota.Rate, 1, opt.Quota.MaxDelay)
+	if err == nil && !ok {
+		err = &taskq.QuotaExceededError{
+			Queue:      q.Name(),
+			Tenant:     tenant,
+			Limit:      taskq.QuotaRate,
+			RetryAfter: delay,
+		}
+	}
+	if err != nil {
+		if quota.MaxBacklog > 0 {
+			_ = storage.QuotaRelease(ctx, key, 1)
+		}
+		return false, err
+	}
+	if delay > msg.Delay {
+		msg.Delay = delay
+	}
+
+	if quota.MaxBacklog == 0 {
+		return false, nil
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	msg.Headers[taskq.QuotaKeyHeader] = key
+	return true, nil
+}
+
+// ReleaseQuota removes the message counted by AcquireQuota from the backlog
+// when the message could not be added.
+func ReleaseQuota(q taskq.Queue, msg *taskq.Message) {
+	key := msg.Headers[taskq.QuotaKeyHeader]
+	if key == "" {
+		return
+	}
+	delete(msg.Headers, taskq.QuotaKeyHeader)
+
+	storage, ok := q.Options().Storage.(taskq.QuotaStorage)
+	if !ok {
+		return
+	}
+	ctx := msg.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := storage.QuotaRelease(ctx, key, 1); err != nil {
+		internal.Logger.Printf("taskq: QuotaRelease failed: %s", err)
+	}
+}
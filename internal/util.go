@@ -27,3 +27,11 @@ func DecodeString(src string) ([]byte, error) {
 	}
 	return dst[:ndst], nil
 }
+
+// Namespaced prefixes s with the namespace using sep.
+func Namespaced(namespace, sep, s string) string {
+	if namespace == "" {
+		return s
+	}
+	return namespace + sep + s
+}
@@ -2,11 +2,13 @@ package ironmq
 
 import (
 	"context"
+	"time"
 
 	iron_config "github.com/iron-io/iron_go3/config"
 	"github.com/iron-io/iron_go3/mq"
 
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
 	"github.com/frain-dev/taskq/v3/internal/base"
 )
 
@@ -19,7 +21,8 @@ type factory struct {
 var _ taskq.Factory = (*factory)(nil)
 
 func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
-	ironq := mq.ConfigNew(opt.Name, f.cfg)
+	f.base.InitOptions(opt)
+	ironq := mq.ConfigNew(internal.Namespaced(opt.Namespace, "-", opt.Name), f.cfg)
 	q := NewQueue(ironq, opt)
 	if err := f.base.Register(q); err != nil {
 		panic(err)
@@ -27,16 +30,81 @@ func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
 	return q
 }
 
+func (f *factory) UnregisterQueue(name string) error {
+	q, err := f.base.Unregister(name)
+	if err != nil {
+		return err
+	}
+	_ = q.Close()
+	return q.(*Queue).q.Delete()
+}
+
+// Discover returns names of the IronMQ queues in the project.
+func (f *factory) Discover(
+	ctx context.Context, register func(name string) *taskq.QueueOptions,
+) ([]string, error) {
+	const perPage = 100
+
+	settings := iron_config.ManualConfig("iron_mq", f.cfg)
+
+	var names []string
+	var prev string
+	for {
+		queues, err := mq.ListQueues(settings, "", prev, perPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range queues {
+			names = append(names, q.Name)
+		}
+		if len(queues) < perPage {
+			break
+		}
+		prev = queues[len(queues)-1].Name
+	}
+
+	f.base.RegisterDiscovered("", names, register, f.RegisterQueue)
+	return names, nil
+}
+
 func (f *factory) Range(fn func(taskq.Queue) bool) {
 	f.base.Range(fn)
 }
 
+func (f *factory) SetRegistry(r *taskq.TaskMap) {
+	f.base.SetRegistry(r)
+}
+
+func (f *factory) Stats() *taskq.FactoryStats {
+	return f.base.Stats()
+}
+
+func (f *factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.base.OnQueueRegister(fn)
+}
+
+func (f *factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.base.OnConsumerStart(fn)
+}
+
+func (f *factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.base.OnConsumerStop(fn)
+}
+
 func (f *factory) StartConsumers(ctx context.Context) error {
 	return f.base.StartConsumers(ctx)
 }
 
-func (f *factory) StopConsumers() error {
-	return f.base.StopConsumers()
+func (f *factory) StopConsumers(ctx context.Context) error {
+	return f.base.StopConsumers(ctx)
+}
+
+func (f *factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.base.PauseConsumers(ctx, d)
+}
+
+func (f *factory) ResumeConsumers(ctx context.Context) error {
+	return f.base.ResumeConsumers(ctx)
 }
 
 func (f *factory) Close() error {
@@ -0,0 +1,81 @@
+package ironmq
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/iron-io/iron_go3/mq"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+const pushMessageIDHeader = "Iron-Message-Id"
+
+// EnablePush turns the queue into a unicast push queue that pushes messages
+// to the subscriber URL where PushHandler is served. Messages are retried
+// by IronMQ up to retries times and are not reserved by the consumer,
+// so the consumer should not be started for push queues.
+func (q *Queue) EnablePush(subscriberURL string, retries int) error {
+	_, err := q.q.Update(mq.QueueInfo{
+		Type: "unicast",
+		Push: &mq.PushInfo{
+			Retries: retries,
+			Subscribers: []mq.QueueSubscriber{{
+				Name: q.Name(),
+				URL:  subscriberURL,
+			}},
+		},
+	})
+	return err
+}
+
+// PushHandler returns an HTTP handler that receives messages pushed by IronMQ
+// and processes them with the queue consumer. Handler errors are reported
+// with 503 status code so IronMQ retries the message, and successfully
+// processed messages are acknowledged with 200 status code.
+func (q *Queue) PushHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		msg := new(taskq.Message)
+		if err := unmarshalPushMessage(msg, b); err != nil {
+			// Retrying won't help.
+			internal.Logger.Printf("ironmq: can't decode pushed message: %s", err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		msg.Ctx = req.Context()
+		msg.ID = req.Header.Get(pushMessageIDHeader)
+
+		consumer := q.Consumer().(*taskq.Consumer)
+		if err := consumer.Process(msg); err != nil && msg.Delay > 0 {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func unmarshalPushMessage(msg *taskq.Message, body []byte) error {
+	b, err := internal.DecodeString(internal.BytesToString(body))
+	if err != nil {
+		return err
+	}
+	return msg.UnmarshalBinary(b)
+}
+
+// isPushed reports whether the message was pushed by IronMQ. Pushed messages
+// are not reserved and are acknowledged with the HTTP response.
+func isPushed(msg *taskq.Message) bool {
+	return msg.ReservationID == ""
+}
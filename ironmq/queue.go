@@ -22,6 +22,10 @@ type Queue struct {
 
 	q mq.Queue
 
+	// Registry of the internal tasks so queues with the same name
+	// in different factories don't collide.
+	tasks *taskq.TaskMap
+
 	addQueue *memqueue.Queue
 	addTask  *taskq.Task
 
@@ -33,6 +37,7 @@ type Queue struct {
 }
 
 var _ taskq.Queue = (*Queue)(nil)
+var _ taskq.Toucher = (*Queue)(nil)
 
 func NewQueue(mqueue mq.Queue, opt *taskq.QueueOptions) *Queue {
 	if opt.Name == "" {
@@ -45,6 +50,7 @@ func NewQueue(mqueue mq.Queue, opt *taskq.QueueOptions) *Queue {
 		opt: opt,
 	}
 
+	q.tasks = taskq.NewRegistry()
 	q.initAddQueue()
 	q.initDelQueue()
 
@@ -57,8 +63,9 @@ func (q *Queue) initAddQueue() {
 		Name:       queueName,
 		BufferSize: 100,
 		Redis:      q.opt.Redis,
+		Handler:    q.tasks,
 	})
-	q.addTask = taskq.RegisterTask(&taskq.TaskOptions{
+	q.addTask = q.tasks.RegisterTask(&taskq.TaskOptions{
 		Name:            queueName + ":add-mesage",
 		Handler:         taskq.HandlerFunc(q.add),
 		FallbackHandler: msgutil.UnwrapMessageHandler(q.opt.Handler.HandleMessage),
@@ -73,19 +80,34 @@ func (q *Queue) initDelQueue() {
 		Name:       queueName,
 		BufferSize: 100,
 		Redis:      q.opt.Redis,
+		Handler:    q.tasks,
 	})
-	q.delTask = taskq.RegisterTask(&taskq.TaskOptions{
+	q.delTask = q.tasks.RegisterTask(&taskq.TaskOptions{
 		Name:       queueName + ":delete-message",
 		Handler:    taskq.HandlerFunc(q.delBatcherAdd),
 		RetryLimit: 3,
 		MinBackoff: time.Second,
 	})
 	q.delBatcher = base.NewBatcher(q.delQueue.Consumer(), &base.BatcherOptions{
-		Handler:     q.deleteBatch,
-		ShouldBatch: q.shouldBatchDelete,
+		Handler: q.deleteBatch,
+		MaxSize: q.deleteBatchSize(),
+		Timeout: q.opt.DeleteBatchTimeout,
 	})
 }
 
+func (q *Queue) deleteBatchSize() int {
+	const maxBatchSize = 100
+	if q.opt.DeleteBatchSize > maxBatchSize {
+		return maxBatchSize
+	}
+	return q.opt.DeleteBatchSize
+}
+
+// DeleteBatchStats returns stats of batches of deleted messages.
+func (q *Queue) DeleteBatchStats() *taskq.BatchStats {
+	return q.delBatcher.Stats()
+}
+
 func (q *Queue) Name() string {
 	return q.q.Name
 }
@@ -123,13 +145,130 @@ func (q *Queue) Add(msg *taskq.Message) error {
 	if msg.TaskName == "" {
 		return internal.ErrTaskNameRequired
 	}
+	if err := msgutil.BeforeAdd(q, msg); err != nil {
+		return err
+	}
 	if q.isDuplicate(msg) {
 		msg.Err = taskq.ErrDuplicate
 		return nil
 	}
-	msg = msgutil.WrapMessage(msg)
-	msg.TaskName = q.addTask.Name()
-	return q.addQueue.Add(msg)
+	acquired, err := msgutil.AcquireQuota(q, msg)
+	if err != nil {
+		return err
+	}
+	audit := msgutil.SetAuditID(q, msg)
+	wrapped := msgutil.WrapMessage(msg)
+	wrapped.TaskName = q.addTask.Name()
+	err = q.addQueue.Add(wrapped)
+	if err != nil {
+		if acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		return err
+	}
+	if audit {
+		msgutil.AuditEnqueued(q, msg)
+	}
+	return nil
+}
+
+// AddN adds messages to the queue pushing up to 100 messages per
+// IronMQ API call. Unlike Add it waits for messages to be pushed.
+// Duplicate messages are skipped and have Err set to ErrDuplicate.
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	const maxBatchSize = 100
+
+	for _, msg := range msgs {
+		if msg.TaskName == "" {
+			return internal.ErrTaskNameRequired
+		}
+	}
+
+	var rejectErr error
+	reject := func(msg *taskq.Message, err error) {
+		msg.Err = err
+		if rejectErr == nil {
+			rejectErr = err
+		}
+	}
+
+	batch := make([]*taskq.Message, 0, len(msgs))
+	acquired := make([]bool, 0, len(msgs))
+	audited := make([]bool, 0, len(msgs))
+	for _, msg := range msgs {
+		if err := msgutil.BeforeAdd(q, msg); err != nil {
+			reject(msg, err)
+			continue
+		}
+		if q.isDuplicate(msg) {
+			msg.Err = taskq.ErrDuplicate
+			continue
+		}
+		ok, err := msgutil.AcquireQuota(q, msg)
+		if err != nil {
+			reject(msg, err)
+			continue
+		}
+		batch = append(batch, msg)
+		acquired = append(acquired, ok)
+		audited = append(audited, msgutil.SetAuditID(q, msg))
+	}
+
+	for len(batch) > 0 {
+		// IronMQ client doesn't support contexts so only
+		// check the context between API calls.
+		err := ctx.Err()
+		n := len(batch)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if err == nil {
+			err = q.pushMessages(batch[:n])
+		}
+		if err != nil {
+			// Messages that are not pushed leave the backlog.
+			for i, msg := range batch {
+				if acquired[i] {
+					msgutil.ReleaseQuota(q, msg)
+				}
+			}
+			return err
+		}
+		for i, msg := range batch[:n] {
+			if audited[i] {
+				msgutil.AuditEnqueued(q, msg)
+			}
+		}
+		batch = batch[n:]
+		acquired = acquired[n:]
+		audited = audited[n:]
+	}
+	return rejectErr
+}
+
+func (q *Queue) pushMessages(msgs []*taskq.Message) error {
+	mqMsgs := make([]mq.Message, len(msgs))
+	for i, msg := range msgs {
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		mqMsgs[i] = mq.Message{
+			Body:  internal.EncodeToString(b),
+			Delay: int64(msg.Delay / time.Second),
+		}
+	}
+
+	ids, err := q.q.PushMessages(mqMsgs...)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if i < len(msgs) {
+			msgs[i].ID = id
+		}
+	}
+	return nil
 }
 
 func (q *Queue) ReserveN(
@@ -178,13 +317,35 @@ func (q *Queue) ReserveN(
 }
 
 func (q *Queue) Release(msg *taskq.Message) error {
+	if isPushed(msg) {
+		return nil
+	}
 	return retry(func() error {
 		return q.q.ReleaseMessage(msg.ID, msg.ReservationID, int64(msg.Delay/time.Second))
 	})
 }
 
+// Touch extends reservation of the message by ReservationTimeout.
+func (q *Queue) Touch(ctx context.Context, msg *taskq.Message) error {
+	if isPushed(msg) {
+		return taskq.ErrNotSupported
+	}
+	reservationSecs := int(q.opt.ReservationTimeout / time.Second)
+	return retry(func() error {
+		id, err := q.q.TouchMessageFor(msg.ID, msg.ReservationID, reservationSecs)
+		if err != nil {
+			return err
+		}
+		msg.ReservationID = id
+		return nil
+	})
+}
+
 // Delete deletes the message from the queue.
 func (q *Queue) Delete(msg *taskq.Message) error {
+	if isPushed(msg) {
+		return nil
+	}
 	err := retry(func() error {
 		return q.q.DeleteMessage(msg.ID, msg.ReservationID)
 	})
@@ -210,7 +371,9 @@ func (q *Queue) Close() error {
 // CloseTimeout closes the queue waiting for pending messages to be processed.
 func (q *Queue) CloseTimeout(timeout time.Duration) error {
 	if q.consumer != nil {
-		_ = q.consumer.StopTimeout(timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_ = q.consumer.Stop(ctx)
+		cancel()
 	}
 
 	firstErr := q.delBatcher.Close()
@@ -279,11 +442,6 @@ func (q *Queue) deleteBatch(msgs []*taskq.Message) error {
 	return nil
 }
 
-func (q *Queue) shouldBatchDelete(batch []*taskq.Message, msg *taskq.Message) bool {
-	const messagesLimit = 10
-	return len(batch)+1 < messagesLimit
-}
-
 func (q *Queue) isDuplicate(msg *taskq.Message) bool {
 	if msg.Name == "" {
 		return false
@@ -1,11 +1,18 @@
 package taskq_test
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	iron_config "github.com/iron-io/iron_go3/config"
+	"github.com/iron-io/iron_go3/mq"
 
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
 	"github.com/frain-dev/taskq/v3/ironmq"
 )
 
@@ -113,3 +120,47 @@ func TestIronmqInvalidCredentials(t *testing.T) {
 		Name: queueName("ironmq-invalid-credentials"),
 	})
 }
+
+func TestIronmqPushHandler(t *testing.T) {
+	// Pushed messages are processed without calling IronMQ API.
+	q := ironmq.NewQueue(mq.Queue{Name: queueName("ironmq-push")}, &taskq.QueueOptions{
+		Name: queueName("ironmq-push"),
+	})
+	defer q.Close()
+
+	var calls []string
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(s string) error {
+			calls = append(calls, s)
+			if s == "fail" {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	})
+
+	push := func(arg string) int {
+		msg := task.WithArgs(context.Background(), arg)
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/push", strings.NewReader(internal.EncodeToString(b)))
+		req.Header.Set("Iron-Message-Id", "1")
+		w := httptest.NewRecorder()
+		q.PushHandler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := push("ok"); code != http.StatusOK {
+		t.Fatalf("got %d, wanted %d", code, http.StatusOK)
+	}
+	if code := push("fail"); code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, wanted %d", code, http.StatusServiceUnavailable)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, wanted 2", len(calls))
+	}
+}
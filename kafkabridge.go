@@ -0,0 +1,163 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KafkaRecord is a record consumed from a Kafka topic.
+type KafkaRecord struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Time      time.Time
+}
+
+// KafkaConsumer is implemented by an adapter of the Kafka client
+// the application uses, e.g. a kafka-go Reader or a sarama consumer group.
+type KafkaConsumer interface {
+	// FetchRecord blocks until the next record is available.
+	FetchRecord(ctx context.Context) (*KafkaRecord, error)
+	// CommitRecord commits the offset of the record.
+	CommitRecord(ctx context.Context, rec *KafkaRecord) error
+}
+
+// KafkaBridgeOptions configures NewKafkaBridge.
+type KafkaBridgeOptions struct {
+	// Queue where messages are added.
+	Queue Queue
+	// Consumer of the Kafka topics.
+	Consumer KafkaConsumer
+
+	// Tasks by topic. The record value is passed to the task as []byte
+	// and the record headers are copied to the message headers.
+	Tasks map[string]*Task
+	// Optional function that maps records to messages instead of Tasks.
+	// Records are skipped when it returns a nil message.
+	Map func(rec *KafkaRecord) (*Message, error)
+
+	// Optional function that is called when a record can't be mapped or added.
+	// The record is committed and skipped when it returns nil; otherwise
+	// Run stops with the returned error. The default stops Run.
+	OnError func(rec *KafkaRecord, err error) error
+}
+
+// KafkaBridge adds records consumed from Kafka topics to a queue, so
+// the records are processed with the task retry semantics. Records are
+// committed after they are added, so they are added at least once; messages
+// are named after the record offsets, so with QueueOptions.Storage
+// redelivered records are added only once.
+type KafkaBridge struct {
+	opt *KafkaBridgeOptions
+}
+
+func NewKafkaBridge(opt *KafkaBridgeOptions) *KafkaBridge {
+	if opt.Queue == nil || opt.Consumer == nil {
+		panic("taskq: KafkaBridgeOptions.Queue and Consumer are required")
+	}
+	if opt.Map == nil && len(opt.Tasks) == 0 {
+		panic("taskq: KafkaBridgeOptions.Tasks or Map is required")
+	}
+	return &KafkaBridge{opt: opt}
+}
+
+// Run consumes records until the context is canceled or an error is returned
+// by the consumer or OnError.
+func (b *KafkaBridge) Run(ctx context.Context) error {
+	for {
+		rec, err := b.opt.Consumer.FetchRecord(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := b.add(ctx, rec); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := b.onError(rec, err); err != nil {
+				return err
+			}
+		}
+
+		if err := b.opt.Consumer.CommitRecord(ctx, rec); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (b *KafkaBridge) add(ctx context.Context, rec *KafkaRecord) error {
+	msg, err := b.message(rec)
+	if err != nil {
+		return err
+	}
+	if msg == nil {
+		return nil
+	}
+	if msg.Name == "" {
+		msg.Name = fmt.Sprintf("kafka:%s:%d:%d", rec.Topic, rec.Partition, rec.Offset)
+	}
+
+	for {
+		err := b.opt.Queue.Add(msg)
+
+		var quotaErr *QuotaExceededError
+		if !errors.As(err, &quotaErr) || quotaErr.RetryAfter <= 0 {
+			return err
+		}
+
+		// Wait for the quota instead of skipping the record.
+		if err := sleep(ctx, quotaErr.RetryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *KafkaBridge) message(rec *KafkaRecord) (*Message, error) {
+	if b.opt.Map != nil {
+		return b.opt.Map(rec)
+	}
+
+	task, ok := b.opt.Tasks[rec.Topic]
+	if !ok {
+		return nil, fmt.Errorf("taskq: no task for topic=%q", rec.Topic)
+	}
+	msg := task.WithArgs(context.Background(), rec.Value)
+	if len(rec.Headers) > 0 {
+		msg.Headers = make(map[string]string, len(rec.Headers))
+		for k, v := range rec.Headers {
+			msg.Headers[k] = v
+		}
+	}
+	return msg, nil
+}
+
+func (b *KafkaBridge) onError(rec *KafkaRecord, err error) error {
+	if b.opt.OnError != nil {
+		return b.opt.OnError(rec, err)
+	}
+	return fmt.Errorf("taskq: kafka record topic=%q partition=%d offset=%d: %w",
+		rec.Topic, rec.Partition, rec.Offset, err)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+package taskq_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+type fakeKafkaConsumer struct {
+	mu        sync.Mutex
+	records   []*taskq.KafkaRecord
+	committed []int64
+}
+
+func (c *fakeKafkaConsumer) FetchRecord(ctx context.Context) (*taskq.KafkaRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.records) == 0 {
+		return nil, errors.New("no more records")
+	}
+	rec := c.records[0]
+	c.records = c.records[1:]
+	return rec, nil
+}
+
+func (c *fakeKafkaConsumer) CommitRecord(ctx context.Context, rec *taskq.KafkaRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = append(c.committed, rec.Offset)
+	return nil
+}
+
+func TestKafkaBridge(t *testing.T) {
+	factory := newTestFactory(t)
+
+	type processed struct {
+		value  string
+		header string
+	}
+	ch := make(chan processed, 10)
+
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "kafka",
+		Handler: func(msg *taskq.Message) error {
+			ch <- processed{string(msg.Args[0].([]byte)), msg.Headers["trace"]}
+			return nil
+		},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "kafka-queue",
+	})
+
+	consumer := &fakeKafkaConsumer{
+		records: []*taskq.KafkaRecord{
+			{Topic: "orders", Offset: 1, Value: []byte("first"), Headers: map[string]string{"trace": "t1"}},
+			{Topic: "unknown", Offset: 2, Value: []byte("skipped")},
+			// Redelivered record.
+			{Topic: "orders", Offset: 1, Value: []byte("first")},
+			{Topic: "orders", Offset: 3, Value: []byte("second")},
+		},
+	}
+
+	var skipped []int64
+	bridge := taskq.NewKafkaBridge(&taskq.KafkaBridgeOptions{
+		Queue:    q,
+		Consumer: consumer,
+		Tasks:    map[string]*taskq.Task{"orders": task},
+		OnError: func(rec *taskq.KafkaRecord, err error) error {
+			skipped = append(skipped, rec.Offset)
+			return nil
+		},
+	})
+	if err := bridge.Run(context.Background()); err == nil || err.Error() != "no more records" {
+		t.Fatalf("got %v", err)
+	}
+
+	if len(skipped) != 1 || skipped[0] != 2 {
+		t.Fatalf("got skipped %v", skipped)
+	}
+	if len(consumer.committed) != 4 {
+		t.Fatalf("got committed %v", consumer.committed)
+	}
+
+	want := []processed{{"first", "t1"}, {"second", ""}}
+	for _, w := range want {
+		select {
+		case got := <-ch:
+			if got != w {
+				t.Fatalf("got %+v, wanted %+v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not processed")
+		}
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("redelivered record is processed twice: %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,147 @@
+package taskq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// QueueLag describes how far the consumers are behind the producers.
+// Negative values mean that the backend can't tell.
+type QueueLag struct {
+	Queue string
+	// Number of messages that are ready to be processed,
+	// i.e. not delayed and not reserved by consumers.
+	Ready int
+	// Number of delayed messages that are due, but not ready yet.
+	DelayedDue int
+	// Age of the oldest ready or due message.
+	OldestAge time.Duration
+}
+
+// Total returns the number of messages waiting for consumers,
+// e.g. to scale the consumers.
+func (l *QueueLag) Total() int {
+	var n int
+	if l.Ready > 0 {
+		n += l.Ready
+	}
+	if l.DelayedDue > 0 {
+		n += l.DelayedDue
+	}
+	return n
+}
+
+// LagReporter is implemented by queues that can report their lag,
+// e.g. redisq.
+type LagReporter interface {
+	Lag(ctx context.Context) (*QueueLag, error)
+}
+
+// Lag returns the lag of the queue. Queues that don't implement
+// LagReporter report Queue.Len as ready messages.
+func Lag(ctx context.Context, q Queue) (*QueueLag, error) {
+	if r, ok := q.(LagReporter); ok {
+		return r.Lag(ctx)
+	}
+
+	n, err := q.Len()
+	if err != nil {
+		return nil, err
+	}
+	return &QueueLag{
+		Queue:      q.Name(),
+		Ready:      n,
+		DelayedDue: -1,
+		OldestAge:  -1,
+	}, nil
+}
+
+// factoryLags returns lags of the queues in the order of FactoryStats.
+// Queues that fail to report their lag are skipped.
+func factoryLags(ctx context.Context, factory Factory) []*QueueLag {
+	var lags []*QueueLag
+	for _, qs := range factory.Stats().Queues {
+		q := findQueue(factory, qs.Name)
+		if q == nil {
+			continue
+		}
+		lag, err := Lag(ctx, q)
+		if err != nil {
+			continue
+		}
+		lags = append(lags, lag)
+	}
+	return lags
+}
+
+func findQueue(factory Factory, name string) Queue {
+	var found Queue
+	factory.Range(func(q Queue) bool {
+		if q.Name() == name {
+			found = q
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+type scalerMetrics struct {
+	Lag                     int     `json:"lag"`
+	Ready                   int     `json:"ready"`
+	DelayedDue              int     `json:"delayedDue"`
+	OldestMessageAgeSeconds float64 `json:"oldestMessageAgeSeconds"`
+}
+
+func newScalerMetrics(lag *QueueLag) *scalerMetrics {
+	age := -1.0
+	if lag.OldestAge >= 0 {
+		age = lag.OldestAge.Seconds()
+	}
+	return &scalerMetrics{
+		Lag:                     lag.Total(),
+		Ready:                   lag.Ready,
+		DelayedDue:              lag.DelayedDue,
+		OldestMessageAgeSeconds: age,
+	}
+}
+
+// ScalerHandler returns a handler that serves the lag of the queues
+// registered in the factory as JSON for external autoscalers, e.g.
+// the KEDA metrics-api scaler with valueLocation "queues.emails.lag":
+//
+//	{"queues": {"emails": {"lag": 12, "ready": 10, "delayedDue": 2, "oldestMessageAgeSeconds": 3.5}}}
+//
+// With the queue query parameter only the metrics of the queue are returned,
+// e.g. /scaler?queue=emails with valueLocation "lag".
+func ScalerHandler(factory Factory) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var resp interface{}
+		if name := req.URL.Query().Get("queue"); name != "" {
+			q := findQueue(factory, name)
+			if q == nil {
+				http.Error(w, "taskq: queue not found", http.StatusNotFound)
+				return
+			}
+			lag, err := Lag(req.Context(), q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			resp = newScalerMetrics(lag)
+		} else {
+			queues := make(map[string]*scalerMetrics)
+			for _, lag := range factoryLags(req.Context(), factory) {
+				queues[lag.Queue] = newScalerMetrics(lag)
+			}
+			resp = map[string]interface{}{"queues": queues}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
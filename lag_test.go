@@ -0,0 +1,93 @@
+package taskq_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestScalerHandler(t *testing.T) {
+	ctx := context.Background()
+
+	factory := newTestFactory(t)
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name:    "scaler",
+		Handler: func() {},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "scaler-queue",
+	})
+	if err := q.Consumer().Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Purge()
+	for i := 0; i < 3; i++ {
+		if err := q.Add(task.WithArgs(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lag, err := taskq.Lag(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.Queue != "scaler-queue" || lag.Ready != 3 || lag.DelayedDue != -1 || lag.OldestAge != -1 {
+		t.Fatalf("got %+v", lag)
+	}
+	if n := lag.Total(); n != 3 {
+		t.Fatalf("got %d, wanted 3", n)
+	}
+
+	type metrics struct {
+		Lag                     int     `json:"lag"`
+		Ready                   int     `json:"ready"`
+		DelayedDue              int     `json:"delayedDue"`
+		OldestMessageAgeSeconds float64 `json:"oldestMessageAgeSeconds"`
+	}
+	want := metrics{Lag: 3, Ready: 3, DelayedDue: -1, OldestMessageAgeSeconds: -1}
+
+	h := taskq.ScalerHandler(factory)
+	get := func(path string, code int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Fatalf("%s: got %d, wanted %d", path, w.Code, code)
+		}
+		return w
+	}
+
+	var one metrics
+	if err := json.NewDecoder(get("/scaler?queue=scaler-queue", http.StatusOK).Body).Decode(&one); err != nil {
+		t.Fatal(err)
+	}
+	if one != want {
+		t.Fatalf("got %+v, wanted %+v", one, want)
+	}
+
+	var all struct {
+		Queues map[string]metrics `json:"queues"`
+	}
+	if err := json.NewDecoder(get("/scaler", http.StatusOK).Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Queues) != 1 || all.Queues["scaler-queue"] != want {
+		t.Fatalf("got %+v", all.Queues)
+	}
+
+	get("/scaler?queue=unknown", http.StatusNotFound)
+
+	w := httptest.NewRecorder()
+	taskq.MetricsHandler(factory).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `taskq_queue_lag_ready{queue="scaler-queue"} 3`) {
+		t.Fatalf("/metrics does not contain the lag:\n%s", body)
+	}
+	if strings.Contains(body, `taskq_queue_lag_delayed_due{queue="scaler-queue"}`) {
+		t.Fatalf("/metrics contains the unknown lag:\n%s", body)
+	}
+}
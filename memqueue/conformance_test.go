@@ -0,0 +1,16 @@
+package memqueue_test
+
+import (
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/memqueue"
+	"github.com/frain-dev/taskq/v3/queuetest"
+)
+
+func TestConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T, opt *taskq.QueueOptions) taskq.Queue {
+		opt.Storage = taskq.NewLocalStorage()
+		return memqueue.NewQueue(opt)
+	})
+}
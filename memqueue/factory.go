@@ -2,6 +2,7 @@ package memqueue
 
 import (
 	"context"
+	"time"
 
 	"github.com/frain-dev/taskq/v3"
 	"github.com/frain-dev/taskq/v3/internal/base"
@@ -18,23 +19,74 @@ func NewFactory() taskq.Factory {
 }
 
 func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
+	f.base.InitOptions(opt)
 	q := NewQueue(opt)
-	if err := f.base.Register(q); err != nil {
+	// The consumer is started by NewQueue.
+	if err := f.base.Add(q); err != nil {
 		panic(err)
 	}
 	return q
 }
 
+func (f *factory) UnregisterQueue(name string) error {
+	q, err := f.base.Unregister(name)
+	if err != nil {
+		return err
+	}
+	return q.Close()
+}
+
+// Discover returns names of the registered queues,
+// because memqueue queues only exist in the current process.
+func (f *factory) Discover(
+	_ context.Context, _ func(name string) *taskq.QueueOptions,
+) ([]string, error) {
+	var names []string
+	f.base.Range(func(q taskq.Queue) bool {
+		names = append(names, q.Name())
+		return true
+	})
+	return names, nil
+}
+
 func (f *factory) Range(fn func(taskq.Queue) bool) {
 	f.base.Range(fn)
 }
 
+func (f *factory) SetRegistry(r *taskq.TaskMap) {
+	f.base.SetRegistry(r)
+}
+
+func (f *factory) Stats() *taskq.FactoryStats {
+	return f.base.Stats()
+}
+
+func (f *factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.base.OnQueueRegister(fn)
+}
+
+func (f *factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.base.OnConsumerStart(fn)
+}
+
+func (f *factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.base.OnConsumerStop(fn)
+}
+
 func (f *factory) StartConsumers(ctx context.Context) error {
 	return f.base.StartConsumers(ctx)
 }
 
-func (f *factory) StopConsumers() error {
-	return f.base.StopConsumers()
+func (f *factory) StopConsumers(ctx context.Context) error {
+	return f.base.StopConsumers(ctx)
+}
+
+func (f *factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.base.PauseConsumers(ctx, d)
+}
+
+func (f *factory) ResumeConsumers(ctx context.Context) error {
+	return f.base.ResumeConsumers(ctx)
 }
 
 func (f *factory) Close() error {
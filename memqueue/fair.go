@@ -0,0 +1,105 @@
+package memqueue
+
+import (
+	"sync"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// fairQueue dequeues messages round-robin across tenants identified by
+// the message header and in insertion order within a tenant.
+type fairQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	header string
+
+	tenants map[string][]*taskq.Message
+	order   []string // tenants with messages in round-robin order
+	next    int
+	n       int
+	closed  bool
+}
+
+func newFairQueue(header string) *fairQueue {
+	fq := &fairQueue{
+		header:  header,
+		tenants: make(map[string][]*taskq.Message),
+	}
+	fq.cond = sync.NewCond(&fq.mu)
+	return fq
+}
+
+func (fq *fairQueue) Push(msg *taskq.Message) {
+	tenant := msg.Headers[fq.header]
+
+	fq.mu.Lock()
+	msgs, ok := fq.tenants[tenant]
+	if !ok {
+		fq.order = append(fq.order, tenant)
+	}
+	fq.tenants[tenant] = append(msgs, msg)
+	fq.n++
+	fq.mu.Unlock()
+	fq.cond.Signal()
+}
+
+// Pop waits for a message of the next tenant.
+// It returns nil when the queue is closed.
+func (fq *fairQueue) Pop() *taskq.Message {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	for fq.n == 0 && !fq.closed {
+		fq.cond.Wait()
+	}
+	if fq.closed {
+		return nil
+	}
+
+	tenant := fq.order[fq.next]
+	msgs := fq.tenants[tenant]
+	msg := msgs[0]
+	msgs[0] = nil
+	fq.n--
+
+	if len(msgs) == 1 {
+		delete(fq.tenants, tenant)
+		fq.order = append(fq.order[:fq.next], fq.order[fq.next+1:]...)
+	} else {
+		fq.tenants[tenant] = msgs[1:]
+		fq.next++
+	}
+	if fq.next >= len(fq.order) {
+		fq.next = 0
+	}
+	return msg
+}
+
+func (fq *fairQueue) Len() int {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	return fq.n
+}
+
+func (fq *fairQueue) Close() {
+	fq.mu.Lock()
+	fq.closed = true
+	fq.mu.Unlock()
+	fq.cond.Broadcast()
+}
+
+// Purge removes and returns all messages.
+func (fq *fairQueue) Purge() []*taskq.Message {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	msgs := make([]*taskq.Message, 0, fq.n)
+	for _, tenant := range fq.order {
+		msgs = append(msgs, fq.tenants[tenant]...)
+	}
+	fq.tenants = make(map[string][]*taskq.Message)
+	fq.order = nil
+	fq.next = 0
+	fq.n = 0
+	return msgs
+}
@@ -1,22 +1,30 @@
 package memqueue_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis_rate/v9"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/frain-dev/taskq/v3"
 	"github.com/frain-dev/taskq/v3/memqueue"
+	"github.com/frain-dev/taskq/v3/taskqtest"
 )
 
 func TestMemqueue(t *testing.T) {
@@ -108,7 +116,7 @@ var _ = Describe("message with invalid number of args", func() {
 			},
 			RetryLimit: 1,
 		})
-		q.Consumer().Stop()
+		q.Consumer().Stop(ctx)
 
 		err := q.Add(task.WithArgs(ctx))
 		Expect(err).NotTo(HaveOccurred())
@@ -228,248 +236,1028 @@ var _ = Describe("message retry timing", func() {
 	})
 })
 
-var _ = Describe("failing queue with error handler", func() {
+var _ = Describe("message pacing", func() {
 	ctx := context.Background()
-	var q *memqueue.Queue
-	ch := make(chan bool, 10)
+	interval := 100 * time.Millisecond
+	ch := make(chan time.Time, 10)
 
 	BeforeEach(func() {
-		q = memqueue.NewQueue(&taskq.QueueOptions{
-			Name:    "test",
-			Storage: taskq.NewLocalStorage(),
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:         "test",
+			Storage:      taskq.NewLocalStorage(),
+			PaceInterval: interval,
 		})
 		task := taskq.RegisterTask(&taskq.TaskOptions{
 			Name: "test",
-			Handler: func() error {
-				return errors.New("fake error")
-			},
-			FallbackHandler: func() {
-				ch <- true
+			Handler: func() {
+				ch <- time.Now()
 			},
-			RetryLimit: 1,
 		})
-		q.Add(task.WithArgs(ctx))
+
+		for i := 0; i < 3; i++ {
+			err := q.Add(task.WithArgs(ctx))
+			Expect(err).NotTo(HaveOccurred())
+		}
 
 		err := q.Close()
 		Expect(err).NotTo(HaveOccurred())
 	})
 
-	It("error handler is called when handler fails", func() {
-		Expect(ch).To(Receive())
+	It("spaces messages evenly", func() {
+		var prev time.Time
+		for i := 0; i < 3; i++ {
+			var tm time.Time
+			Expect(ch).To(Receive(&tm))
+			if !prev.IsZero() {
+				Expect(tm.Sub(prev)).To(BeNumerically(">=", interval-interval/10))
+			}
+			prev = tm
+		}
 		Expect(ch).NotTo(Receive())
 	})
 })
 
-var _ = Describe("named message", func() {
+var _ = Describe("delayed messages", func() {
 	ctx := context.Background()
-	var count int64
+	const n = 1000
+	var early, count int64
 
 	BeforeEach(func() {
+		atomic.StoreInt64(&early, 0)
+		atomic.StoreInt64(&count, 0)
+
 		q := memqueue.NewQueue(&taskq.QueueOptions{
 			Name:    "test",
 			Storage: taskq.NewLocalStorage(),
 		})
 		task := taskq.RegisterTask(&taskq.TaskOptions{
 			Name: "test",
-			Handler: func() {
+			Handler: func(due time.Time) {
+				if time.Now().Before(due) {
+					atomic.AddInt64(&early, 1)
+				}
 				atomic.AddInt64(&count, 1)
 			},
 		})
 
-		name := uuid.NewV4().String()
-
-		var wg sync.WaitGroup
-		for i := 0; i < 100; i++ {
-			wg.Add(1)
-			go func() {
-				defer GinkgoRecover()
-				defer wg.Done()
-				msg := task.WithArgs(ctx)
-				msg.Name = name
-				q.Add(msg)
-			}()
+		for i := 0; i < n; i++ {
+			delay := time.Duration(i%300) * time.Millisecond
+			msg := task.WithArgs(ctx, time.Now().Add(delay))
+			msg.Delay = delay
+			err := q.Add(msg)
+			Expect(err).NotTo(HaveOccurred())
 		}
-		wg.Wait()
 
 		err := q.Close()
 		Expect(err).NotTo(HaveOccurred())
 	})
 
-	It("is processed once", func() {
-		n := atomic.LoadInt64(&count)
-		Expect(n).To(Equal(int64(1)))
+	It("are processed after the delay", func() {
+		Expect(atomic.LoadInt64(&count)).To(Equal(int64(n)))
+		Expect(atomic.LoadInt64(&early)).To(Equal(int64(0)))
 	})
 })
 
-var _ = Describe("CallOnce", func() {
-	ctx := context.Background()
-	var now time.Time
-	delay := 3 * time.Second
-	ch := make(chan time.Time, 10)
+var _ = Describe("unregistered queue", func() {
+	It("can be registered again", func() {
+		factory := memqueue.NewFactory()
+		defer factory.Close()
 
-	BeforeEach(func() {
-		now = time.Now()
+		factory.RegisterQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
 
-		q := memqueue.NewQueue(&taskq.QueueOptions{
+		err := factory.UnregisterQueue("test")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = factory.UnregisterQueue("test")
+		Expect(err).To(HaveOccurred())
+
+		q := factory.RegisterQueue(&taskq.QueueOptions{
 			Name:    "test",
 			Storage: taskq.NewLocalStorage(),
 		})
-		task := taskq.RegisterTask(&taskq.TaskOptions{
-			Name: "test",
-			Handler: func() error {
-				ch <- time.Now()
-				return nil
-			},
+		Expect(q.Name()).To(Equal("test"))
+	})
+
+	It("is not discovered", func() {
+		factory := memqueue.NewFactory()
+		defer factory.Close()
+
+		factory.RegisterQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
 		})
 
-		var wg sync.WaitGroup
-		for i := 0; i < 10; i++ {
-			wg.Add(1)
-			go func() {
-				defer GinkgoRecover()
-				defer wg.Done()
+		names, err := factory.Discover(context.Background(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"test"}))
 
-				msg := task.WithArgs(ctx)
-				msg.OnceInPeriod(delay)
+		err = factory.UnregisterQueue("test")
+		Expect(err).NotTo(HaveOccurred())
 
-				q.Add(msg)
-			}()
-		}
-		wg.Wait()
+		names, err = factory.Discover(context.Background(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(BeEmpty())
+	})
+})
 
-		err := q.Close()
+var _ = Describe("factory hooks", func() {
+	It("are called for every queue", func() {
+		factory := memqueue.NewFactory()
+		defer factory.Close()
+
+		var registered, stopped []string
+		factory.OnQueueRegister(func(q taskq.Queue) {
+			registered = append(registered, q.Name())
+		})
+		factory.OnConsumerStop(func(q taskq.Queue) {
+			stopped = append(stopped, q.Name())
+		})
+
+		factory.RegisterQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		Expect(registered).To(Equal([]string{"test"}))
+
+		err := factory.StopConsumers(context.Background())
 		Expect(err).NotTo(HaveOccurred())
+		Expect(stopped).To(Equal([]string{"test"}))
 	})
+})
 
-	It("processes message once with delay", func() {
-		Eventually(ch).Should(Receive(BeTemporally(">", now.Add(delay), time.Second)))
-		Consistently(ch).ShouldNot(Receive())
+var _ = Describe("factory stats", func() {
+	ctx := context.Background()
+
+	It("merges stats of all queues", func() {
+		factory := memqueue.NewFactory()
+		defer factory.Close()
+
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name:    "test",
+			Handler: func() {},
+		})
+
+		for _, name := range []string{"q2", "q1"} {
+			q := factory.RegisterQueue(&taskq.QueueOptions{
+				Name:    name,
+				Storage: taskq.NewLocalStorage(),
+			})
+			err := q.Add(task.WithArgs(ctx))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Eventually(func() uint64 {
+			return factory.Stats().Consumer.Processed
+		}).Should(Equal(uint64(2)))
+
+		stats := factory.Stats()
+		Expect(stats.Queues).To(HaveLen(2))
+		Expect(stats.Queues[0].Name).To(Equal("q1"))
+		Expect(stats.Queues[0].Consumer.Processed).To(Equal(uint64(1)))
+		Expect(stats.Len).To(Equal(0))
 	})
 })
 
-var _ = Describe("stress testing", func() {
-	const n = 10000
+var _ = Describe("message recorder", func() {
 	ctx := context.Background()
-	var count int64
 
-	BeforeEach(func() {
+	It("records processed messages", func() {
 		q := memqueue.NewQueue(&taskq.QueueOptions{
 			Name:    "test",
 			Storage: taskq.NewLocalStorage(),
 		})
+		defer q.Close()
+		q.SetSync(true)
+
+		rec := taskq.NewRecorder(2)
+		q.Consumer().AddHook(rec)
+
 		task := taskq.RegisterTask(&taskq.TaskOptions{
 			Name: "test",
-			Handler: func() {
-				atomic.AddInt64(&count, 1)
+			Handler: func(n int) error {
+				if n == 3 {
+					return errors.New("fake error")
+				}
+				return nil
 			},
+			RetryLimit: 1,
 		})
 
-		for i := 0; i < n; i++ {
-			q.Add(task.WithArgs(ctx))
+		for i := 1; i <= 2; i++ {
+			err := q.Add(task.WithArgs(ctx, i))
+			Expect(err).NotTo(HaveOccurred())
 		}
+		err := q.Add(task.WithArgs(ctx, 3))
+		Expect(err).To(MatchError("fake error"))
 
-		err := q.Close()
+		msgs := rec.Find("test")
+		Expect(msgs).To(HaveLen(2))
+		Expect(msgs[1].Args).To(Equal([]interface{}{3}))
+		Expect(msgs[1].Err).To(MatchError("fake error"))
+
+		rec.Reset()
+		Expect(rec.Messages()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("mock clock", func() {
+	ctx := context.Background()
+
+	It("retries messages after the clock is advanced", func() {
+		clock := taskqtest.NewClock(time.Now())
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:         "test",
+			Storage:      taskq.NewLocalStorage(),
+			MinNumWorker: 1,
+			MaxNumWorker: 1,
+			Clock:        clock,
+		})
+		defer q.Close()
+
+		var count uint32
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name:       "test",
+			MinBackoff: time.Hour,
+			Handler: func() error {
+				if atomic.AddUint32(&count, 1) == 1 {
+					return errors.New("fake error")
+				}
+				return nil
+			},
+		})
+
+		err := q.Add(task.WithArgs(ctx))
 		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(q.DelayedLen).Should(Equal(1))
+		clock.BlockUntil(1)
+		Consistently(func() uint32 {
+			return atomic.LoadUint32(&count)
+		}, 100*time.Millisecond).Should(Equal(uint32(1)))
+
+		clock.Advance(time.Hour)
+		Eventually(func() uint32 {
+			return atomic.LoadUint32(&count)
+		}).Should(Equal(uint32(2)))
 	})
+})
 
-	It("handler is called for all messages", func() {
-		nn := atomic.LoadInt64(&count)
-		Expect(nn).To(Equal(int64(n)))
+var _ = Describe("task registry", func() {
+	ctx := context.Background()
+
+	It("uses the registry attached to the factory", func() {
+		registry := taskq.NewRegistry()
+		factory := memqueue.NewFactory()
+		factory.SetRegistry(registry)
+		defer factory.Close()
+
+		ch := make(chan string, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+		Expect(taskq.Tasks.Get("test")).To(BeNil())
+
+		q := factory.RegisterQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		err := q.Add(task.WithArgs(ctx, "hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(ch).Should(Receive(Equal("hello")))
 	})
 })
 
-var _ = Describe("stress testing failing queue", func() {
-	const n = 100000
+var _ = Describe("many workers", func() {
 	ctx := context.Background()
-	var errorCount int64
 
-	BeforeEach(func() {
+	It("processes every message once", func() {
+		const n = 10000
+
+		var mu sync.Mutex
+		seen := make(map[int]int, n)
+		var wg sync.WaitGroup
+		wg.Add(n)
+
 		q := memqueue.NewQueue(&taskq.QueueOptions{
-			Name:                 "test",
-			PauseErrorsThreshold: -1,
-			Storage:              taskq.NewLocalStorage(),
+			Name:         "test",
+			BufferSize:   64,
+			MinNumWorker: 64,
+			MaxNumWorker: 64,
 		})
+		defer q.Close()
+
 		task := taskq.RegisterTask(&taskq.TaskOptions{
 			Name: "test",
-			Handler: func() error {
-				return errors.New("fake error")
-			},
-			FallbackHandler: func() {
-				atomic.AddInt64(&errorCount, 1)
+			Handler: func(i int) {
+				mu.Lock()
+				seen[i]++
+				mu.Unlock()
+				wg.Done()
 			},
-			RetryLimit: 1,
 		})
 
 		for i := 0; i < n; i++ {
-			q.Add(task.WithArgs(ctx))
+			err := q.Add(task.WithArgs(ctx, i))
+			Expect(err).NotTo(HaveOccurred())
 		}
+		wg.Wait()
 
-		err := q.Close()
-		Expect(err).NotTo(HaveOccurred())
+		Expect(seen).To(HaveLen(n))
+		for i, count := range seen {
+			Expect(count).To(Equal(1), "message %d", i)
+		}
 	})
+})
 
-	It("error handler is called for all messages", func() {
-		nn := atomic.LoadInt64(&errorCount)
-		Expect(nn).To(Equal(int64(n)))
+var _ = Describe("resource probes", func() {
+	ctx := context.Background()
+
+	It("removes workers when resources are exhausted", func() {
+		var free uint32
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:         "test",
+			MinNumWorker: 1,
+			MaxNumWorker: 4,
+			ResourceProbes: []taskq.ResourceProbe{
+				taskq.ResourceProbeFunc(func() bool {
+					return atomic.LoadUint32(&free) == 1
+				}),
+			},
+		})
+		defer q.Close()
+
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name:    "test",
+			Handler: func() {},
+		})
+		err := q.Add(task.WithArgs(ctx))
+		Expect(err).NotTo(HaveOccurred())
+
+		numWorker := func() uint32 {
+			return q.Consumer().Stats().NumWorker
+		}
+		Eventually(numWorker, 5*time.Second).Should(Equal(uint32(1)))
+
+		atomic.StoreUint32(&free, 1)
+		Eventually(numWorker, 5*time.Second).Should(Equal(uint32(4)))
 	})
 })
 
-var _ = Describe("empty queue", func() {
+var _ = Describe("bounded queue", func() {
 	ctx := context.Background()
 	var q *memqueue.Queue
 	var task *taskq.Task
-	var processed uint32
+	var unblock chan struct{}
 
-	BeforeEach(func() {
-		processed = 0
+	newQueue := func(policy taskq.OverflowPolicy) {
+		unblock = make(chan struct{})
 		q = memqueue.NewQueue(&taskq.QueueOptions{
-			Name:    "test",
-			Storage: taskq.NewLocalStorage(),
+			Name:            "test",
+			Storage:         taskq.NewLocalStorage(),
+			MaxPending:      2,
+			OverflowPolicy:  policy,
+			OverflowTimeout: time.Second,
 		})
 		task = taskq.RegisterTask(&taskq.TaskOptions{
 			Name: "test",
 			Handler: func() {
-				atomic.AddUint32(&processed, 1)
+				<-unblock
 			},
 		})
-	})
 
-	AfterEach(func() {
-		_ = q.Close()
-	})
+		for i := 0; i < 2; i++ {
+			err := q.Add(task.WithArgs(ctx))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
 
-	It("can be closed", func() {
+	AfterEach(func() {
 		err := q.Close()
 		Expect(err).NotTo(HaveOccurred())
 	})
 
-	It("stops processor", func() {
-		err := q.Consumer().Stop()
+	It("rejects messages when full", func() {
+		newQueue(taskq.OverflowReject)
+
+		err := q.Add(task.WithArgs(ctx))
+		Expect(err).To(Equal(taskq.ErrQueueFull))
+
+		close(unblock)
+	})
+
+	It("blocks until there is room", func() {
+		newQueue(taskq.OverflowBlock)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(unblock)
+		}()
+
+		start := time.Now()
+		err := q.Add(task.WithArgs(ctx))
 		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
 	})
+})
 
-	testEmptyQueue := func() {
-		It("processes all messages", func() {
-			err := q.Consumer().ProcessAll(ctx)
-			Expect(err).NotTo(HaveOccurred())
-		})
+var _ = Describe("priority queue", func() {
+	ctx := context.Background()
+	var processed []string
 
-		It("processes one message", func() {
-			err := q.Consumer().ProcessOne(ctx)
-			Expect(err).To(MatchError("taskq: queue is empty"))
+	BeforeEach(func() {
+		processed = nil
+		unblock := make(chan struct{})
 
-			err = q.Consumer().ProcessAll(ctx)
-			Expect(err).NotTo(HaveOccurred())
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:          "test",
+			Storage:       taskq.NewLocalStorage(),
+			MinNumWorker:  1,
+			MaxNumWorker:  1,
+			BufferSize:    1,
+			PriorityQueue: true,
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func(s string) {
+				if s == "blocker" {
+					<-unblock
+				}
+				processed = append(processed, s)
+			},
 		})
-	}
 
-	Context("when processor is stopped", func() {
-		BeforeEach(func() {
-			err := q.Consumer().Stop()
+		add := func(s string, priority int) {
+			msg := task.WithArgs(ctx, s)
+			msg.Priority = priority
+			err := q.Add(msg)
 			Expect(err).NotTo(HaveOccurred())
-		})
+		}
 
-		testEmptyQueue()
+		add("blocker", 0)
+		time.Sleep(100 * time.Millisecond)
+		for _, s := range []string{"a", "b", "c"} {
+			add(s, 0)
+		}
+		add("urgent", 10)
+		close(unblock)
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("processes messages with higher priority first", func() {
+		Expect(processed).To(HaveLen(5))
+		Expect(processed[0]).To(Equal("blocker"))
+		Expect(processed[4]).To(Equal("c"))
+		Expect(processed).To(ContainElement("urgent"))
+	})
+})
+
+var _ = Describe("queue snapshot", func() {
+	ctx := context.Background()
+	var q *memqueue.Queue
+
+	BeforeEach(func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name:    "test",
+			Handler: func() {},
+		})
+
+		msg := task.WithArgs(ctx)
+		msg.Delay = time.Hour
+		err := q.Add(msg)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = q.Add(task.WithArgs(ctx))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() int {
+			return q.Snapshot().Processed
+		}).Should(Equal(1))
+	})
+
+	AfterEach(func() {
+		err := q.Purge()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports delayed and processed messages", func() {
+		Expect(q.DelayedLen()).To(Equal(1))
+
+		n, err := q.Len()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(0))
+
+		snap := q.Snapshot()
+		Expect(snap.Delayed).To(Equal(1))
+		Expect(snap.Buffered).To(Equal(0))
+		Expect(snap.InFlight).To(Equal(0))
+	})
+})
+
+var _ = Describe("sync mode", func() {
+	ctx := context.Background()
+	var q *memqueue.Queue
+	var clock *taskqtest.Clock
+	var start time.Time
+	var calls int
+	var fallbacks int
+
+	BeforeEach(func() {
+		calls, fallbacks = 0, 0
+
+		start = time.Now()
+		clock = taskqtest.NewClock(start)
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Clock:   clock,
+		})
+		q.SetSync(true)
+
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() error {
+				calls++
+				return errors.New("fake error")
+			},
+			FallbackHandler: func() {
+				fallbacks++
+			},
+			RetryLimit: 3,
+			MinBackoff: time.Second,
+		})
+
+		_ = q.Add(task.WithArgs(ctx))
+	})
+
+	AfterEach(func() {
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("retries the message inline", func() {
+		Expect(calls).To(Equal(3))
+		Expect(fallbacks).To(Equal(1))
+	})
+
+	It("advances the mock clock by backoff", func() {
+		Expect(clock.Now().Sub(start)).To(Equal(3 * time.Second))
+	})
+})
+
+var _ = Describe("canceled delayed message", func() {
+	ctx := context.Background()
+	ch := make(chan string, 10)
+	var canceled bool
+
+	BeforeEach(func() {
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+
+		msg := task.WithArgs(ctx, "superseded")
+		msg.Delay = 100 * time.Millisecond
+		h, err := q.AddCancelable(msg)
+		Expect(err).NotTo(HaveOccurred())
+
+		msg = task.WithArgs(ctx, "latest")
+		msg.Delay = 100 * time.Millisecond
+		err = q.Add(msg)
+		Expect(err).NotTo(HaveOccurred())
+
+		canceled = h.Cancel()
+
+		err = q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is not processed", func() {
+		Expect(canceled).To(BeTrue())
+		Expect(ch).To(Receive(Equal("latest")))
+		Expect(ch).NotTo(Receive())
+	})
+})
+
+var _ = Describe("persisted queue", func() {
+	ctx := context.Background()
+	var file string
+	ch := make(chan string, 10)
+
+	newQueue := func() *memqueue.Queue {
+		return memqueue.NewQueue(&taskq.QueueOptions{
+			Name:        "test",
+			Storage:     taskq.NewLocalStorage(),
+			PersistFile: file,
+		})
+	}
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "memqueue")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "queue.msgpack")
+
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+
+		q := newQueue()
+		msg := task.WithArgs(ctx, "delayed")
+		msg.Delay = 2 * time.Second
+		err = q.Add(msg)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(filepath.Dir(file))
+	})
+
+	It("restores delayed messages", func() {
+		Expect(ch).NotTo(Receive())
+		Expect(file).To(BeAnExistingFile())
+
+		q := newQueue()
+		Expect(file).NotTo(BeAnExistingFile())
+		Expect(q.DelayedLen()).To(Equal(1))
+
+		Eventually(ch, 3*time.Second).Should(Receive(Equal("delayed")))
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("persisted queue with stopped consumer", func() {
+	ctx := context.Background()
+	var file string
+	ch := make(chan string, 10)
+
+	newQueue := func() *memqueue.Queue {
+		return memqueue.NewQueue(&taskq.QueueOptions{
+			Name:        "test-stopped",
+			Storage:     taskq.NewLocalStorage(),
+			PersistFile: file,
+		})
+	}
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "memqueue")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "queue.msgpack")
+
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test-stopped",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+
+		// Producer-only process.
+		q := newQueue()
+		err = q.Consumer().Stop(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, s := range []string{"first", "second"} {
+			err = q.Add(task.WithArgs(ctx, s))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		n, err := q.Len()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+
+		err = q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(filepath.Dir(file))
+	})
+
+	It("restores buffered messages", func() {
+		Expect(ch).NotTo(Receive())
+		Expect(file).To(BeAnExistingFile())
+
+		q := newQueue()
+		Expect(file).NotTo(BeAnExistingFile())
+
+		Eventually(ch, 3*time.Second).Should(Receive(Equal("first")))
+		Eventually(ch, 3*time.Second).Should(Receive(Equal("second")))
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("failing queue with error handler", func() {
+	ctx := context.Background()
+	var q *memqueue.Queue
+	ch := make(chan bool, 10)
+
+	BeforeEach(func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() error {
+				return errors.New("fake error")
+			},
+			FallbackHandler: func() {
+				ch <- true
+			},
+			RetryLimit: 1,
+		})
+		q.Add(task.WithArgs(ctx))
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("error handler is called when handler fails", func() {
+		Expect(ch).To(Receive())
+		Expect(ch).NotTo(Receive())
+	})
+})
+
+var _ = Describe("named message", func() {
+	ctx := context.Background()
+	var count int64
+
+	BeforeEach(func() {
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() {
+				atomic.AddInt64(&count, 1)
+			},
+		})
+
+		name := uuid.NewV4().String()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				msg := task.WithArgs(ctx)
+				msg.Name = name
+				q.Add(msg)
+			}()
+		}
+		wg.Wait()
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is processed once", func() {
+		n := atomic.LoadInt64(&count)
+		Expect(n).To(Equal(int64(1)))
+	})
+})
+
+var _ = Describe("named message with storage TTL", func() {
+	ctx := context.Background()
+	var count int64
+
+	BeforeEach(func() {
+		atomic.StoreInt64(&count, 0)
+
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name: "test",
+			Storage: taskq.NewLocalStorageWithOptions(&taskq.LocalStorageOptions{
+				MaxSize: 10,
+				TTL:     100 * time.Millisecond,
+			}),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() {
+				atomic.AddInt64(&count, 1)
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			msg := task.WithArgs(ctx)
+			msg.Name = "myname"
+			err := q.Add(msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(150 * time.Millisecond)
+		}
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is processed again after the TTL", func() {
+		n := atomic.LoadInt64(&count)
+		Expect(n).To(Equal(int64(3)))
+	})
+})
+
+var _ = Describe("CallOnce", func() {
+	ctx := context.Background()
+	var now time.Time
+	delay := 3 * time.Second
+	ch := make(chan time.Time, 10)
+
+	BeforeEach(func() {
+		now = time.Now()
+
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() error {
+				ch <- time.Now()
+				return nil
+			},
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				msg := task.WithArgs(ctx)
+				msg.OnceInPeriod(delay)
+
+				q.Add(msg)
+			}()
+		}
+		wg.Wait()
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("processes message once with delay", func() {
+		Eventually(ch).Should(Receive(BeTemporally(">", now.Add(delay), time.Second)))
+		Consistently(ch).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("stress testing", func() {
+	const n = 10000
+	ctx := context.Background()
+	var count int64
+
+	BeforeEach(func() {
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() {
+				atomic.AddInt64(&count, 1)
+			},
+		})
+
+		for i := 0; i < n; i++ {
+			q.Add(task.WithArgs(ctx))
+		}
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("handler is called for all messages", func() {
+		nn := atomic.LoadInt64(&count)
+		Expect(nn).To(Equal(int64(n)))
+	})
+})
+
+var _ = Describe("stress testing failing queue", func() {
+	const n = 100000
+	ctx := context.Background()
+	var errorCount int64
+
+	BeforeEach(func() {
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:                 "test",
+			PauseErrorsThreshold: -1,
+			Storage:              taskq.NewLocalStorage(),
+		})
+		task := taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() error {
+				return errors.New("fake error")
+			},
+			FallbackHandler: func() {
+				atomic.AddInt64(&errorCount, 1)
+			},
+			RetryLimit: 1,
+		})
+
+		for i := 0; i < n; i++ {
+			q.Add(task.WithArgs(ctx))
+		}
+
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("error handler is called for all messages", func() {
+		nn := atomic.LoadInt64(&errorCount)
+		Expect(nn).To(Equal(int64(n)))
+	})
+})
+
+var _ = Describe("empty queue", func() {
+	ctx := context.Background()
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var processed uint32
+
+	BeforeEach(func() {
+		processed = 0
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "test",
+			Handler: func() {
+				atomic.AddUint32(&processed, 1)
+			},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("can be closed", func() {
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("stops processor", func() {
+		err := q.Consumer().Stop(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	testEmptyQueue := func() {
+		It("processes all messages", func() {
+			err := q.Consumer().ProcessAll(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("processes one message", func() {
+			err := q.Consumer().ProcessOne(ctx)
+			Expect(err).To(MatchError(taskq.ErrQueueEmpty))
+
+			err = q.Consumer().ProcessAll(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	}
+
+	Context("when processor is stopped", func() {
+		BeforeEach(func() {
+			err := q.Consumer().Stop(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		testEmptyQueue()
 
 		Context("when there are messages in the queue", func() {
 			BeforeEach(func() {
@@ -477,44 +1265,1778 @@ var _ = Describe("empty queue", func() {
 					err := q.Add(task.WithArgs(ctx))
 					Expect(err).NotTo(HaveOccurred())
 				}
-			})
+			})
+
+			It("processes all messages", func() {
+				p := q.Consumer()
+
+				err := p.ProcessAll(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				n := atomic.LoadUint32(&processed)
+				Expect(n).To(Equal(uint32(3)))
+			})
+
+			It("processes one message", func() {
+				p := q.Consumer()
+
+				err := p.ProcessOne(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				n := atomic.LoadUint32(&processed)
+				Expect(n).To(Equal(uint32(1)))
+
+				err = p.ProcessAll(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				n = atomic.LoadUint32(&processed)
+				Expect(n).To(Equal(uint32(3)))
+			})
+		})
+	})
+})
+
+// slot splits time into equal periods (called slots) and returns
+// slot number for provided time.
+func slot(period time.Duration) int64 {
+	tm := time.Now()
+	periodSec := int64(period / time.Second)
+	if periodSec == 0 {
+		return tm.Unix()
+	}
+	return tm.Unix() / periodSec
+}
+
+var _ = Describe("sentinel errors", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+
+	BeforeEach(func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("returns ErrQueueClosed when adding to the closed queue", func() {
+		err := q.Close()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = q.Add(taskq.NewMessage(ctx))
+		Expect(errors.Is(err, taskq.ErrQueueClosed)).To(BeTrue())
+
+		err = q.Close()
+		Expect(errors.Is(err, taskq.ErrQueueClosed)).To(BeTrue())
+	})
+
+	It("returns ErrNotSupported from ReserveN", func() {
+		_, err := q.ReserveN(ctx, 1, 0)
+		Expect(errors.Is(err, taskq.ErrNotSupported)).To(BeTrue())
+	})
+
+	It("returns ErrStopped when stopping the stopped consumer", func() {
+		err := q.Consumer().Stop(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = q.Consumer().Stop(ctx)
+		Expect(errors.Is(err, taskq.ErrStopped)).To(BeTrue())
+	})
+})
+
+var _ = Describe("consumer stats", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+
+	BeforeEach(func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name:    "consumer-stats",
+			Handler: func() {},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	addMessages := func(n int) {
+		for i := 0; i < n; i++ {
+			err := q.Add(task.WithArgs(ctx))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		err := q.WaitTimeout(time.Second)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("returns counters changed since the previous stats", func() {
+		addMessages(3)
+		prev := q.Consumer().Stats()
+		Expect(prev.Processed).To(Equal(uint64(3)))
+
+		addMessages(2)
+		delta := q.Consumer().Stats().Delta(prev)
+		Expect(delta.Processed).To(Equal(uint64(2)))
+	})
+
+	It("resets counters", func() {
+		addMessages(3)
+		prev := q.Consumer().Stats()
+
+		q.Consumer().ResetStats()
+		Expect(q.Consumer().Stats().Processed).To(Equal(uint64(0)))
+
+		addMessages(1)
+		st := q.Consumer().Stats()
+		Expect(st.Processed).To(Equal(uint64(1)))
+		Expect(st.Delta(prev).Processed).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("stopping consumer with context", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var started, release chan struct{}
+
+	BeforeEach(func() {
+		started = make(chan struct{})
+		release = make(chan struct{})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "stop-with-context",
+			Handler: func() {
+				close(started)
+				<-release
+			},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("returns the context error when workers are busy", func() {
+		err := q.Add(task.WithArgs(ctx))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(started).Should(BeClosed())
+
+		stopCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		err = q.Consumer().Stop(stopCtx)
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+		close(release)
+	})
+})
+
+var _ = Describe("delivery audit", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var release chan struct{}
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:     "test",
+			Storage:  taskq.NewLocalStorage(),
+			Audit:    true,
+			AuditTTL: 10 * time.Millisecond,
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name:       "delivery-audit",
+			RetryLimit: 1,
+			Handler: func(s string) error {
+				switch s {
+				case "fail":
+					return errors.New("fake error")
+				case "block":
+					<-release
+				}
+				return nil
+			},
+		})
+	})
+
+	AfterEach(func() {
+		close(release)
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("reports messages that didn't reach a terminal state", func() {
+		ok := task.WithArgs(ctx, "ok")
+		Expect(q.Add(ok)).NotTo(HaveOccurred())
+		Expect(q.Add(task.WithArgs(ctx, "fail"))).NotTo(HaveOccurred())
+		lost := task.WithArgs(ctx, "block")
+		Expect(q.Add(lost)).NotTo(HaveOccurred())
+
+		Expect(ok.Headers[taskq.AuditIDHeader]).NotTo(BeEmpty())
+
+		Eventually(func() []taskq.AuditRecord {
+			report, err := taskq.Reconcile(ctx, q)
+			Expect(err).NotTo(HaveOccurred())
+			return report.Lost
+		}).Should(HaveLen(1))
+
+		report, err := taskq.Reconcile(ctx, q)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Queue).To(Equal("test"))
+		Expect(report.Lost[0].ID).To(Equal(lost.Headers[taskq.AuditIDHeader]))
+		Expect(report.Lost[0].TaskName).To(Equal("delivery-audit"))
+	})
+
+	It("does not report rejected messages", func() {
+		q2 := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:       "bounded-audit",
+			Storage:    taskq.NewLocalStorage(),
+			Audit:      true,
+			AuditTTL:   10 * time.Millisecond,
+			MaxPending: 1,
+		})
+		defer q2.Close()
+
+		blocked := task.WithArgs(ctx, "block")
+		Expect(q2.Add(blocked)).NotTo(HaveOccurred())
+		rejected := task.WithArgs(ctx, "ok")
+		Expect(q2.Add(rejected)).To(Equal(taskq.ErrQueueFull))
+
+		time.Sleep(50 * time.Millisecond)
+		report, err := taskq.Reconcile(ctx, q2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Lost).To(HaveLen(1))
+		Expect(report.Lost[0].ID).To(Equal(blocked.Headers[taskq.AuditIDHeader]))
+
+		release <- struct{}{}
+	})
+
+	It("requires the audit to be enabled", func() {
+		q2 := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "no-audit",
+			Storage: taskq.NewLocalStorage(),
+		})
+		defer q2.Close()
+
+		_, err := taskq.Reconcile(ctx, q2)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("duplicate delivery detection", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+
+	BeforeEach(func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:                      "test",
+			Storage:                   taskq.NewLocalStorage(),
+			DetectDuplicateDeliveries: true,
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name:    "duplicate-delivery",
+			Handler: func() {},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("counts messages processed more than once", func() {
+		for _, id := range []string{"1", "2", "1"} {
+			msg := task.WithArgs(ctx)
+			msg.ID = id
+			Expect(q.Add(msg)).NotTo(HaveOccurred())
+		}
+
+		Eventually(func() uint64 {
+			return q.Consumer().Stats().Processed
+		}).Should(Equal(uint64(3)))
+		Expect(q.Consumer().Stats().Duplicates).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("strict FIFO", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+
+	var mu sync.Mutex
+	var processed []string
+	var fallback []string
+
+	BeforeEach(func() {
+		processed = nil
+		fallback = nil
+		failures := map[string]int{"retry": 1, "dead": 100}
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:       "test",
+			Storage:    taskq.NewLocalStorage(),
+			StrictFIFO: true,
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "strict-fifo",
+			Handler: func(s string) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				processed = append(processed, s)
+				if failures[s] > 0 {
+					failures[s]--
+					return errors.New("fake error")
+				}
+				return nil
+			},
+			FallbackHandler: func(s string) {
+				mu.Lock()
+				fallback = append(fallback, s)
+				mu.Unlock()
+			},
+			RetryLimit: 3,
+			MinBackoff: time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("retries failed messages in place", func() {
+		for _, s := range []string{"1", "retry", "2", "dead", "3"} {
+			Expect(q.Add(task.WithArgs(ctx, s))).NotTo(HaveOccurred())
+		}
+
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), processed...)
+		}).Should(Equal([]string{"1", "retry", "retry", "2", "dead", "dead", "dead", "3"}))
+
+		mu.Lock()
+		Expect(fallback).To(Equal([]string{"dead"}))
+		mu.Unlock()
+
+		st := q.Consumer().Stats()
+		Expect(st.NumWorker).To(Equal(uint32(1)))
+		Expect(st.Fails).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("StrictFIFO with Clock", func() {
+	ctx := context.Background()
+
+	It("waits for messages using the clock", func() {
+		clock := taskqtest.NewClock(time.Now())
+		registry := taskq.NewRegistry()
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:       "test",
+			Storage:    taskq.NewLocalStorage(),
+			Handler:    registry,
+			StrictFIFO: true,
+			Clock:      clock,
+		})
+		defer q.Close()
+
+		ch := make(chan string, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "strict-fifo-clock",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+
+		// The worker waits for messages on a timer of the clock.
+		Eventually(clock.Len).Should(Equal(1))
+
+		Expect(q.Add(task.WithArgs(ctx, "hello"))).NotTo(HaveOccurred())
+		Eventually(ch).Should(Receive(Equal("hello")))
+	})
+})
+
+var _ = Describe("unknown task policy", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var registry *taskq.TaskMap
+	var fallback chan *taskq.Message
+
+	BeforeEach(func() {
+		registry = taskq.NewRegistry()
+		fallback = make(chan *taskq.Message, 10)
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	addUnknown := func() {
+		msg := taskq.NewMessage(ctx)
+		msg.TaskName = "unknown-task"
+		Expect(q.Add(msg)).NotTo(HaveOccurred())
+	}
+
+	fallbackHandler := func(msg *taskq.Message) error {
+		fallback <- msg
+		return nil
+	}
+
+	It("dead-letters messages", func() {
+		registry.SetUnknownTaskPolicy(&taskq.UnknownTaskPolicy{
+			Action:          taskq.UnknownTaskDeadLetter,
+			FallbackHandler: fallbackHandler,
+		})
+		addUnknown()
+
+		var msg *taskq.Message
+		Eventually(fallback).Should(Receive(&msg))
+		Expect(errors.Is(msg.Err, taskq.ErrUnknownTask)).To(BeTrue())
+		Expect(msg.ReservedCount).To(Equal(1))
+		Eventually(func() uint64 {
+			return q.Consumer().Stats().Fails
+		}).Should(Equal(uint64(1)))
+	})
+
+	It("drops messages", func() {
+		registry.SetUnknownTaskPolicy(&taskq.UnknownTaskPolicy{
+			Action:          taskq.UnknownTaskDrop,
+			FallbackHandler: fallbackHandler,
+		})
+		addUnknown()
+
+		Eventually(func() uint64 {
+			return q.Consumer().Stats().Fails
+		}).Should(Equal(uint64(1)))
+		Consistently(fallback).ShouldNot(Receive())
+	})
+
+	It("releases messages with delay", func() {
+		registry.SetUnknownTaskPolicy(&taskq.UnknownTaskPolicy{
+			Action:          taskq.UnknownTaskRelease,
+			Delay:           time.Hour,
+			FallbackHandler: fallbackHandler,
+		})
+		addUnknown()
+
+		Eventually(func() uint64 {
+			return q.Consumer().Stats().Retries
+		}).Should(Equal(uint64(1)))
+		Expect(q.Consumer().Stats().Fails).To(Equal(uint64(0)))
+		Consistently(fallback).ShouldNot(Receive())
+
+		Expect(q.Purge()).NotTo(HaveOccurred())
+	})
+
+	It("processes messages with custom handler", func() {
+		registry.SetUnknownTaskPolicy(&taskq.UnknownTaskPolicy{
+			Action: taskq.UnknownTaskHandle,
+			Handler: func(msg *taskq.Message) error {
+				fallback <- msg
+				return nil
+			},
+		})
+		addUnknown()
+
+		var msg *taskq.Message
+		Eventually(fallback).Should(Receive(&msg))
+		Expect(msg.TaskName).To(Equal("unknown-task"))
+		Eventually(func() uint64 {
+			return q.Consumer().Stats().Processed
+		}).Should(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("error history", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var fallback chan []string
+
+	BeforeEach(func() {
+		fallback = make(chan []string, 1)
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:             "test",
+			Storage:          taskq.NewLocalStorage(),
+			ErrorHistorySize: 2,
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "error-history",
+			Handler: func(msg *taskq.Message) error {
+				return fmt.Errorf("attempt %d failed", msg.ReservedCount)
+			},
+			FallbackHandler: func(msg *taskq.Message) {
+				fallback <- msg.Errors()
+			},
+			RetryLimit: 3,
+			MinBackoff: time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("keeps the last errors on the message", func() {
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+
+		var errs []string
+		Eventually(fallback).Should(Receive(&errs))
+		Expect(errs).To(Equal([]string{"attempt 2 failed", "attempt 3 failed"}))
+	})
+})
+
+var _ = Describe("functional options", func() {
+	It("creates the queue", func() {
+		q, err := memqueue.New("test", taskq.WithWorkers(2))
+		Expect(err).NotTo(HaveOccurred())
+		defer q.Close()
+
+		Expect(q.Options().MaxNumWorker).To(Equal(int32(2)))
+		Expect(q.Consumer().Stats().NumWorker).To(Equal(uint32(2)))
+	})
+
+	It("validates the options", func() {
+		_, err := memqueue.New("")
+		Expect(err).To(MatchError("taskq: QueueOptions.Name is required"))
+	})
+})
+
+var _ = Describe("Add with context", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var started, release chan struct{}
+
+	BeforeEach(func() {
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:        "test",
+			Storage:     taskq.NewLocalStorage(),
+			WorkerLimit: 1,
+			BufferSize:  1,
+		})
+		task = taskq.RegisterTask(&taskq.TaskOptions{
+			Name: "add-with-context",
+			Handler: func() {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-release
+			},
+		})
+	})
+
+	AfterEach(func() {
+		close(release)
+		_ = q.Close()
+		taskq.Tasks.Unregister(task)
+	})
+
+	It("stops waiting for room in the buffer when the context is done", func() {
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(started).Should(Receive())
+		// Fill the buffer.
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+
+		addCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		err := q.Add(task.WithArgs(addCtx))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+})
+
+var _ = Describe("HandlerTimeout", func() {
+	ctx := context.Background()
+
+	It("cancels the handler context", func() {
+		registry := taskq.NewRegistry()
+		q := memqueue.NewQueue(&taskq.QueueOptions{
+			Name:           "test",
+			Storage:        taskq.NewLocalStorage(),
+			Handler:        registry,
+			HandlerTimeout: 50 * time.Millisecond,
+		})
+		defer q.Close()
+
+		ch := make(chan error, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "handler-timeout",
+			Handler: func(ctx context.Context) {
+				<-ctx.Done()
+				ch <- ctx.Err()
+			},
+		})
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(ch).Should(Receive(Equal(context.DeadlineExceeded)))
+	})
+})
+
+var _ = Describe("handler dependencies", func() {
+	ctx := context.Background()
+
+	type deps struct {
+		name string
+	}
+
+	var q *memqueue.Queue
+	var registry *taskq.TaskMap
+	var ch chan string
+
+	BeforeEach(func() {
+		registry = taskq.NewRegistry()
+		ch = make(chan string, 10)
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+			Deps:    &deps{name: "db"},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("passes dependencies with the context", func() {
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "deps-context",
+			Handler: func(ctx context.Context) {
+				ch <- taskq.DepsFromContext(ctx).(*deps).name
+			},
+		})
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(ch).Should(Receive(Equal("db")))
+	})
+
+	It("creates handlers with the factory", func() {
+		var created uint32
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "deps-factory",
+			HandlerFactory: func(v interface{}) interface{} {
+				atomic.AddUint32(&created, 1)
+				d := v.(*deps)
+				return func(s string) {
+					ch <- d.name + ":" + s
+				}
+			},
+		})
+
+		Expect(q.Add(task.WithArgs(ctx, "a"))).NotTo(HaveOccurred())
+		Expect(q.Add(task.WithArgs(ctx, "b"))).NotTo(HaveOccurred())
+
+		var got []string
+		for i := 0; i < 2; i++ {
+			var s string
+			Eventually(ch).Should(Receive(&s))
+			got = append(got, s)
+		}
+		Expect(got).To(ConsistOf("db:a", "db:b"))
+		Expect(atomic.LoadUint32(&created)).To(Equal(uint32(1)))
+	})
+})
+
+var _ = Describe("Debounce", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var ch chan string
+
+	BeforeEach(func() {
+		registry := taskq.NewRegistry()
+		ch = make(chan string, 10)
+		task = registry.RegisterTask(&taskq.TaskOptions{
+			Name: "debounce",
+			Handler: func(s string) {
+				ch <- s
+			},
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("runs the task once with the args of the last call", func() {
+		for _, s := range []string{"a", "b", "c"} {
+			err := taskq.Debounce(ctx, q, task, "key", 200*time.Millisecond, s)
+			Expect(err).NotTo(HaveOccurred())
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		Eventually(ch).Should(Receive(Equal("c")))
+		Consistently(ch, 500*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("debounces keys independently", func() {
+		Expect(taskq.Debounce(ctx, q, task, "a", 100*time.Millisecond, "a")).NotTo(HaveOccurred())
+		Expect(taskq.Debounce(ctx, q, task, "b", 100*time.Millisecond, "b")).NotTo(HaveOccurred())
+
+		var got []string
+		for i := 0; i < 2; i++ {
+			var s string
+			Eventually(ch).Should(Receive(&s))
+			got = append(got, s)
+		}
+		Expect(got).To(ConsistOf("a", "b"))
+	})
+
+	It("runs the task again after the window", func() {
+		Expect(taskq.Debounce(ctx, q, task, "key", 50*time.Millisecond, "a")).NotTo(HaveOccurred())
+		Eventually(ch).Should(Receive(Equal("a")))
+
+		Expect(taskq.Debounce(ctx, q, task, "key", 50*time.Millisecond, "b")).NotTo(HaveOccurred())
+		Eventually(ch).Should(Receive(Equal("b")))
+	})
+})
+
+var _ = Describe("Consumer.UpdateOptions", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var registry *taskq.TaskMap
+
+	newQueue := func(min, max int32) {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:         "test",
+			Storage:      taskq.NewLocalStorage(),
+			Handler:      registry,
+			MinNumWorker: min,
+			MaxNumWorker: max,
+			// The autoscaler removes workers when the host is busy,
+			// e.g. while other packages are tested.
+			ResourceProbes: []taskq.ResourceProbe{
+				taskq.ResourceProbeFunc(func() bool { return true }),
+			},
+		})
+	}
+
+	numWorker := func() uint32 {
+		return q.Consumer().Stats().NumWorker
+	}
+
+	BeforeEach(func() {
+		registry = taskq.NewRegistry()
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("updates the number of workers without dropping messages", func() {
+		newQueue(2, 2)
+
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		done := make(chan struct{}, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "update-workers",
+			Handler: func() {
+				started <- struct{}{}
+				<-release
+				done <- struct{}{}
+			},
+		})
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(started).Should(Receive())
+
+		c := q.Consumer().(*taskq.Consumer)
+		Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+			MinNumWorker: 4,
+			MaxNumWorker: 4,
+		})).NotTo(HaveOccurred())
+		Eventually(numWorker).Should(Equal(uint32(4)))
+
+		Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+			MinNumWorker: 1,
+			MaxNumWorker: 1,
+		})).NotTo(HaveOccurred())
+		Eventually(numWorker).Should(Equal(uint32(1)))
+
+		close(release)
+		Eventually(done).Should(Receive())
+
+		// The options are shared with the queue.
+		Expect(q.Options().MinNumWorker).To(Equal(int32(2)))
+		Expect(q.Options().MaxNumWorker).To(Equal(int32(2)))
+	})
+
+	It("updates options while messages are processed", func() {
+		newQueue(1, 4)
+
+		const n = 1000
+		var wg sync.WaitGroup
+		wg.Add(n)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "update-concurrently",
+			Handler: func() {
+				// The options are read without locks by the queue.
+				opt := q.Options()
+				_, _ = opt.MaxNumWorker, opt.RateLimit
+				wg.Done()
+			},
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			for i := 0; i < n; i++ {
+				Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+			}
+		}()
+
+		c := q.Consumer().(*taskq.Consumer)
+		var noLimit redis_rate.Limit
+		for i := int32(0); ; i++ {
+			select {
+			case <-done:
+				wg.Wait()
+				return
+			default:
+			}
+			Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+				MinNumWorker: 1,
+				MaxNumWorker: 2 + i%4,
+				RateLimit:    &noLimit,
+				RetryLimit:   int(1 + i%3),
+			})).NotTo(HaveOccurred())
+			_ = c.Stats()
+		}
+	})
+
+	It("updates the range of autoscaled workers", func() {
+		newQueue(1, 8)
+		Eventually(numWorker).Should(Equal(uint32(8)))
+
+		c := q.Consumer().(*taskq.Consumer)
+		Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+			MaxNumWorker: 2,
+		})).NotTo(HaveOccurred())
+		Eventually(numWorker).Should(Equal(uint32(2)))
+	})
+
+	It("starts autoscaling fixed workers", func() {
+		newQueue(2, 2)
+		Eventually(numWorker).Should(Equal(uint32(2)))
+
+		c := q.Consumer().(*taskq.Consumer)
+		Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+			MinNumWorker: 1,
+			MaxNumWorker: 6,
+		})).NotTo(HaveOccurred())
+		Eventually(numWorker).Should(Equal(uint32(6)))
+	})
+
+	It("overrides the retry limit", func() {
+		newQueue(1, 1)
+
+		failed := make(chan error, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "update-retry",
+			Handler: func() error {
+				return errors.New("fake error")
+			},
+			FallbackHandler: func(ctx context.Context, msg *taskq.Message, err error) {
+				failed <- err
+			},
+			RetryLimit: 10,
+			MinBackoff: time.Hour,
+		})
+
+		c := q.Consumer().(*taskq.Consumer)
+		Expect(c.UpdateOptions(&taskq.ConsumerUpdate{
+			RetryLimit: 1,
+		})).NotTo(HaveOccurred())
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(failed).Should(Receive(MatchError("fake error")))
+	})
+
+	It("rejects invalid updates", func() {
+		newQueue(1, 4)
+		c := q.Consumer().(*taskq.Consumer)
+
+		err := c.UpdateOptions(&taskq.ConsumerUpdate{MinNumWorker: 8})
+		Expect(err).To(MatchError("taskq: ConsumerUpdate.MinNumWorker=8 is greater than MaxNumWorker=4"))
+
+		limit := redis_rate.PerSecond(10)
+		err = c.UpdateOptions(&taskq.ConsumerUpdate{RateLimit: &limit})
+		Expect(err).To(MatchError("taskq: ConsumerUpdate.RateLimit requires RateLimiter or Redis"))
+	})
+})
+
+var _ = Describe("Quota", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var release chan struct{}
+	var processed chan string
+
+	newMessage := func(tenant string) *taskq.Message {
+		msg := task.WithArgs(ctx, tenant)
+		msg.Headers = map[string]string{"tenant": tenant}
+		return msg
+	}
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		processed = make(chan string, 10)
+
+		registry := taskq.NewRegistry()
+		task = registry.RegisterTask(&taskq.TaskOptions{
+			Name: "quota",
+			Handler: func(tenant string) {
+				<-release
+				processed <- tenant
+			},
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:         "test",
+			Storage:      taskq.NewLocalStorage(),
+			Handler:      registry,
+			MinNumWorker: 4,
+			MaxNumWorker: 4,
+			Quota: &taskq.QuotaOptions{
+				TenantHeader: "tenant",
+				Default: taskq.Quota{
+					MaxBacklog: 2,
+				},
+				Tenants: map[string]taskq.Quota{
+					"limited": {
+						Rate: redis_rate.PerMinute(1),
+					},
+				},
+			},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("limits the backlog of each tenant", func() {
+		var once sync.Once
+		defer once.Do(func() { close(release) })
+
+		Expect(q.Add(newMessage("a"))).NotTo(HaveOccurred())
+		Expect(q.Add(newMessage("a"))).NotTo(HaveOccurred())
+		Expect(q.Add(newMessage("b"))).NotTo(HaveOccurred())
+
+		err := q.Add(newMessage("a"))
+		Expect(errors.Is(err, taskq.ErrQuotaExceeded)).To(BeTrue())
+
+		var quotaErr *taskq.QuotaExceededError
+		Expect(errors.As(err, &quotaErr)).To(BeTrue())
+		Expect(quotaErr.Tenant).To(Equal("a"))
+		Expect(quotaErr.Limit).To(Equal(taskq.QuotaBacklog))
+
+		once.Do(func() { close(release) })
+		for i := 0; i < 3; i++ {
+			Eventually(processed).Should(Receive())
+		}
+
+		// Processed messages leave the backlog.
+		Eventually(func() error {
+			return q.Add(newMessage("a"))
+		}).ShouldNot(HaveOccurred())
+	})
+
+	It("limits the rate of a tenant", func() {
+		close(release)
+
+		Expect(q.Add(newMessage("limited"))).NotTo(HaveOccurred())
+
+		err := q.Add(newMessage("limited"))
+		var quotaErr *taskq.QuotaExceededError
+		Expect(errors.As(err, &quotaErr)).To(BeTrue())
+		Expect(quotaErr.Limit).To(Equal(taskq.QuotaRate))
+		Expect(quotaErr.RetryAfter).To(BeNumerically(">", 0))
+	})
+
+	It("sets Err of messages exceeding the quota in AddN", func() {
+		defer close(release)
+
+		msgs := []*taskq.Message{newMessage("a"), newMessage("a"), newMessage("a")}
+		err := q.AddN(ctx, msgs...)
+		Expect(errors.Is(err, taskq.ErrQuotaExceeded)).To(BeTrue())
+		Expect(msgs[0].Err).NotTo(HaveOccurred())
+		Expect(msgs[1].Err).NotTo(HaveOccurred())
+		Expect(errors.Is(msgs[2].Err, taskq.ErrQuotaExceeded)).To(BeTrue())
+	})
+})
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.buf = append(b.buf, p...)
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+var _ = Describe("RedactPayload", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var logs *syncBuffer
+
+	BeforeEach(func() {
+		logs = new(syncBuffer)
+		taskq.SetLogger(log.New(logs, "", 0))
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+		taskq.SetLogger(log.New(ioutil.Discard, "", 0))
+	})
+
+	It("logs failed messages using the redacted payload", func() {
+		registry := taskq.NewRegistry()
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "redact",
+			Handler: func(email string) error {
+				return errors.New("fake error")
+			},
+			RetryLimit: 1,
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+			RedactPayload: func(msg *taskq.Message) string {
+				return "email=<redacted>"
+			},
+		})
+
+		Expect(q.Add(task.WithArgs(ctx, "user@example.com"))).NotTo(HaveOccurred())
+
+		Eventually(logs.String).Should(ContainSubstring(
+			`task="redact" payload="email=<redacted>" handler failed after retry=1: fake error`))
+		Expect(logs.String()).NotTo(ContainSubstring("user@example.com"))
+	})
+})
+
+type fakeKafkaProducer struct {
+	mu     sync.Mutex
+	keys   []string
+	events []taskq.AuditEvent
+}
+
+func (p *fakeKafkaProducer) Produce(_ context.Context, topic string, key, value []byte) error {
+	var evt taskq.AuditEvent
+	if err := json.Unmarshal(value, &evt); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, string(key))
+	p.events = append(p.events, evt)
+	return nil
+}
+
+func (p *fakeKafkaProducer) types() []taskq.AuditEventType {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	types := make([]taskq.AuditEventType, len(p.events))
+	for i, evt := range p.events {
+		types[i] = evt.Type
+	}
+	return types
+}
+
+var _ = Describe("AuditSink", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var producer *fakeKafkaProducer
+	var registry *taskq.TaskMap
+
+	newQueue := func(sink taskq.AuditSink) {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:      "test",
+			Storage:   taskq.NewLocalStorage(),
+			Handler:   registry,
+			AuditSink: sink,
+		})
+	}
+
+	BeforeEach(func() {
+		producer = new(fakeKafkaProducer)
+		registry = taskq.NewRegistry()
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("writes the lifecycle of a retried message", func() {
+		var count int32
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "retried",
+			Handler: func() error {
+				if atomic.AddInt32(&count, 1) == 1 {
+					return errors.New("fake error")
+				}
+				return nil
+			},
+			RetryLimit: 2,
+			MinBackoff: time.Millisecond,
+		})
+		newQueue(taskq.NewKafkaAuditSink(producer, "audit"))
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+
+		Eventually(producer.types).Should(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+			taskq.AuditEventRetry,
+			taskq.AuditEventStart,
+			taskq.AuditEventDelete,
+		}))
+
+		producer.mu.Lock()
+		defer producer.mu.Unlock()
+		id := producer.events[0].AuditID
+		Expect(id).NotTo(BeEmpty())
+		for i, evt := range producer.events {
+			Expect(producer.keys[i]).To(Equal(id))
+			Expect(evt.AuditID).To(Equal(id))
+			Expect(evt.Queue).To(Equal("test"))
+			Expect(evt.Task).To(Equal("retried"))
+		}
+		Expect(producer.events[2].Error).To(Equal("fake error"))
+	})
 
-			It("processes all messages", func() {
-				p := q.Consumer()
+	It("writes dead-letter events", func() {
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "failed",
+			Handler: func() error {
+				return errors.New("fake error")
+			},
+			RetryLimit: 1,
+		})
+		newQueue(taskq.NewKafkaAuditSink(producer, "audit"))
 
-				err := p.ProcessAll(ctx)
-				Expect(err).NotTo(HaveOccurred())
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
 
-				n := atomic.LoadUint32(&processed)
-				Expect(n).To(Equal(uint32(3)))
-			})
+		Eventually(producer.types).Should(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+			taskq.AuditEventDeadLetter,
+		}))
+	})
 
-			It("processes one message", func() {
-				p := q.Consumer()
+	It("does not write events of rejected messages", func() {
+		unblock := make(chan struct{})
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "rejected",
+			Handler: func() {
+				<-unblock
+			},
+		})
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:       "test",
+			Storage:    taskq.NewLocalStorage(),
+			Handler:    registry,
+			AuditSink:  taskq.NewKafkaAuditSink(producer, "audit"),
+			MaxPending: 1,
+		})
 
-				err := p.ProcessOne(ctx)
-				Expect(err).NotTo(HaveOccurred())
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(producer.types).Should(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+		}))
 
-				n := atomic.LoadUint32(&processed)
-				Expect(n).To(Equal(uint32(1)))
+		Expect(q.Add(task.WithArgs(ctx))).To(Equal(taskq.ErrQueueFull))
+		close(unblock)
 
-				err = p.ProcessAll(ctx)
-				Expect(err).NotTo(HaveOccurred())
+		Eventually(producer.types).Should(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+			taskq.AuditEventDelete,
+		}))
+	})
 
-				n = atomic.LoadUint32(&processed)
-				Expect(n).To(Equal(uint32(3)))
-			})
+	It("does not write events of messages that are not added before the context is done", func() {
+		started := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		defer close(unblock)
+
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "canceled",
+			Handler: func() {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-unblock
+			},
+		})
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:        "test",
+			Storage:     taskq.NewLocalStorage(),
+			Handler:     registry,
+			AuditSink:   taskq.NewKafkaAuditSink(producer, "audit"),
+			WorkerLimit: 1,
+			BufferSize:  1,
+		})
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Eventually(started).Should(Receive())
+		// Fill the buffer.
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+
+		addCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		Expect(q.Add(task.WithArgs(addCtx))).To(Equal(context.DeadlineExceeded))
+		Consistently(producer.types, 100*time.Millisecond).Should(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+			taskq.AuditEventEnqueue,
+		}))
+	})
+
+	It("appends events to the file", func() {
+		dir, err := ioutil.TempDir("", "memqueue")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "audit.log")
+		sink, err := taskq.NewFileAuditSink(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer sink.Close()
+
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name:    "file",
+			Handler: func() {},
 		})
+		newQueue(sink)
+
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+		Expect(q.Close()).NotTo(HaveOccurred())
+
+		b, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		var types []taskq.AuditEventType
+		dec := json.NewDecoder(bytes.NewReader(b))
+		for dec.More() {
+			var evt taskq.AuditEvent
+			Expect(dec.Decode(&evt)).NotTo(HaveOccurred())
+			types = append(types, evt.Type)
+		}
+		Expect(types).To(Equal([]taskq.AuditEventType{
+			taskq.AuditEventEnqueue,
+			taskq.AuditEventStart,
+			taskq.AuditEventDelete,
+		}))
 	})
 })
 
-// slot splits time into equal periods (called slots) and returns
-// slot number for provided time.
-func slot(period time.Duration) int64 {
-	tm := time.Now()
-	periodSec := int64(period / time.Second)
-	if periodSec == 0 {
-		return tm.Unix()
+var _ = Describe("Authorizer", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var invoice, refund *taskq.Task
+	var processed int32
+
+	BeforeEach(func() {
+		processed = 0
+		registry := taskq.NewRegistry()
+		handler := func() {
+			atomic.AddInt32(&processed, 1)
+		}
+		invoice = registry.RegisterTask(&taskq.TaskOptions{
+			Name:    "invoice",
+			Handler: handler,
+		})
+		refund = registry.RegisterTask(&taskq.TaskOptions{
+			Name:    "refund",
+			Handler: handler,
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+			Authorizer: taskq.AllowTasks(map[string][]string{
+				"billing": {"invoice"},
+				"admin":   {"*"},
+			}),
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("allows tasks listed for the identity", func() {
+		billing := taskq.ContextWithIdentity(ctx, &taskq.Identity{Name: "billing"})
+		admin := taskq.ContextWithIdentity(ctx, &taskq.Identity{Name: "admin"})
+
+		Expect(q.Add(invoice.WithArgs(billing))).NotTo(HaveOccurred())
+		Expect(q.Add(refund.WithArgs(admin))).NotTo(HaveOccurred())
+		Eventually(func() int32 {
+			return atomic.LoadInt32(&processed)
+		}).Should(Equal(int32(2)))
+	})
+
+	It("rejects other tasks and producers without identity", func() {
+		billing := taskq.ContextWithIdentity(ctx, &taskq.Identity{Name: "billing"})
+
+		err := q.Add(refund.WithArgs(billing))
+		Expect(errors.Is(err, taskq.ErrForbidden)).To(BeTrue())
+		err = q.Add(invoice.WithArgs(ctx))
+		Expect(errors.Is(err, taskq.ErrForbidden)).To(BeTrue())
+
+		allowed := invoice.WithArgs(billing)
+		rejected := refund.WithArgs(billing)
+		err = q.AddN(ctx, allowed, rejected)
+		Expect(errors.Is(err, taskq.ErrForbidden)).To(BeTrue())
+		Expect(errors.Is(rejected.Err, taskq.ErrForbidden)).To(BeTrue())
+
+		Expect(q.Close()).NotTo(HaveOccurred())
+		Expect(allowed.Err).NotTo(HaveOccurred())
+		Expect(atomic.LoadInt32(&processed)).To(Equal(int32(1)))
+	})
+})
+
+var _ = Describe("Producer quota", func() {
+	ctx := context.Background()
+
+	type processedMsg struct {
+		producer string
+		at       time.Time
 	}
-	return tm.Unix() / periodSec
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var processed chan processedMsg
+
+	add := func(producer string) error {
+		ctx := taskq.ContextWithIdentity(ctx, &taskq.Identity{Name: producer})
+		return q.Add(task.WithArgs(ctx, producer))
+	}
+
+	BeforeEach(func() {
+		processed = make(chan processedMsg, 10)
+
+		registry := taskq.NewRegistry()
+		task = registry.RegisterTask(&taskq.TaskOptions{
+			Name: "producer-quota",
+			Handler: func(producer string) {
+				processed <- processedMsg{producer: producer, at: time.Now()}
+			},
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:    "test",
+			Storage: taskq.NewLocalStorage(),
+			Handler: registry,
+			Quota: &taskq.QuotaOptions{
+				TenantHeader: taskq.ProducerHeader,
+				Default: taskq.Quota{
+					Rate: redis_rate.Limit{Rate: 10, Burst: 1, Period: time.Second},
+				},
+				MaxDelay: 250 * time.Millisecond,
+			},
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("delays and then rejects messages of a runaway producer", func() {
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			Expect(add("runaway")).NotTo(HaveOccurred())
+		}
+
+		err := add("runaway")
+		var quotaErr *taskq.QuotaExceededError
+		Expect(errors.As(err, &quotaErr)).To(BeTrue())
+		Expect(quotaErr.Tenant).To(Equal("runaway"))
+		Expect(quotaErr.Limit).To(Equal(taskq.QuotaRate))
+		Expect(quotaErr.RetryAfter).To(BeNumerically(">", 250*time.Millisecond))
+
+		// Other producers have their own quota.
+		Expect(add("quiet")).NotTo(HaveOccurred())
+
+		var last time.Duration
+		for i := 0; i < 4; i++ {
+			var msg processedMsg
+			Eventually(processed).Should(Receive(&msg))
+			if msg.producer == "runaway" {
+				last = msg.at.Sub(start)
+			}
+		}
+		Expect(last).To(BeNumerically(">=", 180*time.Millisecond))
+	})
+})
+
+var _ = Describe("FairTenantHeader", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var task *taskq.Task
+	var gate chan struct{}
+	var processed chan string
+
+	add := func(tenant string) {
+		msg := task.WithArgs(ctx, tenant)
+		msg.Headers = map[string]string{"tenant": tenant}
+		Expect(q.Add(msg)).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		gate = make(chan struct{})
+		processed = make(chan string, 100)
+
+		registry := taskq.NewRegistry()
+		task = registry.RegisterTask(&taskq.TaskOptions{
+			Name: "fair",
+			Handler: func(tenant string) {
+				<-gate
+				processed <- tenant
+			},
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:             "test",
+			Storage:          taskq.NewLocalStorage(),
+			Handler:          registry,
+			MinNumWorker:     1,
+			MaxNumWorker:     1,
+			BufferSize:       1,
+			FairTenantHeader: "tenant",
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("round-robins messages across tenants", func() {
+		for i := 0; i < 20; i++ {
+			add("big")
+		}
+		add("small")
+		add("small")
+		close(gate)
+
+		var small []int
+		for i := 0; i < 22; i++ {
+			var tenant string
+			Eventually(processed).Should(Receive(&tenant))
+			if tenant == "small" {
+				small = append(small, i)
+			}
+		}
+		Expect(small).To(HaveLen(2))
+		// A few messages of the big tenant are already buffered
+		// by the consumer when the small tenant adds messages.
+		Expect(small[1]).To(BeNumerically("<", 10))
+	})
+})
+
+var _ = Describe("PayloadPolicy", func() {
+	ctx := context.Background()
+
+	type payload struct {
+		Email string
+	}
+
+	var q *memqueue.Queue
+	var registry *taskq.TaskMap
+	var policy *taskq.PayloadPolicy
+
+	newQueue := func() {
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:          "test",
+			Storage:       taskq.NewLocalStorage(),
+			Handler:       registry,
+			PayloadPolicy: policy,
+		})
+	}
+
+	BeforeEach(func() {
+		registry = taskq.NewRegistry()
+		policy = &taskq.PayloadPolicy{
+			MaxArgsSize:    64,
+			ForbiddenTypes: []reflect.Type{reflect.TypeOf(map[string]interface{}{})},
+			Check: func(msg *taskq.Message, args []interface{}) error {
+				for _, arg := range args {
+					if s, ok := arg.(string); ok && s == "" {
+						return errors.New("empty string")
+					}
+				}
+				return nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("rejects messages in Add", func() {
+		var processed int32
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "policy",
+			Handler: func(arg interface{}) {
+				atomic.AddInt32(&processed, 1)
+			},
+		})
+		newQueue()
+
+		for _, arg := range []interface{}{
+			strings.Repeat("x", 100),
+			map[string]interface{}{"email": "user@example.com"},
+			"",
+		} {
+			err := q.Add(task.WithArgs(ctx, arg))
+			Expect(errors.Is(err, taskq.ErrPolicyViolation)).To(BeTrue())
+		}
+
+		Expect(q.Add(task.WithArgs(ctx, "hello"))).NotTo(HaveOccurred())
+		Expect(q.Close()).NotTo(HaveOccurred())
+		Expect(atomic.LoadInt32(&processed)).To(Equal(int32(1)))
+	})
+
+	It("checks decoded args in the consumer without retrying", func() {
+		var processed int32
+		fallback := make(chan error, 1)
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "policy",
+			Handler: func(arg interface{}) {
+				atomic.AddInt32(&processed, 1)
+			},
+			FallbackHandler: func(msg *taskq.Message) {
+				fallback <- msg.Err
+			},
+			RetryLimit: 3,
+		})
+		newQueue()
+
+		// The struct is allowed in Add, but is decoded as a map,
+		// e.g. when it was encoded by another producer.
+		b, err := task.WithArgs(ctx, payload{Email: "user@example.com"}).MarshalArgs()
+		Expect(err).NotTo(HaveOccurred())
+		msg := &taskq.Message{
+			Ctx:      ctx,
+			TaskName: "policy",
+			ArgsBin:  b,
+		}
+		Expect(q.Add(msg)).NotTo(HaveOccurred())
+
+		Eventually(fallback).Should(Receive(&err))
+		Expect(errors.Is(err, taskq.ErrPolicyViolation)).To(BeTrue())
+
+		Expect(q.Close()).NotTo(HaveOccurred())
+		Expect(atomic.LoadInt32(&processed)).To(Equal(int32(0)))
+		Expect(q.Consumer().Stats().Retries).To(Equal(uint64(0)))
+	})
+})
+
+type producerKey struct{}
+
+type consumerKey struct{}
+
+// headerPropagator passes the producer value in the "trace" header
+// and extracts it under another key, so tests can tell it apart.
+type headerPropagator struct{}
+
+func (headerPropagator) Inject(ctx context.Context, headers map[string]string) {
+	if s, ok := ctx.Value(producerKey{}).(string); ok {
+		headers["trace"] = s
+	}
+}
+
+func (headerPropagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, consumerKey{}, headers["trace"])
+}
+
+var _ = Describe("Propagator", func() {
+	var q *memqueue.Queue
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("propagates the producer context in headers", func() {
+		got := make(chan string, 1)
+		registry := taskq.NewRegistry()
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "propagate",
+			Handler: func(ctx context.Context) {
+				got <- ctx.Value(consumerKey{}).(string)
+			},
+		})
+
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:       "test",
+			Storage:    taskq.NewLocalStorage(),
+			Handler:    registry,
+			Propagator: headerPropagator{},
+		})
+
+		ctx := context.WithValue(context.Background(), producerKey{}, "span-1")
+		Expect(q.Add(task.WithArgs(ctx))).NotTo(HaveOccurred())
+
+		Eventually(got).Should(Receive(Equal("span-1")))
+	})
+})
+
+type errorReports struct {
+	mu      sync.Mutex
+	reports []taskq.ErrorReport
 }
+
+func (r *errorReports) ReportError(_ context.Context, report *taskq.ErrorReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, *report)
+}
+
+func (r *errorReports) Reports() []taskq.ErrorReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]taskq.ErrorReport(nil), r.reports...)
+}
+
+var _ = Describe("ErrorReporter", func() {
+	ctx := context.Background()
+
+	var q *memqueue.Queue
+	var registry *taskq.TaskMap
+	var reporter *errorReports
+
+	BeforeEach(func() {
+		registry = taskq.NewRegistry()
+		reporter = new(errorReports)
+		q = memqueue.NewQueue(&taskq.QueueOptions{
+			Name:          "test",
+			Storage:       taskq.NewLocalStorage(),
+			Handler:       registry,
+			ErrorReporter: reporter,
+		})
+	})
+
+	AfterEach(func() {
+		_ = q.Close()
+	})
+
+	It("reports handler errors", func() {
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "report",
+			Handler: func(email string) error {
+				return errors.New("fake error")
+			},
+			RetryLimit: 2,
+			MinBackoff: time.Millisecond,
+		})
+
+		Expect(q.Add(task.WithArgs(ctx, "user@example.com"))).NotTo(HaveOccurred())
+
+		Eventually(func() int {
+			return len(reporter.Reports())
+		}).Should(Equal(2))
+
+		reports := reporter.Reports()
+		for i, report := range reports {
+			Expect(report.Queue).To(Equal("test"))
+			Expect(report.Task).To(Equal("report"))
+			Expect(report.Attempt).To(Equal(i + 1))
+			Expect(report.Err).To(MatchError("fake error"))
+			Expect(report.ArgsFingerprint).To(HaveLen(16))
+			Expect(report.ArgsFingerprint).To(Equal(reports[0].ArgsFingerprint))
+			Expect(report.Tags()).NotTo(ContainElement(ContainSubstring("user@example.com")))
+		}
+		Expect(reports[0].Final).To(BeFalse())
+		Expect(reports[1].Final).To(BeTrue())
+	})
+
+	It("reports and re-raises panics", func() {
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "panic",
+			Handler: func() {
+				panic("fake panic")
+			},
+		})
+
+		Expect(func() {
+			_ = q.Consumer().Process(task.WithArgs(ctx))
+		}).To(PanicWith("fake panic"))
+
+		reports := reporter.Reports()
+		Expect(reports).To(HaveLen(1))
+		var panicErr *taskq.PanicError
+		Expect(errors.As(reports[0].Err, &panicErr)).To(BeTrue())
+		Expect(panicErr.Value).To(Equal("fake panic"))
+		Expect(panicErr.Stack).NotTo(BeEmpty())
+	})
+})
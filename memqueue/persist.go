@@ -0,0 +1,135 @@
+package memqueue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+	"github.com/frain-dev/taskq/v3/internal/msgutil"
+)
+
+type persistedMessage struct {
+	Name        string
+	DelayUntil  time.Time
+	Priority    int
+	OrderingKey string
+	Body        []byte
+}
+
+// persister collects delayed and buffered messages that are purged
+// when the queue is closed.
+type persister struct {
+	mu         sync.Mutex
+	collecting bool
+	msgs       []*taskq.Message
+}
+
+func (p *persister) Start() {
+	p.mu.Lock()
+	p.collecting = true
+	p.mu.Unlock()
+}
+
+func (p *persister) Add(msg *taskq.Message) {
+	p.mu.Lock()
+	if p.collecting {
+		p.msgs = append(p.msgs, msg)
+	}
+	p.mu.Unlock()
+}
+
+func (p *persister) Messages() []*taskq.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.msgs
+}
+
+// saveMessages writes unprocessed messages to the PersistFile.
+func (q *Queue) saveMessages(msgs []*taskq.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	pms := make([]persistedMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			internal.Logger.Printf("memqueue: can't persist %s: %s", msgutil.LogString(q, msg), err)
+			continue
+		}
+		pm := persistedMessage{
+			Name:        msg.Name,
+			Priority:    msg.Priority,
+			OrderingKey: msg.OrderingKey,
+			Body:        b,
+		}
+		if msg.Delay > 0 {
+			pm.DelayUntil = now.Add(msg.Delay)
+		}
+		pms = append(pms, pm)
+	}
+
+	b, err := msgpack.Marshal(pms)
+	if err != nil {
+		return err
+	}
+
+	tmp := q.opt.PersistFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.opt.PersistFile)
+}
+
+// loadMessages adds messages saved by the previous process
+// and removes the PersistFile.
+func (q *Queue) loadMessages() error {
+	b, err := ioutil.ReadFile(q.opt.PersistFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var pms []persistedMessage
+	if err := msgpack.Unmarshal(b, &pms); err != nil {
+		return err
+	}
+
+	for i := range pms {
+		pm := &pms[i]
+
+		msg := new(taskq.Message)
+		if err := msg.UnmarshalBinary(pm.Body); err != nil {
+			internal.Logger.Printf("memqueue: can't restore message: %s", err)
+			continue
+		}
+		msg.Ctx = context.Background()
+		msg.Name = pm.Name
+		msg.Priority = pm.Priority
+		msg.OrderingKey = pm.OrderingKey
+		if !pm.DelayUntil.IsZero() {
+			if d := time.Until(pm.DelayUntil); d > 0 {
+				msg.Delay = d
+			}
+		}
+		// Don't count the previous reservation twice.
+		if msg.ReservedCount > 0 {
+			msg.ReservedCount--
+		}
+
+		if err := q.Add(msg); err != nil {
+			internal.Logger.Printf("memqueue: can't restore %s: %s", msgutil.LogString(q, msg), err)
+		}
+	}
+
+	return os.Remove(q.opt.PersistFile)
+}
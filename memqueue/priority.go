@@ -0,0 +1,124 @@
+package memqueue
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// backlog holds added messages until there is room in the consumer buffer.
+type backlog interface {
+	Push(msg *taskq.Message)
+	// Pop waits for the next message. It returns nil when the backlog is closed.
+	Pop() *taskq.Message
+	Len() int
+	Close()
+	// Purge removes and returns all messages.
+	Purge() []*taskq.Message
+}
+
+var (
+	_ backlog = (*priorityQueue)(nil)
+	_ backlog = (*fairQueue)(nil)
+)
+
+type priorityItem struct {
+	msg *taskq.Message
+	seq uint64
+}
+
+type priorityHeap []priorityItem
+
+var _ heap.Interface = (*priorityHeap)(nil)
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].msg.Priority != h[j].msg.Priority {
+		return h[i].msg.Priority > h[j].msg.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *priorityHeap) Push(x interface{}) {
+	*h = append(*h, x.(priorityItem))
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = priorityItem{}
+	*h = old[:n-1]
+	return item
+}
+
+// priorityQueue orders messages by priority and then by insertion order.
+type priorityQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	h      priorityHeap
+	seq    uint64
+	closed bool
+}
+
+func newPriorityQueue() *priorityQueue {
+	pq := new(priorityQueue)
+	pq.cond = sync.NewCond(&pq.mu)
+	return pq
+}
+
+func (pq *priorityQueue) Push(msg *taskq.Message) {
+	pq.mu.Lock()
+	pq.seq++
+	heap.Push(&pq.h, priorityItem{
+		msg: msg,
+		seq: pq.seq,
+	})
+	pq.mu.Unlock()
+	pq.cond.Signal()
+}
+
+// Pop waits for a message with the highest priority.
+// It returns nil when the queue is closed.
+func (pq *priorityQueue) Pop() *taskq.Message {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	for len(pq.h) == 0 && !pq.closed {
+		pq.cond.Wait()
+	}
+	if pq.closed {
+		return nil
+	}
+	return heap.Pop(&pq.h).(priorityItem).msg
+}
+
+func (pq *priorityQueue) Len() int {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	return len(pq.h)
+}
+
+func (pq *priorityQueue) Close() {
+	pq.mu.Lock()
+	pq.closed = true
+	pq.mu.Unlock()
+	pq.cond.Broadcast()
+}
+
+// Purge removes and returns all messages.
+func (pq *priorityQueue) Purge() []*taskq.Message {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	msgs := make([]*taskq.Message, len(pq.h))
+	for i, item := range pq.h {
+		msgs[i] = item.msg
+	}
+	pq.h = nil
+	return msgs
+}
@@ -13,64 +13,18 @@ import (
 	"github.com/frain-dev/taskq/v3/internal/msgutil"
 )
 
-type scheduler struct {
-	timerLock sync.Mutex
-	timerMap  map[*taskq.Message]*time.Timer
-}
-
-func (q *scheduler) Schedule(msg *taskq.Message, fn func()) {
-	q.timerLock.Lock()
-	defer q.timerLock.Unlock()
-
-	timer := time.AfterFunc(msg.Delay, func() {
-		// Remove our entry from the map
-		q.timerLock.Lock()
-		delete(q.timerMap, msg)
-		q.timerLock.Unlock()
-
-		fn()
-	})
-
-	if q.timerMap == nil {
-		q.timerMap = make(map[*taskq.Message]*time.Timer)
-	}
-	q.timerMap[msg] = timer
-}
-
-func (q *scheduler) Remove(msg *taskq.Message) {
-	q.timerLock.Lock()
-	defer q.timerLock.Unlock()
-
-	timer, ok := q.timerMap[msg]
-	if ok {
-		timer.Stop()
-		delete(q.timerMap, msg)
-	}
-}
-
-func (q *scheduler) Purge() int {
-	q.timerLock.Lock()
-	defer q.timerLock.Unlock()
-
-	// Stop all delayed items
-	for _, timer := range q.timerMap {
-		timer.Stop()
-	}
-
-	n := len(q.timerMap)
-	q.timerMap = nil
-
-	return n
-}
-
-//------------------------------------------------------------------------------
-
 const (
 	stateRunning = 0
 	stateClosing = 1
 	stateClosed  = 2
 )
 
+// advancer is implemented by mock clocks that sync mode advances
+// instead of waiting for delays.
+type advancer interface {
+	Advance(d time.Duration)
+}
+
 type Queue struct {
 	opt *taskq.QueueOptions
 
@@ -82,23 +36,67 @@ type Queue struct {
 
 	scheduler scheduler
 
+	// Backlog ordered by priority when PriorityQueue is set
+	// or by tenant when FairTenantHeader is set.
+	backlog backlog
+
+	// Messages purged on Close when PersistFile is set.
+	persisted persister
+
+	// Slots for pending messages when MaxPending is set.
+	slots chan struct{}
+
 	_state int32
 }
 
 var _ taskq.Queue = (*Queue)(nil)
 
+// New is like NewQueue, but creates the options with taskq.NewQueueOptions.
+// The default storage is taskq.NewLocalStorage.
+//
+//	q, err := memqueue.New("api-worker", taskq.WithWorkers(8))
+func New(name string, opts ...taskq.QueueOption) (*Queue, error) {
+	opts = append([]taskq.QueueOption{
+		taskq.WithStorage(taskq.NewLocalStorage()),
+	}, opts...)
+	opt, err := taskq.NewQueueOptions(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewQueue(opt), nil
+}
+
 func NewQueue(opt *taskq.QueueOptions) *Queue {
 	opt.Init()
 
 	q := &Queue{
 		opt: opt,
 	}
+	q.scheduler.clock = opt.Clock
+	if opt.MaxPending > 0 {
+		q.slots = make(chan struct{}, opt.MaxPending)
+	}
 
 	q.consumer = taskq.NewConsumer(q)
 	if err := q.consumer.Start(context.Background()); err != nil {
 		panic(err)
 	}
 
+	switch {
+	case opt.PriorityQueue:
+		q.backlog = newPriorityQueue()
+		go q.dispatch()
+	case opt.FairTenantHeader != "":
+		q.backlog = newFairQueue(opt.FairTenantHeader)
+		go q.dispatch()
+	}
+
+	if opt.PersistFile != "" {
+		if err := q.loadMessages(); err != nil {
+			internal.Logger.Printf("memqueue: can't restore messages: %s", err)
+		}
+	}
+
 	return q
 }
 
@@ -118,6 +116,10 @@ func (q *Queue) Consumer() taskq.QueueConsumer {
 	return q.consumer
 }
 
+// SetSync enables sync mode where Add processes the message in the calling
+// goroutine including retries. Delays are not waited for, but advance
+// QueueOptions.Clock when it is a mock clock (see taskqtest.Clock),
+// so tests are deterministic.
 func (q *Queue) SetSync(sync bool) {
 	q.sync = sync
 }
@@ -134,17 +136,33 @@ func (q *Queue) Close() error {
 // CloseTimeout closes the queue waiting for pending messages to be processed.
 func (q *Queue) CloseTimeout(timeout time.Duration) error {
 	if !atomic.CompareAndSwapInt32(&q._state, stateRunning, stateClosing) {
-		return fmt.Errorf("taskq: %s is already closed", q)
+		return fmt.Errorf("%w: %s", taskq.ErrQueueClosed, q)
+	}
+
+	// Unprocessed messages are saved so don't wait for delayed messages.
+	var err error
+	if q.opt.PersistFile == "" {
+		err = q.WaitTimeout(timeout)
 	}
-	err := q.WaitTimeout(timeout)
 
+	if q.opt.PersistFile != "" {
+		q.persisted.Start()
+	}
 	if !atomic.CompareAndSwapInt32(&q._state, stateClosing, stateClosed) {
 		panic("not reached")
 	}
 
-	_ = q.consumer.StopTimeout(timeout)
-	_ = q.Purge()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	_ = q.consumer.Stop(ctx)
+	cancel()
+	if q.backlog != nil {
+		q.backlog.Close()
+	}
 
+	_ = q.Purge()
+	if q.opt.PersistFile != "" {
+		return q.saveMessages(q.persisted.Messages())
+	}
 	return err
 }
 
@@ -164,64 +182,277 @@ func (q *Queue) WaitTimeout(timeout time.Duration) error {
 	return nil
 }
 
+// Len returns the number of messages waiting to be processed
+// excluding delayed messages.
 func (q *Queue) Len() (int, error) {
-	return q.consumer.Len(), nil
+	n := q.consumer.Len()
+	if q.backlog != nil {
+		n += q.backlog.Len()
+	}
+	return n, nil
+}
+
+// DelayedLen returns the number of delayed messages.
+func (q *Queue) DelayedLen() int {
+	return q.scheduler.Len()
+}
+
+// Snapshot describes messages in the queue at a point in time.
+type Snapshot struct {
+	// Messages waiting to be processed.
+	Buffered int
+	// Messages scheduled to be processed later.
+	Delayed int
+	// Messages being processed.
+	InFlight int
+
+	// Totals since the queue was created.
+	Processed int
+	Retries   int
+	Fails     int
+}
+
+// Snapshot returns the current state of the queue.
+func (q *Queue) Snapshot() *Snapshot {
+	n, _ := q.Len()
+	st := q.consumer.Stats()
+	return &Snapshot{
+		Buffered: n,
+		Delayed:  q.DelayedLen(),
+		InFlight: int(st.InFlight),
+
+		Processed: int(st.Processed),
+		Retries:   int(st.Retries),
+		Fails:     int(st.Fails),
+	}
 }
 
 // Add adds message to the queue.
 func (q *Queue) Add(msg *taskq.Message) error {
 	if q.closed() {
-		return fmt.Errorf("taskq: %s is closed", q)
+		return fmt.Errorf("%w: %s", taskq.ErrQueueClosed, q)
 	}
 	if msg.TaskName == "" {
 		return internal.ErrTaskNameRequired
 	}
+	if err := msgutil.BeforeAdd(q, msg); err != nil {
+		return err
+	}
 	if q.isDuplicate(msg) {
 		msg.Err = taskq.ErrDuplicate
 		return nil
 	}
+	acquired, err := msgutil.AcquireQuota(q, msg)
+	if err != nil {
+		return err
+	}
+	audit := msgutil.SetAuditID(q, msg)
+	if err := q.acquireSlot(msg.Ctx); err != nil {
+		if acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		return err
+	}
+	// The message can be processed as soon as it is handed off
+	// to the consumer, so it is recorded first. The sink is append-only,
+	// so the event is written only once the message can't be rejected.
+	var added func()
+	if audit {
+		msgutil.AuditRecord(q, msg)
+		added = func() {
+			msgutil.AuditEnqueueEvent(q, msg)
+		}
+	}
 	q.wg.Add(1)
-	return q.enqueueMessage(msg)
+	err = q.enqueueMessage(msg, added)
+	// In the sync mode the error is returned by the handler.
+	if err != nil && !q.sync {
+		if acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		if audit {
+			msgutil.AuditAborted(q, msg)
+		}
+	}
+	return err
 }
 
-func (q *Queue) enqueueMessage(msg *taskq.Message) error {
-	if (q.noDelay || q.sync) && msg.Delay > 0 {
-		msg.Delay = 0
+// AddN adds messages to the queue. Messages that are not added have Err set.
+// It returns the first error.
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	var firstErr error
+	for _, msg := range msgs {
+		if err := q.Add(msg); err != nil {
+			msg.Err = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Handle refers to a message added with AddCancelable.
+type Handle struct {
+	q   *Queue
+	msg *taskq.Message
+}
+
+// Cancel cancels the delayed message. It returns false if the message
+// is already being processed or was processed.
+func (h *Handle) Cancel() bool {
+	if !h.q.scheduler.Cancel(h.msg) {
+		return false
+	}
+	h.q.releaseSlot()
+	h.q.wg.Done()
+	return true
+}
+
+// AddCancelable is like Add, but returns a handle that can be used to cancel
+// the message before its delay elapses, e.g. to debounce notifications.
+func (q *Queue) AddCancelable(msg *taskq.Message) (*Handle, error) {
+	if err := q.Add(msg); err != nil {
+		return nil, err
+	}
+	return &Handle{
+		q:   q,
+		msg: msg,
+	}, nil
+}
+
+// acquireSlot reserves room for a pending message applying the overflow policy.
+func (q *Queue) acquireSlot(ctx context.Context) error {
+	if q.slots == nil {
+		return nil
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if q.opt.OverflowPolicy != taskq.OverflowBlock {
+		return taskq.ErrQueueFull
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timer := time.NewTimer(q.opt.OverflowTimeout)
+	defer timer.Stop()
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return taskq.ErrQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) releaseSlot() {
+	if q.slots != nil {
+		<-q.slots
+	}
+}
+
+// enqueueMessage hands the message off to the consumer. added, if not nil,
+// is called once the message is accepted and before it can be processed.
+func (q *Queue) enqueueMessage(msg *taskq.Message, added func()) error {
+	if added == nil {
+		added = func() {}
 	}
-	msg.ReservedCount++
 
 	if q.sync {
+		added()
+		if msg.Delay > 0 {
+			if clock, ok := q.opt.Clock.(advancer); ok {
+				clock.Advance(msg.Delay)
+			}
+			msg.Delay = 0
+		}
+		msg.ReservedCount++
 		return q.consumer.Process(msg)
 	}
 
+	if q.noDelay && msg.Delay > 0 {
+		msg.Delay = 0
+	}
+
 	if msg.Delay > 0 {
+		added()
+		msg.ReservedCount++
 		q.scheduler.Schedule(msg, func() {
 			// If the queue closed while we were waiting, just return
 			if q.closed() {
+				msg.Delay = 0
+				q.persisted.Add(msg)
+				q.releaseSlot()
 				q.wg.Done()
 				return
 			}
 			msg.Delay = 0
-			_ = q.consumer.Add(msg)
+			_ = q.add(msg)
 		})
 		return nil
 	}
+
+	// Unlike delayed messages, wait for room in the consumer buffer
+	// only until msg.Ctx is done.
+	if q.backlog == nil {
+		err := q.consumer.AddContextFunc(msg.Ctx, msg, func() {
+			added()
+			msg.ReservedCount++
+		})
+		if err != nil {
+			q.releaseSlot()
+			q.wg.Done()
+			return err
+		}
+		return nil
+	}
+	added()
+	msg.ReservedCount++
+	return q.add(msg)
+}
+
+func (q *Queue) add(msg *taskq.Message) error {
+	if q.backlog != nil {
+		q.backlog.Push(msg)
+		return nil
+	}
 	return q.consumer.Add(msg)
 }
 
+// dispatch moves messages from the backlog to the consumer
+// as soon as there is room in the consumer buffer.
+func (q *Queue) dispatch() {
+	for {
+		msg := q.backlog.Pop()
+		if msg == nil {
+			return
+		}
+		_ = q.consumer.Add(msg)
+	}
+}
+
 func (q *Queue) ReserveN(_ context.Context, _ int, _ time.Duration) ([]taskq.Message, error) {
-	return nil, internal.ErrNotSupported
+	return nil, taskq.ErrNotSupported
 }
 
 func (q *Queue) Release(msg *taskq.Message) error {
 	// Shallow copy.
 	clone := *msg
 	clone.Err = nil
-	return q.enqueueMessage(&clone)
+	return q.enqueueMessage(&clone, nil)
 }
 
 func (q *Queue) Delete(msg *taskq.Message) error {
 	q.scheduler.Remove(msg)
+	q.releaseSlot()
 	q.wg.Done()
 	return nil
 }
@@ -239,11 +470,28 @@ func (q *Queue) DeleteBatch(msgs []*taskq.Message) error {
 }
 
 func (q *Queue) Purge() error {
-	// Purge any messages already in the consumer
-	err := q.consumer.Purge()
+	if q.backlog != nil {
+		for _, msg := range q.backlog.Purge() {
+			q.persisted.Add(msg)
+			_ = q.Delete(msg)
+		}
+	}
+
+	// Purge any messages already in the consumer. They are persisted
+	// when the consumer was not started or did not stop in time.
+	var err error
+	if q.opt.PersistFile != "" {
+		for _, msg := range q.consumer.TakeBuffered() {
+			q.persisted.Add(msg)
+			_ = q.Delete(msg)
+		}
+	} else {
+		err = q.consumer.Purge()
+	}
 
-	numPurged := q.scheduler.Purge()
-	for i := 0; i < numPurged; i++ {
+	for _, msg := range q.scheduler.Purge() {
+		q.persisted.Add(msg)
+		q.releaseSlot()
 		q.wg.Done()
 	}
 
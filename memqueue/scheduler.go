@@ -0,0 +1,245 @@
+package memqueue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// The timing wheel has wheelLevels levels of wheelSize slots. A slot on
+// level N covers wheelSize^N ticks, so 6 levels of 64 slots with 1ms ticks
+// cover ~2 years. Longer delays are cascaded down until they are due.
+const (
+	wheelTick   = time.Millisecond
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 6
+	wheelSpan   = int64(1) << (wheelBits * wheelLevels)
+)
+
+type timerEntry struct {
+	msg *taskq.Message
+	fn  func()
+	due time.Time
+
+	expires int64 // tick when the entry is due
+	level   int
+	slot    int
+	pos     int // index in the slot
+}
+
+// scheduler is a hierarchical timing wheel that runs callbacks of delayed
+// messages. It uses a single ticker that is only running while there are
+// scheduled messages instead of a runtime timer per message.
+type scheduler struct {
+	clock taskq.Clock
+
+	mu      sync.Mutex
+	start   time.Time
+	now     int64 // last processed tick
+	slots   [wheelLevels][wheelSize][]*timerEntry
+	entries map[*taskq.Message]*timerEntry
+	stop    chan struct{}
+}
+
+func (q *scheduler) Schedule(msg *taskq.Message, fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.clock.Now()
+	if q.entries == nil {
+		q.entries = make(map[*taskq.Message]*timerEntry)
+	}
+	if q.stop == nil {
+		// The wheel is empty so it is safe to skip the idle ticks.
+		if q.start.IsZero() {
+			q.start = now
+		}
+		q.now = int64(now.Sub(q.start) / wheelTick)
+		q.stop = make(chan struct{})
+		go q.run(q.stop)
+	}
+
+	due := now.Add(msg.Delay)
+	e := &timerEntry{
+		msg: msg,
+		fn:  fn,
+		due: due,
+		// Round up so messages are never processed before the delay.
+		expires: int64((due.Sub(q.start) + wheelTick - 1) / wheelTick),
+	}
+	if e.expires <= q.now {
+		e.expires = q.now + 1
+	}
+	q.entries[msg] = e
+	q.insert(e)
+}
+
+func (q *scheduler) insert(e *timerEntry) {
+	diff := e.expires - q.now
+	if diff >= wheelSpan {
+		diff = wheelSpan - 1
+	}
+
+	var level int
+	for level = 0; level < wheelLevels-1; level++ {
+		if diff < int64(1)<<(wheelBits*(level+1)) {
+			break
+		}
+	}
+	expires := q.now + diff
+
+	e.level = level
+	e.slot = int(expires>>(wheelBits*level)) & wheelMask
+	slot := &q.slots[e.level][e.slot]
+	e.pos = len(*slot)
+	*slot = append(*slot, e)
+}
+
+func (q *scheduler) unlink(e *timerEntry) {
+	slot := &q.slots[e.level][e.slot]
+	last := len(*slot) - 1
+	if e.pos != last {
+		(*slot)[e.pos] = (*slot)[last]
+		(*slot)[e.pos].pos = e.pos
+	}
+	(*slot)[last] = nil
+	*slot = (*slot)[:last]
+}
+
+func (q *scheduler) run(stop chan struct{}) {
+	ticker := q.clock.NewTicker(wheelTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case tm := <-ticker.C():
+			if !q.advance(tm, stop) {
+				return
+			}
+		}
+	}
+}
+
+// advance processes ticks up to tm and reports whether the wheel
+// is still running.
+func (q *scheduler) advance(tm time.Time, stop chan struct{}) bool {
+	q.mu.Lock()
+
+	if q.stop != stop {
+		q.mu.Unlock()
+		return false
+	}
+
+	var due []*timerEntry
+	target := int64(tm.Sub(q.start) / wheelTick)
+	for q.now < target && len(q.entries) > 0 {
+		q.now++
+		due = q.tick(due)
+	}
+
+	running := len(q.entries) > 0
+	if !running {
+		close(q.stop)
+		q.stop = nil
+	}
+
+	q.mu.Unlock()
+
+	for _, e := range due {
+		go e.fn()
+	}
+	return running
+}
+
+func (q *scheduler) tick(due []*timerEntry) []*timerEntry {
+	idx := int(q.now) & wheelMask
+	if idx == 0 {
+		due = q.cascade(1, due)
+	}
+
+	slot := q.slots[0][idx]
+	q.slots[0][idx] = slot[:0]
+	for i, e := range slot {
+		slot[i] = nil
+		delete(q.entries, e.msg)
+		due = append(due, e)
+	}
+	return due
+}
+
+// cascade moves entries of the current slot on the level to lower levels.
+func (q *scheduler) cascade(level int, due []*timerEntry) []*timerEntry {
+	if level >= wheelLevels {
+		return due
+	}
+
+	idx := int(q.now>>(wheelBits*level)) & wheelMask
+	if idx == 0 {
+		due = q.cascade(level+1, due)
+	}
+
+	slot := q.slots[level][idx]
+	q.slots[level][idx] = nil
+	for _, e := range slot {
+		if e.expires <= q.now {
+			delete(q.entries, e.msg)
+			due = append(due, e)
+			continue
+		}
+		q.insert(e)
+	}
+	return due
+}
+
+// Remove removes the message without running its callback.
+func (q *scheduler) Remove(msg *taskq.Message) {
+	_ = q.Cancel(msg)
+}
+
+// Cancel removes the message before it is due and reports whether
+// the message was removed.
+func (q *scheduler) Cancel(msg *taskq.Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[msg]
+	if !ok {
+		return false
+	}
+	delete(q.entries, msg)
+	q.unlink(e)
+	return true
+}
+
+func (q *scheduler) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Purge removes all scheduled messages and returns them
+// with Delay set to the remaining time.
+func (q *scheduler) Purge() []*taskq.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs := make([]*taskq.Message, 0, len(q.entries))
+	for msg, e := range q.entries {
+		msg.Delay = e.due.Sub(q.clock.Now())
+		msgs = append(msgs, msg)
+	}
+
+	q.entries = nil
+	q.slots = [wheelLevels][wheelSize][]*timerEntry{}
+	if q.stop != nil {
+		close(q.stop)
+		q.stop = nil
+	}
+
+	return msgs
+}
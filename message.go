@@ -1,11 +1,14 @@
 package taskq
 
 import (
+	"bytes"
 	"context"
 	"encoding"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/klauspost/compress/s2"
@@ -33,6 +36,15 @@ type Message struct {
 	// before executing the message.
 	Delay time.Duration `msgpack:"-"`
 
+	// Messages with higher priority are processed first by queues
+	// with PriorityQueue option. Default is 0.
+	Priority int `msgpack:"-"`
+
+	// Optional ordering key. Messages with the same key are processed
+	// in order by backends that support it, e.g. SQS FIFO queues,
+	// and on the same node with QueueOptions.StickyOrderingKeys.
+	OrderingKey string `msgpack:"7,omitempty,alias:OrderingKey"`
+
 	// Args passed to the handler.
 	Args []interface{} `msgpack:"-"`
 
@@ -49,8 +61,16 @@ type Message struct {
 	TaskName string `msgpack:"5,alias:TaskName"`
 	Err      error  `msgpack:"-"`
 
+	// Optional metadata, e.g. trace context or tenant id, that is
+	// passed along with the message but not to the handler args.
+	Headers map[string]string `msgpack:"6,omitempty,alias:Headers"`
+
 	evt                *ProcessMessageEvent
+	toucher            Toucher
+	retry              *retryOptions  // set by the consumer
+	policy             *PayloadPolicy // set by the consumer
 	marshalBinaryCache []byte
+	bufs               *messageBuffers // set for pooled messages
 }
 
 func NewMessage(ctx context.Context, args ...interface{}) *Message {
@@ -60,9 +80,132 @@ func NewMessage(ctx context.Context, args ...interface{}) *Message {
 	}
 }
 
-func (m *Message) String() string {
-	return fmt.Sprintf("Message<ID=%q Name=%q ReservedCount=%d>",
-		m.ID, m.Name, m.ReservedCount)
+// Pooled buffers that grew larger are left for GC.
+const maxPooledBufferSize = 64 << 10
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return new(Message)
+	},
+}
+
+// AcquireMessage is like NewMessage, but reuses a message returned to the pool
+// with ReleaseMessage together with its encode buffers, which cuts allocations
+// of producers that add many messages.
+func AcquireMessage(ctx context.Context, args ...interface{}) *Message {
+	msg := messagePool.Get().(*Message)
+	if msg.bufs == nil {
+		msg.bufs = newMessageBuffers()
+	}
+	msg.Ctx = ctx
+	msg.Args = args
+	return msg
+}
+
+// ReleaseMessage returns the message acquired with AcquireMessage to the pool.
+// The message and the slices returned by MarshalArgs and MarshalBinary must
+// not be used after that. Only release messages the queue is done with:
+// redisq is done when Add returns, but memqueue and azsqs keep messages
+// until they are processed. Messages reserved by consumers are not pooled.
+func ReleaseMessage(msg *Message) {
+	bufs := msg.bufs
+	*msg = Message{}
+	if bufs == nil {
+		return
+	}
+	if bufs.args.Cap()+bufs.msg.Cap() <= maxPooledBufferSize {
+		msg.bufs = bufs
+	}
+	messagePool.Put(msg)
+}
+
+// messageBuffers are encode buffers that are reused with pooled messages.
+type messageBuffers struct {
+	args bytes.Buffer
+	msg  bytes.Buffer
+	enc  *msgpack.Encoder
+}
+
+func newMessageBuffers() *messageBuffers {
+	return &messageBuffers{
+		enc: msgpack.NewEncoder(nil),
+	}
+}
+
+func (b *messageBuffers) encode(buf *bytes.Buffer, v interface{}) ([]byte, error) {
+	buf.Reset()
+	b.enc.Reset(buf)
+	if err := b.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// String describes the message without args, headers, and the name,
+// which is often derived from the args, so messages can be logged safely.
+// It has a value receiver, so printing a Message with %v or %+v
+// doesn't dump the args either.
+func (m Message) String() string {
+	return fmt.Sprintf("Message<ID=%q Task=%q Attempt=%d Args=%d ArgsSize=%d Headers=%d>",
+		m.ID, m.TaskName, m.ReservedCount, len(m.Args), len(m.ArgsBin), len(m.Headers))
+}
+
+// GoString is like String, but is used by the %#v verb.
+func (m Message) GoString() string {
+	return m.String()
+}
+
+// Touch extends reservation of the message by ReservationTimeout so long
+// running handlers don't get the message redelivered. It is only available
+// while the message is processed by a consumer.
+func (m *Message) Touch(ctx context.Context) error {
+	if m.toucher == nil {
+		return ErrNotSupported
+	}
+	return m.toucher.Touch(ctx, m)
+}
+
+// ErrorsHeader is the message header that holds errors of the last failed
+// attempts as a JSON array when QueueOptions.ErrorHistorySize is set.
+const ErrorsHeader = "taskq-errors"
+
+// Errors are truncated so the history doesn't blow up the message size.
+const maxErrorLen = 1024
+
+// Errors returns errors of the last failed attempts, oldest first.
+func (m *Message) Errors() []string {
+	s, ok := m.Headers[ErrorsHeader]
+	if !ok {
+		return nil
+	}
+	var errs []string
+	if err := json.Unmarshal([]byte(s), &errs); err != nil {
+		return nil
+	}
+	return errs
+}
+
+// addError appends the error to the history keeping at most size errors.
+func (m *Message) addError(err error, size int) {
+	s := err.Error()
+	if len(s) > maxErrorLen {
+		s = s[:maxErrorLen]
+	}
+
+	errs := append(m.Errors(), s)
+	if len(errs) > size {
+		errs = errs[len(errs)-size:]
+	}
+
+	b, jsonErr := json.Marshal(errs)
+	if jsonErr != nil {
+		return
+	}
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[ErrorsHeader] = internal.BytesToString(b)
+	m.marshalBinaryCache = nil
 }
 
 // SetDelay sets the message delay.
@@ -92,13 +235,18 @@ func (m *Message) OnceWithSchedule(tm time.Time) {
 func (m *Message) setNameFromArgs(period time.Duration, args ...interface{}) {
 	var b []byte
 	if len(args) > 0 {
-		b, _ = msgpack.Marshal(args)
+		b, _ = marshal(args)
 	} else {
 		b, _ = m.MarshalArgs()
 	}
 	if period > 0 {
 		b = appendTimeSlot(b, period)
 	}
+	if m.bufs != nil {
+		// The buffer is reused when the message is released.
+		m.Name = string(b)
+		return
+	}
 	m.Name = internal.BytesToString(b)
 }
 
@@ -112,7 +260,13 @@ func (m *Message) MarshalArgs() ([]byte, error) {
 		}
 	}
 
-	b, err := msgpack.Marshal(m.Args)
+	var b []byte
+	var err error
+	if m.bufs != nil {
+		b, err = m.bufs.encode(&m.bufs.args, m.Args)
+	} else {
+		b, err = marshal(m.Args)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -146,7 +300,12 @@ func (m *Message) MarshalBinary() ([]byte, error) {
 		}
 	}
 
-	b, err := msgpack.Marshal((*messageRaw)(m))
+	var b []byte
+	if m.bufs != nil {
+		b, err = m.bufs.encode(&m.bufs.msg, (*messageRaw)(m))
+	} else {
+		b, err = marshal((*messageRaw)(m))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +317,7 @@ func (m *Message) MarshalBinary() ([]byte, error) {
 var _ encoding.BinaryUnmarshaler = (*Message)(nil)
 
 func (m *Message) UnmarshalBinary(b []byte) error {
-	if err := msgpack.Unmarshal(b, (*messageRaw)(m)); err != nil {
+	if err := unmarshal(b, (*messageRaw)(m)); err != nil {
 		return err
 	}
 
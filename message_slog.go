@@ -0,0 +1,19 @@
+//go:build go1.21
+// +build go1.21
+
+package taskq
+
+import "log/slog"
+
+// LogValue implements slog.LogValuer. Like String it excludes args,
+// headers, and the name of the message.
+func (m Message) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", m.ID),
+		slog.String("task", m.TaskName),
+		slog.Int("attempt", m.ReservedCount),
+		slog.Int("args", len(m.Args)),
+		slog.Int("args_size", len(m.ArgsBin)),
+		slog.Int("headers", len(m.Headers)),
+	)
+}
@@ -0,0 +1,74 @@
+package taskq_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestPooledMessage(t *testing.T) {
+	ctx := context.Background()
+
+	for _, arg := range []string{"hello", strings.Repeat("x", 1024), "world"} {
+		msg := taskq.AcquireMessage(ctx, arg)
+		msg.TaskName = "test"
+
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := new(taskq.Message)
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		taskq.ReleaseMessage(msg)
+
+		if got.TaskName != "test" {
+			t.Fatalf("got task=%q, wanted %q", got.TaskName, "test")
+		}
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	msg := taskq.NewMessage(context.Background(), "user@example.com")
+	msg.Name = "welcome:user@example.com"
+	msg.TaskName = "welcome"
+	msg.Headers = map[string]string{"email": "user@example.com"}
+	msg.ReservedCount = 2
+
+	want := `Message<ID="" Task="welcome" Attempt=2 Args=1 ArgsSize=0 Headers=1>`
+	if got := msg.String(); got != want {
+		t.Fatalf("got %s, wanted %s", got, want)
+	}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		for _, v := range []interface{}{msg, *msg} {
+			if got := fmt.Sprintf(format, v); strings.Contains(got, "user@example.com") {
+				t.Fatalf("%s of %T leaks the payload: %s", format, v, got)
+			}
+		}
+	}
+}
+
+func TestMessageOrderingKey(t *testing.T) {
+	msg := taskq.NewMessage(context.Background(), "arg")
+	msg.TaskName = "test"
+	msg.OrderingKey = "user:1"
+
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(taskq.Message)
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if got.OrderingKey != msg.OrderingKey {
+		t.Fatalf("got %q, wanted %q", got.OrderingKey, msg.OrderingKey)
+	}
+}
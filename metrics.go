@@ -0,0 +1,151 @@
+package taskq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsHandler returns a handler that serves stats of the queues
+// registered in the factory:
+//
+//   - /metrics in the Prometheus text format.
+//   - /healthz responds with 200 status code when the lengths of all queues
+//     can be read from their backends and with 503 otherwise.
+//   - /debug/taskq with FactoryStats as JSON.
+//   - /scaler with the lag of the queues for autoscalers, see ScalerHandler.
+//
+// Use RequireAuth to protect the handler on public networks.
+func MetricsHandler(factory Factory) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, factory.Stats())
+		writeLagMetrics(w, factoryLags(req.Context(), factory))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		if err := checkHealth(factory); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/debug/taskq", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(factory.Stats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.Handle("/scaler", ScalerHandler(factory))
+	return mux
+}
+
+// ServeMetrics listens on the TCP address and serves MetricsHandler.
+// It blocks like http.ListenAndServe, so it is usually run in a goroutine:
+//
+//	go func() {
+//		log.Println(taskq.ServeMetrics(":9090", factory))
+//	}()
+func ServeMetrics(addr string, factory Factory) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           MetricsHandler(factory),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+func checkHealth(factory Factory) error {
+	var errs []string
+	factory.Range(func(q Queue) bool {
+		if _, err := q.Len(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", q, err))
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("taskq: unhealthy queues: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetrics(w io.Writer, stats *FactoryStats) {
+	type metric struct {
+		name  string
+		typ   string
+		help  string
+		value func(qs *QueueStats) float64
+	}
+
+	metrics := []metric{
+		{"taskq_queue_length", "gauge", "Number of messages in the queue.",
+			func(qs *QueueStats) float64 { return float64(qs.Len) }},
+		{"taskq_consumer_workers", "gauge", "Number of workers.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.NumWorker) }},
+		{"taskq_consumer_fetchers", "gauge", "Number of fetchers.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.NumFetcher) }},
+		{"taskq_consumer_buffered", "gauge", "Number of messages in the consumer buffer.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.Buffered) }},
+		{"taskq_consumer_in_flight", "gauge", "Number of messages being processed.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.InFlight) }},
+		{"taskq_consumer_processed_total", "counter", "Number of processed messages.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.Processed) }},
+		{"taskq_consumer_retries_total", "counter", "Number of retried messages.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.Retries) }},
+		{"taskq_consumer_fails_total", "counter", "Number of messages that failed permanently.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.Fails) }},
+		{"taskq_consumer_duplicates_total", "counter", "Number of messages processed more than once.",
+			func(qs *QueueStats) float64 { return float64(qs.Consumer.Duplicates) }},
+		{"taskq_consumer_timing_seconds", "gauge", "Average duration of processing a message.",
+			func(qs *QueueStats) float64 { return qs.Consumer.Timing.Seconds() }},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for i := range stats.Queues {
+			qs := &stats.Queues[i]
+			if m.name == "taskq_queue_length" && qs.Len < 0 {
+				// The backend can't tell.
+				continue
+			}
+			fmt.Fprintf(w, "%s{queue=\"%s\"} %s\n",
+				m.name, labelEscaper.Replace(qs.Name), strconv.FormatFloat(m.value(qs), 'g', -1, 64))
+		}
+	}
+}
+
+func writeLagMetrics(w io.Writer, lags []*QueueLag) {
+	type metric struct {
+		name  string
+		help  string
+		value func(lag *QueueLag) float64
+	}
+
+	metrics := []metric{
+		{"taskq_queue_lag_ready", "Number of messages that are ready to be processed.",
+			func(lag *QueueLag) float64 { return float64(lag.Ready) }},
+		{"taskq_queue_lag_delayed_due", "Number of delayed messages that are due.",
+			func(lag *QueueLag) float64 { return float64(lag.DelayedDue) }},
+		{"taskq_queue_oldest_message_age_seconds", "Age of the oldest ready or due message.",
+			func(lag *QueueLag) float64 { return lag.OldestAge.Seconds() }},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, lag := range lags {
+			value := m.value(lag)
+			if value < 0 {
+				// The backend can't tell.
+				continue
+			}
+			fmt.Fprintf(w, "%s{queue=\"%s\"} %s\n",
+				m.name, labelEscaper.Replace(lag.Queue), strconv.FormatFloat(value, 'g', -1, 64))
+		}
+	}
+}
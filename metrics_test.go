@@ -0,0 +1,107 @@
+package taskq_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ctx := context.Background()
+
+	factory := newTestFactory(t)
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name:    "metrics",
+		Handler: func() {},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "metrics-queue",
+	})
+	if err := q.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return q.Consumer().Stats().Processed > 0
+	})
+
+	h := taskq.MetricsHandler(factory)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d, wanted %d", path, w.Code, http.StatusOK)
+		}
+		return w
+	}
+
+	body := get("/metrics").Body.String()
+	for _, line := range []string{
+		"# TYPE taskq_consumer_processed_total counter",
+		`taskq_consumer_processed_total{queue="metrics-queue"} 1`,
+		`taskq_queue_length{queue="metrics-queue"} 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("/metrics does not contain %q:\n%s", line, body)
+		}
+	}
+
+	if body := get("/healthz").Body.String(); body != "ok\n" {
+		t.Fatalf("/healthz: got %q", body)
+	}
+
+	var stats taskq.FactoryStats
+	if err := json.NewDecoder(get("/debug/taskq").Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Queues) != 1 || stats.Queues[0].Name != "metrics-queue" {
+		t.Fatalf("got %+v", stats.Queues)
+	}
+}
+
+func TestFactoryStatsAdd(t *testing.T) {
+	var stats taskq.FactoryStats
+	stats.Add(taskq.QueueStats{
+		Name: "q1",
+		Len:  3,
+		Consumer: taskq.ConsumerStats{
+			NumWorker:  2,
+			Processed:  10,
+			Retries:    1,
+			Fails:      1,
+			Duplicates: 2,
+			Timing:     time.Second,
+		},
+	})
+	stats.Add(taskq.QueueStats{
+		Name: "q2",
+		Len:  -1,
+		Consumer: taskq.ConsumerStats{
+			NumWorker:  3,
+			Processed:  30,
+			Retries:    2,
+			Fails:      3,
+			Duplicates: 4,
+			Timing:     3 * time.Second,
+		},
+	})
+
+	if len(stats.Queues) != 2 || stats.Len != 3 {
+		t.Fatalf("got queues=%d len=%d", len(stats.Queues), stats.Len)
+	}
+	c := stats.Consumer
+	if c.NumWorker != 5 || c.Processed != 40 || c.Retries != 3 || c.Fails != 4 {
+		t.Fatalf("got %+v", c)
+	}
+	if c.Duplicates != 6 {
+		t.Fatalf("got Duplicates=%d, wanted 6", c.Duplicates)
+	}
+	if c.Timing != 2500*time.Millisecond {
+		t.Fatalf("got Timing=%s, wanted 2.5s", c.Timing)
+	}
+}
@@ -0,0 +1,350 @@
+package taskq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis_rate/v9"
+)
+
+// QueueOption configures QueueOptions created with NewQueueOptions.
+type QueueOption func(*QueueOptions)
+
+// NewQueueOptions returns validated QueueOptions with defaults applied.
+// It is an alternative to filling the QueueOptions struct:
+//
+//	opt, err := taskq.NewQueueOptions("api-worker",
+//		taskq.WithWorkers(8),
+//		taskq.WithRedis(rdb),
+//	)
+func NewQueueOptions(name string, opts ...QueueOption) (*QueueOptions, error) {
+	opt := &QueueOptions{
+		Name: name,
+	}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	opt.Init()
+	return opt, nil
+}
+
+// Validate reports whether the options are consistent.
+// It does not apply defaults, so zero values are valid.
+// Backends call it when the queue is created and panic on error.
+func (opt *QueueOptions) Validate() error {
+	if opt.Name == "" {
+		return errors.New("taskq: QueueOptions.Name is required")
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"MinNumWorker", int64(opt.MinNumWorker)},
+		{"MaxNumWorker", int64(opt.MaxNumWorker)},
+		{"WorkerLimit", int64(opt.WorkerLimit)},
+		{"MaxNumFetcher", int64(opt.MaxNumFetcher)},
+		{"ReservationSize", int64(opt.ReservationSize)},
+		{"ReservationTimeout", int64(opt.ReservationTimeout)},
+		{"TouchInterval", int64(opt.TouchInterval)},
+		{"HandlerTimeout", int64(opt.HandlerTimeout)},
+		{"WaitTimeout", int64(opt.WaitTimeout)},
+		{"BufferSize", int64(opt.BufferSize)},
+		{"PipelineSize", int64(opt.PipelineSize)},
+		{"DeleteBatchSize", int64(opt.DeleteBatchSize)},
+		{"MaxLen", int64(opt.MaxLen)},
+		{"MaxPending", int64(opt.MaxPending)},
+		{"OverflowTimeout", int64(opt.OverflowTimeout)},
+		{"PaceInterval", int64(opt.PaceInterval)},
+		{"AuditTTL", int64(opt.AuditTTL)},
+		{"ErrorHistorySize", int64(opt.ErrorHistorySize)},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("taskq: QueueOptions.%s=%d must not be negative", f.name, f.value)
+		}
+	}
+
+	if opt.MinNumWorker > 0 && opt.MaxNumWorker > 0 && opt.MinNumWorker > opt.MaxNumWorker {
+		return fmt.Errorf("taskq: QueueOptions.MinNumWorker=%d is greater than MaxNumWorker=%d",
+			opt.MinNumWorker, opt.MaxNumWorker)
+	}
+	if opt.ReservationTimeout > 0 && opt.TouchInterval >= opt.ReservationTimeout {
+		return fmt.Errorf("taskq: QueueOptions.TouchInterval=%s must be less than ReservationTimeout=%s",
+			opt.TouchInterval, opt.ReservationTimeout)
+	}
+	if opt.ReservationTimeout > 0 && opt.TouchInterval == 0 &&
+		opt.HandlerTimeout >= opt.ReservationTimeout {
+		return fmt.Errorf("taskq: QueueOptions.ReservationTimeout=%s must be greater than HandlerTimeout=%s",
+			opt.ReservationTimeout, opt.HandlerTimeout)
+	}
+	if maxNumWorker := opt.maxNumWorker(); opt.BufferSize > 0 && opt.BufferSize < int(maxNumWorker) {
+		return fmt.Errorf("taskq: QueueOptions.BufferSize=%d must not be less than MaxNumWorker=%d",
+			opt.BufferSize, maxNumWorker)
+	}
+	if !opt.RateLimit.IsZero() && opt.RateLimiter == nil && opt.Redis == nil {
+		return errors.New("taskq: QueueOptions.RateLimit requires RateLimiter or Redis")
+	}
+	if opt.PriorityQueue && opt.FairTenantHeader != "" {
+		return errors.New("taskq: QueueOptions.PriorityQueue and FairTenantHeader can't be used together")
+	}
+	if opt.StickyOrderingKeys && opt.Redis == nil {
+		return errors.New("taskq: QueueOptions.StickyOrderingKeys requires Redis")
+	}
+	if opt.PauseErrorsThreshold < -1 {
+		return fmt.Errorf("taskq: QueueOptions.PauseErrorsThreshold=%d is invalid",
+			opt.PauseErrorsThreshold)
+	}
+	if opt.Audit && opt.Storage != nil {
+		if _, ok := opt.Storage.(AuditStorage); !ok {
+			return fmt.Errorf("taskq: QueueOptions.Audit requires Storage that implements AuditStorage")
+		}
+	}
+	if opt.Quota != nil && opt.Storage != nil {
+		if _, ok := opt.Storage.(QuotaStorage); !ok {
+			return fmt.Errorf("taskq: QueueOptions.Quota requires Storage that implements QuotaStorage")
+		}
+	}
+	return nil
+}
+
+// maxNumWorker returns the configured maximum number of workers
+// or 0 if it is not set.
+func (opt *QueueOptions) maxNumWorker() int32 {
+	if opt.WorkerLimit > 0 {
+		return opt.WorkerLimit
+	}
+	return opt.MaxNumWorker
+}
+
+// WithNamespace sets QueueOptions.Namespace.
+func WithNamespace(namespace string) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Namespace = namespace
+	}
+}
+
+// WithGroup sets QueueOptions.Group.
+func WithGroup(group string) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Group = group
+	}
+}
+
+// WithWorkers sets the fixed number of workers, i.e. both
+// QueueOptions.MinNumWorker and QueueOptions.MaxNumWorker.
+func WithWorkers(n int32) QueueOption {
+	return WithWorkerRange(n, n)
+}
+
+// WithWorkerRange sets QueueOptions.MinNumWorker and QueueOptions.MaxNumWorker.
+func WithWorkerRange(min, max int32) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.MinNumWorker = min
+		opt.MaxNumWorker = max
+	}
+}
+
+// WithWorkerLimit sets QueueOptions.WorkerLimit.
+func WithWorkerLimit(n int32) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.WorkerLimit = n
+	}
+}
+
+// WithFetchers sets QueueOptions.MaxNumFetcher.
+func WithFetchers(n int32) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.MaxNumFetcher = n
+	}
+}
+
+// WithStrictFIFO sets QueueOptions.StrictFIFO.
+func WithStrictFIFO() QueueOption {
+	return func(opt *QueueOptions) {
+		opt.StrictFIFO = true
+	}
+}
+
+// WithReservation sets QueueOptions.ReservationSize and
+// QueueOptions.ReservationTimeout.
+func WithReservation(size int, timeout time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.ReservationSize = size
+		opt.ReservationTimeout = timeout
+	}
+}
+
+// WithTouchInterval sets QueueOptions.TouchInterval.
+func WithTouchInterval(d time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.TouchInterval = d
+	}
+}
+
+// WithHandlerTimeout sets QueueOptions.HandlerTimeout.
+func WithHandlerTimeout(d time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.HandlerTimeout = d
+	}
+}
+
+// WithWaitTimeout sets QueueOptions.WaitTimeout.
+func WithWaitTimeout(d time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.WaitTimeout = d
+	}
+}
+
+// WithBufferSize sets QueueOptions.BufferSize.
+func WithBufferSize(n int) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.BufferSize = n
+	}
+}
+
+// WithMaxLen sets QueueOptions.MaxLen.
+func WithMaxLen(n int) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.MaxLen = n
+	}
+}
+
+// WithMaxPending sets QueueOptions.MaxPending.
+func WithMaxPending(n int) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.MaxPending = n
+	}
+}
+
+// WithOverflowPolicy sets QueueOptions.OverflowPolicy and
+// QueueOptions.OverflowTimeout.
+func WithOverflowPolicy(policy OverflowPolicy, timeout time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.OverflowPolicy = policy
+		opt.OverflowTimeout = timeout
+	}
+}
+
+// WithRateLimit sets QueueOptions.RateLimit.
+func WithRateLimit(limit redis_rate.Limit) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.RateLimit = limit
+	}
+}
+
+// WithPaceInterval sets QueueOptions.PaceInterval.
+func WithPaceInterval(d time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.PaceInterval = d
+	}
+}
+
+// WithRedis sets QueueOptions.Redis.
+func WithRedis(rdb Redis) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Redis = rdb
+	}
+}
+
+// WithStorage sets QueueOptions.Storage.
+func WithStorage(storage Storage) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Storage = storage
+	}
+}
+
+// WithAudit enables the delivery audit with the QueueOptions.AuditTTL.
+func WithAudit(ttl time.Duration) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Audit = true
+		opt.AuditTTL = ttl
+	}
+}
+
+// WithAuditSink sets QueueOptions.AuditSink.
+func WithAuditSink(sink AuditSink) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.AuditSink = sink
+	}
+}
+
+// WithAuthorizer sets QueueOptions.Authorizer.
+func WithAuthorizer(auth Authorizer) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Authorizer = auth
+	}
+}
+
+// WithPropagator sets QueueOptions.Propagator.
+func WithPropagator(p Propagator) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Propagator = p
+	}
+}
+
+// WithPayloadPolicy sets QueueOptions.PayloadPolicy.
+func WithPayloadPolicy(policy *PayloadPolicy) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.PayloadPolicy = policy
+	}
+}
+
+// WithStickyOrderingKeys sets QueueOptions.StickyOrderingKeys.
+func WithStickyOrderingKeys() QueueOption {
+	return func(opt *QueueOptions) {
+		opt.StickyOrderingKeys = true
+	}
+}
+
+// WithQuota sets QueueOptions.Quota.
+func WithQuota(quota *QuotaOptions) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Quota = quota
+	}
+}
+
+// WithDuplicateDetection sets QueueOptions.DetectDuplicateDeliveries.
+func WithDuplicateDetection() QueueOption {
+	return func(opt *QueueOptions) {
+		opt.DetectDuplicateDeliveries = true
+	}
+}
+
+// WithErrorHistory sets QueueOptions.ErrorHistorySize.
+func WithErrorHistory(size int) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.ErrorHistorySize = size
+	}
+}
+
+// WithRedactPayload sets QueueOptions.RedactPayload.
+func WithRedactPayload(fn func(msg *Message) string) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.RedactPayload = fn
+	}
+}
+
+// WithErrorReporter sets QueueOptions.ErrorReporter.
+func WithErrorReporter(r ErrorReporter) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.ErrorReporter = r
+	}
+}
+
+// WithHandler sets QueueOptions.Handler.
+func WithHandler(handler Handler) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Handler = handler
+	}
+}
+
+// WithClock sets QueueOptions.Clock.
+func WithClock(clock Clock) QueueOption {
+	return func(opt *QueueOptions) {
+		opt.Clock = clock
+	}
+}
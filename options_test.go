@@ -0,0 +1,105 @@
+package taskq_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis_rate/v9"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/memqueue"
+)
+
+func TestNewQueueOptions(t *testing.T) {
+	opt, err := taskq.NewQueueOptions("test",
+		taskq.WithWorkers(8),
+		taskq.WithReservation(5, time.Minute),
+		taskq.WithStorage(taskq.NewLocalStorage()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opt.MinNumWorker != 8 || opt.MaxNumWorker != 8 {
+		t.Fatalf("got workers %d-%d, wanted 8-8", opt.MinNumWorker, opt.MaxNumWorker)
+	}
+	if opt.ReservationSize != 5 || opt.ReservationTimeout != time.Minute {
+		t.Fatalf("got reservation %d/%s, wanted 5/1m", opt.ReservationSize, opt.ReservationTimeout)
+	}
+	// Defaults are applied.
+	if opt.BufferSize != 5 {
+		t.Fatalf("got BufferSize=%d, wanted 5", opt.BufferSize)
+	}
+	if opt.WaitTimeout != 10*time.Second {
+		t.Fatalf("got WaitTimeout=%s, wanted 10s", opt.WaitTimeout)
+	}
+}
+
+func TestNewQueueOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []taskq.QueueOption
+		err  string
+	}{
+		{"", nil, "Name is required"},
+		{"test", []taskq.QueueOption{taskq.WithWorkers(-1)}, "MinNumWorker=-1 must not be negative"},
+		{"test", []taskq.QueueOption{taskq.WithWorkerRange(8, 4)}, "MinNumWorker=8 is greater than MaxNumWorker=4"},
+		{"test", []taskq.QueueOption{
+			taskq.WithReservation(10, time.Second),
+			taskq.WithTouchInterval(time.Minute),
+		}, "TouchInterval=1m0s must be less than ReservationTimeout=1s"},
+		{"test", []taskq.QueueOption{
+			taskq.WithReservation(10, time.Minute),
+			taskq.WithHandlerTimeout(time.Minute),
+		}, "ReservationTimeout=1m0s must be greater than HandlerTimeout=1m0s"},
+		{"test", []taskq.QueueOption{
+			taskq.WithWorkers(8),
+			taskq.WithBufferSize(4),
+		}, "BufferSize=4 must not be less than MaxNumWorker=8"},
+		{"test", []taskq.QueueOption{
+			taskq.WithWorkerLimit(8),
+			taskq.WithBufferSize(4),
+		}, "BufferSize=4 must not be less than MaxNumWorker=8"},
+		{"test", []taskq.QueueOption{
+			taskq.WithRateLimit(redis_rate.PerSecond(10)),
+		}, "RateLimit requires RateLimiter or Redis"},
+		{"test", []taskq.QueueOption{
+			func(opt *taskq.QueueOptions) {
+				opt.PriorityQueue = true
+				opt.FairTenantHeader = "tenant"
+			},
+		}, "PriorityQueue and FairTenantHeader can't be used together"},
+		{"test", []taskq.QueueOption{
+			taskq.WithStickyOrderingKeys(),
+		}, "StickyOrderingKeys requires Redis"},
+	}
+
+	for _, test := range tests {
+		_, err := taskq.NewQueueOptions(test.name, test.opts...)
+		if err == nil {
+			t.Fatalf("got nil error, wanted %q", test.err)
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("got %q, wanted %q", err, test.err)
+		}
+	}
+}
+
+func TestQueueOptionsInitValidates(t *testing.T) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			t.Fatal("expected a panic")
+		}
+		err, ok := v.(error)
+		if !ok || !strings.Contains(err.Error(), "MaxNumWorker=-1 must not be negative") {
+			t.Fatalf("got %v", v)
+		}
+	}()
+
+	memqueue.NewQueue(&taskq.QueueOptions{
+		Name:         "test",
+		MaxNumWorker: -1,
+	})
+}
@@ -0,0 +1,329 @@
+package taskq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// OutboxOptions configures NewOutbox.
+type OutboxOptions struct {
+	// Database with the outbox tables.
+	DB *sql.DB
+	// Factory with the queues named in the outbox rows. Required by Run.
+	Factory Factory
+
+	// Name of the outbox table with the columns:
+	//
+	//	id    BIGINT  increasing id, e.g. BIGSERIAL or AUTO_INCREMENT
+	//	queue TEXT    queue name
+	//	task  TEXT    task name
+	//	args  BLOB    args encoded by Message.MarshalArgs
+	//
+	// Default is "taskq_outbox".
+	Table string
+	// Name of the table with high-water marks with the columns
+	// name TEXT PRIMARY KEY and last_id BIGINT.
+	// Default is "taskq_outbox_offsets".
+	OffsetTable string
+	// Name of the high-water mark. Default is Table.
+	Name string
+	// Placeholder style: "?" for MySQL and SQLite or "$" for PostgreSQL.
+	// Default is "?".
+	Placeholder string
+
+	// Maximum number of rows read in one query. Default is 100.
+	BatchSize int
+	// Interval at which the outbox table is polled when it is drained.
+	// Default is 1 second.
+	PollInterval time.Duration
+	// Time to wait for a missing id below ids of committed rows, e.g. of
+	// a transaction that is still running, before the id is skipped.
+	// Default is 5 seconds. Negative disables waiting, which is required
+	// when ids are not contiguous.
+	GapTimeout time.Duration
+
+	// Optional function that is called when a row can't be added.
+	// The row is skipped when it returns nil; otherwise Run stops with
+	// the returned error. The default stops Run.
+	OnError func(id int64, err error) error
+}
+
+func (opt *OutboxOptions) init() {
+	if opt.Table == "" {
+		opt.Table = "taskq_outbox"
+	}
+	if opt.OffsetTable == "" {
+		opt.OffsetTable = "taskq_outbox_offsets"
+	}
+	if opt.Name == "" {
+		opt.Name = opt.Table
+	}
+	if opt.Placeholder == "" {
+		opt.Placeholder = "?"
+	}
+	if opt.BatchSize == 0 {
+		opt.BatchSize = 100
+	}
+	if opt.PollInterval == 0 {
+		opt.PollInterval = time.Second
+	}
+	if opt.GapTimeout == 0 {
+		opt.GapTimeout = 5 * time.Second
+	}
+}
+
+// Outbox implements the transactional outbox: messages are inserted into
+// the outbox table in the application transaction and Run adds them to
+// the queues after the transaction is committed, so messages are added
+// if and only if the transaction is committed.
+//
+// Run reads rows in the id order and stores the id of the last added row
+// as a high-water mark. Messages are named after the row ids, so with
+// QueueOptions.Storage rows re-read after a crash are added only once.
+type Outbox struct {
+	opt *OutboxOptions
+
+	last     int64
+	loaded   bool
+	gapID    int64
+	gapSince time.Time
+}
+
+func NewOutbox(opt *OutboxOptions) *Outbox {
+	if opt.DB == nil {
+		panic("taskq: OutboxOptions.DB is required")
+	}
+	opt.init()
+	return &Outbox{opt: opt}
+}
+
+// Insert inserts the message of the task into the outbox table
+// in the transaction.
+func (o *Outbox) Insert(
+	ctx context.Context, tx *sql.Tx, queue string, task *Task, args ...interface{},
+) error {
+	b, err := task.WithArgs(ctx, args...).MarshalArgs()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (queue, task, args) VALUES (%s, %s, %s)",
+		o.opt.Table, o.placeholder(1), o.placeholder(2), o.placeholder(3)),
+		queue, task.Name(), b)
+	return err
+}
+
+// Run polls the outbox table until the context is canceled or an error
+// is returned by OnError. Database errors are logged and retried.
+func (o *Outbox) Run(ctx context.Context) error {
+	for {
+		n, err := o.Poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var rowErr *outboxRowError
+			if errors.As(err, &rowErr) {
+				return err
+			}
+			internal.Logger.Printf("taskq: outbox=%q poll failed: %s", o.opt.Name, err)
+		}
+
+		if n < o.opt.BatchSize || err != nil {
+			if err := sleep(ctx, o.opt.PollInterval); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// Poll adds a batch of rows to the queues and returns the number of rows
+// that were added or skipped.
+func (o *Outbox) Poll(ctx context.Context) (int, error) {
+	if o.opt.Factory == nil {
+		panic("taskq: OutboxOptions.Factory is required")
+	}
+
+	if !o.loaded {
+		last, err := o.loadMark(ctx)
+		if err != nil {
+			return 0, err
+		}
+		o.last = last
+		o.loaded = true
+	}
+
+	rows, err := o.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	last := o.last
+	for i := range rows {
+		row := &rows[i]
+		if o.waitGap(last, row.id) {
+			break
+		}
+
+		if err := o.add(row); err != nil {
+			if isTransientAddError(err) {
+				// Retry the row on the next poll.
+				if saveErr := o.saveMark(ctx, last); saveErr != nil {
+					return n, saveErr
+				}
+				return n, err
+			}
+			if o.opt.OnError != nil {
+				err = o.opt.OnError(row.id, err)
+			}
+			if err != nil {
+				if saveErr := o.saveMark(ctx, last); saveErr != nil {
+					return n, saveErr
+				}
+				return n, &outboxRowError{id: row.id, err: err}
+			}
+		}
+
+		last = row.id
+		n++
+	}
+
+	if err := o.saveMark(ctx, last); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// waitGap reports whether the row id is not the next one and the missing
+// ids should be waited for.
+func (o *Outbox) waitGap(last, id int64) bool {
+	if o.opt.GapTimeout < 0 || id == last+1 {
+		return false
+	}
+	if o.gapID != last+1 {
+		o.gapID = last + 1
+		o.gapSince = time.Now()
+	}
+	return time.Since(o.gapSince) < o.opt.GapTimeout
+}
+
+type outboxRow struct {
+	id    int64
+	queue string
+	task  string
+	args  []byte
+}
+
+func (o *Outbox) read(ctx context.Context) ([]outboxRow, error) {
+	rows, err := o.opt.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, queue, task, args FROM %s WHERE id > %s ORDER BY id LIMIT %d",
+		o.opt.Table, o.placeholder(1), o.opt.BatchSize), o.last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.id, &row.queue, &row.task, &row.args); err != nil {
+			return nil, err
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
+
+func (o *Outbox) add(row *outboxRow) error {
+	q := o.queue(row.queue)
+	if q == nil {
+		return fmt.Errorf("taskq: queue=%q not found", row.queue)
+	}
+
+	msg := NewMessage(context.Background())
+	msg.TaskName = row.task
+	msg.ArgsBin = row.args
+	msg.Name = "outbox:" + o.opt.Name + ":" + strconv.FormatInt(row.id, 10)
+	return q.Add(msg)
+}
+
+func (o *Outbox) queue(name string) Queue {
+	var found Queue
+	o.opt.Factory.Range(func(q Queue) bool {
+		if q.Name() == name {
+			found = q
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func (o *Outbox) loadMark(ctx context.Context) (int64, error) {
+	var last int64
+	err := o.opt.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT last_id FROM %s WHERE name = %s",
+		o.opt.OffsetTable, o.placeholder(1)), o.opt.Name).Scan(&last)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return last, err
+}
+
+func (o *Outbox) saveMark(ctx context.Context, last int64) error {
+	if last == o.last {
+		return nil
+	}
+
+	res, err := o.opt.DB.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET last_id = %s WHERE name = %s",
+		o.opt.OffsetTable, o.placeholder(1), o.placeholder(2)), last, o.opt.Name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		_, err = o.opt.DB.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (name, last_id) VALUES (%s, %s)",
+			o.opt.OffsetTable, o.placeholder(1), o.placeholder(2)), o.opt.Name, last)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.last = last
+	return nil
+}
+
+func (o *Outbox) placeholder(n int) string {
+	if o.opt.Placeholder == "$" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func isTransientAddError(err error) bool {
+	var quotaErr *QuotaExceededError
+	return errors.As(err, &quotaErr) || errors.Is(err, ErrQueueFull)
+}
+
+type outboxRowError struct {
+	id  int64
+	err error
+}
+
+func (e *outboxRowError) Error() string {
+	return fmt.Sprintf("taskq: outbox row id=%d: %s", e.id, e.err)
+}
+
+func (e *outboxRowError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,252 @@
+package taskq_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// fakeOutboxDB is a database/sql driver that understands
+// the queries of taskq.Outbox.
+type fakeOutboxDB struct {
+	mu      sync.Mutex
+	rows    [][]driver.Value
+	nextID  int64
+	offsets map[string]int64
+}
+
+var fakeOutbox = &fakeOutboxDB{offsets: make(map[string]int64)}
+
+func init() {
+	sql.Register("taskq-fake-outbox", fakeOutbox)
+}
+
+func (db *fakeOutboxDB) Open(name string) (driver.Conn, error) {
+	return fakeOutboxConn{db}, nil
+}
+
+func (db *fakeOutboxDB) insert(id int64, queue, task string, args []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if id == 0 {
+		id = db.nextID + 1
+	}
+	db.nextID = id
+	db.rows = append(db.rows, []driver.Value{id, queue, task, args})
+}
+
+type fakeOutboxConn struct {
+	db *fakeOutboxDB
+}
+
+func (c fakeOutboxConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeOutboxStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeOutboxConn) Close() error              { return nil }
+func (c fakeOutboxConn) Begin() (driver.Tx, error) { return fakeOutboxTx{}, nil }
+
+type fakeOutboxTx struct{}
+
+func (fakeOutboxTx) Commit() error   { return nil }
+func (fakeOutboxTx) Rollback() error { return nil }
+
+type fakeOutboxStmt struct {
+	db    *fakeOutboxDB
+	query string
+}
+
+func (s fakeOutboxStmt) Close() error  { return nil }
+func (s fakeOutboxStmt) NumInput() int { return -1 }
+
+func (s fakeOutboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO taskq_outbox_offsets"):
+		s.db.mu.Lock()
+		s.db.offsets[args[0].(string)] = args[1].(int64)
+		s.db.mu.Unlock()
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "INSERT INTO taskq_outbox"):
+		s.db.insert(0, args[0].(string), args[1].(string), args[2].([]byte))
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "UPDATE taskq_outbox_offsets"):
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+		name := args[1].(string)
+		if _, ok := s.db.offsets[name]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		s.db.offsets[name] = args[0].(int64)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, errors.New("unexpected query: " + s.query)
+}
+
+func (s fakeOutboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(s.query, "SELECT last_id"):
+		last, ok := s.db.offsets[args[0].(string)]
+		if !ok {
+			return &fakeOutboxRows{cols: []string{"last_id"}}, nil
+		}
+		return &fakeOutboxRows{
+			cols: []string{"last_id"},
+			rows: [][]driver.Value{{last}},
+		}, nil
+	case strings.HasPrefix(s.query, "SELECT id, queue, task, args"):
+		res := &fakeOutboxRows{cols: []string{"id", "queue", "task", "args"}}
+		for _, row := range s.db.rows {
+			if row[0].(int64) > args[0].(int64) {
+				res.rows = append(res.rows, row)
+			}
+		}
+		return res, nil
+	}
+	return nil, errors.New("unexpected query: " + s.query)
+}
+
+type fakeOutboxRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeOutboxRows) Columns() []string { return r.cols }
+func (r *fakeOutboxRows) Close() error      { return nil }
+
+func (r *fakeOutboxRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	fakeOutbox.mu.Lock()
+	fakeOutbox.rows = nil
+	fakeOutbox.nextID = 0
+	fakeOutbox.offsets = make(map[string]int64)
+	fakeOutbox.mu.Unlock()
+
+	db, err := sql.Open("taskq-fake-outbox", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	factory := newTestFactory(t)
+
+	ch := make(chan string, 10)
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "outbox",
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+	factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "outbox-queue",
+	})
+
+	newOutbox := func() *taskq.Outbox {
+		return taskq.NewOutbox(&taskq.OutboxOptions{
+			DB:         db,
+			Factory:    factory,
+			GapTimeout: 50 * time.Millisecond,
+		})
+	}
+	outbox := newOutbox()
+
+	expectProcessed := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-ch:
+				if got != w {
+					t.Fatalf("got %q, wanted %q", got, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%q is not processed", w)
+			}
+		}
+		select {
+		case got := <-ch:
+			t.Fatalf("unexpected message %q", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	expectPoll := func(o *taskq.Outbox, want int) {
+		t.Helper()
+		n, err := o.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("got %d rows, wanted %d", n, want)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"first", "second"} {
+		if err := outbox.Insert(ctx, tx, "outbox-queue", task, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPoll(outbox, 2)
+	expectProcessed("first", "second")
+
+	// Id 3 is missing, e.g. its transaction is not committed yet.
+	args, err := task.WithArgs(ctx, "fourth").MarshalArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeOutbox.insert(4, "outbox-queue", "outbox", args)
+	expectPoll(outbox, 0)
+	time.Sleep(60 * time.Millisecond)
+	expectPoll(outbox, 1)
+	expectProcessed("fourth")
+
+	// The high-water mark is loaded on restart.
+	expectPoll(newOutbox(), 0)
+
+	// Rows re-read after a crash are not added again.
+	fakeOutbox.mu.Lock()
+	fakeOutbox.offsets["taskq_outbox"] = 0
+	fakeOutbox.mu.Unlock()
+	restarted := newOutbox()
+	expectPoll(restarted, 2)
+	time.Sleep(60 * time.Millisecond)
+	expectPoll(restarted, 1)
+	expectProcessed()
+
+	fakeOutbox.insert(0, "unknown-queue", "outbox", args)
+	if _, err := outbox.Poll(ctx); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got %v", err)
+	}
+	fakeOutbox.mu.Lock()
+	last := fakeOutbox.offsets["taskq_outbox"]
+	fakeOutbox.mu.Unlock()
+	if last != 4 {
+		t.Fatalf("got high-water mark %d, wanted 4", last)
+	}
+}
@@ -0,0 +1,132 @@
+package taskq
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+type PatternOptions struct {
+	// Pattern of the queue names in path.Match syntax, e.g. "emails-*".
+	Pattern string
+	// QueueOptions returns options used to register a matching queue.
+	// The queue is skipped when it returns nil.
+	QueueOptions func(name string) *QueueOptions
+	// How often the backend is checked for new queues.
+	// Default is 30 seconds.
+	Interval time.Duration
+}
+
+func (opt *PatternOptions) init() {
+	if opt.Interval == 0 {
+		opt.Interval = 30 * time.Second
+	}
+}
+
+// PatternConsumer registers queues that match the pattern in the factory
+// as they appear in the backend. Consumers of the registered queues are
+// started when consumers of the factory are started with StartConsumers.
+type PatternConsumer struct {
+	factory Factory
+	opt     *PatternOptions
+
+	mu     sync.Mutex
+	queues []string
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewPatternConsumer(factory Factory, opt *PatternOptions) *PatternConsumer {
+	if opt.QueueOptions == nil {
+		panic("PatternOptions.QueueOptions is required")
+	}
+	if _, err := path.Match(opt.Pattern, ""); err != nil {
+		panic(fmt.Errorf("taskq: invalid pattern %q: %w", opt.Pattern, err))
+	}
+	opt.init()
+	return &PatternConsumer{
+		factory: factory,
+		opt:     opt,
+	}
+}
+
+// Start registers matching queues and keeps checking
+// the backend for new queues until Stop is called.
+func (c *PatternConsumer) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopCh != nil {
+		return fmt.Errorf("taskq: PatternConsumer is already started")
+	}
+	if err := c.discover(ctx); err != nil {
+		return err
+	}
+
+	c.stopCh = make(chan struct{})
+	c.wg.Add(1)
+	go c.watch(ctx, c.stopCh)
+	return nil
+}
+
+// Stop stops checking the backend for new queues.
+// Registered queues are not unregistered.
+func (c *PatternConsumer) Stop() {
+	c.mu.Lock()
+	if c.stopCh != nil {
+		close(c.stopCh)
+		c.stopCh = nil
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+// Queues returns names of the registered queues.
+func (c *PatternConsumer) Queues() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.queues...)
+}
+
+func (c *PatternConsumer) watch(ctx context.Context, stopCh chan struct{}) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		err := c.discover(ctx)
+		c.mu.Unlock()
+		if err != nil {
+			internal.Logger.Printf("taskq: discovering %q failed: %s", c.opt.Pattern, err)
+		}
+	}
+}
+
+func (c *PatternConsumer) discover(ctx context.Context) error {
+	_, err := c.factory.Discover(ctx, func(name string) *QueueOptions {
+		if ok, _ := path.Match(c.opt.Pattern, name); !ok {
+			return nil
+		}
+		opt := c.opt.QueueOptions(name)
+		if opt != nil {
+			c.queues = append(c.queues, name)
+		}
+		return opt
+	})
+	return err
+}
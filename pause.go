@@ -0,0 +1,125 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// How often consumers check whether the fleet is paused.
+const pauseCheckInterval = 5 * time.Second
+
+func pauseKey(namespace string) string {
+	return internal.Namespaced(namespace, ":", "taskq:paused")
+}
+
+func queuePauseKey(namespace, queue string) string {
+	return internal.Namespaced(namespace, ":", fmt.Sprintf("taskq:{%s}:paused", queue))
+}
+
+// PauseConsumers pauses fetching messages by consumers on every node that
+// shares the Redis client and the namespace of the queue options, e.g. to
+// quiesce a deployment before maintenance. Messages that are already fetched
+// are still processed. When d is positive, the pause expires after d.
+func PauseConsumers(ctx context.Context, opt *QueueOptions, d time.Duration) error {
+	if opt.Redis == nil {
+		return errors.New("taskq: PauseConsumers requires QueueOptions.Redis")
+	}
+	return opt.Redis.SetNX(ctx, pauseKey(opt.Namespace), time.Now().Unix(), d).Err()
+}
+
+// ResumeConsumers resumes consumers paused with PauseConsumers.
+func ResumeConsumers(ctx context.Context, opt *QueueOptions) error {
+	if opt.Redis == nil {
+		return errors.New("taskq: ResumeConsumers requires QueueOptions.Redis")
+	}
+	return opt.Redis.Del(ctx, pauseKey(opt.Namespace)).Err()
+}
+
+// PauseQueue pauses fetching messages from the queue by its consumers on
+// every node that shares the Redis client and the namespace of the queue
+// options. Messages that are already fetched are still processed.
+// When d is positive, the pause expires after d.
+func PauseQueue(ctx context.Context, opt *QueueOptions, d time.Duration) error {
+	if opt.Redis == nil {
+		return errors.New("taskq: PauseQueue requires QueueOptions.Redis")
+	}
+	return opt.Redis.SetNX(ctx, queuePauseKey(opt.Namespace, opt.Name), time.Now().Unix(), d).Err()
+}
+
+// ResumeQueue resumes consumers of the queue paused with PauseQueue.
+func ResumeQueue(ctx context.Context, opt *QueueOptions) error {
+	if opt.Redis == nil {
+		return errors.New("taskq: ResumeQueue requires QueueOptions.Redis")
+	}
+	return opt.Redis.Del(ctx, queuePauseKey(opt.Namespace, opt.Name)).Err()
+}
+
+// QueuePaused reports whether the queue is paused with PauseQueue
+// or PauseConsumers.
+func QueuePaused(ctx context.Context, opt *QueueOptions) (bool, error) {
+	if opt.Redis == nil {
+		return false, errors.New("taskq: QueuePaused requires QueueOptions.Redis")
+	}
+	return pausedIn(ctx, opt)
+}
+
+// pausedIn checks the keys in a pipeline, because they can be stored
+// on different shards.
+func pausedIn(ctx context.Context, opt *QueueOptions) (bool, error) {
+	var fleet, queue *redis.IntCmd
+	if _, err := opt.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fleet = pipe.Exists(ctx, pauseKey(opt.Namespace))
+		queue = pipe.Exists(ctx, queuePauseKey(opt.Namespace, opt.Name))
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return fleet.Val() > 0 || queue.Val() > 0, nil
+}
+
+// fleetPause caches whether the fleet is paused.
+type fleetPause struct {
+	checkedAt int64  // atomic, unix nanoseconds
+	paused    uint32 // atomic
+}
+
+// fleetPaused reports whether consumers were paused with PauseConsumers
+// or the queue was paused with PauseQueue.
+func (c *Consumer) fleetPaused(ctx context.Context) bool {
+	if c.opt.Redis == nil {
+		return false
+	}
+
+	p := &c.fleet
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&p.checkedAt) < int64(pauseCheckInterval) {
+		return atomic.LoadUint32(&p.paused) == 1
+	}
+	atomic.StoreInt64(&p.checkedAt, now)
+
+	ok, err := pausedIn(ctx, c.opt)
+	if err != nil {
+		internal.Logger.Printf("%s: checking pause failed: %s", c, err)
+		return atomic.LoadUint32(&p.paused) == 1
+	}
+
+	var paused uint32
+	if ok {
+		paused = 1
+	}
+	if atomic.SwapUint32(&p.paused, paused) != paused {
+		if paused == 1 {
+			internal.Logger.Printf("%s is paused", c)
+		} else {
+			internal.Logger.Printf("%s is resumed", c)
+		}
+	}
+	return paused == 1
+}
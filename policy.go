@@ -0,0 +1,127 @@
+package taskq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrPolicyViolation matches errors returned when a message violates
+// QueueOptions.PayloadPolicy.
+var ErrPolicyViolation = errors.New("taskq: payload policy violation")
+
+// PolicyViolationError is returned by Queue.Add and passed to the fallback
+// handler when the message violates the payload policy. Such messages are
+// not retried.
+type PolicyViolationError struct {
+	Task   string
+	Reason string
+}
+
+var _ Delayer = (*PolicyViolationError)(nil)
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("taskq: task=%q violates payload policy: %s", e.Task, e.Reason)
+}
+
+func (e *PolicyViolationError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// Delay returns 0, so the message fails permanently.
+func (e *PolicyViolationError) Delay() time.Duration {
+	return 0
+}
+
+// PayloadPolicy checks message args before the message is added and again
+// in the consumer after the args are decoded, so messages that violate
+// the policy fail fast instead of being retried.
+type PayloadPolicy struct {
+	// Maximum size of the encoded args in bytes.
+	// Default is 0 (unlimited).
+	MaxArgsSize int
+	// Types of args that are not allowed, e.g. map[string]interface{}.
+	// Only top-level args are checked.
+	ForbiddenTypes []reflect.Type
+	// Optional check of the args, e.g. schema validation. In Add it receives
+	// the args passed to the message. In the consumer it receives the args
+	// decoded for the handler and it isn't called for handlers that accept
+	// *Message. A returned error is wrapped in PolicyViolationError.
+	Check func(msg *Message, args []interface{}) error
+}
+
+// CheckMessage checks the message before it is added. It encodes the args
+// to check their size, so it must be called after the args are set.
+func (p *PayloadPolicy) CheckMessage(msg *Message) error {
+	if p.MaxArgsSize > 0 {
+		b, err := msg.MarshalArgs()
+		if err != nil {
+			return err
+		}
+		if err := p.checkSize(msg, b); err != nil {
+			return err
+		}
+	}
+	return p.checkArgs(msg, msg.Args)
+}
+
+func (p *PayloadPolicy) checkSize(msg *Message, b []byte) error {
+	if p.MaxArgsSize > 0 && len(b) > p.MaxArgsSize {
+		return &PolicyViolationError{
+			Task:   msg.TaskName,
+			Reason: fmt.Sprintf("args size %d exceeds %d bytes", len(b), p.MaxArgsSize),
+		}
+	}
+	return nil
+}
+
+func (p *PayloadPolicy) checkArgs(msg *Message, args []interface{}) error {
+	for i, arg := range args {
+		typ := reflect.TypeOf(arg)
+		for _, forbidden := range p.ForbiddenTypes {
+			if typ == forbidden {
+				return &PolicyViolationError{
+					Task:   msg.TaskName,
+					Reason: fmt.Sprintf("arg=%d has forbidden type %s", i, typ),
+				}
+			}
+		}
+	}
+	if p.Check != nil {
+		if err := p.Check(msg, args); err != nil {
+			return &PolicyViolationError{
+				Task:   msg.TaskName,
+				Reason: err.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// checkDecoded checks the args decoded for the handler.
+func (p *PayloadPolicy) checkDecoded(msg *Message, in []reflect.Value) error {
+	args := make([]interface{}, len(in))
+	for i, v := range in {
+		args[i] = v.Interface()
+	}
+	return p.checkArgs(msg, args)
+}
+
+// checkPayload checks the size of the args of the reserved message and
+// makes the handler check the decoded args.
+func (c *Consumer) checkPayload(msg *Message) error {
+	policy := c.opt.PayloadPolicy
+	if policy == nil {
+		return nil
+	}
+	msg.policy = policy
+	if policy.MaxArgsSize == 0 {
+		return nil
+	}
+	b, err := msg.MarshalArgs()
+	if err != nil {
+		return err
+	}
+	return policy.checkSize(msg, b)
+}
@@ -0,0 +1,21 @@
+package taskq
+
+import "context"
+
+// Propagator carries context values, e.g. trace context, from producers to
+// handlers in message headers. See taskqotel.Propagator for OpenTelemetry.
+type Propagator interface {
+	// Inject adds values of the producer context to the headers.
+	// It is called when the message is added.
+	Inject(ctx context.Context, headers map[string]string)
+	// Extract returns a context with values from the headers.
+	// It is called before the message is processed.
+	Extract(ctx context.Context, headers map[string]string) context.Context
+}
+
+func (c *Consumer) extractContext(msg *Message) {
+	if c.opt.Propagator == nil {
+		return
+	}
+	msg.Ctx = c.opt.Propagator.Extract(msgContext(msg), msg.Headers)
+}
@@ -2,16 +2,59 @@ package taskq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"time"
 
 	"github.com/go-redis/redis_rate/v9"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// Errors returned by queues. Backends may wrap them with details,
+// so check them using errors.Is.
+var (
+	// ErrQueueFull is returned when adding a message to the queue that has
+	// MaxLen messages.
+	ErrQueueFull = errors.New("taskq: queue is full")
+	// ErrQueueEmpty is returned when there are no messages to process,
+	// e.g. by Consumer.ProcessOne.
+	ErrQueueEmpty = errors.New("taskq: queue is empty")
+	// ErrQueueClosed is returned when using the queue after it is closed.
+	ErrQueueClosed = errors.New("taskq: queue is closed")
+	// ErrNotSupported is returned when the backend doesn't support
+	// the operation, e.g. Queue.ReserveN in memqueue.
+	ErrNotSupported = internal.ErrNotSupported
+)
+
+// OverflowPolicy specifies what happens when a message is added to the full queue.
+type OverflowPolicy int
+
+const (
+	// OverflowReject rejects new messages with ErrQueueFull.
+	OverflowReject OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest messages to make room for new ones.
+	OverflowDropOldest
+	// OverflowBlock waits until there is room in the queue or OverflowTimeout
+	// is elapsed.
+	OverflowBlock
 )
 
 type QueueOptions struct {
 	// Queue name.
 	Name string
+	// Optional consumer group. Every group receives every message that is
+	// added to the queue after the group started consuming, i.e. queues with
+	// the same name and different groups have fan-out semantics. A group
+	// stops receiving messages when none of its consumers reserved messages
+	// for ConsumerIdleTimeout. Only supported by redisq.
+	Group string
+	// Optional namespace that prefixes backend keys and queue names so
+	// multiple environments or applications can share one broker, e.g.
+	// Redis keys are prefixed with "staging:" and SQS and IronMQ
+	// queue names with "staging-".
+	Namespace string
 
 	// Minimum number of goroutines processing messages.
 	// Default is 1.
@@ -22,24 +65,105 @@ type QueueOptions struct {
 	// Global limit of concurrently running workers across all servers.
 	// Overrides MaxNumWorker.
 	WorkerLimit int32
+	// Processes messages one at a time in the queue order using a single
+	// worker, which is enforced across all servers when Redis is set.
+	// A failed message is retried in place and blocks the queue until
+	// the task RetryLimit is reached; then it is passed to the
+	// FallbackHandler and deleted. Overrides WorkerLimit.
+	StrictFIFO bool
 	// Maximum number of goroutines fetching messages.
 	// Default is 8 * number of CPUs.
 	MaxNumFetcher int32
+	// Probes consulted by the autoscaler when MinNumWorker is less than
+	// MaxNumWorker. Workers are removed while any probe reports no free
+	// resources and added back when all probes report free resources.
+	// Default is DefaultResourceProbes().
+	ResourceProbes []ResourceProbe
 
-	// Number of messages reserved by a fetcher in the queue in one request.
+	// Maximum number of messages reserved by a fetcher in the queue in one
+	// request. Fetchers reserve fewer messages when workers can't process
+	// them before ReservationTimeout given the average handler duration.
 	// Default is 10 messages.
 	ReservationSize int
 	// Time after which the reserved message is returned to the queue.
 	// Default is 5 minutes.
 	ReservationTimeout time.Duration
+	// Interval at which reservation of a message is automatically extended
+	// (see Message.Touch) while the message is being processed.
+	// Default is 0 (disabled).
+	TouchInterval time.Duration
+	// Maximum time the handler may take to process a message. The message
+	// context is canceled when it expires or when the handler returns.
+	// Unless TouchInterval is set, it must be less than ReservationTimeout,
+	// so the message is not redelivered while it is being processed.
+	// Default is 0 (no timeout).
+	HandlerTimeout time.Duration
 	// Time that a long polling receive call waits for a message to become
 	// available before returning an empty response.
 	// Default is 10 seconds.
 	WaitTimeout time.Duration
+	// Wait for new messages using blocking reads and Pub/Sub notifications
+	// instead of polling the backend. Only supported by redisq.
+	PushFetch bool
 	// Size of the buffer where reserved messages are stored.
 	// Default is the same as ReservationSize.
 	BufferSize int
 
+	// Maximum number of Add and Delete commands that are sent to the backend
+	// in a single pipeline. Only supported by redisq.
+	// Default is 0 (pipelining is disabled).
+	PipelineSize int
+	// Time after which a partially filled pipeline is sent.
+	// Default is 1 millisecond.
+	PipelineInterval time.Duration
+
+	// Maximum number of processed messages that are deleted from the backend
+	// in a single request. Only supported by azsqs (max 10) and ironmq (max 100).
+	// Default is 10 messages.
+	DeleteBatchSize int
+	// Time after which a partially filled batch of deletes is sent.
+	// Lower values acknowledge messages faster under low traffic.
+	// Only supported by azsqs and ironmq. Default is 3 seconds.
+	DeleteBatchTimeout time.Duration
+
+	// Approximate maximum number of messages in the queue including delayed messages.
+	// Only supported by redisq.
+	// Default is 0 (unlimited).
+	MaxLen int
+	// Process messages with higher Message.Priority first. Only supported by memqueue.
+	// Add does not block when the consumer buffer is full, so MaxPending
+	// should be set to limit memory usage.
+	PriorityQueue bool
+
+	// Message header that identifies tenants. Messages are processed
+	// round-robin across tenants, so a tenant with a large backlog doesn't
+	// monopolize workers. Only supported by memqueue. Like with
+	// PriorityQueue, MaxPending should be set to limit memory usage.
+	FairTenantHeader string
+
+	// Process messages with the same Message.OrderingKey on the same consumer
+	// instance, e.g. to use per-key in-memory caches in handlers. Keys are
+	// assigned to the live consumers of the queue using consistent hashing
+	// and other consumers return the messages to the queue. Requires Redis.
+	StickyOrderingKeys bool
+
+	// File where memqueue saves unprocessed and delayed messages on Close.
+	// The messages are added back to the queue and the file is removed
+	// when the queue is created again. Only supported by memqueue.
+	PersistFile string
+
+	// Maximum number of messages that are added to memqueue, but are not
+	// processed yet, including delayed messages. Only supported by memqueue.
+	// Default is 0 (unlimited).
+	MaxPending int
+	// Behavior of Add when the queue has MaxLen or MaxPending messages.
+	// memqueue does not support OverflowDropOldest and rejects messages instead.
+	// Default is OverflowReject.
+	OverflowPolicy OverflowPolicy
+	// Maximum time Add waits for room in the queue with OverflowBlock policy.
+	// Default is 30 seconds.
+	OverflowTimeout time.Duration
+
 	// Number of consecutive failures after which queue processing is paused.
 	// Default is 100 failures.
 	PauseErrorsThreshold int
@@ -50,15 +174,79 @@ type QueueOptions struct {
 	// Optional rate limiter. The default is to use Redis.
 	RateLimiter *redis_rate.Limiter
 
+	// Minimum interval between starting consecutive messages (leaky bucket).
+	// Unlike RateLimit that allows bursts, messages are spaced evenly.
+	// Default is 0 (disabled).
+	PaceInterval time.Duration
+
 	// Redis client that is used for storing metadata.
 	Redis Redis
 
 	// Optional storage interface. The default is to use Redis.
 	Storage Storage
 
-	// Optional message handler. The default is the global Tasks registry.
+	// Records ids of added messages and of messages that reached a terminal
+	// state in the Storage, so Reconcile can report lost messages.
+	// Storage must implement AuditStorage.
+	Audit bool
+	// Time after which Reconcile reports a message that hasn't reached
+	// a terminal state. Default is 24 hours.
+	AuditTTL time.Duration
+
+	// Optional sink that receives enqueue, start, retry, dead-letter,
+	// and delete events of messages, e.g. NewFileAuditSink.
+	AuditSink AuditSink
+
+	// Optional hook that decides whether producers may add messages,
+	// e.g. AllowTasks. Producers supply their identity with
+	// ContextWithIdentity.
+	Authorizer Authorizer
+
+	// Optional propagator of context values, e.g. trace context,
+	// from producers to handlers in message headers.
+	Propagator Propagator
+
+	// Optional policy that checks message args when messages are added
+	// and when they are processed.
+	PayloadPolicy *PayloadPolicy
+
+	// Optional quotas of the queue or of its tenants that are enforced
+	// when messages are added. Storage must implement QuotaStorage.
+	Quota *QuotaOptions
+
+	// Records ids of processed messages in the Storage and counts messages
+	// processed more than once in ConsumerStats.Duplicates. Ids are
+	// remembered for the Storage TTL, i.e. 24 hours by default.
+	DetectDuplicateDeliveries bool
+
+	// Number of handler errors kept in the message headers, so the fallback
+	// handler can inspect the failure history with Message.Errors.
+	// The history survives retries only with backends that re-add released
+	// messages, i.e. memqueue and redisq. Default is 0 (disabled).
+	ErrorHistorySize int
+
+	// Optional dependencies, e.g. DB pools and API clients, that are passed
+	// to handlers with the message context. Use DepsFromContext or
+	// TaskOptions.HandlerFactory to get them.
+	Deps interface{}
+
+	// Optional function that describes the message when taskq logs it,
+	// e.g. when the message fails or is retried, so logs contain enough
+	// context without sensitive args. The default is to log only the task
+	// name and the handler error, which is logged as is.
+	RedactPayload func(msg *Message) string
+
+	// Optional reporter of handler errors and panics, e.g. to Sentry.
+	ErrorReporter ErrorReporter
+
+	// Optional message handler, e.g. a registry created with NewRegistry.
+	// The default is the global Tasks registry.
 	Handler Handler
 
+	// Optional clock used for backoff, delays and autotuning.
+	// The default is SystemClock.
+	Clock Clock
+
 	inited bool
 
 	// ConsumerIdleTimeout Time after which the consumer need to be deleted.
@@ -70,10 +258,14 @@ func (opt *QueueOptions) Init() {
 	if opt.inited {
 		return
 	}
+	if err := opt.Validate(); err != nil {
+		panic(err)
+	}
 	opt.inited = true
 
-	if opt.Name == "" {
-		panic("QueueOptions.Name is required")
+	if opt.StrictFIFO {
+		opt.WorkerLimit = 1
+		opt.ReservationSize = 1
 	}
 
 	if opt.WorkerLimit > 0 {
@@ -90,6 +282,9 @@ func (opt *QueueOptions) Init() {
 	if opt.MaxNumFetcher == 0 {
 		opt.MaxNumFetcher = 8 * int32(runtime.NumCPU())
 	}
+	if opt.ResourceProbes == nil {
+		opt.ResourceProbes = DefaultResourceProbes()
+	}
 
 	switch opt.PauseErrorsThreshold {
 	case -1:
@@ -110,6 +305,18 @@ func (opt *QueueOptions) Init() {
 	if opt.WaitTimeout == 0 {
 		opt.WaitTimeout = 10 * time.Second
 	}
+	if opt.OverflowTimeout == 0 {
+		opt.OverflowTimeout = 30 * time.Second
+	}
+	if opt.PipelineInterval == 0 {
+		opt.PipelineInterval = time.Millisecond
+	}
+	if opt.DeleteBatchSize == 0 {
+		opt.DeleteBatchSize = 10
+	}
+	if opt.DeleteBatchTimeout == 0 {
+		opt.DeleteBatchTimeout = 3 * time.Second
+	}
 
 	if opt.ConsumerIdleTimeout == 0 {
 		opt.ConsumerIdleTimeout = 6 * time.Hour
@@ -118,6 +325,9 @@ func (opt *QueueOptions) Init() {
 	if opt.Storage == nil {
 		opt.Storage = newRedisStorage(opt.Redis)
 	}
+	if opt.AuditTTL == 0 {
+		opt.AuditTTL = 24 * time.Hour
+	}
 
 	if !opt.RateLimit.IsZero() && opt.RateLimiter == nil && opt.Redis != nil {
 		opt.RateLimiter = redis_rate.NewLimiter(opt.Redis)
@@ -126,6 +336,10 @@ func (opt *QueueOptions) Init() {
 	if opt.Handler == nil {
 		opt.Handler = &Tasks
 	}
+
+	if opt.Clock == nil {
+		opt.Clock = SystemClock
+	}
 }
 
 //------------------------------------------------------------------------------
@@ -137,7 +351,14 @@ type Queue interface {
 	Consumer() QueueConsumer
 
 	Len() (int, error)
+	// Add adds the message to the queue. Waiting for the backend or for room
+	// in the queue stops when msg.Ctx is done; delayed and asynchronously
+	// sent messages are not affected by msg.Ctx once Add returns.
 	Add(msg *Message) error
+	// AddN adds messages to the queue in as few backend round trips as
+	// possible. Duplicate messages are skipped and have Err set to
+	// ErrDuplicate.
+	AddN(ctx context.Context, msgs ...*Message) error
 	ReserveN(ctx context.Context, n int, waitTimeout time.Duration) ([]Message, error)
 	Release(msg *Message) error
 	Delete(msg *Message) error
@@ -146,6 +367,12 @@ type Queue interface {
 	CloseTimeout(timeout time.Duration) error
 }
 
+// Toucher is implemented by queues that can extend reservation of a message.
+type Toucher interface {
+	// Touch extends reservation of the message by ReservationTimeout.
+	Touch(ctx context.Context, msg *Message) error
+}
+
 // QueueConsumer reserves messages from the queue, processes them,
 // and then either releases or deletes messages from the queue.
 type QueueConsumer interface {
@@ -156,14 +383,14 @@ type QueueConsumer interface {
 	Len() int
 	// Stats returns processor stats.
 	Stats() *ConsumerStats
+	// ResetStats resets the counters returned by Stats.
+	ResetStats()
 	Add(msg *Message) error
 	// Start starts consuming messages in the queue.
 	Start(ctx context.Context) error
-	// Stop is StopTimeout with 30 seconds timeout.
-	Stop() error
-	// StopTimeout waits workers for timeout duration to finish processing current
-	// messages and stops workers.
-	StopTimeout(timeout time.Duration) error
+	// Stop stops fetchers and waits for workers to finish processing current
+	// messages until the context is done.
+	Stop(ctx context.Context) error
 	// ProcessAll starts workers to process messages in the queue and then stops
 	// them when all messages are processed.
 	ProcessAll(ctx context.Context) error
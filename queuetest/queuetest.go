@@ -0,0 +1,343 @@
+// Package queuetest is a conformance test suite for taskq.Queue
+// implementations, so third-party backends and forks stay behaviorally
+// compatible with the bundled ones:
+//
+//	func TestConformance(t *testing.T) {
+//		queuetest.Run(t, func(t *testing.T, opt *taskq.QueueOptions) taskq.Queue {
+//			return mybackend.NewQueue(opt)
+//		})
+//	}
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// How long the suite waits for messages to be processed.
+const timeout = 30 * time.Second
+
+// NewQueueFunc returns a new empty queue with the options. The options
+// have Name and Handler set; the function may set other options,
+// e.g. Redis or Storage, before creating the queue.
+type NewQueueFunc func(t *testing.T, opt *taskq.QueueOptions) taskq.Queue
+
+// Run runs the conformance tests against queues returned by newQueue.
+// Tests of reserve, release and delete are skipped for queues that
+// don't support ReserveN.
+func Run(t *testing.T, newQueue NewQueueFunc) {
+	s := &suite{
+		newQueue: newQueue,
+		prefix:   fmt.Sprintf("queuetest-%d", time.Now().UnixNano()),
+	}
+
+	t.Run("Consume", s.testConsume)
+	t.Run("Retry", s.testRetry)
+	t.Run("Delay", s.testDelay)
+	t.Run("Dedup", s.testDedup)
+	t.Run("AddN", s.testAddN)
+	t.Run("ReserveRelease", s.testReserveRelease)
+	t.Run("ReserveDelete", s.testReserveDelete)
+}
+
+type suite struct {
+	newQueue NewQueueFunc
+	prefix   string
+}
+
+func (s *suite) queue(t *testing.T, registry *taskq.TaskMap) taskq.Queue {
+	name := t.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	q := s.newQueue(t, &taskq.QueueOptions{
+		Name:    s.prefix + "-" + strings.ToLower(name),
+		Handler: registry,
+	})
+	t.Cleanup(func() {
+		_ = q.Close()
+	})
+	return q
+}
+
+func startConsumer(q taskq.Queue) {
+	// Some backends start consumers when queues are created.
+	_ = q.Consumer().Start(context.Background())
+}
+
+func (s *suite) testConsume(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	ch := make(chan string, 10)
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name: "consume",
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+
+	q := s.queue(t, registry)
+	if err := q.Add(task.WithArgs(ctx, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	startConsumer(q)
+
+	select {
+	case got := <-ch:
+		if got != "hello" {
+			t.Fatalf("got %q, wanted %q", got, "hello")
+		}
+	case <-time.After(timeout):
+		t.Fatal("message is not processed")
+	}
+}
+
+func (s *suite) testRetry(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	var count uint32
+	ch := make(chan int, 10)
+	fallbackCh := make(chan struct{}, 10)
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name:       "retry",
+		RetryLimit: 2,
+		MinBackoff: time.Second,
+		Handler: func(msg *taskq.Message) error {
+			atomic.AddUint32(&count, 1)
+			ch <- msg.ReservedCount
+			return errors.New("fake error")
+		},
+		FallbackHandler: func() {
+			fallbackCh <- struct{}{}
+		},
+	})
+
+	q := s.queue(t, registry)
+	if err := q.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	startConsumer(q)
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case got := <-ch:
+			if got != i {
+				t.Fatalf("got ReservedCount=%d, wanted %d", got, i)
+			}
+		case <-time.After(timeout):
+			t.Fatalf("message is not processed %d times", i)
+		}
+	}
+
+	select {
+	case <-fallbackCh:
+	case <-time.After(timeout):
+		t.Fatal("FallbackHandler is not called")
+	}
+	if n := atomic.LoadUint32(&count); n != 2 {
+		t.Fatalf("handler is called %d times, wanted 2", n)
+	}
+}
+
+func (s *suite) testDelay(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	ch := make(chan time.Time, 10)
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name: "delay",
+		Handler: func() {
+			ch <- time.Now()
+		},
+	})
+
+	q := s.queue(t, registry)
+	startConsumer(q)
+
+	const delay = 2 * time.Second
+	start := time.Now()
+	msg := task.WithArgs(ctx)
+	msg.Delay = delay
+	if err := q.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case tm := <-ch:
+		if d := tm.Sub(start); d < delay {
+			t.Fatalf("message is processed after %s, wanted at least %s", d, delay)
+		}
+	case <-time.After(timeout):
+		t.Fatal("message is not processed")
+	}
+}
+
+func (s *suite) testDedup(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	var count uint32
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name: "dedup",
+		Handler: func() {
+			atomic.AddUint32(&count, 1)
+		},
+	})
+
+	q := s.queue(t, registry)
+	name := s.prefix + "-dedup"
+
+	msg := task.WithArgs(ctx)
+	msg.Name = name
+	if err := q.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := task.WithArgs(ctx)
+	dup.Name = name
+	if err := q.Add(dup); err != nil {
+		t.Fatal(err)
+	}
+	if dup.Err != taskq.ErrDuplicate {
+		t.Fatalf("got %v, wanted ErrDuplicate", dup.Err)
+	}
+
+	startConsumer(q)
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadUint32(&count) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(time.Second)
+	if n := atomic.LoadUint32(&count); n != 1 {
+		t.Fatalf("handler is called %d times, wanted 1", n)
+	}
+}
+
+func (s *suite) testAddN(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	ch := make(chan int, 10)
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name: "addn",
+		Handler: func(n int) {
+			ch <- n
+		},
+	})
+
+	q := s.queue(t, registry)
+	msgs := make([]*taskq.Message, 3)
+	for i := range msgs {
+		msgs[i] = task.WithArgs(ctx, i)
+	}
+	dup := task.WithArgs(ctx, 0)
+	msgs[0].Name = s.prefix + "-addn"
+	dup.Name = msgs[0].Name
+
+	if err := q.AddN(ctx, append(msgs, dup)...); err != nil {
+		t.Fatal(err)
+	}
+	if dup.Err != taskq.ErrDuplicate {
+		t.Fatalf("got %v, wanted ErrDuplicate", dup.Err)
+	}
+	startConsumer(q)
+
+	seen := make(map[int]bool)
+	for len(seen) < len(msgs) {
+		select {
+		case n := <-ch:
+			seen[n] = true
+		case <-time.After(timeout):
+			t.Fatalf("got %d messages, wanted %d", len(seen), len(msgs))
+		}
+	}
+}
+
+func (s *suite) testReserveRelease(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name:    "reserve-release",
+		Handler: func(string) {},
+	})
+
+	q := s.queue(t, registry)
+	if err := q.Add(task.WithArgs(ctx, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := reserveOne(t, q)
+	if msg.TaskName != task.Name() {
+		t.Fatalf("got task=%q, wanted %q", msg.TaskName, task.Name())
+	}
+
+	msg.Delay = 0
+	if err := q.Release(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msg = reserveOne(t, q)
+	if msg.TaskName != task.Name() {
+		t.Fatalf("got task=%q, wanted %q", msg.TaskName, task.Name())
+	}
+	if err := q.Delete(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *suite) testReserveDelete(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name:    "reserve-delete",
+		Handler: func() {},
+	})
+
+	q := s.queue(t, registry)
+	if err := q.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := reserveOne(t, q)
+	if err := q.Delete(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := q.ReserveN(ctx, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("got %d messages after Delete, wanted 0", len(msgs))
+	}
+}
+
+// reserveOne reserves a message skipping the test
+// if the queue doesn't support ReserveN.
+func reserveOne(t *testing.T, q taskq.Queue) *taskq.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		msgs, err := q.ReserveN(context.Background(), 1, time.Second)
+		if errors.Is(err, taskq.ErrNotSupported) {
+			t.Skipf("%s doesn't support ReserveN", q)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) == 1 {
+			return &msgs[0]
+		}
+		if len(msgs) > 1 {
+			t.Fatalf("got %d messages, wanted 1", len(msgs))
+		}
+	}
+	t.Fatal("message is not reserved")
+	return nil
+}
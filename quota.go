@@ -0,0 +1,315 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redis_rate/v9"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// ProducerHeader is the message header that holds the name of the producer
+// identity supplied with ContextWithIdentity. It is set when the message is
+// added, so quotas can be enforced per producer with QuotaOptions.TenantHeader.
+const ProducerHeader = "taskq-producer"
+
+// QuotaKeyHeader is the message header that holds the storage key of the
+// backlog the message is counted in. The key is assigned when the message
+// is added to a queue with a backlog quota.
+const QuotaKeyHeader = "taskq-quota-key"
+
+// Backlog counters expire when no messages are added for that long,
+// so counters of purged queues eventually heal.
+const quotaBacklogTTL = 24 * time.Hour
+
+// ErrQuotaExceeded matches errors returned when a message exceeds
+// the quota of its queue or tenant.
+var ErrQuotaExceeded = errors.New("taskq: quota exceeded")
+
+// QuotaLimit is the limit of a quota that was exceeded.
+type QuotaLimit int
+
+const (
+	// QuotaRate is exceeded when messages are added faster than Quota.Rate.
+	QuotaRate QuotaLimit = iota + 1
+	// QuotaBacklog is exceeded when there are Quota.MaxBacklog messages
+	// that are added but not processed yet.
+	QuotaBacklog
+)
+
+func (l QuotaLimit) String() string {
+	switch l {
+	case QuotaRate:
+		return "rate"
+	case QuotaBacklog:
+		return "backlog"
+	}
+	return fmt.Sprintf("QuotaLimit(%d)", int(l))
+}
+
+// QuotaExceededError is returned by Queue.Add when the message exceeds
+// the quota. Use errors.As to get it and errors.Is with ErrQuotaExceeded
+// to check for it.
+type QuotaExceededError struct {
+	Queue string
+	// Tenant of the message or empty when the quota is per queue.
+	Tenant string
+	Limit  QuotaLimit
+	// Time after which the rate quota allows the message again.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	s := fmt.Sprintf("taskq: %s quota exceeded for queue=%q", e.Limit, e.Queue)
+	if e.Tenant != "" {
+		s += fmt.Sprintf(" tenant=%q", e.Tenant)
+	}
+	if e.RetryAfter > 0 {
+		s += fmt.Sprintf(" (retry after %s)", e.RetryAfter)
+	}
+	return s
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Quota limits how messages are added to a queue.
+type Quota struct {
+	// Maximum rate of adding messages. Default is 0 (unlimited).
+	Rate redis_rate.Limit
+	// Maximum number of messages that are added, but not processed
+	// or failed permanently yet. Default is 0 (unlimited).
+	MaxBacklog int
+}
+
+func (q Quota) IsZero() bool {
+	return q.Rate.IsZero() && q.MaxBacklog == 0
+}
+
+// QuotaOptions configures quotas enforced by Queue.Add.
+// Storage must implement QuotaStorage.
+type QuotaOptions struct {
+	// Message header that identifies the tenant. Each tenant has its own
+	// quota. Default is empty, i.e. the queue has a single quota.
+	TenantHeader string
+	// Quota of the queue or of tenants missing in Tenants.
+	Default Quota
+	// Quotas of specific tenants.
+	Tenants map[string]Quota
+	// Messages that exceed the rate quota are delayed until the quota
+	// allows them if that takes at most MaxDelay. Default is 0, i.e. such
+	// messages are rejected. Backlog quotas always reject messages.
+	MaxDelay time.Duration
+}
+
+// Tenant returns the tenant of the message.
+func (opt *QuotaOptions) Tenant(msg *Message) string {
+	if opt.TenantHeader == "" {
+		return ""
+	}
+	return msg.Headers[opt.TenantHeader]
+}
+
+// Quota returns the quota of the tenant.
+func (opt *QuotaOptions) Quota(tenant string) Quota {
+	if quota, ok := opt.Tenants[tenant]; ok {
+		return quota
+	}
+	return opt.Default
+}
+
+// QuotaStorage is implemented by storages that can enforce quotas.
+// Both built-in storages implement it.
+type QuotaStorage interface {
+	// QuotaReserve reserves n messages at the rate. When the rate is
+	// exceeded, the messages are reserved in the future if that is at most
+	// maxDelay from now. It returns the delay of the reservation, or false
+	// and the time to wait when nothing was reserved.
+	QuotaReserve(
+		ctx context.Context, key string, rate redis_rate.Limit, n int, maxDelay time.Duration,
+	) (time.Duration, bool, error)
+	// QuotaAcquire adds n messages to the backlog unless that exceeds max.
+	QuotaAcquire(ctx context.Context, key string, n, max int) (bool, error)
+	// QuotaRelease removes n messages from the backlog.
+	QuotaRelease(ctx context.Context, key string, n int) error
+}
+
+// QuotaKey returns the storage key of the quota of the tenant in the queue.
+func QuotaKey(q Queue, tenant string) string {
+	return "taskq:{" + internal.Namespaced(q.Options().Namespace, ":", q.Name()) +
+		"}:quota:" + tenant
+}
+
+func (c *Consumer) quotaFinished(msg *Message) {
+	key := msg.Headers[QuotaKeyHeader]
+	if key == "" {
+		return
+	}
+	storage, ok := c.opt.Storage.(QuotaStorage)
+	if !ok {
+		return
+	}
+	if err := storage.QuotaRelease(msgContext(msg), key, 1); err != nil {
+		internal.Logger.Printf("taskq: QuotaRelease failed: %s", err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var _ QuotaStorage = (*localStorage)(nil)
+var _ QuotaStorage = (*redisStorage)(nil)
+
+type localQuota struct {
+	mu      sync.Mutex
+	backlog map[string]int
+	tat     map[string]time.Time // theoretical arrival time of GCRA
+}
+
+func (s *localStorage) QuotaReserve(
+	_ context.Context, key string, rate redis_rate.Limit, n int, maxDelay time.Duration,
+) (time.Duration, bool, error) {
+	if rate.IsZero() {
+		return 0, true, nil
+	}
+
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	if s.quota.tat == nil {
+		s.quota.tat = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	interval := rate.Period / time.Duration(rate.Rate)
+
+	tat := s.quota.tat[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(n) * interval)
+	allowAt := newTat.Add(-time.Duration(rate.Burst) * interval)
+	delay := allowAt.Sub(now)
+	if delay > maxDelay {
+		return delay, false, nil
+	}
+	s.quota.tat[key] = newTat
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true, nil
+}
+
+func (s *localStorage) QuotaAcquire(_ context.Context, key string, n, max int) (bool, error) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	if s.quota.backlog == nil {
+		s.quota.backlog = make(map[string]int)
+	}
+	if max > 0 && s.quota.backlog[key]+n > max {
+		return false, nil
+	}
+	s.quota.backlog[key] += n
+	return true, nil
+}
+
+func (s *localStorage) QuotaRelease(_ context.Context, key string, n int) error {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	if s.quota.backlog[key] <= n {
+		delete(s.quota.backlog, key)
+	} else {
+		s.quota.backlog[key] -= n
+	}
+	return nil
+}
+
+var quotaAcquireScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local max = tonumber(ARGV[2])
+local backlog = tonumber(redis.call("get", KEYS[1]) or "0")
+if max > 0 and backlog + n > max then
+  return 0
+end
+redis.call("incrby", KEYS[1], n)
+redis.call("pexpire", KEYS[1], ARGV[3])
+return 1
+`)
+
+// quotaReserveScript implements the same GCRA as localStorage.QuotaReserve.
+// The theoretical arrival time is stored in milliseconds.
+var quotaReserveScript = redis.NewScript(`
+redis.replicate_commands()
+
+local now = redis.call("time")
+now = tonumber(now[1]) * 1000 + tonumber(now[2]) / 1000
+
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local max_delay = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call("get", KEYS[1]) or "0")
+if tat < now then
+  tat = now
+end
+local new_tat = tat + n * interval
+local delay = new_tat - burst * interval - now
+if delay > max_delay then
+  return {0, math.ceil(delay)}
+end
+
+redis.call("set", KEYS[1], tostring(new_tat), "px", math.ceil(new_tat - now) + 1)
+if delay < 0 then
+  delay = 0
+end
+return {1, math.ceil(delay)}
+`)
+
+var quotaReleaseScript = redis.NewScript(`
+local backlog = redis.call("decrby", KEYS[1], ARGV[1])
+if backlog <= 0 then
+  redis.call("del", KEYS[1])
+end
+return 1
+`)
+
+func (s *redisStorage) QuotaReserve(
+	ctx context.Context, key string, rate redis_rate.Limit, n int, maxDelay time.Duration,
+) (time.Duration, bool, error) {
+	if rate.IsZero() {
+		return 0, true, nil
+	}
+	interval := float64(rate.Period/time.Millisecond) / float64(rate.Rate)
+	vals, err := quotaReserveScript.Run(ctx, s.redis, []string{key + ":rate"},
+		interval, rate.Burst, n, int64(maxDelay/time.Millisecond)).Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(vals) != 2 {
+		return 0, false, fmt.Errorf("taskq: unexpected QuotaReserve reply: %v", vals)
+	}
+	ok, _ := vals[0].(int64)
+	delay, _ := vals[1].(int64)
+	return time.Duration(delay) * time.Millisecond, ok == 1, nil
+}
+
+func (s *redisStorage) QuotaAcquire(ctx context.Context, key string, n, max int) (bool, error) {
+	ttl := int64(quotaBacklogTTL / time.Millisecond)
+	ok, err := quotaAcquireScript.Run(ctx, s.redis, []string{key}, n, max, ttl).Int()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+func (s *redisStorage) QuotaRelease(ctx context.Context, key string, n int) error {
+	return quotaReleaseScript.Run(ctx, s.redis, []string{key}, n).Err()
+}
@@ -0,0 +1,173 @@
+package taskq
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// RecordedMessage describes a message processed by a consumer.
+type RecordedMessage struct {
+	ID       string
+	Name     string
+	TaskName string
+	Args     []interface{}
+	// The number of times the message has been reserved.
+	ReservedCount int
+	// Error returned by the handler or nil on success.
+	Err       error
+	StartTime time.Time
+	Duration  time.Duration
+}
+
+// Recorder is a ConsumerHook that records processed messages into a ring
+// buffer, e.g. to verify complex enqueue flows end-to-end in tests or to
+// inspect recent messages with a debug endpoint:
+//
+//	rec := taskq.NewRecorder(1000)
+//	q.Consumer().AddHook(rec)
+//	http.Handle("/debug/taskq", rec)
+type Recorder struct {
+	mu   sync.Mutex
+	buf  []RecordedMessage
+	next int
+	full bool
+}
+
+var _ ConsumerHook = (*Recorder)(nil)
+
+// NewRecorder returns a recorder that keeps the last size messages.
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		panic("taskq: recorder size must be positive")
+	}
+	return &Recorder{
+		buf: make([]RecordedMessage, size),
+	}
+}
+
+func (r *Recorder) BeforeProcessMessage(evt *ProcessMessageEvent) error {
+	return nil
+}
+
+func (r *Recorder) AfterProcessMessage(evt *ProcessMessageEvent) error {
+	msg := evt.Message
+	rec := RecordedMessage{
+		ID:            msg.ID,
+		Name:          msg.Name,
+		TaskName:      msg.TaskName,
+		Args:          recordedArgs(msg),
+		ReservedCount: msg.ReservedCount,
+		Err:           msg.Err,
+		StartTime:     evt.StartTime,
+		Duration:      time.Since(evt.StartTime),
+	}
+
+	r.mu.Lock()
+	r.buf[r.next] = rec
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+func recordedArgs(msg *Message) []interface{} {
+	if msg.Args != nil {
+		return msg.Args
+	}
+	b, err := msg.MarshalArgs()
+	if err != nil {
+		return nil
+	}
+	var args []interface{}
+	_ = msgpack.Unmarshal(b, &args)
+	return args
+}
+
+// Messages returns recorded messages from the oldest to the newest.
+func (r *Recorder) Messages() []RecordedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]RecordedMessage(nil), r.buf[:r.next]...)
+	}
+	msgs := make([]RecordedMessage, 0, len(r.buf))
+	msgs = append(msgs, r.buf[r.next:]...)
+	msgs = append(msgs, r.buf[:r.next]...)
+	return msgs
+}
+
+// Find returns recorded messages of the task from the oldest to the newest.
+func (r *Recorder) Find(taskName string) []RecordedMessage {
+	var msgs []RecordedMessage
+	for _, msg := range r.Messages() {
+		if msg.TaskName == taskName {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Reset removes recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.buf {
+		r.buf[i] = RecordedMessage{}
+	}
+	r.next = 0
+	r.full = false
+}
+
+// ServeHTTP writes recorded messages as JSON. The optional "task"
+// query parameter limits messages to the task.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	type jsonMessage struct {
+		ID            string        `json:"id,omitempty"`
+		Name          string        `json:"name,omitempty"`
+		TaskName      string        `json:"task"`
+		Args          []interface{} `json:"args"`
+		ReservedCount int           `json:"reserved_count"`
+		Error         string        `json:"error,omitempty"`
+		StartTime     time.Time     `json:"start_time"`
+		Duration      float64       `json:"duration_ms"`
+	}
+
+	var msgs []RecordedMessage
+	if task := req.URL.Query().Get("task"); task != "" {
+		msgs = r.Find(task)
+	} else {
+		msgs = r.Messages()
+	}
+
+	out := make([]jsonMessage, len(msgs))
+	for i := range msgs {
+		msg := &msgs[i]
+		out[i] = jsonMessage{
+			ID:            msg.ID,
+			Name:          msg.Name,
+			TaskName:      msg.TaskName,
+			Args:          msg.Args,
+			ReservedCount: msg.ReservedCount,
+			StartTime:     msg.StartTime,
+			Duration:      float64(msg.Duration) / float64(time.Millisecond),
+		}
+		if msg.Err != nil {
+			out[i].Error = msg.Err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
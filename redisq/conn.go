@@ -0,0 +1,128 @@
+package redisq
+
+import (
+	"context"
+	"crypto/tls"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// ConnFactory creates a Redis client for the queue. It is used by the factory
+// for queues registered without QueueOptions.Redis.
+type ConnFactory func(opt *taskq.QueueOptions) (redis.UniversalClient, error)
+
+type ConnOptions struct {
+	// Redis addresses. A single address creates a standalone client,
+	// multiple addresses create a cluster client.
+	Addrs []string
+	// Sentinel master name. When set Addrs are addresses of sentinels.
+	MasterName string
+
+	// ACL user name and password.
+	Username string
+	Password string
+	DB       int
+
+	// TLS config. TLS is disabled when nil.
+	TLSConfig *tls.Config
+
+	// Prefix of the client name set with CLIENT SETNAME.
+	// The queue name is appended to the prefix, e.g. "taskq:emails".
+	// Default is "taskq".
+	ClientName string
+
+	// Maximum number of connections per queue.
+	// Default is 10 connections per every available CPU.
+	PoolSize int
+}
+
+// NewConnFactory returns a ConnFactory that creates a separate client for every
+// queue so connections can be identified in CLIENT LIST by the queue name.
+func NewConnFactory(connOpt *ConnOptions) ConnFactory {
+	return func(opt *taskq.QueueOptions) (redis.UniversalClient, error) {
+		prefix := connOpt.ClientName
+		if prefix == "" {
+			prefix = "taskq"
+		}
+		name := prefix + ":" + opt.Name
+
+		return redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:      connOpt.Addrs,
+			MasterName: connOpt.MasterName,
+			Username:   connOpt.Username,
+			Password:   connOpt.Password,
+			DB:         connOpt.DB,
+			TLSConfig:  connOpt.TLSConfig,
+			PoolSize:   connOpt.PoolSize,
+			OnConnect: func(ctx context.Context, cn *redis.Conn) error {
+				return cn.ClientSetName(ctx, name).Err()
+			},
+		}), nil
+	}
+}
+
+// scanQueueNames returns names of the queues in the namespace
+// with streams on all Redis nodes.
+func scanQueueNames(
+	ctx context.Context, client redis.UniversalClient, namespace string,
+) ([]string, error) {
+	const suffix = "}:stream"
+	prefix := internal.Namespaced(namespace, ":", "taskq:{")
+
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, prefix+"*"+suffix, 1000).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			name := key[len(prefix) : len(key)-len(suffix)]
+			if strings.Contains(name, "}") {
+				// Stream of a consumer group.
+				continue
+			}
+			mu.Lock()
+			seen[name] = struct{}{}
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		err = c.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	case *redis.Ring:
+		err = c.ForEachShard(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	default:
+		err = scan(ctx, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ConnStats returns connection pool statistics of the Redis client
+// or nil when the client does not expose them.
+func (q *Queue) ConnStats() *redis.PoolStats {
+	if c, ok := q.redis.(interface{ PoolStats() *redis.PoolStats }); ok {
+		return c.PoolStats()
+	}
+	return nil
+}
@@ -2,6 +2,11 @@ package redisq
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
 
 	"github.com/frain-dev/taskq/v3"
 	"github.com/frain-dev/taskq/v3/internal/base"
@@ -9,6 +14,10 @@ import (
 
 type factory struct {
 	base base.Factory
+
+	conn    ConnFactory
+	mu      sync.Mutex
+	clients []redis.UniversalClient
 }
 
 var _ taskq.Factory = (*factory)(nil)
@@ -17,7 +26,29 @@ func NewFactory() taskq.Factory {
 	return &factory{}
 }
 
+// NewFactoryWithConn returns a factory that uses conn to create Redis clients
+// for queues registered without QueueOptions.Redis. The clients are closed
+// when the factory is closed.
+func NewFactoryWithConn(conn ConnFactory) taskq.Factory {
+	return &factory{
+		conn: conn,
+	}
+}
+
 func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
+	f.base.InitOptions(opt)
+	if opt.Redis == nil && f.conn != nil {
+		client, err := f.conn(opt)
+		if err != nil {
+			panic(err)
+		}
+		opt.Redis = client
+
+		f.mu.Lock()
+		f.clients = append(f.clients, client)
+		f.mu.Unlock()
+	}
+
 	q := NewQueue(opt)
 	if err := f.base.Register(q); err != nil {
 		panic(err)
@@ -25,18 +56,113 @@ func (f *factory) RegisterQueue(opt *taskq.QueueOptions) taskq.Queue {
 	return q
 }
 
+func (f *factory) UnregisterQueue(name string) error {
+	q, err := f.base.Unregister(name)
+	if err != nil {
+		return err
+	}
+	_ = q.Close()
+	return q.(*Queue).deleteKeys()
+}
+
+// Discover returns names of the queues that have a stream in Redis.
+// It uses the connection factory or the client of a registered queue
+// and only returns the queues in the namespace of the registered queues.
+func (f *factory) Discover(
+	ctx context.Context, register func(name string) *taskq.QueueOptions,
+) ([]string, error) {
+	client, err := f.discoverClient()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := f.base.Namespace()
+	names, err := scanQueueNames(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	f.base.RegisterDiscovered(namespace, names, register, f.RegisterQueue)
+	return names, nil
+}
+
+func (f *factory) discoverClient() (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	f.base.Range(func(q taskq.Queue) bool {
+		client, _ = q.Options().Redis.(redis.UniversalClient)
+		return client == nil
+	})
+	if client != nil {
+		return client, nil
+	}
+
+	if f.conn == nil {
+		return nil, errors.New("redisq: Discover requires a registered queue or NewFactoryWithConn")
+	}
+	client, err := f.conn(&taskq.QueueOptions{Name: "discover"})
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.clients = append(f.clients, client)
+	f.mu.Unlock()
+
+	return client, nil
+}
+
 func (f *factory) Range(fn func(taskq.Queue) bool) {
 	f.base.Range(fn)
 }
 
+func (f *factory) SetRegistry(r *taskq.TaskMap) {
+	f.base.SetRegistry(r)
+}
+
+func (f *factory) Stats() *taskq.FactoryStats {
+	return f.base.Stats()
+}
+
+func (f *factory) OnQueueRegister(fn func(taskq.Queue)) {
+	f.base.OnQueueRegister(fn)
+}
+
+func (f *factory) OnConsumerStart(fn func(taskq.Queue)) {
+	f.base.OnConsumerStart(fn)
+}
+
+func (f *factory) OnConsumerStop(fn func(taskq.Queue)) {
+	f.base.OnConsumerStop(fn)
+}
+
 func (f *factory) StartConsumers(ctx context.Context) error {
 	return f.base.StartConsumers(ctx)
 }
 
-func (f *factory) StopConsumers() error {
-	return f.base.StopConsumers()
+func (f *factory) StopConsumers(ctx context.Context) error {
+	return f.base.StopConsumers(ctx)
+}
+
+func (f *factory) PauseConsumers(ctx context.Context, d time.Duration) error {
+	return f.base.PauseConsumers(ctx, d)
+}
+
+func (f *factory) ResumeConsumers(ctx context.Context) error {
+	return f.base.ResumeConsumers(ctx)
 }
 
 func (f *factory) Close() error {
-	return f.base.Close()
+	firstErr := f.base.Close()
+
+	f.mu.Lock()
+	clients := f.clients
+	f.clients = nil
+	f.mu.Unlock()
+
+	for _, client := range clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -0,0 +1,215 @@
+package redisq
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+const failoverRetries = 5
+
+// NewSentinelClient returns a Redis client that uses Redis Sentinel to discover
+// the current master and automatically reconnects to the new master on failover.
+func NewSentinelClient(opt *redis.FailoverOptions) *redis.Client {
+	if opt.MaxRetries == 0 {
+		opt.MaxRetries = failoverRetries
+	}
+	if opt.MinRetryBackoff == 0 {
+		opt.MinRetryBackoff = 100 * time.Millisecond
+	}
+	if opt.MaxRetryBackoff == 0 {
+		opt.MaxRetryBackoff = time.Second
+	}
+	return redis.NewFailoverClient(opt)
+}
+
+// isFailoverError reports whether the error is caused by a master failover,
+// i.e. the operation may succeed after the client reconnects to the new master.
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	s := err.Error()
+	for _, prefix := range []string{"READONLY ", "LOADING ", "MASTERDOWN ", "TRYAGAIN "} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(s, "connection refused") ||
+		strings.Contains(s, "connection reset")
+}
+
+// withFailoverRetry calls fn until it succeeds, fails with an error that is not
+// caused by a failover, or the number of retries is exhausted.
+func withFailoverRetry(ctx context.Context, fn func() error) error {
+	var err error
+	for i := 0; i < failoverRetries; i++ {
+		if i > 0 {
+			if err := sleep(ctx, failoverBackoff(i)); err != nil {
+				return err
+			}
+		}
+
+		err = fn()
+		if !isFailoverError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func failoverBackoff(retry int) time.Duration {
+	d := 100 * time.Millisecond << uint(retry-1)
+	if d > 2*time.Second {
+		return 2 * time.Second
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type failedOpKind int
+
+const (
+	failedRelease failedOpKind = iota
+	failedDelete
+)
+
+type failedOp struct {
+	kind  failedOpKind
+	id    string
+	name  string
+	delay time.Duration
+	body  []byte // marshaled message to re-add on release
+}
+
+// newFailedOp copies the fields needed to replay the operation, because
+// the consumer reuses or releases the message once Release or Delete returns.
+func newFailedOp(kind failedOpKind, msg *taskq.Message) (failedOp, error) {
+	op := failedOp{
+		kind:  kind,
+		id:    msg.ID,
+		name:  msg.Name,
+		delay: msg.Delay,
+	}
+	if kind == failedRelease {
+		b, err := msg.MarshalBinary()
+		if err != nil {
+			return failedOp{}, err
+		}
+		op.body = append([]byte(nil), b...)
+	}
+	return op, nil
+}
+
+func (op *failedOp) message(ctx context.Context) (*taskq.Message, error) {
+	msg := new(taskq.Message)
+	if op.body != nil {
+		if err := msg.UnmarshalBinary(op.body); err != nil {
+			return nil, err
+		}
+	}
+	msg.Ctx = ctx
+	msg.ID = op.id
+	msg.Name = op.name
+	msg.Delay = op.delay
+	return msg, nil
+}
+
+// failedOps keeps Release and Delete operations that failed during failover
+// so they can be retried once the client reconnects to the new master.
+type failedOps struct {
+	mu  sync.Mutex
+	ops []failedOp
+}
+
+func (f *failedOps) Add(ops ...failedOp) {
+	f.mu.Lock()
+	f.ops = append(f.ops, ops...)
+	f.mu.Unlock()
+}
+
+func (f *failedOps) Take() []failedOp {
+	f.mu.Lock()
+	ops := f.ops
+	f.ops = nil
+	f.mu.Unlock()
+	return ops
+}
+
+// retryFailedLoop retries operations that failed during failover.
+// Unlike schedulers it does not use a lock, because failed operations
+// are local to the process.
+func (q *Queue) retryFailedLoop() {
+	for !q.closed() {
+		n, err := q.retryFailedOps(context.TODO())
+		if err != nil {
+			internal.Logger.Printf("redisq: retry_failed failed: %s", err)
+		}
+		if err != nil || n == 0 {
+			time.Sleep(q.schedulerBackoff())
+		}
+	}
+}
+
+func (q *Queue) retryFailedOps(ctx context.Context) (int, error) {
+	ops := q.failed.Take()
+	for i := range ops {
+		op := &ops[i]
+
+		msg, err := op.message(ctx)
+		if err != nil {
+			internal.Logger.Printf("redisq: dropping failed operation on message=%s: %s", op.id, err)
+			continue
+		}
+
+		switch op.kind {
+		case failedRelease:
+			err = q.release(msg)
+		case failedDelete:
+			err = q.delete(msg)
+		}
+		if err != nil {
+			q.failed.Add(ops[i:]...)
+			return i, err
+		}
+	}
+	if len(ops) > 0 {
+		internal.Logger.Printf("redisq: %s retried %d operations after failover", q, len(ops))
+	}
+	return len(ops), nil
+}
@@ -0,0 +1,132 @@
+package redisq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestIsFailoverError(t *testing.T) {
+	tests := []struct {
+		err      error
+		failover bool
+	}{
+		{nil, false},
+		{redis.Nil, false},
+		{context.Canceled, false},
+		{errors.New("ERR wrong number of arguments"), false},
+		{io.EOF, true},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("i/o timeout")}, true},
+		{errors.New("READONLY You can't write against a read only replica."), true},
+		{errors.New("LOADING Redis is loading the dataset in memory"), true},
+		{errors.New("MASTERDOWN Link with MASTER is down"), true},
+		{errors.New("TRYAGAIN Multiple keys request during rehashing"), true},
+		{errors.New("dial tcp 127.0.0.1:6379: connect: connection refused"), true},
+		{errors.New("read tcp 127.0.0.1:6379: connection reset by peer"), true},
+	}
+	for _, test := range tests {
+		if got := isFailoverError(test.err); got != test.failover {
+			t.Errorf("isFailoverError(%v) = %t, wanted %t", test.err, got, test.failover)
+		}
+	}
+}
+
+func TestWithFailoverRetry(t *testing.T) {
+	readonly := errors.New("READONLY You can't write against a read only replica.")
+	other := errors.New("ERR unknown command")
+
+	tests := []struct {
+		name    string
+		errs    []error // returned by consecutive calls, the last one repeats
+		calls   int
+		wantErr error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"other error", []error{other}, 1, other},
+		{"failover then success", []error{readonly, nil}, 2, nil},
+		{"failover then other error", []error{io.EOF, other}, 2, other},
+		{"retries exhausted", []error{readonly}, failoverRetries, readonly},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			err := withFailoverRetry(context.Background(), func() error {
+				i := calls
+				if i >= len(test.errs) {
+					i = len(test.errs) - 1
+				}
+				calls++
+				return test.errs[i]
+			})
+			if err != test.wantErr {
+				t.Fatalf("got error %v, wanted %v", err, test.wantErr)
+			}
+			if calls != test.calls {
+				t.Fatalf("got %d calls, wanted %d", calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestWithFailoverRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls int
+	err := withFailoverRetry(ctx, func() error {
+		calls++
+		cancel()
+		return io.EOF
+	})
+	if err != context.Canceled {
+		t.Fatalf("got error %v, wanted %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, wanted 1", calls)
+	}
+}
+
+func TestFailedOpCopiesMessage(t *testing.T) {
+	msg := taskq.NewMessage(context.Background(), "hello")
+	msg.ID = "1-0"
+	msg.Name = "name"
+	msg.Delay = time.Second
+	msg.TaskName = "task"
+
+	op, err := newFailedOp(failedRelease, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumer may reuse the message once Release returns.
+	msg.ID = "2-0"
+	msg.Name = "other"
+	msg.Delay = 0
+	msg.TaskName = "other"
+
+	ctx := context.Background()
+	replay, err := op.message(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay == msg {
+		t.Fatal("replayed message is the caller's message")
+	}
+	if replay.Ctx != ctx {
+		t.Fatal("replayed message does not use the retry context")
+	}
+	if replay.ID != "1-0" || replay.Name != "name" || replay.Delay != time.Second {
+		t.Fatalf("got id=%q name=%q delay=%s", replay.ID, replay.Name, replay.Delay)
+	}
+	if replay.TaskName != "task" || len(replay.ArgsBin) == 0 {
+		t.Fatalf("got task=%q args=%q", replay.TaskName, replay.ArgsBin)
+	}
+}
@@ -0,0 +1,107 @@
+package redisq
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// How long the list of consumer groups is cached by producers.
+const groupsCacheTTL = time.Second
+
+// Maximum interval between heartbeats of a consumer group.
+const groupHeartbeatInterval = time.Minute
+
+// groupKeys returns keys of the delayed messages ZSET and the stream
+// of the consumer group. The default group uses the queue keys.
+func groupKeys(prefix, name, group string) (zset, stream string) {
+	key := prefix + "{" + name + "}:"
+	if group != "" {
+		key += "group:" + group + ":"
+	}
+	return key + "zset", key + "stream"
+}
+
+type groupTarget struct {
+	zset   string
+	stream string
+}
+
+type groupCache struct {
+	mu        sync.Mutex
+	targets   []groupTarget
+	updatedAt time.Time
+}
+
+// heartbeatGroup registers the consumer group of the queue so producers
+// add messages to the group stream. The group is registered in a ZSET
+// scored by the time it expires, i.e. when none of its consumers reserved
+// messages for ConsumerIdleTimeout, so retired groups stop receiving
+// messages. It is called by every ReserveN, but writes at most once
+// per heartbeat interval.
+func (q *Queue) heartbeatGroup(ctx context.Context) {
+	now := time.Now()
+	interval := q.opt.ConsumerIdleTimeout / 4
+	if interval > groupHeartbeatInterval {
+		interval = groupHeartbeatInterval
+	}
+	if next := atomic.LoadInt64(&q.groupHeartbeatAt); now.UnixNano() < next {
+		return
+	}
+	atomic.StoreInt64(&q.groupHeartbeatAt, now.Add(interval).UnixNano())
+
+	err := q.redis.ZAdd(ctx, q.groupsKey, &redis.Z{
+		Score:  float64(unixMs(now.Add(q.opt.ConsumerIdleTimeout))),
+		Member: q.opt.Group,
+	}).Err()
+	if err != nil {
+		internal.Logger.Printf("redisq: %s: registering group failed: %s", q, err)
+	}
+}
+
+// groupTargets returns keys of all consumer groups the message must be added to.
+// Messages are added to the default group when no group is consuming the queue.
+func (q *Queue) groupTargets(ctx context.Context) ([]groupTarget, error) {
+	c := &q.groups
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.targets != nil && time.Since(c.updatedAt) < groupsCacheTTL {
+		return c.targets, nil
+	}
+
+	// Expired groups are removed, so they don't grow the fan-out forever.
+	now := strconv.FormatInt(unixMs(time.Now()), 10)
+	pipe := q.redis.Pipeline()
+	pipe.ZRemRangeByScore(ctx, q.groupsKey, "-inf", "("+now)
+	groupsCmd := pipe.ZRangeByScore(ctx, q.groupsKey, &redis.ZRangeBy{
+		Min: now,
+		Max: "+inf",
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		if c.targets != nil {
+			// Keep using the stale list.
+			return c.targets, nil
+		}
+		return nil, err
+	}
+	groups := groupsCmd.Val()
+	if len(groups) == 0 {
+		groups = []string{""}
+	}
+
+	targets := make([]groupTarget, len(groups))
+	for i, group := range groups {
+		targets[i].zset, targets[i].stream = groupKeys(q.keyPrefix, q.opt.Name, group)
+	}
+
+	c.targets = targets
+	c.updatedAt = time.Now()
+	return targets, nil
+}
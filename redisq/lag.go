@@ -0,0 +1,114 @@
+package redisq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+var _ taskq.LagReporter = (*Queue)(nil)
+
+// Lag returns the number of messages in the stream that are not delivered
+// to the consumer group, the number of delayed messages that are due,
+// and the age of the oldest of them.
+func (q *Queue) Lag(ctx context.Context) (*taskq.QueueLag, error) {
+	now := time.Now()
+	max := strconv.FormatInt(unixMs(now), 10)
+
+	pipe := q.redis.Pipeline()
+	lenCmd := pipe.XLen(ctx, q.stream)
+	groupsCmd := pipe.XInfoGroups(ctx, q.stream)
+	dueCmd := pipe.ZCount(ctx, q.zset, "-inf", max)
+	oldestDueCmd := pipe.ZRangeByScoreWithScores(ctx, q.zset, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: 1,
+	})
+	// XINFO GROUPS fails when the stream does not exist yet.
+	_, _ = pipe.Exec(ctx)
+
+	n, err := lenCmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	due, err := dueCmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	oldestDue, err := oldestDueCmd.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	lag := &taskq.QueueLag{
+		Queue:      q.opt.Name,
+		Ready:      int(n),
+		DelayedDue: int(due),
+	}
+
+	var oldest time.Time
+	if len(oldestDue) > 0 {
+		oldest = time.Unix(0, int64(oldestDue[0].Score)*int64(time.Millisecond))
+	}
+
+	if n > 0 {
+		groups, err := groupsCmd.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		lastID := "0-0"
+		for _, g := range groups {
+			if g.Name == q.streamGroup {
+				lag.Ready -= int(g.Pending)
+				lastID = g.LastDeliveredID
+				break
+			}
+		}
+		if lag.Ready < 0 {
+			lag.Ready = 0
+		}
+
+		if lag.Ready > 0 {
+			// The first entry may be the last delivered one.
+			msgs, err := q.redis.XRangeN(ctx, q.stream, lastID, "+", 2).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i := range msgs {
+				if msgs[i].ID == lastID {
+					continue
+				}
+				if tm, ok := streamIDTime(msgs[i].ID); ok && (oldest.IsZero() || tm.Before(oldest)) {
+					oldest = tm
+				}
+				break
+			}
+		}
+	}
+
+	if !oldest.IsZero() {
+		lag.OldestAge = now.Sub(oldest)
+		if lag.OldestAge < 0 {
+			lag.OldestAge = 0
+		}
+	}
+	return lag, nil
+}
+
+// streamIDTime returns the time when the stream entry was added.
+func streamIDTime(id string) (time.Time, bool) {
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		id = id[:i]
+	}
+	ms, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
@@ -0,0 +1,73 @@
+package redisq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+const maxDelayedWait = time.Minute
+
+// subscribeDelayed listens for notifications about added delayed messages
+// and wakes up the delayed scheduler.
+func (q *Queue) subscribeDelayed() {
+	ch := q.pubsub.Channel()
+	for range ch {
+		select {
+		case q.delayedCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyDelayed notifies schedulers that a delayed message was added.
+func (q *Queue) notifyDelayed(ctx context.Context, tm time.Time) {
+	if q.pubsub == nil {
+		return
+	}
+	ms := strconv.FormatInt(unixMs(tm), 10)
+	if err := q.redis.Publish(ctx, q.delayedChannel, ms).Err(); err != nil {
+		internal.Logger.Printf("redisq: Publish failed: %s", err)
+	}
+}
+
+// waitDelayed waits until the next delayed message is due or a new delayed
+// message is added. Without push fetching it falls back to polling.
+func (q *Queue) waitDelayed() {
+	if q.pubsub == nil {
+		time.Sleep(q.schedulerBackoff())
+		return
+	}
+
+	timer := time.NewTimer(q.nextDelayed(context.TODO()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-q.delayedCh:
+	}
+}
+
+func (q *Queue) nextDelayed(ctx context.Context) time.Duration {
+	zz, err := q.redis.ZRangeWithScores(ctx, q.zset, 0, 0).Result()
+	if err != nil && err != redis.Nil {
+		return q.schedulerBackoff()
+	}
+	if len(zz) == 0 {
+		return maxDelayedWait
+	}
+
+	tm := time.Unix(0, int64(zz[0].Score)*int64(time.Millisecond))
+	d := time.Until(tm)
+	if d < 0 {
+		return 0
+	}
+	if d > maxDelayedWait {
+		return maxDelayedWait
+	}
+	return d
+}
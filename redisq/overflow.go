@@ -0,0 +1,74 @@
+package redisq
+
+import (
+	"context"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// ensureRoom applies the overflow policy when the queue has no room
+// for n more messages within MaxLen. The check is not atomic so the queue
+// can temporarily exceed MaxLen when there are many concurrent producers.
+func (q *Queue) ensureRoom(ctx context.Context, n int) error {
+	if q.opt.MaxLen <= 0 {
+		return nil
+	}
+
+	switch q.opt.OverflowPolicy {
+	case taskq.OverflowDropOldest:
+		// The stream is trimmed when the message is added.
+		return nil
+	case taskq.OverflowBlock:
+		return q.waitRoom(ctx, n)
+	default:
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		full, err := q.full(ctx, n)
+		if err != nil {
+			return err
+		}
+		if full {
+			return taskq.ErrQueueFull
+		}
+		return nil
+	}
+}
+
+func (q *Queue) waitRoom(ctx context.Context, n int) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, q.opt.OverflowTimeout)
+	defer cancel()
+
+	const backoff = 100 * time.Millisecond
+	for {
+		full, err := q.full(ctx, n)
+		if err != nil {
+			return err
+		}
+		if !full {
+			return nil
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			if err := parent.Err(); err != nil {
+				return err
+			}
+			return taskq.ErrQueueFull
+		}
+	}
+}
+
+// full reports whether n more messages would exceed MaxLen.
+func (q *Queue) full(ctx context.Context, n int) (bool, error) {
+	pipe := q.redis.Pipeline()
+	xlen := pipe.XLen(ctx, q.stream)
+	zcard := pipe.ZCard(ctx, q.zset)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return int(xlen.Val()+zcard.Val())+n > q.opt.MaxLen, nil
+}
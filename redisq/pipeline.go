@@ -0,0 +1,125 @@
+package redisq
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type pipelineFunc func(pipe redis.Pipeliner) ([]redis.Cmder, error)
+
+type pipelineOp struct {
+	fn   pipelineFunc
+	cmds []redis.Cmder
+	done chan error
+}
+
+// pipeliner collects commands from concurrent callers and sends them
+// to Redis in a single pipeline when the pipeline is full or
+// the flush interval is elapsed.
+type pipeliner struct {
+	redis    RedisStreamClient
+	size     int
+	interval time.Duration
+
+	mu    sync.Mutex
+	ops   []*pipelineOp
+	timer *time.Timer
+}
+
+func newPipeliner(redis RedisStreamClient, size int, interval time.Duration) *pipeliner {
+	p := &pipeliner{
+		redis:    redis,
+		size:     size,
+		interval: interval,
+	}
+	p.timer = time.AfterFunc(time.Minute, p.flush)
+	p.timer.Stop()
+	return p
+}
+
+// Do adds the commands to the pipeline and waits until the pipeline
+// is executed or the context is done.
+func (p *pipeliner) Do(ctx context.Context, fn pipelineFunc) error {
+	done := make(chan error, 1)
+	op := &pipelineOp{
+		fn:   fn,
+		done: done,
+	}
+
+	p.mu.Lock()
+	p.ops = append(p.ops, op)
+	if len(p.ops) >= p.size {
+		ops := p.ops
+		p.ops = nil
+		p.timer.Stop()
+		p.mu.Unlock()
+
+		p.exec(ops)
+	} else {
+		if len(p.ops) == 1 {
+			p.timer.Reset(p.interval)
+		}
+		p.mu.Unlock()
+	}
+
+	if ctx == nil {
+		return <-done
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pipeliner) flush() {
+	p.mu.Lock()
+	ops := p.ops
+	p.ops = nil
+	p.mu.Unlock()
+
+	if len(ops) > 0 {
+		p.exec(ops)
+	}
+}
+
+func (p *pipeliner) exec(ops []*pipelineOp) {
+	pipe := p.redis.Pipeline()
+
+	var queued int
+	for _, op := range ops {
+		cmds, err := op.fn(pipe)
+		if err != nil {
+			op.done <- err
+			op.done = nil
+			continue
+		}
+		op.cmds = cmds
+		queued += len(cmds)
+	}
+
+	if queued > 0 {
+		// Errors are reported by the individual commands.
+		_, _ = pipe.Exec(context.TODO())
+	}
+
+	for _, op := range ops {
+		if op.done == nil {
+			continue
+		}
+		op.done <- firstCmdErr(op.cmds)
+	}
+}
+
+func firstCmdErr(cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package redisq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -25,6 +26,7 @@ const batchSize = 100
 
 type RedisStreamClient interface {
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Pipeline() redis.Pipeliner
 	TxPipeline() redis.Pipeliner
 
 	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
@@ -34,22 +36,55 @@ type RedisStreamClient interface {
 	XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd
 	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd
 	XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd
+	XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd
 	XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd
 	XTrim(ctx context.Context, key string, maxLen int64) *redis.IntCmd
 	XGroupDelConsumer(ctx context.Context, stream, group, consumer string) *redis.IntCmd
 
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+
 	ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd
 	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
 	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	ZCard(ctx context.Context, key string) *redis.IntCmd
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd
 	XInfoConsumers(ctx context.Context, key string, group string) *redis.XInfoConsumersCmd
+
+	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+
+	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// redisAdder is implemented by clients and pipelines.
+type redisAdder interface {
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+	ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd
 }
 
+var (
+	_ RedisStreamClient = (*redis.Client)(nil)
+	_ RedisStreamClient = (*redis.ClusterClient)(nil)
+	_ RedisStreamClient = (*redis.Ring)(nil)
+)
+
+// Queue is a Redis Streams based queue. All keys that belong to the same queue
+// use the queue name as a hash tag so the queue can be used with Redis Cluster.
 type Queue struct {
+	// 64-bit atomic values are first so they are aligned on 32-bit platforms.
+	groupHeartbeatAt int64 // unix nanoseconds of the next group heartbeat
+
 	opt *taskq.QueueOptions
 
 	consumer *taskq.Consumer
 
 	redis RedisStreamClient
+	pipe  *pipeliner
 	wg    sync.WaitGroup
 
 	zset                string
@@ -57,17 +92,33 @@ type Queue struct {
 	streamGroup         string
 	streamConsumer      string
 	schedulerLockPrefix string
+	delayedChannel      string
+
+	// Consumer groups that receive every added message.
+	groupsKey string
+	keyPrefix string
+	groups    groupCache
+
+	pubsub    *redis.PubSub
+	delayedCh chan struct{}
+
+	failed failedOps
+
+	reclaimed uint64 // atomic
 
 	_closed uint32
 }
 
 var _ taskq.Queue = (*Queue)(nil)
+var _ taskq.Toucher = (*Queue)(nil)
 
 func NewQueue(opt *taskq.QueueOptions) *Queue {
-	const redisPrefix = "taskq:"
-
 	if opt.WaitTimeout == 0 {
-		opt.WaitTimeout = time.Second
+		if opt.PushFetch {
+			opt.WaitTimeout = 5 * time.Second
+		} else {
+			opt.WaitTimeout = time.Second
+		}
 	}
 	opt.Init()
 	if opt.Redis == nil {
@@ -78,34 +129,56 @@ func NewQueue(opt *taskq.QueueOptions) *Queue {
 		panic(fmt.Errorf("redisq: Redis client must support streams"))
 	}
 
+	redisPrefix := internal.Namespaced(opt.Namespace, ":", "taskq:")
+	zset, stream := groupKeys(redisPrefix, opt.Name, opt.Group)
 	q := &Queue{
 		opt: opt,
 
 		redis: red,
 
-		zset:                redisPrefix + "{" + opt.Name + "}:zset",
-		stream:              redisPrefix + "{" + opt.Name + "}:stream",
+		zset:                zset,
+		stream:              stream,
+		groupsKey:           redisPrefix + "{" + opt.Name + "}:groups",
+		keyPrefix:           redisPrefix,
 		streamGroup:         "taskq",
 		streamConsumer:      consumer(),
-		schedulerLockPrefix: redisPrefix + opt.Name + ":scheduler-lock:",
+		schedulerLockPrefix: redisPrefix + "{" + opt.Name + "}:scheduler-lock:",
+		delayedChannel:      redisPrefix + "{" + opt.Name + "}:delayed",
+	}
+
+	if opt.PushFetch {
+		q.pubsub = red.Subscribe(context.TODO(), q.delayedChannel)
+		q.delayedCh = make(chan struct{}, 1)
+		go q.subscribeDelayed()
 	}
 
+	if opt.PipelineSize > 1 {
+		q.pipe = newPipeliner(red, opt.PipelineSize, opt.PipelineInterval)
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.scheduler("delayed", q.scheduleDelayed, q.waitDelayed)
+	}()
+
+	// Reaper that returns messages with expired reservation to the queue.
 	q.wg.Add(1)
 	go func() {
 		defer q.wg.Done()
-		q.scheduler("delayed", q.scheduleDelayed)
+		q.scheduler("pending", q.schedulePending, q.waitBackoff)
 	}()
 
 	q.wg.Add(1)
 	go func() {
 		defer q.wg.Done()
-		q.scheduler("pending", q.schedulePending)
+		q.scheduler("clean_zombie_consumers", q.cleanZombieConsumers, q.waitBackoff)
 	}()
 
 	q.wg.Add(1)
 	go func() {
 		defer q.wg.Done()
-		q.scheduler("clean_zombie_consumers", q.cleanZombieConsumers)
+		q.retryFailedLoop()
 	}()
 
 	return q
@@ -137,17 +210,191 @@ func (q *Queue) Consumer() taskq.QueueConsumer {
 	return q.consumer
 }
 
+// Reclaimed returns the number of messages that were returned to the queue
+// because their reservation expired, e.g. the consumer crashed.
+func (q *Queue) Reclaimed() uint64 {
+	return atomic.LoadUint64(&q.reclaimed)
+}
+
 func (q *Queue) Len() (int, error) {
 	n, err := q.redis.XLen(context.TODO(), q.stream).Result()
 	return int(n), err
 }
 
 // Add adds message to the queue.
-func (q *Queue) Add(msg *taskq.Message) error {
-	return q.add(q.redis, msg)
+func (q *Queue) Add(msg *taskq.Message) (err error) {
+	if q.closed() {
+		return fmt.Errorf("%w: %s", taskq.ErrQueueClosed, q)
+	}
+	if msg.TaskName == "" {
+		return internal.ErrTaskNameRequired
+	}
+	if err := msgutil.BeforeAdd(q, msg); err != nil {
+		return err
+	}
+	if q.isDuplicate(msg) {
+		msg.Err = taskq.ErrDuplicate
+		return nil
+	}
+	acquired, err := msgutil.AcquireQuota(q, msg)
+	if err != nil {
+		return err
+	}
+	if acquired {
+		defer func() {
+			if err != nil {
+				msgutil.ReleaseQuota(q, msg)
+			}
+		}()
+	}
+	audit := msgutil.SetAuditID(q, msg)
+	if err := q.ensureRoom(msg.Ctx, 1); err != nil {
+		return err
+	}
+
+	targets, err := q.groupTargets(msg.Ctx)
+	if err != nil {
+		return err
+	}
+
+	addCmds := func(pipe redisAdder) ([]redis.Cmder, error) {
+		cmds := make([]redis.Cmder, 0, len(targets))
+		for _, t := range targets {
+			cmd, err := q.addCmdTo(pipe, msg, t.zset, t.stream)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, cmd)
+		}
+		return cmds, nil
+	}
+
+	switch {
+	case q.pipe != nil:
+		err = q.pipe.Do(msg.Ctx, func(pipe redis.Pipeliner) ([]redis.Cmder, error) {
+			return addCmds(pipe)
+		})
+	case len(targets) == 1:
+		var cmds []redis.Cmder
+		cmds, err = addCmds(q.redis)
+		if err == nil {
+			err = cmds[0].Err()
+		}
+	default:
+		pipe := q.redis.TxPipeline()
+		if _, err = addCmds(pipe); err == nil {
+			_, err = pipe.Exec(msg.Ctx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if audit {
+		msgutil.AuditEnqueued(q, msg)
+	}
+
+	if msg.Delay > 0 {
+		q.notifyDelayed(msg.Ctx, time.Now().Add(msg.Delay))
+	}
+	return nil
+}
+
+// AddN adds messages to the queue using a single Redis pipeline.
+// Duplicate messages are skipped and have Err set to ErrDuplicate.
+// The overflow policy is applied once for the whole batch.
+func (q *Queue) AddN(ctx context.Context, msgs ...*taskq.Message) error {
+	if q.closed() {
+		return fmt.Errorf("%w: %s", taskq.ErrQueueClosed, q)
+	}
+	for _, msg := range msgs {
+		if msg.TaskName == "" {
+			return internal.ErrTaskNameRequired
+		}
+	}
+
+	var rejectErr error
+	reject := func(msg *taskq.Message, err error) {
+		msg.Err = err
+		if rejectErr == nil {
+			rejectErr = err
+		}
+	}
+
+	added := make([]*taskq.Message, 0, len(msgs))
+	acquired := make([]*taskq.Message, 0, len(msgs))
+	var audited []*taskq.Message
+	for _, msg := range msgs {
+		if err := msgutil.BeforeAdd(q, msg); err != nil {
+			reject(msg, err)
+			continue
+		}
+		if q.isDuplicate(msg) {
+			msg.Err = taskq.ErrDuplicate
+			continue
+		}
+		ok, err := msgutil.AcquireQuota(q, msg)
+		if err != nil {
+			reject(msg, err)
+			continue
+		}
+		if ok {
+			acquired = append(acquired, msg)
+		}
+		if msgutil.SetAuditID(q, msg) {
+			audited = append(audited, msg)
+		}
+		added = append(added, msg)
+	}
+	if len(added) == 0 {
+		return rejectErr
+	}
+
+	if err := q.addN(ctx, added); err != nil {
+		for _, msg := range acquired {
+			msgutil.ReleaseQuota(q, msg)
+		}
+		return err
+	}
+	for _, msg := range audited {
+		msgutil.AuditEnqueued(q, msg)
+	}
+	return rejectErr
+}
+
+func (q *Queue) addN(ctx context.Context, added []*taskq.Message) error {
+	// The batch is added in one pipeline, so it is rejected as a whole.
+	if err := q.ensureRoom(ctx, len(added)); err != nil {
+		return err
+	}
+
+	targets, err := q.groupTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	var minDelay time.Duration
+	pipe := q.redis.Pipeline()
+	for _, msg := range added {
+		for _, t := range targets {
+			if _, err := q.addCmdTo(pipe, msg, t.zset, t.stream); err != nil {
+				return err
+			}
+		}
+		if msg.Delay > 0 && (minDelay == 0 || msg.Delay < minDelay) {
+			minDelay = msg.Delay
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if minDelay > 0 {
+		q.notifyDelayed(ctx, time.Now().Add(minDelay))
+	}
+	return nil
 }
 
-func (q *Queue) add(pipe RedisStreamClient, msg *taskq.Message) error {
+func (q *Queue) add(pipe redisAdder, msg *taskq.Message) error {
 	if msg.TaskName == "" {
 		return internal.ErrTaskNameRequired
 	}
@@ -155,7 +402,20 @@ func (q *Queue) add(pipe RedisStreamClient, msg *taskq.Message) error {
 		msg.Err = taskq.ErrDuplicate
 		return nil
 	}
+	cmd, err := q.addCmd(pipe, msg)
+	if err != nil {
+		return err
+	}
+	return cmd.Err()
+}
 
+func (q *Queue) addCmd(pipe redisAdder, msg *taskq.Message) (redis.Cmder, error) {
+	return q.addCmdTo(pipe, msg, q.zset, q.stream)
+}
+
+func (q *Queue) addCmdTo(
+	pipe redisAdder, msg *taskq.Message, zset, stream string,
+) (redis.Cmder, error) {
 	if msg.ID == "" {
 		u := uuid.New()
 		msg.ID = internal.BytesToString(u[:])
@@ -163,50 +423,61 @@ func (q *Queue) add(pipe RedisStreamClient, msg *taskq.Message) error {
 
 	body, err := msg.MarshalBinary()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if msg.Delay > 0 {
 		tm := time.Now().Add(msg.Delay)
-		return pipe.ZAdd(msg.Ctx, q.zset, &redis.Z{
+		return pipe.ZAdd(msg.Ctx, zset, &redis.Z{
 			Score:  float64(unixMs(tm)),
 			Member: body,
-		}).Err()
+		}), nil
 	}
 
-	return pipe.XAdd(msg.Ctx, &redis.XAddArgs{
-		Stream: q.stream,
+	args := &redis.XAddArgs{
+		Stream: stream,
 		Values: map[string]interface{}{
 			"body": body,
+			"rc":   msg.ReservedCount,
 		},
-	}).Err()
+	}
+	if q.opt.MaxLen > 0 && q.opt.OverflowPolicy == taskq.OverflowDropOldest {
+		args.MaxLen = int64(q.opt.MaxLen)
+	}
+	return pipe.XAdd(msg.Ctx, args), nil
 }
 
+// ReserveN reserves up to n messages. Due delayed messages are promoted
+// and reserved atomically using a Lua script so a crash can neither lose
+// nor duplicate messages. If there are no messages, it blocks waiting
+// for new messages for waitTimeout.
 func (q *Queue) ReserveN(
 	ctx context.Context, n int, waitTimeout time.Duration,
 ) ([]taskq.Message, error) {
-	streams, err := q.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
-		Streams:  []string{q.stream, ">"},
-		Group:    q.streamGroup,
-		Consumer: q.streamConsumer,
-		Count:    int64(n),
-		Block:    waitTimeout,
-	}).Result()
+	q.heartbeatGroup(ctx)
+
+	var xmsgs []redis.XMessage
+	var err error
+
+	// With push fetching delayed messages are promoted by the scheduler
+	// as soon as they are due so there is no need to poll the ZSET.
+	if !q.opt.PushFetch {
+		xmsgs, err = q.reserve(ctx, n)
+	}
+	if err == nil && len(xmsgs) == 0 && waitTimeout > 0 {
+		xmsgs, err = q.readGroup(ctx, n, waitTimeout)
+	}
 	if err != nil {
-		if err == redis.Nil { // timeout
-			return nil, nil
-		}
-		if strings.HasPrefix(err.Error(), "NOGROUP") {
+		if strings.Contains(err.Error(), "NOGROUP") {
 			q.createStreamGroup(ctx)
 			return q.ReserveN(ctx, n, waitTimeout)
 		}
 		return nil, err
 	}
 
-	stream := &streams[0]
-	msgs := make([]taskq.Message, len(stream.Messages))
-	for i := range stream.Messages {
-		xmsg := &stream.Messages[i]
+	msgs := make([]taskq.Message, len(xmsgs))
+	for i := range xmsgs {
+		xmsg := &xmsgs[i]
 		msg := &msgs[i]
 
 		err = unmarshalMessage(msg, xmsg)
@@ -218,11 +489,68 @@ func (q *Queue) ReserveN(
 	return msgs, nil
 }
 
+func (q *Queue) reserve(ctx context.Context, n int) ([]redis.XMessage, error) {
+	var xmsgs []redis.XMessage
+	err := withFailoverRetry(ctx, func() error {
+		v, err := reserveScript.Run(
+			ctx, q.redis, []string{q.zset, q.stream},
+			unixMs(time.Now()), batchSize, q.streamGroup, q.streamConsumer, n,
+		).Result()
+		if err != nil {
+			return err
+		}
+		xmsgs, err = parseXMessages(v)
+		return err
+	})
+	return xmsgs, err
+}
+
+func (q *Queue) readGroup(
+	ctx context.Context, n int, waitTimeout time.Duration,
+) ([]redis.XMessage, error) {
+	var streams []redis.XStream
+	err := withFailoverRetry(ctx, func() error {
+		var err error
+		streams, err = q.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Streams:  []string{q.stream, ">"},
+			Group:    q.streamGroup,
+			Consumer: q.streamConsumer,
+			Count:    int64(n),
+			Block:    waitTimeout,
+		}).Result()
+		return err
+	})
+	if err != nil {
+		if err == redis.Nil { // timeout
+			return nil, nil
+		}
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
 func (q *Queue) createStreamGroup(ctx context.Context) {
 	_ = q.redis.XGroupCreateMkStream(ctx, q.stream, q.streamGroup, "0").Err()
 }
 
 func (q *Queue) Release(msg *taskq.Message) error {
+	msg.ReservedCount++
+	err := withFailoverRetry(msg.Ctx, func() error {
+		return q.release(msg)
+	})
+	if isFailoverError(err) {
+		op, opErr := newFailedOp(failedRelease, msg)
+		if opErr != nil {
+			return err
+		}
+		internal.Logger.Printf("redisq: Release failed during failover (will retry): %s", err)
+		q.failed.Add(op)
+		return nil
+	}
+	return err
+}
+
+func (q *Queue) release(msg *taskq.Message) error {
 	// Make the delete and re-queue operation atomic in case we crash midway
 	// and lose a message.
 	pipe := q.redis.TxPipeline()
@@ -236,24 +564,93 @@ func (q *Queue) Release(msg *taskq.Message) error {
 		return err
 	}
 
-	msg.ReservedCount++
+	// Released messages already have the audit id unless
+	// the audit was enabled after they were added.
+	audit := msgutil.SetAuditID(q, msg)
 	err = q.add(pipe, msg)
 	if err != nil {
 		return err
 	}
 
-	_, err = pipe.Exec(msg.Ctx)
-	return err
+	if _, err := pipe.Exec(msg.Ctx); err != nil {
+		return err
+	}
+	if audit {
+		msgutil.AuditEnqueued(q, msg)
+	}
+	if msg.Delay > 0 {
+		q.notifyDelayed(msg.Ctx, time.Now().Add(msg.Delay))
+	}
+	return nil
+}
+
+// Touch extends reservation of the message by resetting its idle time
+// in the pending entries list.
+func (q *Queue) Touch(ctx context.Context, msg *taskq.Message) error {
+	return q.redis.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   q.stream,
+		Group:    q.streamGroup,
+		Consumer: q.streamConsumer,
+		Messages: []string{msg.ID},
+	}).Err()
 }
 
 // Delete deletes the message from the queue.
 func (q *Queue) Delete(msg *taskq.Message) error {
+	err := withFailoverRetry(msg.Ctx, func() error {
+		return q.delete(msg)
+	})
+	if isFailoverError(err) {
+		op, opErr := newFailedOp(failedDelete, msg)
+		if opErr != nil {
+			return err
+		}
+		internal.Logger.Printf("redisq: Delete failed during failover (will retry): %s", err)
+		q.failed.Add(op)
+		return nil
+	}
+	return err
+}
+
+func (q *Queue) delete(msg *taskq.Message) error {
+	if q.pipe != nil {
+		return q.pipe.Do(msg.Ctx, func(pipe redis.Pipeliner) ([]redis.Cmder, error) {
+			return []redis.Cmder{
+				pipe.XAck(msg.Ctx, q.stream, q.streamGroup, msg.ID),
+				pipe.XDel(msg.Ctx, q.stream, msg.ID),
+			}, nil
+		})
+	}
+
 	if err := q.redis.XAck(msg.Ctx, q.stream, q.streamGroup, msg.ID).Err(); err != nil {
 		return err
 	}
 	return q.redis.XDel(msg.Ctx, q.stream, msg.ID).Err()
 }
 
+// DeleteBatch deletes messages from the queue in a single round trip.
+func (q *Queue) DeleteBatch(msgs []*taskq.Message) error {
+	if len(msgs) == 0 {
+		return errors.New("redisq: no messages to delete")
+	}
+
+	ctx := msgs[0].Ctx
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+
+	pipe := q.redis.Pipeline()
+	pipe.XAck(ctx, q.stream, q.streamGroup, ids...)
+	pipe.XDel(ctx, q.stream, ids...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
 // Purge deletes all messages from the queue.
 func (q *Queue) Purge() error {
 	ctx := context.TODO()
@@ -262,6 +659,16 @@ func (q *Queue) Purge() error {
 	return nil
 }
 
+// deleteKeys deletes the Redis keys used by the queue
+// and removes its consumer group.
+func (q *Queue) deleteKeys() error {
+	ctx := context.TODO()
+	if err := q.redis.ZRem(ctx, q.groupsKey, q.opt.Group).Err(); err != nil {
+		return err
+	}
+	return q.redis.Del(ctx, q.zset, q.stream).Err()
+}
+
 // Close is like CloseTimeout with 30 seconds timeout.
 func (q *Queue) Close() error {
 	return q.CloseTimeout(30 * time.Second)
@@ -274,12 +681,18 @@ func (q *Queue) CloseTimeout(timeout time.Duration) error {
 	}
 
 	if q.consumer != nil {
-		_ = q.consumer.StopTimeout(timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_ = q.consumer.Stop(ctx)
+		cancel()
 	}
 
 	_ = q.redis.XGroupDelConsumer(
 		context.TODO(), q.stream, q.streamGroup, q.streamConsumer).Err()
 
+	if q.pubsub != nil {
+		_ = q.pubsub.Close()
+	}
+
 	return nil
 }
 
@@ -287,7 +700,9 @@ func (q *Queue) closed() bool {
 	return atomic.LoadUint32(&q._closed) == 1
 }
 
-func (q *Queue) scheduler(name string, fn func(ctx context.Context) (int, error)) {
+func (q *Queue) scheduler(
+	name string, fn func(ctx context.Context) (int, error), wait func(),
+) {
 	for {
 		if q.closed() {
 			break
@@ -305,44 +720,24 @@ func (q *Queue) scheduler(name string, fn func(ctx context.Context) (int, error)
 			internal.Logger.Printf("redisq: %s failed: %s", name, err)
 		}
 		if err != nil || n == 0 {
-			time.Sleep(q.schedulerBackoff())
+			wait()
 		}
 	}
 }
 
+func (q *Queue) waitBackoff() {
+	time.Sleep(q.schedulerBackoff())
+}
+
 func (q *Queue) schedulerBackoff() time.Duration {
 	n := 250 + rand.Intn(250)
 	return time.Duration(n) * time.Millisecond
 }
 
 func (q *Queue) scheduleDelayed(ctx context.Context) (int, error) {
-	tm := time.Now()
-	max := strconv.FormatInt(unixMs(tm), 10)
-	bodies, err := q.redis.ZRangeByScore(ctx, q.zset, &redis.ZRangeBy{
-		Min:   "-inf",
-		Max:   max,
-		Count: batchSize,
-	}).Result()
-	if err != nil {
-		return 0, err
-	}
-
-	pipe := q.redis.TxPipeline()
-	for _, body := range bodies {
-		pipe.XAdd(ctx, &redis.XAddArgs{
-			Stream: q.stream,
-			Values: map[string]interface{}{
-				"body": body,
-			},
-		})
-		pipe.ZRem(ctx, q.zset, body)
-	}
-	_, err = pipe.Exec(ctx)
-	if err != nil {
-		return 0, err
-	}
-
-	return len(bodies), nil
+	return promoteDelayedScript.Run(
+		ctx, q.redis, []string{q.zset, q.stream}, unixMs(time.Now()), batchSize,
+	).Int()
 }
 
 func (q *Queue) cleanZombieConsumers(ctx context.Context) (int, error) {
@@ -364,17 +759,16 @@ func (q *Queue) cleanZombieConsumers(ctx context.Context) (int, error) {
 	return 0, nil
 }
 
-// schedulePending schedules pending messages that are older than the `ReservationTimeout`.
+// schedulePending schedules pending messages that were idle longer than the `ReservationTimeout`.
 // ReservationTimeout is the time after which a message is considered to be not processed and need to be re-enqueue.
+// Idle time is reset when the message is touched.
 func (q *Queue) schedulePending(ctx context.Context) (int, error) {
-	tm := time.Now().Add(-q.opt.ReservationTimeout)
-	end := strconv.FormatInt(unixMs(tm), 10)
-
 	pending, err := q.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
 		Stream: q.stream,
 		Group:  q.streamGroup,
+		Idle:   q.opt.ReservationTimeout,
 		Start:  "-",
-		End:    end,
+		End:    "+",
 		Count:  batchSize,
 	}).Result()
 	if err != nil {
@@ -386,33 +780,26 @@ func (q *Queue) schedulePending(ctx context.Context) (int, error) {
 		return 0, err
 	}
 
-	for i := range pending {
-		xmsgInfo := &pending[i]
-		id := xmsgInfo.ID
-
-		xmsgs, err := q.redis.XRangeN(ctx, q.stream, id, id, 1).Result()
-		if err != nil {
-			return 0, err
-		}
+	if len(pending) == 0 {
+		return 0, nil
+	}
 
-		if len(xmsgs) != 1 {
-			err := fmt.Errorf("redisq: can't find pending message id=%q in stream=%q",
-				id, q.stream)
-			return 0, err
-		}
+	args := make([]interface{}, 0, 1+len(pending))
+	args = append(args, q.streamGroup)
+	for i := range pending {
+		args = append(args, pending[i].ID)
+	}
 
-		xmsg := &xmsgs[0]
-		msg := new(taskq.Message)
-		msg.Ctx = ctx
-		err = unmarshalMessage(msg, xmsg)
-		if err != nil {
-			return 0, err
-		}
+	// Acknowledge and re-add messages atomically so a crash midway
+	// can't lose a message.
+	n, err := requeueScript.Run(ctx, q.redis, []string{q.stream}, args...).Int()
+	if err != nil {
+		return 0, err
+	}
 
-		err = q.Release(msg)
-		if err != nil {
-			return 0, err
-		}
+	if n > 0 {
+		atomic.AddUint64(&q.reclaimed, uint64(n))
+		internal.Logger.Printf("redisq: %s reclaimed %d messages with expired reservation", q, n)
 	}
 
 	return len(pending), nil
@@ -455,6 +842,14 @@ func unmarshalMessage(msg *taskq.Message, xmsg *redis.XMessage) error {
 	}
 
 	msg.ID = xmsg.ID
+
+	// Reservation count is also stored separately, because messages
+	// requeued by Lua scripts don't have the body updated.
+	if s, ok := xmsg.Values["rc"].(string); ok {
+		if rc, err := strconv.Atoi(s); err == nil && rc > msg.ReservedCount {
+			msg.ReservedCount = rc
+		}
+	}
 	if msg.ReservedCount == 0 {
 		msg.ReservedCount = 1
 	}
@@ -0,0 +1,102 @@
+package redisq
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// promoteDelayed moves delayed messages that are due from the ZSET to the stream.
+//
+// KEYS[1] - zset, KEYS[2] - stream, ARGV[1] - current time in ms, ARGV[2] - batch size.
+const promoteDelayed = `
+local bodies = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, body in ipairs(bodies) do
+  redis.call("XADD", KEYS[2], "*", "body", body)
+  redis.call("ZREM", KEYS[1], body)
+end
+`
+
+var promoteDelayedScript = redis.NewScript(promoteDelayed + `
+return #bodies
+`)
+
+// reserveScript atomically promotes due delayed messages and reserves up to
+// ARGV[5] messages adding them to the pending entries list of the consumer.
+//
+// ARGV[3] - group, ARGV[4] - consumer, ARGV[5] - number of messages.
+var reserveScript = redis.NewScript(promoteDelayed + `
+local res = redis.call("XREADGROUP", "GROUP", ARGV[3], ARGV[4], "COUNT", ARGV[5], "STREAMS", KEYS[2], ">")
+if not res then
+  return {}
+end
+return res[1][2]
+`)
+
+// requeueScript atomically acknowledges and re-adds pending messages to the stream
+// incrementing their reservation count.
+//
+// KEYS[1] - stream, ARGV[1] - group, ARGV[2...] - message ids.
+var requeueScript = redis.NewScript(`
+local n = 0
+for i = 2, #ARGV do
+  local id = ARGV[i]
+  local entries = redis.call("XRANGE", KEYS[1], id, id)
+  if #entries == 1 then
+    local fields = entries[1][2]
+    local body
+    local rc = 1
+    for j = 1, #fields, 2 do
+      if fields[j] == "body" then
+        body = fields[j + 1]
+      elseif fields[j] == "rc" then
+        rc = tonumber(fields[j + 1])
+      end
+    end
+    if body then
+      redis.call("XADD", KEYS[1], "*", "body", body, "rc", rc + 1)
+      n = n + 1
+    end
+  end
+  redis.call("XACK", KEYS[1], ARGV[1], id)
+  redis.call("XDEL", KEYS[1], id)
+end
+return n
+`)
+
+// parseXMessages parses stream entries returned by reserveScript.
+func parseXMessages(v interface{}) ([]redis.XMessage, error) {
+	entries, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redisq: got %T, wanted []interface{}", v)
+	}
+
+	msgs := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		vals, ok := entry.([]interface{})
+		if !ok || len(vals) != 2 {
+			return nil, fmt.Errorf("redisq: can't parse stream entry: %v", entry)
+		}
+
+		id, ok := vals[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("redisq: got id=%T, wanted string", vals[0])
+		}
+
+		fields, _ := vals[1].([]interface{})
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, ok := fields[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("redisq: got field=%T, wanted string", fields[i])
+			}
+			values[key] = fields[i+1]
+		}
+
+		msgs = append(msgs, redis.XMessage{
+			ID:     id,
+			Values: values,
+		})
+	}
+	return msgs, nil
+}
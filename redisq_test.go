@@ -1,10 +1,17 @@
 package taskq_test
 
 import (
+	"context"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+
 	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/queuetest"
 	"github.com/frain-dev/taskq/v3/redisq"
 )
 
@@ -18,6 +25,19 @@ func TestRedisqConsumer(t *testing.T) {
 	})
 }
 
+func TestRedisqConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T, opt *taskq.QueueOptions) taskq.Queue {
+		opt.Redis = redisRing()
+		return redisq.NewQueue(opt)
+	})
+}
+
+func TestRedisqRuntimeQueue(t *testing.T) {
+	testRuntimeQueue(t, redisqFactory(), &taskq.QueueOptions{
+		Name: queueName("redisq-runtime-queue"),
+	})
+}
+
 func TestRedisqAckMessage(t *testing.T) {
 	testConsumerDelete(t, redisqFactory(), &taskq.QueueOptions{
 		Name:               queueName("redisq-ack-message"),
@@ -37,6 +57,12 @@ func TestRedisqFallback(t *testing.T) {
 	})
 }
 
+func TestRedisqHeaders(t *testing.T) {
+	testHeaders(t, redisqFactory(), &taskq.QueueOptions{
+		Name: queueName("redisq-headers"),
+	})
+}
+
 func TestRedisqDelay(t *testing.T) {
 	testDelay(t, redisqFactory(), &taskq.QueueOptions{
 		Name: queueName("redisq-delay"),
@@ -67,6 +93,51 @@ func TestRedisqLen(t *testing.T) {
 	})
 }
 
+func TestRedisqMaxLen(t *testing.T) {
+	testMaxLen(t, redisqFactory(), &taskq.QueueOptions{
+		Name: queueName("redisq-max-len"),
+	})
+}
+
+func TestRedisqMaxLenBatch(t *testing.T) {
+	ctx := context.Background()
+	q := redisqFactory().RegisterQueue(&taskq.QueueOptions{
+		Name:        queueName("redisq-max-len-batch"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+		MaxLen:      3,
+	})
+	defer q.Close()
+	purge(t, q)
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    nextTaskID(),
+		Handler: func() {},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := q.Add(task.WithArgs(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The batch straddles MaxLen.
+	err := q.AddN(ctx, task.WithArgs(ctx), task.WithArgs(ctx))
+	if err != taskq.ErrQueueFull {
+		t.Fatalf("got %v, wanted ErrQueueFull", err)
+	}
+	if n, err := q.Len(); err != nil || n != 2 {
+		t.Fatalf("got len=%d err=%v, wanted 2", n, err)
+	}
+
+	if err := q.AddN(ctx, task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Len(); err != nil || n != 3 {
+		t.Fatalf("got len=%d err=%v, wanted 3", n, err)
+	}
+}
+
 func TestRedisqRateLimit(t *testing.T) {
 	testRateLimit(t, redisqFactory(), &taskq.QueueOptions{
 		Name: queueName("redisq-rate-limit"),
@@ -85,6 +156,33 @@ func TestRedisqWorkerLimit(t *testing.T) {
 	})
 }
 
+func TestRedisqPipeline(t *testing.T) {
+	testBatchConsumer(t, redisqFactory(), &taskq.QueueOptions{
+		Name:         queueName("redisq-pipeline"),
+		PipelineSize: 10,
+	}, 100)
+}
+
+func TestRedisqPushFetchConsumer(t *testing.T) {
+	testConsumer(t, redisqFactory(), &taskq.QueueOptions{
+		Name:      queueName("redisq-push-fetch-consumer"),
+		PushFetch: true,
+	})
+}
+
+func TestRedisqPushFetchDelay(t *testing.T) {
+	testDelay(t, redisqFactory(), &taskq.QueueOptions{
+		Name:      queueName("redisq-push-fetch-delay"),
+		PushFetch: true,
+	})
+}
+
+func TestRedisqTouch(t *testing.T) {
+	testTouch(t, redisqFactory(), &taskq.QueueOptions{
+		Name: queueName("redisq-touch"),
+	})
+}
+
 func TestRedisqBatchConsumerSmallMessage(t *testing.T) {
 	testBatchConsumer(t, redisqFactory(), &taskq.QueueOptions{
 		Name: queueName("redisq-batch-consumer-small-message"),
@@ -96,3 +194,796 @@ func TestRedisqBatchConsumerLarge(t *testing.T) {
 		Name: queueName("redisq-batch-processor-large-message"),
 	}, 64000)
 }
+
+var (
+	clusterOnce sync.Once
+	cluster     *redis.ClusterClient
+)
+
+// redisCluster returns a Redis Cluster client using addresses from
+// TASKQ_REDIS_CLUSTER env variable, e.g. ":7000,:7001,:7002".
+func redisCluster(t *testing.T) *redis.ClusterClient {
+	addrs := os.Getenv("TASKQ_REDIS_CLUSTER")
+	if addrs == "" {
+		t.Skip("TASKQ_REDIS_CLUSTER is not set")
+	}
+
+	clusterOnce.Do(func() {
+		cluster = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs: strings.Split(addrs, ","),
+		})
+	})
+
+	ctx := context.TODO()
+	_ = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		return client.FlushDB(ctx).Err()
+	})
+	return cluster
+}
+
+func TestRedisqClusterConsumer(t *testing.T) {
+	testConsumer(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-cluster-consumer"),
+		Redis: redisCluster(t),
+	})
+}
+
+func TestRedisqClusterDelay(t *testing.T) {
+	testDelay(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-cluster-delay"),
+		Redis: redisCluster(t),
+	})
+}
+
+func TestRedisqClusterRetry(t *testing.T) {
+	testRetry(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-cluster-retry"),
+		Redis: redisCluster(t),
+	})
+}
+
+func TestRedisqClusterNamedMessage(t *testing.T) {
+	testNamedMessage(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-cluster-named-message"),
+		Redis: redisCluster(t),
+	})
+}
+
+func TestRedisqClusterWorkerLimit(t *testing.T) {
+	testWorkerLimit(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-cluster-worker-limit"),
+		Redis: redisCluster(t),
+	})
+}
+
+// redisSentinel returns a Redis client using Sentinel addresses from
+// TASKQ_REDIS_SENTINEL env variable, e.g. "mymaster@:26379,:26380".
+func redisSentinel(t *testing.T) *redis.Client {
+	env := os.Getenv("TASKQ_REDIS_SENTINEL")
+	if env == "" {
+		t.Skip("TASKQ_REDIS_SENTINEL is not set")
+	}
+
+	ss := strings.SplitN(env, "@", 2)
+	if len(ss) != 2 {
+		t.Fatalf("can't parse TASKQ_REDIS_SENTINEL=%q", env)
+	}
+
+	client := redisq.NewSentinelClient(&redis.FailoverOptions{
+		MasterName:    ss[0],
+		SentinelAddrs: strings.Split(ss[1], ","),
+	})
+	_ = client.FlushDB(context.TODO()).Err()
+	return client
+}
+
+func TestRedisqSentinelConsumer(t *testing.T) {
+	testConsumer(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-sentinel-consumer"),
+		Redis: redisSentinel(t),
+	})
+}
+
+func TestRedisqSentinelRetry(t *testing.T) {
+	testRetry(t, redisqFactory(), &taskq.QueueOptions{
+		Name:  queueName("redisq-sentinel-retry"),
+		Redis: redisSentinel(t),
+	})
+}
+
+func TestRedisqConnFactory(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactoryWithConn(redisq.NewConnFactory(&redisq.ConnOptions{
+		Addrs:      []string{":6379"},
+		ClientName: "taskq-test",
+	}))
+	defer factory.Close()
+
+	name := queueName("redisq-conn-factory")
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: name,
+	})
+
+	if err := q.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := q.Options().Redis.(redis.UniversalClient).ClientList(ctx).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(list, "name=taskq-test:"+name) {
+		t.Fatalf("client name is not set: %s", list)
+	}
+
+	if stats := q.(*redisq.Queue).ConnStats(); stats == nil || stats.TotalConns == 0 {
+		t.Fatalf("got %+v, wanted open connections", stats)
+	}
+}
+
+func TestRedisqDiscover(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	name := queueName("redisq-discover")
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:  name,
+		Redis: redisRing(),
+	})
+	purge(t, q)
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    nextTaskID(),
+		Handler: func() {},
+	})
+	if err := q.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	other := queueName("redisq-discover-other")
+	_ = redisRing().XAdd(ctx, &redis.XAddArgs{
+		Stream: "taskq:{" + other + "}:stream",
+		Values: map[string]interface{}{"foo": "bar"},
+	}).Err()
+
+	var registered []string
+	names, err := factory.Discover(ctx, func(name string) *taskq.QueueOptions {
+		registered = append(registered, name)
+		return &taskq.QueueOptions{
+			Name:  name,
+			Redis: redisRing(),
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !containsString(names, name) || !containsString(names, other) {
+		t.Fatalf("got %v, wanted %s and %s", names, name, other)
+	}
+	if len(registered) != 1 || registered[0] != other {
+		t.Fatalf("got %v, wanted %s to be registered", registered, other)
+	}
+}
+
+func TestRedisqDiscoverNamespace(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	const namespace = "discover-ns"
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:      queueName("redisq-discover-ns"),
+		Namespace: namespace,
+		Redis:     redisRing(),
+	})
+	purge(t, q)
+
+	found := queueName("redisq-discover-ns-found")
+	outside := queueName("redisq-discover-ns-outside")
+	for _, stream := range []string{
+		namespace + ":taskq:{" + found + "}:stream",
+		"taskq:{" + outside + "}:stream",
+	} {
+		if err := redisRing().XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]interface{}{"foo": "bar"},
+		}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := factory.Discover(ctx, func(name string) *taskq.QueueOptions {
+		return &taskq.QueueOptions{
+			Name:  name,
+			Redis: redisRing(),
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !containsString(names, found) || containsString(names, outside) {
+		t.Fatalf("got %v, wanted %s and not %s", names, found, outside)
+	}
+	factory.Range(func(q taskq.Queue) bool {
+		if q.Name() == found && q.Options().Namespace != namespace {
+			t.Fatalf("got namespace %q, wanted %q", q.Options().Namespace, namespace)
+		}
+		return true
+	})
+}
+
+func containsString(ss []string, s string) bool {
+	for _, el := range ss {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRedisqPatternConsumer(t *testing.T) {
+	ctx := context.Background()
+	ring := redisRing()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	// The factory needs a client to discover queues.
+	factory.RegisterQueue(&taskq.QueueOptions{
+		Name:  queueName("redisq-pattern"),
+		Redis: ring,
+	})
+	if err := factory.StartConsumers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan string, 10)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+
+	pc := taskq.NewPatternConsumer(factory, &taskq.PatternOptions{
+		Pattern: queueName("redisq-pattern-*"),
+		QueueOptions: func(name string) *taskq.QueueOptions {
+			return &taskq.QueueOptions{
+				Name:        name,
+				Redis:       ring,
+				WaitTimeout: waitTimeout,
+			}
+		},
+		Interval: 100 * time.Millisecond,
+	})
+	if err := pc.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Stop()
+
+	// Another service creates the queue and adds a message.
+	other := redisq.NewQueue(&taskq.QueueOptions{
+		Name:  queueName("redisq-pattern-customer1"),
+		Redis: ring,
+	})
+	defer other.Close()
+
+	if err := other.Add(task.WithArgs(ctx, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-ch:
+		if s != "hello" {
+			t.Fatalf("got %q, wanted hello", s)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed")
+	}
+
+	if queues := pc.Queues(); len(queues) != 1 {
+		t.Fatalf("got %v, wanted 1 queue", queues)
+	}
+}
+
+func TestRedisqNamespace(t *testing.T) {
+	ctx := context.Background()
+	ring := redisRing()
+
+	name := queueName("redisq-namespace")
+	staging := redisq.NewQueue(&taskq.QueueOptions{
+		Name:      name,
+		Namespace: "staging",
+		Redis:     ring,
+	})
+	defer staging.Close()
+
+	prod := redisq.NewQueue(&taskq.QueueOptions{
+		Name:      name,
+		Namespace: "prod",
+		Redis:     ring,
+	})
+	defer prod.Close()
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    nextTaskID(),
+		Handler: func() {},
+	})
+	if err := staging.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := staging.Len(); err != nil || n != 1 {
+		t.Fatalf("got %d, %v, wanted 1 message", n, err)
+	}
+	if n, err := prod.Len(); err != nil || n != 0 {
+		t.Fatalf("got %d, %v, wanted 0 messages", n, err)
+	}
+
+	keys, err := ring.Keys(ctx, "staging:taskq:{"+name+"}:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		t.Fatal("namespaced keys do not exist")
+	}
+}
+
+func TestRedisqConsumerGroups(t *testing.T) {
+	ctx := context.Background()
+	ring := redisRing()
+
+	name := queueName("redisq-consumer-groups")
+	ch := make(chan string, 10)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+
+	for _, group := range []string{"emails", "audit"} {
+		q := redisq.NewQueue(&taskq.QueueOptions{
+			Name:        name,
+			Group:       group,
+			Redis:       ring,
+			WaitTimeout: waitTimeout,
+		})
+		defer q.Close()
+
+		if err := q.Consumer().Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wait for the groups to be registered by the consumers.
+	time.Sleep(time.Second)
+
+	producer := redisq.NewQueue(&taskq.QueueOptions{
+		Name:  name,
+		Redis: ring,
+	})
+	defer producer.Close()
+
+	if err := producer.Add(task.WithArgs(ctx, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-ch:
+			if s != "hello" {
+				t.Fatalf("got %q, wanted hello", s)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("message was not processed by every group")
+		}
+	}
+
+	select {
+	case s := <-ch:
+		t.Fatalf("message %q was processed more than once per group", s)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestRedisqRetiredConsumerGroup(t *testing.T) {
+	ctx := context.Background()
+	ring := redisRing()
+
+	name := queueName("redisq-retired-consumer-group")
+	ch := make(chan string, 10)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+
+	live := redisq.NewQueue(&taskq.QueueOptions{
+		Name:        name,
+		Group:       "live",
+		Redis:       ring,
+		WaitTimeout: waitTimeout,
+	})
+	defer live.Close()
+
+	retired := redisq.NewQueue(&taskq.QueueOptions{
+		Name:                name,
+		Group:               "retired",
+		Redis:               ring,
+		WaitTimeout:         waitTimeout,
+		ConsumerIdleTimeout: 2 * time.Second,
+	})
+	defer retired.Close()
+
+	for _, q := range []taskq.Queue{live, retired} {
+		if err := q.Consumer().Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Wait for the groups to be registered by the consumers.
+	time.Sleep(time.Second)
+
+	if err := retired.Consumer().Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the retired group to expire.
+	time.Sleep(3 * time.Second)
+
+	producer := redisq.NewQueue(&taskq.QueueOptions{
+		Name:  name,
+		Redis: ring,
+	})
+	defer producer.Close()
+
+	if err := producer.Add(task.WithArgs(ctx, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-ch:
+		if s != "hello" {
+			t.Fatalf("got %q, wanted hello", s)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed by the live group")
+	}
+
+	n, err := retired.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("retired group has %d messages, wanted 0", n)
+	}
+}
+
+func TestRedisqPauseConsumers(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:        queueName("redisq-pause-consumers"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+	})
+	purge(t, q)
+
+	if err := factory.PauseConsumers(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer factory.ResumeConsumers(ctx)
+
+	ch := make(chan time.Time, 1)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func() {
+			ch <- time.Now()
+		},
+	})
+	if err := q.Add(task.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := factory.StartConsumers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+		t.Fatal("message was processed while consumers are paused")
+	case <-time.After(2 * time.Second):
+	}
+
+	if err := factory.ResumeConsumers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed after resume")
+	}
+}
+
+func TestRedisqPauseQueue(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	paused := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:        queueName("redisq-pause-queue"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+	})
+	purge(t, paused)
+	other := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:        queueName("redisq-pause-queue-other"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+	})
+	purge(t, other)
+
+	if err := taskq.PauseQueue(ctx, paused.Options(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer taskq.ResumeQueue(ctx, paused.Options())
+
+	if ok, err := taskq.QueuePaused(ctx, paused.Options()); err != nil || !ok {
+		t.Fatalf("got %v, %v, wanted the queue to be paused", ok, err)
+	}
+	if ok, err := taskq.QueuePaused(ctx, other.Options()); err != nil || ok {
+		t.Fatalf("got %v, %v, wanted the queue not to be paused", ok, err)
+	}
+
+	ch := make(chan string, 2)
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: nextTaskID(),
+		Handler: func(queue string) {
+			ch <- queue
+		},
+	})
+	for _, q := range []taskq.Queue{paused, other} {
+		if err := q.Add(task.WithArgs(ctx, q.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := factory.StartConsumers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case name := <-ch:
+		if name != other.Name() {
+			t.Fatalf("message of queue=%q was processed while the queue is paused", name)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("message of the other queue was not processed")
+	}
+	select {
+	case name := <-ch:
+		t.Fatalf("message of queue=%q was processed while the queue is paused", name)
+	case <-time.After(2 * time.Second):
+	}
+
+	if err := taskq.ResumeQueue(ctx, paused.Options()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(testTimeout):
+		t.Fatalf("message was not processed after resume")
+	}
+}
+
+func TestRedisqStickyOrderingKeys(t *testing.T) {
+	ctx := context.Background()
+
+	name := queueName("redisq-sticky")
+	type processed struct {
+		consumer int
+		key      string
+	}
+	ch := make(chan processed, 100)
+
+	var queues []taskq.Queue
+	for i := 0; i < 2; i++ {
+		i := i
+		q := redisq.NewQueue(&taskq.QueueOptions{
+			Name:               name,
+			Redis:              redisRing(),
+			WaitTimeout:        waitTimeout,
+			StickyOrderingKeys: true,
+			Handler: taskq.HandlerFunc(func(msg *taskq.Message) error {
+				ch <- processed{i, msg.OrderingKey}
+				return nil
+			}),
+		})
+		defer q.Close()
+		queues = append(queues, q)
+	}
+	purge(t, queues[0])
+
+	for _, q := range queues {
+		if err := q.Consumer().Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Wait until both consumers see each other.
+	time.Sleep(11 * time.Second)
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	const n = 5
+	for i := 0; i < n; i++ {
+		for _, key := range keys {
+			msg := taskq.NewMessage(ctx)
+			msg.TaskName = "sticky"
+			msg.OrderingKey = key
+			if err := queues[0].Add(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	owners := make(map[string]int)
+	for i := 0; i < n*len(keys); i++ {
+		select {
+		case p := <-ch:
+			if owner, ok := owners[p.key]; ok && owner != p.consumer {
+				t.Fatalf("key=%q is processed by consumers %d and %d", p.key, owner, p.consumer)
+			}
+			owners[p.key] = p.consumer
+		case <-time.After(testTimeout):
+			t.Fatal("messages are not processed")
+		}
+	}
+}
+
+func TestRedisqListConsumers(t *testing.T) {
+	ctx := context.Background()
+
+	opt := &taskq.QueueOptions{
+		Name:        queueName("redisq-list-consumers"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+	}
+	q := redisq.NewQueue(opt)
+	defer q.Close()
+
+	if err := q.Consumer().Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []taskq.ConsumerInfo
+	for i := 0; i < 10; i++ {
+		var err error
+		infos, err = taskq.ListConsumers(ctx, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("got %d consumers, wanted 1", len(infos))
+	}
+	if infos[0].Queue != opt.Name || infos[0].PID != os.Getpid() {
+		t.Fatalf("got %+v", infos[0])
+	}
+
+	if err := q.Consumer().Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := taskq.ListConsumers(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 0 {
+		t.Fatalf("got %d consumers, wanted 0", len(infos))
+	}
+}
+
+func TestRedisqFleet(t *testing.T) {
+	ctx := context.Background()
+
+	namespace := queueName("redisq-fleet")
+	var names []string
+	for _, name := range []string{"a", "b"} {
+		opt := &taskq.QueueOptions{
+			Name:        name,
+			Namespace:   namespace,
+			Redis:       redisRing(),
+			WaitTimeout: waitTimeout,
+		}
+		q := redisq.NewQueue(opt)
+		defer q.Close()
+
+		if err := q.Consumer().Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer q.Consumer().Stop(ctx)
+		names = append(names, q.Name())
+	}
+
+	opt := &taskq.QueueOptions{
+		Namespace: namespace,
+		Redis:     redisRing(),
+	}
+	var fleet *taskq.FleetTopology
+	for i := 0; i < 10; i++ {
+		var err error
+		fleet, err = taskq.Fleet(ctx, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fleet.Queues) == 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(fleet.Instances) != 1 {
+		t.Fatalf("got %d instances, wanted 1", len(fleet.Instances))
+	}
+	inst := fleet.Instances[0]
+	if inst.PID != os.Getpid() || len(inst.Consumers) != 2 {
+		t.Fatalf("got %+v", inst)
+	}
+	for _, name := range names {
+		if len(fleet.Queues[name]) != 1 {
+			t.Fatalf("got %d consumers of queue=%q, wanted 1", len(fleet.Queues[name]), name)
+		}
+	}
+}
+
+func TestRedisqLag(t *testing.T) {
+	ctx := context.Background()
+
+	factory := redisq.NewFactory()
+	defer factory.Close()
+
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name:        queueName("redisq-lag"),
+		Redis:       redisRing(),
+		WaitTimeout: waitTimeout,
+	})
+	purge(t, q)
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    nextTaskID(),
+		Handler: func() {},
+	})
+	for i := 0; i < 2; i++ {
+		if err := q.Add(task.WithArgs(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	delayed := task.WithArgs(ctx)
+	delayed.Delay = time.Hour
+	if err := q.Add(delayed); err != nil {
+		t.Fatal(err)
+	}
+
+	lag, err := taskq.Lag(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.Ready != 2 || lag.DelayedDue != 0 || lag.OldestAge < 0 || lag.OldestAge > time.Minute {
+		t.Fatalf("got %+v", lag)
+	}
+}
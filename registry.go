@@ -1,55 +1,212 @@
 package taskq
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrUnknownTask is returned when handling a message of a task
+	// that is not registered.
+	ErrUnknownTask = errors.New("taskq: unknown task")
+	// ErrTaskExists is returned when registering a task with the name
+	// that is already registered.
+	ErrTaskExists = errors.New("taskq: task already exists")
+)
+
+// UnknownTaskAction is what a registry does with messages of tasks
+// that are not registered.
+type UnknownTaskAction int
+
+const (
+	// UnknownTaskRetry retries the message with the retry limit and backoff
+	// set with SetUnknownTaskOptions. It is the default.
+	UnknownTaskRetry UnknownTaskAction = iota
+	// UnknownTaskRelease releases the message with UnknownTaskPolicy.Delay
+	// without ever failing it, e.g. while another version of the service
+	// that handles the task is being deployed.
+	UnknownTaskRelease
+	// UnknownTaskDeadLetter passes the message to
+	// UnknownTaskPolicy.FallbackHandler and deletes it.
+	UnknownTaskDeadLetter
+	// UnknownTaskDrop deletes the message.
+	UnknownTaskDrop
+	// UnknownTaskHandle processes the message with UnknownTaskPolicy.Handler.
+	// Failed messages are retried like with UnknownTaskRetry.
+	UnknownTaskHandle
+)
+
+// UnknownTaskPolicy configures how a registry handles messages of tasks
+// that are not registered.
+type UnknownTaskPolicy struct {
+	Action UnknownTaskAction
+
+	// Delay of messages released with UnknownTaskRelease.
+	// Default is 1 minute.
+	Delay time.Duration
+
+	// Handler used with UnknownTaskHandle.
+	Handler HandlerFunc
+
+	// Optional handler that is called when the message fails permanently,
+	// e.g. to move it to another queue. It is not called with UnknownTaskDrop.
+	FallbackHandler HandlerFunc
+}
+
+func (p *UnknownTaskPolicy) init() {
+	if p.Delay == 0 {
+		p.Delay = time.Minute
+	}
+}
+
+// Tasks is the global task registry used by RegisterTask and by queues
+// without QueueOptions.Handler.
 var Tasks TaskMap
 
 type TaskMap struct {
-	m sync.Map
+	m       sync.Map
+	aliases sync.Map
+
+	unknown atomic.Value // *UnknownTaskPolicy
+}
+
+// NewRegistry returns an empty task registry that can be used instead of
+// the global Tasks registry, e.g. to isolate parallel tests. It is attached
+// to queues with QueueOptions.Handler or to factories with SetRegistry.
+func NewRegistry() *TaskMap {
+	return new(TaskMap)
+}
+
+// RegisterTask is like the global RegisterTask, but registers
+// the task in the registry.
+func (r *TaskMap) RegisterTask(opt *TaskOptions) *Task {
+	task, err := r.Register(opt)
+	if err != nil {
+		panic(err)
+	}
+	return task
 }
 
 func (r *TaskMap) Get(name string) *Task {
 	if v, ok := r.m.Load(name); ok {
 		return v.(*Task)
 	}
+	if v, ok := r.aliases.Load(name); ok {
+		return v.(*Task)
+	}
 	if v, ok := r.m.Load("*"); ok {
 		return v.(*Task)
 	}
 	return nil
 }
 
+// Register registers the task. Unlike RegisterTask it returns an error
+// instead of panicking when the options are invalid or the task
+// with such name already exists.
 func (r *TaskMap) Register(opt *TaskOptions) (*Task, error) {
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
 	opt.init()
 
 	task := &Task{
-		opt:     opt,
-		handler: NewHandler(opt.Handler),
+		opt: opt,
+	}
+	if opt.HandlerFactory != nil {
+		task.handler = &depsHandler{
+			factory: opt.HandlerFactory,
+		}
+	} else {
+		task.handler = NewHandler(opt.Handler)
 	}
 
 	if opt.FallbackHandler != nil {
-		task.fallbackHandler = NewHandler(opt.FallbackHandler)
+		task.fallbackHandler = newFallbackHandler(opt.FallbackHandler)
 	}
 
 	name := task.Name()
-	_, loaded := r.m.LoadOrStore(name, task)
-	if loaded {
-		return nil, fmt.Errorf("task=%q already exists", name)
+	if _, loaded := r.aliases.Load(name); loaded {
+		return nil, fmt.Errorf("%w: task=%q is an alias", ErrTaskExists, name)
+	}
+	if _, loaded := r.m.LoadOrStore(name, task); loaded {
+		return nil, fmt.Errorf("%w: task=%q", ErrTaskExists, name)
+	}
+
+	for _, alias := range opt.Aliases {
+		if err := r.registerAlias(alias, task); err != nil {
+			r.Unregister(task)
+			return nil, err
+		}
 	}
 	return task, nil
 }
 
+func (r *TaskMap) registerAlias(alias string, task *Task) error {
+	if _, loaded := r.m.Load(alias); loaded {
+		return fmt.Errorf("%w: alias=%q of task=%q is a task name",
+			ErrTaskExists, alias, task.Name())
+	}
+	if _, loaded := r.aliases.LoadOrStore(alias, task); loaded {
+		return fmt.Errorf("%w: alias=%q of task=%q", ErrTaskExists, alias, task.Name())
+	}
+	return nil
+}
+
+// UnregisterTask unregisters the task with the name and its aliases and
+// reports whether it was registered. Messages of the task that are
+// processed after that are handled according to the UnknownTaskPolicy.
+func (r *TaskMap) UnregisterTask(name string) bool {
+	v, loaded := r.m.LoadAndDelete(name)
+	if loaded {
+		r.unregisterAliases(v.(*Task))
+	}
+	return loaded
+}
+
+func (r *TaskMap) unregisterAliases(task *Task) {
+	for _, alias := range task.opt.Aliases {
+		if v, ok := r.aliases.Load(alias); ok && v.(*Task) == task {
+			r.aliases.Delete(alias)
+		}
+	}
+}
+
+// SetUnknownTaskPolicy sets how the registry handles messages of tasks
+// that are not registered. The default is UnknownTaskRetry.
+func (r *TaskMap) SetUnknownTaskPolicy(policy *UnknownTaskPolicy) {
+	if policy.Action == UnknownTaskHandle && policy.Handler == nil {
+		panic("taskq: UnknownTaskHandle requires UnknownTaskPolicy.Handler")
+	}
+	p := *policy
+	p.init()
+	r.unknown.Store(&p)
+}
+
+func (r *TaskMap) unknownTaskPolicy() *UnknownTaskPolicy {
+	if p, ok := r.unknown.Load().(*UnknownTaskPolicy); ok {
+		return p
+	}
+	return &defaultUnknownTaskPolicy
+}
+
+var defaultUnknownTaskPolicy = UnknownTaskPolicy{
+	Action: UnknownTaskRetry,
+}
+
 func (r *TaskMap) Unregister(task *Task) {
 	r.m.Delete(task.Name())
+	r.unregisterAliases(task)
 }
 
 func (r *TaskMap) Reset() {
 	r.m = sync.Map{}
+	r.aliases = sync.Map{}
 }
 
+// Range calls fn for every registered task. Aliases are not included.
 func (r *TaskMap) Range(fn func(name string, task *Task) bool) {
 	r.m.Range(func(key, value interface{}) bool {
 		return fn(key.(string), value.(*Task))
@@ -59,8 +216,7 @@ func (r *TaskMap) Range(fn func(name string, task *Task) bool) {
 func (r *TaskMap) HandleMessage(msg *Message) error {
 	task := r.Get(msg.TaskName)
 	if task == nil {
-		msg.Delay = r.delay(msg, nil, unknownTaskOpt)
-		return fmt.Errorf("taskq: unknown task=%q", msg.TaskName)
+		return r.handleUnknownTask(msg)
 	}
 
 	opt := task.Options()
@@ -77,12 +233,56 @@ func (r *TaskMap) HandleMessage(msg *Message) error {
 	return msgErr
 }
 
+func (r *TaskMap) handleUnknownTask(msg *Message) error {
+	policy := r.unknownTaskPolicy()
+
+	if msg.Err != nil {
+		// The message failed permanently.
+		if policy.FallbackHandler != nil && policy.Action != UnknownTaskDrop {
+			return policy.FallbackHandler(msg)
+		}
+		return nil
+	}
+
+	err := fmt.Errorf("%w=%q", ErrUnknownTask, msg.TaskName)
+	switch policy.Action {
+	case UnknownTaskRelease:
+		msg.Delay = policy.Delay
+		return err
+	case UnknownTaskDeadLetter, UnknownTaskDrop:
+		msg.Delay = 0
+		return err
+	case UnknownTaskHandle:
+		err = policy.Handler(msg)
+		if err == nil {
+			return nil
+		}
+	}
+
+	msg.Delay = r.delay(msg, err, unknownTaskOpt)
+	return err
+}
+
 func (r *TaskMap) delay(msg *Message, msgErr error, opt *TaskOptions) time.Duration {
-	if msg.ReservedCount >= opt.RetryLimit {
+	retryLimit, minBackoff, maxBackoff := opt.RetryLimit, opt.MinBackoff, opt.MaxBackoff
+	if retry := msg.retry; retry != nil {
+		// Overrides set with Consumer.UpdateOptions.
+		if retry.limit > 0 {
+			retryLimit = retry.limit
+		}
+		if retry.minBackoff > 0 {
+			minBackoff = retry.minBackoff
+		}
+		if retry.maxBackoff > 0 {
+			maxBackoff = retry.maxBackoff
+		}
+	}
+
+	if msg.ReservedCount >= retryLimit {
 		return 0
 	}
 	if delayer, ok := msgErr.(Delayer); ok {
 		return delayer.Delay()
 	}
-	return exponentialBackoff(opt.MinBackoff, opt.MaxBackoff, msg.ReservedCount)
+	return exponentialBackoff(minBackoff, maxBackoff, msg.ReservedCount)
 }
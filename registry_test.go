@@ -0,0 +1,204 @@
+package taskq_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/azsqs"
+	"github.com/frain-dev/taskq/v3/memqueue"
+)
+
+func TestFactoryRegistries(t *testing.T) {
+	ctx := context.Background()
+
+	newSubsystem := func(ch chan string, name string) (taskq.Factory, *taskq.Task) {
+		registry := taskq.NewRegistry()
+		task := registry.RegisterTask(&taskq.TaskOptions{
+			Name: "cleanup",
+			Handler: func() {
+				ch <- name
+			},
+		})
+
+		factory := memqueue.NewFactory()
+		factory.SetRegistry(registry)
+		return factory, task
+	}
+
+	ch := make(chan string, 2)
+	f1, task1 := newSubsystem(ch, "first")
+	f2, task2 := newSubsystem(ch, "second")
+	defer f1.Close()
+	defer f2.Close()
+
+	q1 := f1.RegisterQueue(&taskq.QueueOptions{
+		Name:    "cleanup",
+		Storage: taskq.NewLocalStorage(),
+	})
+	q2 := f2.RegisterQueue(&taskq.QueueOptions{
+		Name:    "cleanup",
+		Storage: taskq.NewLocalStorage(),
+	})
+
+	if err := q1.Add(task1.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q2.Add(task2.WithArgs(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-ch:
+			got[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if !got["first"] || !got["second"] {
+		t.Fatalf("got %v, wanted both subsystems", got)
+	}
+}
+
+func TestAzsqsQueuesWithSameName(t *testing.T) {
+	newQueue := func() taskq.Queue {
+		return azsqs.NewFactory(newFakeSQS(), "fake").RegisterQueue(&taskq.QueueOptions{
+			Name:    "same-name",
+			Storage: taskq.NewLocalStorage(),
+		})
+	}
+
+	q1 := newQueue()
+	defer q1.Close()
+	// Used to panic because internal tasks were registered globally.
+	q2 := newQueue()
+	defer q2.Close()
+}
+
+func TestTryRegisterTask(t *testing.T) {
+	const name = "TestTryRegisterTask"
+
+	task, err := taskq.TryRegisterTask(&taskq.TaskOptions{
+		Name:    name,
+		Handler: func() {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Name() != name {
+		t.Fatalf("got %q, wanted %q", task.Name(), name)
+	}
+
+	_, err = taskq.TryRegisterTask(&taskq.TaskOptions{
+		Name:    name,
+		Handler: func() {},
+	})
+	if !errors.Is(err, taskq.ErrTaskExists) {
+		t.Fatalf("got %v, wanted ErrTaskExists", err)
+	}
+
+	if !taskq.UnregisterTask(name) {
+		t.Fatal("task is not unregistered")
+	}
+	if taskq.UnregisterTask(name) {
+		t.Fatal("task is unregistered twice")
+	}
+	if taskq.Tasks.Get(name) != nil {
+		t.Fatal("task is still registered")
+	}
+
+	_, err = taskq.TryRegisterTask(&taskq.TaskOptions{
+		Name:    name,
+		Handler: func() {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskq.UnregisterTask(name)
+
+	for _, opt := range []*taskq.TaskOptions{
+		{Handler: func() {}},
+		{Name: name},
+		{Name: name, Handler: 42},
+		{Name: name, Handler: func() {}, FallbackHandler: "fallback"},
+	} {
+		if _, err := taskq.TryRegisterTask(opt); err == nil {
+			t.Fatalf("expected an error for %+v", opt)
+		}
+	}
+	if taskq.Tasks.Get(name) != nil {
+		t.Fatal("invalid task is registered")
+	}
+}
+
+func TestTaskAliases(t *testing.T) {
+	ctx := context.Background()
+	registry := taskq.NewRegistry()
+
+	ch := make(chan string, 2)
+	task := registry.RegisterTask(&taskq.TaskOptions{
+		Name:    "send-email",
+		Aliases: []string{"sendEmail"},
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+	if registry.Get("sendEmail") != task {
+		t.Fatal("alias is not registered")
+	}
+
+	_, err := registry.Register(&taskq.TaskOptions{
+		Name:    "sendEmail",
+		Handler: func() {},
+	})
+	if !errors.Is(err, taskq.ErrTaskExists) {
+		t.Fatalf("got %v, wanted ErrTaskExists", err)
+	}
+	_, err = registry.Register(&taskq.TaskOptions{
+		Name:    "notify",
+		Aliases: []string{"send-email"},
+		Handler: func() {},
+	})
+	if !errors.Is(err, taskq.ErrTaskExists) {
+		t.Fatalf("got %v, wanted ErrTaskExists", err)
+	}
+	if registry.Get("notify") != nil {
+		t.Fatal("task with a conflicting alias is registered")
+	}
+
+	q := memqueue.NewQueue(&taskq.QueueOptions{
+		Name:    "aliases",
+		Storage: taskq.NewLocalStorage(),
+		Handler: registry,
+	})
+	defer q.Close()
+
+	// A message added before the task was renamed.
+	msg := taskq.NewMessage(ctx, "old")
+	msg.TaskName = "sendEmail"
+	if err := q.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Add(task.WithArgs(ctx, "new")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-ch:
+			got[s] = true
+		case <-time.After(3 * time.Second):
+			t.Fatalf("got %v, wanted old and new", got)
+		}
+	}
+
+	registry.Unregister(task)
+	if registry.Get("sendEmail") != nil {
+		t.Fatal("alias is still registered")
+	}
+}
@@ -0,0 +1,141 @@
+package taskq
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// ResourceProbe reports whether the process has enough free resources
+// to run more workers. The autoscaler only adds workers when all probes
+// report free resources and removes workers when any probe does not.
+type ResourceProbe interface {
+	FreeResources() bool
+}
+
+// ResourceProbeFunc is an adapter to use ordinary functions as probes.
+type ResourceProbeFunc func() bool
+
+func (fn ResourceProbeFunc) FreeResources() bool {
+	return fn()
+}
+
+// DefaultResourceProbes returns probes that are used when
+// QueueOptions.ResourceProbes is not set.
+func DefaultResourceProbes() []ResourceProbe {
+	return []ResourceProbe{
+		MemoryProbe(0.05),
+		CPUProbe(0.9),
+		SchedLatencyProbe(10 * time.Millisecond),
+		GCProbe(0.25),
+	}
+}
+
+func hasFreeSystemResources(probes []ResourceProbe) bool {
+	for _, probe := range probes {
+		if !probe.FreeResources() {
+			return false
+		}
+	}
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+const schedLatencies = "/sched/latencies:seconds"
+
+type schedLatencyProbe struct {
+	max time.Duration
+
+	mu     sync.Mutex
+	sample [1]metrics.Sample
+	prev   []uint64
+}
+
+// SchedLatencyProbe returns a probe that reports no free resources when
+// 90% of goroutines waited longer than max to be scheduled since the
+// previous call, i.e. the Go scheduler run queues are backed up.
+func SchedLatencyProbe(max time.Duration) ResourceProbe {
+	p := &schedLatencyProbe{max: max}
+	p.sample[0].Name = schedLatencies
+	return p
+}
+
+func (p *schedLatencyProbe) FreeResources() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	metrics.Read(p.sample[:])
+	if p.sample[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return true
+	}
+	hist := p.sample[0].Value.Float64Histogram()
+
+	prev := p.prev
+	p.prev = append(p.prev[:0:0], hist.Counts...)
+	if len(prev) != len(hist.Counts) {
+		return true
+	}
+
+	var total uint64
+	counts := make([]uint64, len(hist.Counts))
+	for i, n := range hist.Counts {
+		counts[i] = n - prev[i]
+		total += counts[i]
+	}
+	if total == 0 {
+		return true
+	}
+
+	// Find the bucket with the 90th percentile.
+	threshold := total - total/10
+	var n uint64
+	for i, count := range counts {
+		n += count
+		if n >= threshold {
+			// Buckets[i] is the lower bound of the bucket.
+			return hist.Buckets[i] < p.max.Seconds()
+		}
+	}
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+type gcProbe struct {
+	max float64
+
+	mu       sync.Mutex
+	lastTime time.Time
+	lastGC   time.Duration
+}
+
+// GCProbe returns a probe that reports no free resources when the garbage
+// collector paused the program for more than the fraction of wall time
+// since the previous call, e.g. 0.25.
+func GCProbe(max float64) ResourceProbe {
+	return &gcProbe{max: max}
+}
+
+func (p *gcProbe) FreeResources() bool {
+	var st runtime.MemStats
+	runtime.ReadMemStats(&st)
+	now := time.Now()
+	pauses := time.Duration(st.PauseTotalNs)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lastTime, lastGC := p.lastTime, p.lastGC
+	p.lastTime, p.lastGC = now, pauses
+	if lastTime.IsZero() {
+		return st.GCCPUFraction < p.max
+	}
+
+	elapsed := now.Sub(lastTime)
+	if elapsed <= 0 {
+		return true
+	}
+	return float64(pauses-lastGC)/float64(elapsed) < p.max
+}
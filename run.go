@@ -0,0 +1,131 @@
+package taskq
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+type runOptions struct {
+	drainTimeout time.Duration
+	signals      []os.Signal
+}
+
+// RunOption configures Run.
+type RunOption func(opt *runOptions)
+
+// WithDrainTimeout sets how long Run waits for in-flight and buffered
+// messages to be processed after a signal. Default is 25 seconds, which
+// fits into the default Kubernetes termination grace period of 30 seconds.
+func WithDrainTimeout(d time.Duration) RunOption {
+	return func(opt *runOptions) {
+		opt.drainTimeout = d
+	}
+}
+
+// WithSignals sets the signals that stop Run. Default is SIGINT and SIGTERM.
+func WithSignals(signals ...os.Signal) RunOption {
+	return func(opt *runOptions) {
+		opt.signals = signals
+	}
+}
+
+// Run starts the consumers of the factory and blocks until SIGINT or SIGTERM
+// is received or the context is done. Then it stops fetching messages,
+// waits up to the drain timeout for the fetched messages to be processed,
+// returns the messages that are still buffered to their queues, and closes
+// the factory:
+//
+//	func main() {
+//		// ... register queues ...
+//		if err := taskq.Run(context.Background(), factory); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// A second signal terminates the process immediately.
+func Run(ctx context.Context, factory Factory, opts ...RunOption) error {
+	opt := runOptions{
+		drainTimeout: 25 * time.Second,
+		signals:      []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+	for _, fn := range opts {
+		fn(&opt)
+	}
+
+	sigCtx, stop := signal.NotifyContext(ctx, opt.signals...)
+	defer stop()
+
+	// Handlers keep the parent context, so they are not canceled
+	// while the messages are drained.
+	if err := factory.StartConsumers(ctx); err != nil && !errors.Is(err, ErrStarted) {
+		return err
+	}
+
+	<-sigCtx.Done()
+	// Restore the default behavior of the signals.
+	stop()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), opt.drainTimeout)
+	defer cancel()
+
+	firstErr := factory.StopConsumers(drainCtx)
+
+	factory.Range(func(q Queue) bool {
+		if c, ok := q.Consumer().(*Consumer); ok {
+			if n := c.releaseBuffered(); n > 0 {
+				internal.Logger.Printf("%s: released %d buffered messages", c, n)
+			}
+		}
+		return true
+	})
+
+	if err := factory.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// releaseBuffered returns the messages that are fetched, but not processed
+// to the queue, so other consumers don't wait for their reservations to
+// expire. Messages without ID, e.g. in memqueue, are not reserved
+// in a backend and are kept.
+func (c *Consumer) releaseBuffered() int {
+	var n int
+	var keep []*Message
+	for {
+		msg := c.buffer.TryGet()
+		if msg == nil {
+			break
+		}
+		if msg.ID == "" {
+			keep = append(keep, msg)
+			continue
+		}
+
+		if msg.Ctx == nil {
+			msg.Ctx = context.Background()
+		}
+		// The message was not attempted, but Release counts it
+		// in backends that track reservations in the message.
+		if msg.ReservedCount > 0 {
+			msg.ReservedCount--
+		}
+		msg.Delay = 0
+		if err := c.q.Release(msg); err != nil {
+			internal.Logger.Printf("task=%q Release failed: %s", msg.TaskName, err)
+			continue
+		}
+		n++
+	}
+
+	for _, msg := range keep {
+		_ = c.buffer.TryPut(msg)
+	}
+	return n
+}
@@ -0,0 +1,64 @@
+package taskq_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	factory := newTestFactory(t)
+
+	started := make(chan struct{}, 1)
+	var processed int32
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "run",
+		Handler: func(ctx context.Context) error {
+			started <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+			if ctx.Err() != nil {
+				t.Errorf("handler context is canceled: %s", ctx.Err())
+			}
+			atomic.AddInt32(&processed, 1)
+			return nil
+		},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "run-queue",
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- taskq.Run(ctx, factory, taskq.WithDrainTimeout(5*time.Second))
+	}()
+
+	if err := q.Add(task.WithArgs(context.Background())); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not processed")
+	}
+
+	// Stop while the message is being processed.
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run is not stopped")
+	}
+
+	if n := atomic.LoadInt32(&processed); n != 1 {
+		t.Fatalf("got %d processed messages, wanted 1", n)
+	}
+}
@@ -0,0 +1,92 @@
+package taskq
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// SQLTx wraps sql.Tx and defers adding messages until the transaction
+// is committed, so messages are not added for data that was rolled back:
+//
+//	tx, err := taskq.BeginSQLTx(ctx, db, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//
+//	// ... tx.ExecContext ...
+//	tx.Add(queue, CountTask.WithArgs(ctx, "arg1"))
+//
+//	return tx.Commit()
+//
+// Messages are lost if the process crashes after the commit and before
+// they are added; use Outbox when that is not acceptable.
+type SQLTx struct {
+	*sql.Tx
+
+	mu      sync.Mutex
+	pending []pendingMessage
+}
+
+type pendingMessage struct {
+	q   Queue
+	msg *Message
+}
+
+// NewSQLTx wraps the transaction.
+func NewSQLTx(tx *sql.Tx) *SQLTx {
+	return &SQLTx{Tx: tx}
+}
+
+// BeginSQLTx starts a transaction and wraps it.
+func BeginSQLTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (*SQLTx, error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLTx(tx), nil
+}
+
+// Add adds the message to the queue when the transaction is committed.
+func (tx *SQLTx) Add(q Queue, msg *Message) {
+	tx.mu.Lock()
+	tx.pending = append(tx.pending, pendingMessage{q: q, msg: msg})
+	tx.mu.Unlock()
+}
+
+// Commit commits the transaction and adds the messages in the order they
+// were passed to Add. Messages are added even if some of them fail; failed
+// messages have Message.Err set and the first error is returned.
+func (tx *SQLTx) Commit() error {
+	pending := tx.takePending()
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, p := range pending {
+		if err := p.q.Add(p.msg); err != nil {
+			p.msg.Err = err
+			if firstErr == nil {
+				firstErr = fmt.Errorf("taskq: transaction is committed, but adding message failed: %w", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Rollback aborts the transaction and drops the messages.
+func (tx *SQLTx) Rollback() error {
+	_ = tx.takePending()
+	return tx.Tx.Rollback()
+}
+
+func (tx *SQLTx) takePending() []pendingMessage {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	pending := tx.pending
+	tx.pending = nil
+	return pending
+}
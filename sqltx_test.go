@@ -0,0 +1,72 @@
+package taskq_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestSQLTx(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("taskq-fake-outbox", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	factory := newTestFactory(t)
+
+	ch := make(chan string, 10)
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "sqltx",
+		Handler: func(s string) {
+			ch <- s
+		},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "sqltx-queue",
+	})
+
+	tx, err := taskq.BeginSQLTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Add(q, task.WithArgs(ctx, "rolled back"))
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = taskq.BeginSQLTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Add(q, task.WithArgs(ctx, "committed"))
+
+	select {
+	case got := <-ch:
+		t.Fatalf("%q is added before commit", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-ch:
+		if got != "committed" {
+			t.Fatalf("got %q, wanted %q", got, "committed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not processed")
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected message %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
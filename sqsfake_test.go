@@ -0,0 +1,376 @@
+package taskq_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// fakeSQS is an in-process SQS that replaces the HTTP transport of the SDK
+// client. It implements only the calls azsqs makes, so benchmarks measure
+// taskq and not the network.
+type fakeSQS struct {
+	mu     sync.Mutex
+	queues map[string]*fakeSQSQueue
+}
+
+type fakeSQSQueue struct {
+	name              string
+	visibilityTimeout time.Duration
+	attrs             map[string]*string
+
+	mu     sync.Mutex
+	msgs   []*fakeSQSMessage
+	byID   map[string]*fakeSQSMessage
+	nextID int
+	notify chan struct{}
+}
+
+type fakeSQSMessage struct {
+	id           string
+	body         *string
+	bodyMD5      *string
+	attrs        map[string]*sqs.MessageAttributeValue
+	visibleAt    time.Time
+	receiveCount int
+	deleted      bool
+}
+
+func newFakeSQS() *sqs.SQS {
+	fake := &fakeSQS{
+		queues: make(map[string]*fakeSQSQueue),
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("fake", "fake", ""),
+	}))
+	client := sqs.New(sess)
+	client.Handlers.Build.Clear()
+	client.Handlers.Sign.Clear()
+	client.Handlers.Send.Clear()
+	client.Handlers.Send.PushBack(fake.send)
+	client.Handlers.ValidateResponse.Clear()
+	client.Handlers.UnmarshalMeta.Clear()
+	client.Handlers.Unmarshal.Clear()
+	client.Handlers.UnmarshalError.Clear()
+	return client
+}
+
+func (f *fakeSQS) send(r *request.Request) {
+	r.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+
+	switch in := r.Params.(type) {
+	case *sqs.CreateQueueInput:
+		out := r.Data.(*sqs.CreateQueueOutput)
+		out.QueueUrl = f.createQueue(in)
+	case *sqs.GetQueueUrlInput:
+		if f.queue(in.QueueName) == nil {
+			r.Error = awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil)
+			return
+		}
+		out := r.Data.(*sqs.GetQueueUrlOutput)
+		out.QueueUrl = in.QueueName
+	case *sqs.GetQueueAttributesInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		out := r.Data.(*sqs.GetQueueAttributesOutput)
+		out.Attributes = q.attributes()
+	case *sqs.SetQueueAttributesInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		q.setAttributes(in.Attributes)
+	case *sqs.SendMessageBatchInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		out := r.Data.(*sqs.SendMessageBatchOutput)
+		out.Successful = q.sendBatch(in.Entries)
+	case *sqs.ReceiveMessageInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		wait := time.Duration(aws.Int64Value(in.WaitTimeSeconds)) * time.Second
+		out := r.Data.(*sqs.ReceiveMessageOutput)
+		out.Messages = q.receive(r, int(aws.Int64Value(in.MaxNumberOfMessages)), wait)
+	case *sqs.DeleteMessageBatchInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		out := r.Data.(*sqs.DeleteMessageBatchOutput)
+		for _, entry := range in.Entries {
+			q.delete(aws.StringValue(entry.ReceiptHandle))
+			out.Successful = append(out.Successful,
+				&sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+	case *sqs.ChangeMessageVisibilityInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		timeout := time.Duration(aws.Int64Value(in.VisibilityTimeout)) * time.Second
+		if !q.changeVisibility(aws.StringValue(in.ReceiptHandle), timeout) {
+			r.Error = awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, "Message does not exist", nil)
+		}
+	case *sqs.PurgeQueueInput:
+		q, ok := f.queueOrError(r, in.QueueUrl)
+		if !ok {
+			return
+		}
+		q.purge()
+	case *sqs.ListQueuesInput:
+		out := r.Data.(*sqs.ListQueuesOutput)
+		out.QueueUrls = f.listQueues(aws.StringValue(in.QueueNamePrefix))
+	case *sqs.DeleteQueueInput:
+		f.mu.Lock()
+		delete(f.queues, aws.StringValue(in.QueueUrl))
+		f.mu.Unlock()
+	default:
+		r.Error = awserr.New("NotImplemented", r.Operation.Name+" is not supported", nil)
+	}
+}
+
+func (f *fakeSQS) createQueue(in *sqs.CreateQueueInput) *string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.StringValue(in.QueueName)
+	if _, ok := f.queues[name]; !ok {
+		q := &fakeSQSQueue{
+			name:              name,
+			visibilityTimeout: 30 * time.Second,
+			attrs:             make(map[string]*string),
+			byID:              make(map[string]*fakeSQSMessage),
+			notify:            make(chan struct{}),
+		}
+		if v, ok := in.Attributes["VisibilityTimeout"]; ok {
+			if secs, err := strconv.Atoi(*v); err == nil {
+				q.visibilityTimeout = time.Duration(secs) * time.Second
+			}
+		}
+		f.queues[name] = q
+	}
+	return aws.String(name)
+}
+
+func (f *fakeSQS) listQueues(prefix string) []*string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var urls []*string
+	for name := range f.queues {
+		if strings.HasPrefix(name, prefix) {
+			urls = append(urls, aws.String("https://sqs.fake/000000000000/"+name))
+		}
+	}
+	return urls
+}
+
+func (f *fakeSQS) queue(url *string) *fakeSQSQueue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queues[aws.StringValue(url)]
+}
+
+func (f *fakeSQS) queueOrError(r *request.Request, url *string) (*fakeSQSQueue, bool) {
+	q := f.queue(url)
+	if q == nil {
+		r.Error = awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil)
+		return nil, false
+	}
+	return q, true
+}
+
+func (q *fakeSQSQueue) attributes() map[string]*string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	attrs := map[string]*string{
+		"QueueArn":                    aws.String("arn:aws:sqs:us-east-1:000000000000:" + q.name),
+		"ApproximateNumberOfMessages": aws.String(strconv.Itoa(len(q.byID))),
+	}
+	for k, v := range q.attrs {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+func (q *fakeSQSQueue) setAttributes(attrs map[string]*string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, v := range attrs {
+		q.attrs[k] = v
+	}
+}
+
+func (q *fakeSQSQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.byID)
+}
+
+func (q *fakeSQSQueue) sendBatch(
+	entries []*sqs.SendMessageBatchRequestEntry,
+) []*sqs.SendMessageBatchResultEntry {
+	now := time.Now()
+	results := make([]*sqs.SendMessageBatchResultEntry, len(entries))
+
+	q.mu.Lock()
+	for i, entry := range entries {
+		q.nextID++
+		msg := &fakeSQSMessage{
+			id:        strconv.Itoa(q.nextID),
+			body:      entry.MessageBody,
+			bodyMD5:   md5Of(entry.MessageBody),
+			attrs:     entry.MessageAttributes,
+			visibleAt: now.Add(time.Duration(aws.Int64Value(entry.DelaySeconds)) * time.Second),
+		}
+		q.msgs = append(q.msgs, msg)
+		q.byID[msg.id] = msg
+		results[i] = &sqs.SendMessageBatchResultEntry{
+			Id:               entry.Id,
+			MessageId:        aws.String(msg.id),
+			MD5OfMessageBody: msg.bodyMD5,
+		}
+	}
+	close(q.notify)
+	q.notify = make(chan struct{})
+	q.mu.Unlock()
+
+	return results
+}
+
+// md5Of returns the checksum the SDK verifies in responses.
+func md5Of(s *string) *string {
+	sum := md5.Sum([]byte(aws.StringValue(s)))
+	return aws.String(hex.EncodeToString(sum[:]))
+}
+
+func (q *fakeSQSQueue) receive(r *request.Request, n int, wait time.Duration) []*sqs.Message {
+	if n <= 0 {
+		n = 1
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		now := time.Now()
+
+		q.mu.Lock()
+		var msgs []*sqs.Message
+		for _, msg := range q.msgs {
+			if len(msgs) == n {
+				break
+			}
+			if msg.deleted || msg.visibleAt.After(now) {
+				continue
+			}
+			msg.receiveCount++
+			msg.visibleAt = now.Add(q.visibilityTimeout)
+			msgs = append(msgs, &sqs.Message{
+				MessageId:         aws.String(msg.id),
+				ReceiptHandle:     aws.String(msg.id + "-" + strconv.Itoa(msg.receiveCount)),
+				Body:              msg.body,
+				MD5OfBody:         msg.bodyMD5,
+				MessageAttributes: msg.attrs,
+				Attributes: map[string]*string{
+					"ApproximateReceiveCount": aws.String(strconv.Itoa(msg.receiveCount)),
+				},
+			})
+		}
+		notify := q.notify
+		q.mu.Unlock()
+
+		if len(msgs) > 0 || !now.Before(deadline) {
+			return msgs
+		}
+
+		// Poll at least every 100ms to notice expired visibility timeouts.
+		timeout := deadline.Sub(now)
+		if timeout > 100*time.Millisecond {
+			timeout = 100 * time.Millisecond
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case <-notify:
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil
+		}
+		timer.Stop()
+	}
+}
+
+// find returns the message received with the receipt handle.
+// The caller must hold the lock.
+func (q *fakeSQSQueue) find(handle string) *fakeSQSMessage {
+	for i := len(handle) - 1; i >= 0; i-- {
+		if handle[i] == '-' {
+			return q.byID[handle[:i]]
+		}
+	}
+	return nil
+}
+
+func (q *fakeSQSQueue) delete(handle string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg := q.find(handle)
+	if msg == nil {
+		return
+	}
+	msg.deleted = true
+	delete(q.byID, msg.id)
+
+	// Drop deleted messages from the head so receive doesn't skip them.
+	for len(q.msgs) > 0 && q.msgs[0].deleted {
+		q.msgs[0] = nil
+		q.msgs = q.msgs[1:]
+	}
+}
+
+func (q *fakeSQSQueue) changeVisibility(handle string, timeout time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg := q.find(handle)
+	if msg == nil {
+		return false
+	}
+	msg.visibleAt = time.Now().Add(timeout)
+	if timeout == 0 {
+		close(q.notify)
+		q.notify = make(chan struct{})
+	}
+	return true
+}
+
+func (q *fakeSQSQueue) purge() {
+	q.mu.Lock()
+	q.msgs = nil
+	q.byID = make(map[string]*fakeSQSMessage)
+	q.mu.Unlock()
+}
@@ -0,0 +1,82 @@
+package taskq
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// Delay of messages returned to the queue by consumers
+// that don't own their ordering keys.
+const stickyRedirectDelay = 100 * time.Millisecond
+
+// stickyRouter assigns ordering keys to the live consumers of the queue
+// using rendezvous hashing, so only the keys of a consumer that joins or
+// leaves are reassigned.
+type stickyRouter struct {
+	members atomic.Value // []string
+}
+
+// refreshSticky updates the consumers of the queue. It is called with every
+// heartbeat, so consumers that crashed own their keys until their
+// heartbeats expire.
+func (c *Consumer) refreshSticky(ctx context.Context) {
+	infos, err := ListConsumers(ctx, c.opt)
+	if err != nil {
+		internal.Logger.Printf("%s: ListConsumers failed: %s", c, err)
+		return
+	}
+
+	members := make([]string, 0, len(infos))
+	for i := range infos {
+		info := &infos[i]
+		if info.Queue == c.q.Name() && info.Group == c.opt.Group {
+			members = append(members, info.ID)
+		}
+	}
+	c.sticky.members.Store(members)
+}
+
+// ownsKey reports whether the consumer owns the ordering key. Consumers
+// own all keys until they see themselves in the list of consumers.
+func (c *Consumer) ownsKey(key string) bool {
+	members, _ := c.sticky.members.Load().([]string)
+
+	var owner string
+	var max uint64
+	var found bool
+	for _, id := range members {
+		if id == c.instanceID {
+			found = true
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(id))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+		if sum := h.Sum64(); owner == "" || sum > max {
+			owner = id
+			max = sum
+		}
+	}
+	return !found || owner == c.instanceID
+}
+
+// redirectSticky returns the message to the queue when the ordering key
+// is owned by another consumer.
+func (c *Consumer) redirectSticky(msg *Message) bool {
+	if !c.opt.StickyOrderingKeys || msg.OrderingKey == "" || c.ownsKey(msg.OrderingKey) {
+		return false
+	}
+
+	// Redirects are not attempts, but Release counts them
+	// in backends that track reservations in the message.
+	if msg.ReservedCount > 0 {
+		msg.ReservedCount--
+	}
+	msg.Delay = stickyRedirectDelay
+	c.release(msg)
+	return true
+}
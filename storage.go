@@ -2,6 +2,7 @@ package taskq
 
 import (
 	"context"
+	"hash/fnv"
 	"sync"
 	"time"
 
@@ -17,36 +18,121 @@ var _ Storage = (*redisStorage)(nil)
 
 // LOCAL
 
+// LocalStorageOptions configures the storage returned by
+// NewLocalStorageWithOptions.
+type LocalStorageOptions struct {
+	// Maximum number of keys kept in memory. The least recently added keys
+	// are evicted first. Default is 128000.
+	MaxSize int
+	// How long a key is considered to exist. Default is 24 hours
+	// like in the Redis storage.
+	TTL time.Duration
+	// Number of shards keys are spread across, each with its own lock,
+	// so concurrent producers don't contend on a single mutex. MaxSize is
+	// split evenly between shards, so eviction order is only kept within
+	// a shard. Default is 16.
+	NumShards int
+}
+
+func (opt *LocalStorageOptions) init() {
+	if opt.MaxSize == 0 {
+		opt.MaxSize = 128000
+	}
+	if opt.TTL == 0 {
+		opt.TTL = 24 * time.Hour
+	}
+	if opt.NumShards == 0 {
+		opt.NumShards = 16
+	}
+	if opt.NumShards > opt.MaxSize {
+		opt.NumShards = opt.MaxSize
+	}
+}
+
 type localStorage struct {
+	opt      LocalStorageOptions
+	shards   []localShard
+	audit    localAudit
+	debounce localDebounce
+	quota    localQuota
+}
+
+type localShard struct {
 	mu    sync.Mutex
 	cache *simplelru.LRU
+	size  int
+	_     [64]byte // avoid false sharing between shards
 }
 
 func NewLocalStorage() Storage {
-	return &localStorage{}
+	return NewLocalStorageWithOptions(nil)
+}
+
+// NewLocalStorageWithOptions returns an in-memory storage that is bounded
+// by the number of keys and forgets keys after the TTL.
+func NewLocalStorageWithOptions(opt *LocalStorageOptions) Storage {
+	s := new(localStorage)
+	if opt != nil {
+		s.opt = *opt
+	}
+	s.opt.init()
+
+	s.shards = make([]localShard, s.opt.NumShards)
+	size := (s.opt.MaxSize + s.opt.NumShards - 1) / s.opt.NumShards
+	for i := range s.shards {
+		s.shards[i].size = size
+	}
+	return s
 }
 
 func (s *localStorage) Exists(_ context.Context, key string) bool {
+	return s.shard(key).exists(key, s.opt.TTL)
+}
+
+func (s *localStorage) shard(key string) *localShard {
+	if len(s.shards) == 1 {
+		return &s.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *localShard) exists(key string, ttl time.Duration) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.cache == nil {
 		var err error
-		s.cache, err = simplelru.NewLRU(128000, nil)
+		s.cache, err = simplelru.NewLRU(s.size, nil)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	_, ok := s.cache.Get(key)
-	if ok {
+	now := time.Now()
+	s.evictExpired(now)
+
+	if v, ok := s.cache.Peek(key); ok && now.Before(v.(time.Time)) {
 		return true
 	}
 
-	s.cache.Add(key, nil)
+	s.cache.Add(key, now.Add(ttl))
 	return false
 }
 
+// evictExpired removes expired keys. Keys are never refreshed
+// so the oldest key always expires first.
+func (s *localShard) evictExpired(now time.Time) {
+	for {
+		_, v, ok := s.cache.GetOldest()
+		if !ok || now.Before(v.(time.Time)) {
+			return
+		}
+		s.cache.RemoveOldest()
+	}
+}
+
 // REDIS
 
 type redisStorage struct {
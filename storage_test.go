@@ -0,0 +1,106 @@
+package taskq_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestLocalStorageConcurrent(t *testing.T) {
+	ctx := context.Background()
+	storage := taskq.NewLocalStorageWithOptions(&taskq.LocalStorageOptions{
+		NumShards: 8,
+	})
+
+	const numKey = 1000
+	const numProducer = 8
+
+	var added uint32
+	var wg sync.WaitGroup
+	for i := 0; i < numProducer; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numKey; j++ {
+				if !storage.Exists(ctx, "key"+strconv.Itoa(j)) {
+					atomic.AddUint32(&added, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if added != numKey {
+		t.Fatalf("got %d new keys, wanted %d", added, numKey)
+	}
+}
+
+func TestLocalStorageMaxSize(t *testing.T) {
+	ctx := context.Background()
+	storage := taskq.NewLocalStorageWithOptions(&taskq.LocalStorageOptions{
+		MaxSize:   4,
+		NumShards: 16,
+	})
+
+	if storage.Exists(ctx, "key") {
+		t.Fatal("new key exists")
+	}
+	if !storage.Exists(ctx, "key") {
+		t.Fatal("added key does not exist")
+	}
+}
+
+func TestLocalStorageAuditLookup(t *testing.T) {
+	testAuditLookup(t, taskq.NewLocalStorage().(taskq.AuditStorage))
+}
+
+func TestRedisStorageAuditLookup(t *testing.T) {
+	opt := &taskq.QueueOptions{
+		Name:  "audit-lookup",
+		Redis: redisRing(),
+	}
+	opt.Init()
+	testAuditLookup(t, opt.Storage.(taskq.AuditStorage))
+}
+
+func testAuditLookup(t *testing.T, storage taskq.AuditStorage) {
+	ctx := context.Background()
+	const queue = "audit-lookup"
+
+	enqueuedAt := time.Now().Truncate(time.Millisecond)
+	err := storage.AuditEnqueued(ctx, queue, taskq.AuditRecord{
+		ID:         "id1",
+		TaskName:   "task",
+		EnqueuedAt: enqueuedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := storage.AuditLookup(ctx, queue, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil {
+		t.Fatal("record not found")
+	}
+	if rec.ID != "id1" || rec.TaskName != "task" || !rec.EnqueuedAt.Equal(enqueuedAt) {
+		t.Fatalf("got %+v", rec)
+	}
+
+	if rec, err := storage.AuditLookup(ctx, "other-queue", "id1"); err != nil || rec != nil {
+		t.Fatalf("other queue: got %+v, %v", rec, err)
+	}
+
+	if err := storage.AuditFinished(ctx, queue, "id1"); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := storage.AuditLookup(ctx, queue, "id1"); err != nil || rec != nil {
+		t.Fatalf("finished message: got %+v, %v", rec, err)
+	}
+}
@@ -4,29 +4,267 @@
 package taskq
 
 import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/capnm/sysinfo"
 )
 
-func hasFreeSystemResources() bool {
-	si := sysinfo.Get()
-	free := si.FreeRam + si.BufferRam
+const cgroupRoot = "/sys/fs/cgroup"
 
-	// at least 100MB of RAM is free
-	if free < 1e5 {
-		return false
+// Limits larger than this mean there is no limit in cgroup v1.
+const cgroupNoLimit = 1 << 62
+
+// cgroupFile returns the path of the file of the cgroup controller the
+// process belongs to. Use an empty controller for cgroup v2.
+func cgroupFile(controller, name string) string {
+	var dirs []string
+	if path, ok := cgroupPath(controller); ok {
+		if controller == "" {
+			dirs = append(dirs, filepath.Join(cgroupRoot, path))
+		} else {
+			dirs = append(dirs, filepath.Join(cgroupRoot, controller, path))
+		}
+	}
+	// Containers usually see their own cgroup as the root.
+	dirs = append(dirs, filepath.Join(cgroupRoot, controller))
+
+	for _, dir := range dirs {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
 	}
+	return ""
+}
 
-	// at least 5% of RAM is free
-	if float64(free)/float64(si.TotalRam) < 0.05 {
-		return false
+// cgroupPath parses /proc/self/cgroup, e.g. "4:memory:/docker/id"
+// or "0::/docker/id" for cgroup v2.
+func cgroupPath(controller string) (string, bool) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", false
 	}
+	defer f.Close()
 
-	// avg load is not too high
-	if si.Loads[0] > 1.5*float64(runtime.NumCPU()) {
-		return false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == controller {
+				return parts[2], true
+			}
+		}
 	}
+	return "", false
+}
 
-	return true
+func readCgroupFile(controller, name string) (string, bool) {
+	file := cgroupFile(controller, name)
+	if file == "" {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(b)), true
+}
+
+func readCgroupUint(controller, name string) (uint64, bool) {
+	s, ok := readCgroupFile(controller, name)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// readCgroupStat returns the value of the key in a stat file,
+// e.g. "usage_usec" in cpu.stat.
+func readCgroupStat(controller, name, key string) (uint64, bool) {
+	s, ok := readCgroupFile(controller, name)
+	if !ok {
+		return 0, false
+	}
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+//------------------------------------------------------------------------------
+
+// MemoryProbe returns a probe that reports no free resources when less than
+// the fraction of memory is free, e.g. 0.05. The memory limit of the cgroup
+// is used when it is set, e.g. in containers, and RAM size otherwise.
+func MemoryProbe(minFree float64) ResourceProbe {
+	return ResourceProbeFunc(func() bool {
+		if limit, usage, ok := cgroupMemory(); ok {
+			if usage >= limit {
+				return false
+			}
+			return float64(limit-usage)/float64(limit) >= minFree
+		}
+
+		si := sysinfo.Get()
+		free := si.FreeRam + si.BufferRam
+
+		// at least 100MB of RAM is free
+		if free < 1e5 {
+			return false
+		}
+		return float64(free)/float64(si.TotalRam) >= minFree
+	})
+}
+
+// cgroupMemory returns the memory limit and the usage excluding
+// inactive page cache that the kernel can reclaim.
+func cgroupMemory() (limit, usage uint64, ok bool) {
+	// cgroup v2
+	if s, ok := readCgroupFile("", "memory.max"); ok {
+		if s == "max" {
+			return 0, 0, false
+		}
+		limit, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		usage, ok := readCgroupUint("", "memory.current")
+		if !ok {
+			return 0, 0, false
+		}
+		inactive, _ := readCgroupStat("", "memory.stat", "inactive_file")
+		return limit, subUint64(usage, inactive), true
+	}
+
+	// cgroup v1
+	limit, ok = readCgroupUint("memory", "memory.limit_in_bytes")
+	if !ok || limit >= cgroupNoLimit {
+		return 0, 0, false
+	}
+	usage, ok = readCgroupUint("memory", "memory.usage_in_bytes")
+	if !ok {
+		return 0, 0, false
+	}
+	inactive, _ := readCgroupStat("memory", "memory.stat", "total_inactive_file")
+	return limit, subUint64(usage, inactive), true
+}
+
+func subUint64(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+//------------------------------------------------------------------------------
+
+type cpuProbe struct {
+	maxUsage float64
+
+	mu        sync.Mutex
+	lastTime  time.Time
+	lastUsage time.Duration
+}
+
+// CPUProbe returns a probe that reports no free resources when the process
+// cgroup used more than the fraction of its CPU quota since the previous
+// call, e.g. 0.9. Without cgroup accounting the load average is compared
+// with the number of CPUs.
+func CPUProbe(maxUsage float64) ResourceProbe {
+	return &cpuProbe{maxUsage: maxUsage}
+}
+
+func (p *cpuProbe) FreeResources() bool {
+	usage, ok := cgroupCPUUsage()
+	if !ok {
+		si := sysinfo.Get()
+		// avg load is not too high
+		return si.Loads[0] <= 1.5*float64(runtime.NumCPU())
+	}
+	now := time.Now()
+
+	p.mu.Lock()
+	lastTime, lastUsage := p.lastTime, p.lastUsage
+	p.lastTime, p.lastUsage = now, usage
+	p.mu.Unlock()
+
+	elapsed := now.Sub(lastTime)
+	if lastTime.IsZero() || elapsed <= 0 {
+		return true
+	}
+
+	used := float64(usage-lastUsage) / float64(elapsed)
+	return used/cgroupCPULimit() <= p.maxUsage
+}
+
+// cgroupCPULimit returns the number of CPUs the cgroup can use.
+func cgroupCPULimit() float64 {
+	numCPU := float64(runtime.NumCPU())
+
+	// cgroup v2, e.g. "max 100000" or "50000 100000".
+	if s, ok := readCgroupFile("", "cpu.max"); ok {
+		fields := strings.Fields(s)
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+				return minFloat64(quota/period, numCPU)
+			}
+		}
+		return numCPU
+	}
+
+	// cgroup v1
+	s, ok := readCgroupFile("cpu", "cpu.cfs_quota_us")
+	if !ok {
+		return numCPU
+	}
+	quota, err := strconv.ParseFloat(s, 64)
+	if err != nil || quota <= 0 {
+		return numCPU
+	}
+	period, ok := readCgroupUint("cpu", "cpu.cfs_period_us")
+	if !ok || period == 0 {
+		return numCPU
+	}
+	return minFloat64(quota/float64(period), numCPU)
+}
+
+// cgroupCPUUsage returns the total CPU time used by the cgroup.
+func cgroupCPUUsage() (time.Duration, bool) {
+	// cgroup v2
+	if usec, ok := readCgroupStat("", "cpu.stat", "usage_usec"); ok {
+		return time.Duration(usec) * time.Microsecond, true
+	}
+	// cgroup v1
+	if ns, ok := readCgroupUint("cpuacct", "cpuacct.usage"); ok {
+		return time.Duration(ns), true
+	}
+	return 0, false
+}
+
+func minFloat64(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
 }
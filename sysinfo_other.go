@@ -3,6 +3,20 @@
 
 package taskq
 
-func hasFreeSystemResources() bool {
-	return true
+// MemoryProbe returns a probe that reports no free resources when less than
+// the fraction of memory is free. It always reports free resources on
+// platforms other than Linux.
+func MemoryProbe(minFree float64) ResourceProbe {
+	return ResourceProbeFunc(func() bool {
+		return true
+	})
+}
+
+// CPUProbe returns a probe that reports no free resources when more than
+// the fraction of CPU is used. It always reports free resources on
+// platforms other than Linux.
+func CPUProbe(maxUsage float64) ResourceProbe {
+	return ResourceProbeFunc(func() bool {
+		return true
+	})
 }
@@ -2,6 +2,7 @@ package taskq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -22,6 +23,9 @@ func SetUnknownTaskOptions(opt *TaskOptions) {
 type TaskOptions struct {
 	// Task name.
 	Name string
+	// Optional names the task also accepts, e.g. the old name of a renamed
+	// task, so messages added under that name are still processed.
+	Aliases []string
 
 	// Function called to process a message.
 	// There are three permitted types of signature:
@@ -32,8 +36,16 @@ type TaskOptions struct {
 	// If the handler takes a Context, when it is invoked it will be passed the same Context as that which was passed to
 	// `StartConsumer`. If the handler returns a non-nil error the message processing will fail and will be retried/.
 	Handler interface{}
+	// Optional function that creates the Handler from the dependencies of
+	// the queue that processes the message (see QueueOptions.Deps), so
+	// handlers don't need global state. Handlers are created once for
+	// every comparable dependencies value. Overrides Handler.
+	HandlerFactory func(deps interface{}) interface{}
 	// Function called to process failed message after the specified number of retries have all failed.
-	// The FallbackHandler accepts the same types of function as the Handler.
+	// The FallbackHandler accepts the same types of function as the Handler and
+	// `func(ctx context.Context, msg *Message, err error)` that optionally returns an error.
+	// The latter receives the error of the last attempt and can use msg.ReservedCount
+	// to get the number of attempts.
 	FallbackHandler interface{}
 
 	// Optional function used by Consumer with defer statement
@@ -54,6 +66,28 @@ type TaskOptions struct {
 	inited bool
 }
 
+func (opt *TaskOptions) validate() error {
+	if opt.Name == "" {
+		return errors.New("taskq: TaskOptions.Name is required")
+	}
+	if opt.HandlerFactory == nil {
+		if err := checkHandler(opt.Handler); err != nil {
+			return fmt.Errorf("taskq: task=%q: Handler: %w", opt.Name, err)
+		}
+	}
+	for _, alias := range opt.Aliases {
+		if alias == "" {
+			return fmt.Errorf("taskq: task=%q: alias is empty", opt.Name)
+		}
+	}
+	if opt.FallbackHandler != nil {
+		if err := checkHandler(opt.FallbackHandler); err != nil {
+			return fmt.Errorf("taskq: task=%q: FallbackHandler: %w", opt.Name, err)
+		}
+	}
+	return nil
+}
+
 func (opt *TaskOptions) init() {
 	if opt.inited {
 		return
@@ -90,6 +124,18 @@ func RegisterTask(opt *TaskOptions) *Task {
 	return task
 }
 
+// TryRegisterTask is like RegisterTask, but returns an error instead of
+// panicking, e.g. when the task with such name already exists.
+func TryRegisterTask(opt *TaskOptions) (*Task, error) {
+	return Tasks.Register(opt)
+}
+
+// UnregisterTask unregisters the task with the name from the global
+// Tasks registry and reports whether it was registered.
+func UnregisterTask(name string) bool {
+	return Tasks.UnregisterTask(name)
+}
+
 func (t *Task) Name() string {
 	return t.opt.Name
 }
@@ -117,3 +163,30 @@ func (t *Task) WithArgs(ctx context.Context, args ...interface{}) *Message {
 	msg.TaskName = t.opt.Name
 	return msg
 }
+
+// Invoke runs the handler synchronously with the args passed through
+// the same path as messages processed by a consumer: the args are encoded
+// and decoded using the wire format and DeferFunc is called. It is intended
+// for unit testing handlers without a queue.
+func (t *Task) Invoke(ctx context.Context, args ...interface{}) error {
+	encoded := AcquireMessage(ctx, args...)
+	defer ReleaseMessage(encoded)
+	encoded.TaskName = t.opt.Name
+
+	b, err := encoded.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	msg := new(Message)
+	if err := msg.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	msg.Ctx = ctx
+	msg.ReservedCount = 1
+
+	if t.opt.DeferFunc != nil {
+		defer t.opt.DeferFunc()
+	}
+	return t.HandleMessage(msg)
+}
@@ -23,12 +23,104 @@ func SetLogger(logger *log.Logger) {
 // It is implemented in subpackages memqueue, azsqs, and ironmq.
 type Factory interface {
 	RegisterQueue(*QueueOptions) Queue
+	// UnregisterQueue stops the consumer, closes the queue, and deletes
+	// the queue with its messages from the backend. Queues registered
+	// after StartConsumers start consuming immediately.
+	UnregisterQueue(name string) error
+	// Discover returns names of the queues that exist in the backend.
+	// When register is not nil, it is called for every queue that is not
+	// registered yet and the queue is registered with the returned options
+	// unless they are nil.
+	Discover(ctx context.Context, register func(name string) *QueueOptions) ([]string, error)
 	Range(func(Queue) bool)
+	// SetRegistry sets the task registry that is used by queues registered
+	// without QueueOptions.Handler instead of the global Tasks registry.
+	SetRegistry(r *TaskMap)
+	// Stats returns stats of every registered queue and their totals.
+	Stats() *FactoryStats
+	// OnQueueRegister adds a hook that is called for every queue
+	// registered in the factory.
+	OnQueueRegister(fn func(Queue))
+	// OnConsumerStart adds a hook that is called for every consumer
+	// started by StartConsumers or by registering a queue after it.
+	OnConsumerStart(fn func(Queue))
+	// OnConsumerStop adds a hook that is called for every consumer
+	// stopped by StopConsumers.
+	OnConsumerStop(fn func(Queue))
 	StartConsumers(context.Context) error
-	StopConsumers() error
+	// StopConsumers stops consumers of the registered queues waiting
+	// for them to finish processing current messages until the context is done.
+	StopConsumers(ctx context.Context) error
+	// PauseConsumers pauses consumers of the registered queues on every
+	// node that shares the Redis client. See PauseConsumers.
+	PauseConsumers(ctx context.Context, d time.Duration) error
+	// ResumeConsumers resumes consumers paused with PauseConsumers.
+	ResumeConsumers(ctx context.Context) error
 	Close() error
 }
 
+// FactoryStats is a merged view of the queues registered in a factory.
+type FactoryStats struct {
+	Queues []QueueStats
+	// Total number of messages in the queues.
+	Len int
+	// Totals of the consumer stats. Timing is the average
+	// weighted by the number of processed messages.
+	Consumer ConsumerStats
+}
+
+// BatchStats describes batches of messages sent to the backend,
+// e.g. deletes batched by azsqs and ironmq.
+type BatchStats struct {
+	// Number of sent batches.
+	Batches uint64
+	// Number of messages in the sent batches.
+	Messages uint64
+	// Average time from adding the first message to a batch
+	// until the batch is sent.
+	Wait time.Duration
+	// Average duration of sending a batch.
+	Timing time.Duration
+}
+
+// AvgSize returns the average number of messages in a batch.
+func (s *BatchStats) AvgSize() float64 {
+	if s.Batches == 0 {
+		return 0
+	}
+	return float64(s.Messages) / float64(s.Batches)
+}
+
+type QueueStats struct {
+	Name string
+	// Number of messages in the queue or -1 if the backend can't tell.
+	Len      int
+	Consumer ConsumerStats
+}
+
+// Add adds the queue stats to the totals.
+func (s *FactoryStats) Add(qs QueueStats) {
+	s.Queues = append(s.Queues, qs)
+	if qs.Len > 0 {
+		s.Len += qs.Len
+	}
+
+	c := &s.Consumer
+	if n := c.Processed + qs.Consumer.Processed; n > 0 {
+		c.Timing = (c.Timing*time.Duration(c.Processed) +
+			qs.Consumer.Timing*time.Duration(qs.Consumer.Processed)) / time.Duration(n)
+	}
+	c.NumWorker += qs.Consumer.NumWorker
+	c.NumFetcher += qs.Consumer.NumFetcher
+	c.BufferSize += qs.Consumer.BufferSize
+	c.Buffered += qs.Consumer.Buffered
+	c.InFlight += qs.Consumer.InFlight
+	c.Processed += qs.Consumer.Processed
+	c.Retries += qs.Consumer.Retries
+	c.Fails += qs.Consumer.Fails
+	c.Duplicates += qs.Consumer.Duplicates
+}
+
 type Redis interface {
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
 	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
@@ -0,0 +1,181 @@
+package taskqtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// Clock is a mock taskq.Clock. Time only moves when Advance is called,
+// which fires the timers and tickers that are due.
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*mockTimer
+}
+
+var _ taskq.Clock = (*Clock)(nil)
+
+// NewClock returns a mock clock set to the time.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{
+		now: now,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (c *Clock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+func (c *Clock) NewTimer(d time.Duration) taskq.Timer {
+	return c.newTimer(d, 0)
+}
+
+func (c *Clock) NewTicker(d time.Duration) taskq.Ticker {
+	if d <= 0 {
+		panic("taskqtest: non-positive interval for NewTicker")
+	}
+	return mockTicker{c.newTimer(d, d)}
+}
+
+func (c *Clock) newTimer(d, period time.Duration) *mockTimer {
+	t := &mockTimer{
+		clock:  c,
+		ch:     make(chan time.Time, 1),
+		period: period,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires due timers in order.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		t := c.next()
+		if t == nil || t.when.After(end) {
+			break
+		}
+		c.now = t.when
+		c.remove(t)
+		t.fire(c.now)
+		if t.period > 0 {
+			c.add(t, t.period)
+		}
+	}
+	c.now = end
+}
+
+// BlockUntil blocks until there are at least n active timers and tickers,
+// e.g. to wait until a goroutine under test is sleeping before Advance.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Len returns the number of active timers and tickers.
+func (c *Clock) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (c *Clock) next() *mockTimer {
+	if len(c.timers) == 0 {
+		return nil
+	}
+	return c.timers[0]
+}
+
+func (c *Clock) add(t *mockTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		t.fire(c.now)
+		return
+	}
+	t.active = true
+	i := sort.Search(len(c.timers), func(i int) bool {
+		return c.timers[i].when.After(t.when)
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	c.cond.Broadcast()
+}
+
+func (c *Clock) remove(t *mockTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+type mockTimer struct {
+	clock  *Clock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+	active bool
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+// fire sends the time without blocking like runtime timers do.
+func (t *mockTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func (t *mockTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.add(t, d)
+	return active
+}
+
+type mockTicker struct {
+	*mockTimer
+}
+
+func (t mockTicker) Stop() {
+	t.mockTimer.Stop()
+}
@@ -0,0 +1,70 @@
+package taskqtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3/taskqtest"
+)
+
+func TestClockTimer(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := taskqtest.NewClock(start)
+
+	timer := clock.NewTimer(time.Minute)
+	clock.Advance(59 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case tm := <-timer.C():
+		if !tm.Equal(start.Add(time.Minute)) {
+			t.Fatalf("got %s, wanted %s", tm, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+
+	if timer.Stop() {
+		t.Fatal("fired timer is still active")
+	}
+}
+
+func TestClockTicker(t *testing.T) {
+	clock := taskqtest.NewClock(time.Now())
+
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		select {
+		case <-ticker.C():
+		default:
+			t.Fatalf("ticker did not fire on tick %d", i)
+		}
+	}
+}
+
+func TestClockSleep(t *testing.T) {
+	clock := taskqtest.NewClock(time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Hour)
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sleep did not return")
+	}
+}
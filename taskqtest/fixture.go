@@ -0,0 +1,27 @@
+package taskqtest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// ReplayFixture processes messages recorded with taskq.FixtureRecorder
+// using the consumer in the recorded order. It returns the first error
+// returned by a handler.
+func ReplayFixture(c *taskq.Consumer, path string) error {
+	msgs, err := taskq.LoadFixture(path)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, msg := range msgs {
+		msg.Ctx = context.Background()
+		if err := c.Process(msg); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("taskqtest: message #%d (task=%q): %w", i, msg.TaskName, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,52 @@
+package taskqtest_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/taskqtest"
+)
+
+func TestReplayFixture(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.fixture")
+
+	var got []string
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "test-fixture-task",
+		Handler: func(s string) {
+			got = append(got, s)
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	rec, err := taskq.CreateFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := taskqtest.NewQueue(&taskq.QueueOptions{Name: "test-record"})
+	q.Consumer().AddHook(rec)
+
+	long := strings.Repeat("x", 1024)
+	_ = q.Add(task.WithArgs(ctx, "hello"))
+	_ = q.Add(task.WithArgs(ctx, long))
+	if err := q.DeliverAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got = nil
+	replay := taskqtest.NewQueue(&taskq.QueueOptions{Name: "test-replay"})
+	if err := taskqtest.ReplayFixture(replay.Consumer().(*taskq.Consumer), path); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "hello" || got[1] != long {
+		t.Fatalf("got %q, wanted replayed messages", got)
+	}
+}
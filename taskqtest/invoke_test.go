@@ -0,0 +1,50 @@
+package taskqtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+type point struct {
+	X, Y int
+}
+
+type ctxKey struct{}
+
+func TestTaskInvoke(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	var got point
+	var deferred bool
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "test-invoke-task",
+		Handler: func(ctx context.Context, p point) error {
+			if ctx.Value(ctxKey{}) != "value" {
+				return errors.New("context is not passed")
+			}
+			got = p
+			return nil
+		},
+		DeferFunc: func() {
+			deferred = true
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	if err := task.Invoke(ctx, point{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got != (point{X: 1, Y: 2}) {
+		t.Fatalf("got %v, wanted {1 2}", got)
+	}
+	if !deferred {
+		t.Fatal("DeferFunc is not called")
+	}
+
+	if err := task.Invoke(ctx, "not a point"); err == nil {
+		t.Fatal("got nil, wanted decoding error")
+	}
+}
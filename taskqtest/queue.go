@@ -0,0 +1,244 @@
+// Package taskqtest provides an in-memory fake queue for unit tests.
+// The fake records added messages and processes them only when asked to
+// with DeliverNext or DeliverAll, so tests don't need running consumers.
+package taskqtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/internal"
+)
+
+// ErrEmpty is returned by DeliverNext when there are no pending messages.
+// It is the same error as taskq.ErrQueueEmpty.
+var ErrEmpty = taskq.ErrQueueEmpty
+
+// Queue is a fake queue that keeps messages in memory.
+type Queue struct {
+	opt      *taskq.QueueOptions
+	consumer *taskq.Consumer
+
+	mu      sync.Mutex
+	added   []*taskq.Message
+	pending []*taskq.Message
+}
+
+var _ taskq.Queue = (*Queue)(nil)
+
+// NewQueue returns a fake queue. The consumer of the queue is not started;
+// messages are processed with DeliverNext and DeliverAll.
+func NewQueue(opt *taskq.QueueOptions) *Queue {
+	opt.Init()
+	q := &Queue{
+		opt: opt,
+	}
+	q.consumer = taskq.NewConsumer(q)
+	return q
+}
+
+func (q *Queue) Name() string {
+	return q.opt.Name
+}
+
+func (q *Queue) String() string {
+	return fmt.Sprintf("queue=%q", q.Name())
+}
+
+func (q *Queue) Options() *taskq.QueueOptions {
+	return q.opt
+}
+
+func (q *Queue) Consumer() taskq.QueueConsumer {
+	return q.consumer
+}
+
+// Len returns the number of pending messages.
+func (q *Queue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending), nil
+}
+
+// Add records the message and appends it to the pending messages.
+func (q *Queue) Add(msg *taskq.Message) error {
+	if msg.TaskName == "" {
+		return internal.ErrTaskNameRequired
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.added = append(q.added, msg)
+	q.pending = append(q.pending, msg)
+	return nil
+}
+
+// AddN adds messages one by one. It returns the first error.
+func (q *Queue) AddN(_ context.Context, msgs ...*taskq.Message) error {
+	var firstErr error
+	for _, msg := range msgs {
+		if err := q.Add(msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (q *Queue) ReserveN(_ context.Context, _ int, _ time.Duration) ([]taskq.Message, error) {
+	return nil, taskq.ErrNotSupported
+}
+
+// Release appends the message back to the pending messages.
+func (q *Queue) Release(msg *taskq.Message) error {
+	// Shallow copy.
+	clone := *msg
+	clone.Err = nil
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, &clone)
+	return nil
+}
+
+func (q *Queue) Delete(msg *taskq.Message) error {
+	return nil
+}
+
+// Purge removes pending messages.
+func (q *Queue) Purge() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = nil
+	return nil
+}
+
+func (q *Queue) Close() error {
+	return nil
+}
+
+func (q *Queue) CloseTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// Reset forgets added and pending messages.
+func (q *Queue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.added = nil
+	q.pending = nil
+}
+
+// Messages returns all messages added to the queue in order.
+// Released messages are not included.
+func (q *Queue) Messages() []*taskq.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*taskq.Message(nil), q.added...)
+}
+
+// Enqueued returns messages of the task added to the queue.
+func (q *Queue) Enqueued(task *taskq.Task) []*taskq.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var msgs []*taskq.Message
+	for _, msg := range q.added {
+		if msg.TaskName == task.Name() {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// DeliverNext processes the next pending message ignoring its delay and
+// returns the handler error. Failed messages that can be retried are
+// appended back to the pending messages.
+func (q *Queue) DeliverNext() error {
+	q.mu.Lock()
+	if len(q.pending) == 0 {
+		q.mu.Unlock()
+		return ErrEmpty
+	}
+	msg := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	q.mu.Unlock()
+
+	msg.Delay = 0
+	msg.ReservedCount++
+	return q.consumer.Process(msg)
+}
+
+// nextDelay returns the delay of the next pending message.
+func (q *Queue) nextDelay() (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return 0, false
+	}
+	return q.pending[0].Delay, true
+}
+
+// DeliverAll processes pending messages including retries until the queue
+// is empty. It returns the first handler error.
+func (q *Queue) DeliverAll() error {
+	var firstErr error
+	for {
+		err := q.DeliverNext()
+		if err == ErrEmpty {
+			return firstErr
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+}
+
+// AssertEnqueued fails the test unless a message of the task with
+// the args was added to the queue. Args are compared in their
+// msgpack encoding.
+func (q *Queue) AssertEnqueued(t testing.TB, task *taskq.Task, args ...interface{}) {
+	t.Helper()
+	if !q.enqueued(task, args) {
+		t.Errorf("taskqtest: %s with args=%v is not enqueued in %s", task, args, q)
+	}
+}
+
+// AssertNotEnqueued fails the test if a message of the task with
+// the args was added to the queue.
+func (q *Queue) AssertNotEnqueued(t testing.TB, task *taskq.Task, args ...interface{}) {
+	t.Helper()
+	if q.enqueued(task, args) {
+		t.Errorf("taskqtest: %s with args=%v is enqueued in %s", task, args, q)
+	}
+}
+
+// AssertEnqueuedCount fails the test unless n messages
+// of the task were added to the queue.
+func (q *Queue) AssertEnqueuedCount(t testing.TB, task *taskq.Task, n int) {
+	t.Helper()
+	if got := len(q.Enqueued(task)); got != n {
+		t.Errorf("taskqtest: %s is enqueued %d times in %s, wanted %d", task, got, q, n)
+	}
+}
+
+func (q *Queue) enqueued(task *taskq.Task, args []interface{}) bool {
+	want, err := msgpack.Marshal(args)
+	if err != nil {
+		return false
+	}
+	for _, msg := range q.Enqueued(task) {
+		b, err := msg.MarshalArgs()
+		if err == nil && bytes.Equal(b, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package taskqtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/taskqtest"
+)
+
+func TestAssertEnqueued(t *testing.T) {
+	ctx := context.Background()
+	q := taskqtest.NewQueue(&taskq.QueueOptions{Name: "test-assert"})
+
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:    "test-assert-task",
+		Handler: func(string, int) {},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	if err := q.Add(task.WithArgs(ctx, "hello", 42)); err != nil {
+		t.Fatal(err)
+	}
+
+	q.AssertEnqueued(t, task, "hello", 42)
+	q.AssertNotEnqueued(t, task, "hello", 43)
+	q.AssertEnqueuedCount(t, task, 1)
+
+	if n, _ := q.Len(); n != 1 {
+		t.Fatalf("got %d pending messages, wanted 1", n)
+	}
+}
+
+func TestDeliverNext(t *testing.T) {
+	ctx := context.Background()
+	q := taskqtest.NewQueue(&taskq.QueueOptions{Name: "test-deliver"})
+
+	var got []string
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "test-deliver-task",
+		Handler: func(s string) {
+			got = append(got, s)
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	_ = q.Add(task.WithArgs(ctx, "a"))
+	_ = q.Add(task.WithArgs(ctx, "b"))
+	if len(got) != 0 {
+		t.Fatalf("messages are processed before delivery: %v", got)
+	}
+
+	if err := q.DeliverNext(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "a" {
+		t.Fatalf("got %v, wanted [a]", got)
+	}
+
+	if err := q.DeliverAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1] != "b" {
+		t.Fatalf("got %v, wanted [a b]", got)
+	}
+
+	if err := q.DeliverNext(); err != taskqtest.ErrEmpty {
+		t.Fatalf("got %v, wanted ErrEmpty", err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	ctx := context.Background()
+	q := taskqtest.NewQueue(&taskq.QueueOptions{Name: "test-retries"})
+
+	var count int
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:       "test-retries-task",
+		RetryLimit: 3,
+		Handler: func() error {
+			count++
+			return errors.New("fake error")
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	_ = q.Add(task.WithArgs(ctx))
+
+	if err := q.DeliverAll(); err == nil {
+		t.Fatal("got nil, wanted handler error")
+	}
+	if count != 3 {
+		t.Fatalf("handler is called %d times, wanted 3", count)
+	}
+}
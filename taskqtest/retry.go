@@ -0,0 +1,103 @@
+package taskqtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+// RetrySimulation describes a message driven through its retry schedule
+// by SimulateRetries.
+type RetrySimulation struct {
+	// Number of times the handler was called.
+	Attempts int
+	// Errors returned by the handler on each attempt.
+	Errors []error
+	// Delays before each retry.
+	Delays []time.Duration
+	// Virtual time elapsed from the first attempt to the last one.
+	Elapsed time.Duration
+	// Error returned by the last attempt or nil if the message was processed.
+	Err error
+}
+
+// Failed reports whether the message failed after all retries
+// and was passed to the FallbackHandler.
+func (s *RetrySimulation) Failed() bool {
+	return s.Err != nil
+}
+
+// SimulateRetries processes a message of the task with the args and retries
+// it until it is processed or fails permanently. Retry delays are not waited
+// for, but advance a mock clock, so the whole schedule runs instantly.
+func SimulateRetries(ctx context.Context, task *taskq.Task, args ...interface{}) *RetrySimulation {
+	registry := taskq.NewRegistry()
+	registry.RegisterTask(task.Options())
+
+	clock := NewClock(time.Now())
+	q := NewQueue(&taskq.QueueOptions{
+		Name:    "simulate-retries",
+		Handler: registry,
+		Clock:   clock,
+	})
+
+	start := clock.Now()
+	_ = q.Add(task.WithArgs(ctx, args...))
+
+	sim := new(RetrySimulation)
+	for {
+		err := q.DeliverNext()
+		if err == ErrEmpty {
+			break
+		}
+		sim.Attempts++
+		sim.Errors = append(sim.Errors, err)
+		sim.Err = err
+
+		if d, ok := q.nextDelay(); ok {
+			sim.Delays = append(sim.Delays, d)
+			clock.Advance(d)
+		}
+	}
+	sim.Elapsed = clock.Now().Sub(start)
+
+	return sim
+}
+
+// AssertDelays fails the test unless the retry delays match.
+func (s *RetrySimulation) AssertDelays(t testing.TB, delays ...time.Duration) {
+	t.Helper()
+	if len(s.Delays) != len(delays) {
+		t.Errorf("taskqtest: got delays %v, wanted %v", s.Delays, delays)
+		return
+	}
+	for i := range delays {
+		if s.Delays[i] != delays[i] {
+			t.Errorf("taskqtest: got delays %v, wanted %v", s.Delays, delays)
+			return
+		}
+	}
+}
+
+// AssertProcessed fails the test unless the message was eventually processed.
+func (s *RetrySimulation) AssertProcessed(t testing.TB) {
+	t.Helper()
+	if s.Failed() {
+		t.Errorf("taskqtest: message failed after %d attempts: %s", s.Attempts, s.Err)
+	}
+}
+
+// AssertFailed fails the test unless the message failed permanently
+// after the attempts.
+func (s *RetrySimulation) AssertFailed(t testing.TB, attempts int) {
+	t.Helper()
+	if !s.Failed() {
+		t.Errorf("taskqtest: message is processed after %d attempts", s.Attempts)
+		return
+	}
+	if s.Attempts != attempts {
+		t.Errorf("taskqtest: message failed after %d attempts, wanted %d", s.Attempts, attempts)
+	}
+}
@@ -0,0 +1,91 @@
+package taskqtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+	"github.com/frain-dev/taskq/v3/taskqtest"
+)
+
+func TestSimulateRetriesFailed(t *testing.T) {
+	var fallback bool
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:       "test-simulate-failed",
+		RetryLimit: 4,
+		MinBackoff: time.Second,
+		MaxBackoff: 3 * time.Second,
+		Handler: func() error {
+			return errors.New("fake error")
+		},
+		FallbackHandler: func() {
+			fallback = true
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	sim := taskqtest.SimulateRetries(context.Background(), task)
+	sim.AssertFailed(t, 4)
+	sim.AssertDelays(t, time.Second, 2*time.Second, 3*time.Second)
+
+	if sim.Elapsed != 6*time.Second {
+		t.Fatalf("got elapsed %s, wanted 6s", sim.Elapsed)
+	}
+	if !fallback {
+		t.Fatal("FallbackHandler is not called")
+	}
+}
+
+func TestSimulateRetriesProcessed(t *testing.T) {
+	var attempt int
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:       "test-simulate-processed",
+		MinBackoff: time.Minute,
+		Handler: func(n int) error {
+			attempt++
+			if attempt < n {
+				return errors.New("fake error")
+			}
+			return nil
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	sim := taskqtest.SimulateRetries(context.Background(), task, 3)
+	sim.AssertProcessed(t)
+	sim.AssertDelays(t, time.Minute, 2*time.Minute)
+	if sim.Attempts != 3 {
+		t.Fatalf("got %d attempts, wanted 3", sim.Attempts)
+	}
+}
+
+func TestSimulateRetriesFallbackError(t *testing.T) {
+	fakeErr := errors.New("fake error")
+
+	var gotErr error
+	var gotAttempts int
+	task := taskq.RegisterTask(&taskq.TaskOptions{
+		Name:       "test-simulate-fallback-error",
+		RetryLimit: 3,
+		Handler: func() error {
+			return fakeErr
+		},
+		FallbackHandler: func(ctx context.Context, msg *taskq.Message, err error) {
+			gotErr = err
+			gotAttempts = msg.ReservedCount
+		},
+	})
+	defer taskq.Tasks.Unregister(task)
+
+	sim := taskqtest.SimulateRetries(context.Background(), task)
+	sim.AssertFailed(t, 3)
+
+	if gotErr != fakeErr {
+		t.Fatalf("got %v, wanted %v", gotErr, fakeErr)
+	}
+	if gotAttempts != 3 {
+		t.Fatalf("got %d attempts, wanted 3", gotAttempts)
+	}
+}
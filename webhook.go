@@ -0,0 +1,150 @@
+package taskq
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WebhookEventHeader is the message header that holds the event type
+// of messages added by the webhook handler.
+const WebhookEventHeader = "taskq-webhook-event"
+
+// WebhookOptions configures NewWebhookHandler.
+type WebhookOptions struct {
+	// Secrets used to verify the HMAC-SHA256 signature of the body.
+	// Any of them is accepted, so secrets can be rotated. Required.
+	Secrets [][]byte
+	// Header with the hex-encoded signature optionally prefixed with "sha256=".
+	// Default is "X-Signature-256".
+	SignatureHeader string
+	// Header with the event type that is mapped to a task by Tasks.
+	// Default is "X-Event-Type".
+	EventHeader string
+	// Tasks that handle the events. The "*" task handles events
+	// missing in the map. Tasks receive the body as []byte.
+	Tasks map[string]*Task
+	// Optional header with a delivery id that is used as the message name,
+	// so redelivered webhooks are added only once.
+	DeliveryIDHeader string
+	// Maximum size of the body. Default is 1MB.
+	MaxBodySize int64
+}
+
+func (opt *WebhookOptions) init() {
+	if opt.SignatureHeader == "" {
+		opt.SignatureHeader = "X-Signature-256"
+	}
+	if opt.EventHeader == "" {
+		opt.EventHeader = "X-Event-Type"
+	}
+	if opt.MaxBodySize == 0 {
+		opt.MaxBodySize = 1 << 20
+	}
+}
+
+func (opt *WebhookOptions) task(event string) *Task {
+	if task, ok := opt.Tasks[event]; ok {
+		return task
+	}
+	return opt.Tasks["*"]
+}
+
+// NewWebhookHandler returns a handler that accepts signed POST requests
+// and adds their bodies to the queue as messages of the task mapped from
+// the event type, so external systems can add messages without a Go client.
+// It responds with 202 status code when the message is added.
+func NewWebhookHandler(q Queue, opt *WebhookOptions) http.Handler {
+	if len(opt.Secrets) == 0 {
+		panic("taskq: WebhookOptions.Secrets is required")
+	}
+	opt.init()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, opt.MaxBodySize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > opt.MaxBodySize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge),
+				http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if !opt.verify(req.Header.Get(opt.SignatureHeader), body) {
+			http.Error(w, "taskq: invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		event := req.Header.Get(opt.EventHeader)
+		task := opt.task(event)
+		if task == nil {
+			http.Error(w, fmt.Sprintf("taskq: unknown event=%q", event), http.StatusBadRequest)
+			return
+		}
+
+		// The request context is canceled when the response is sent.
+		msg := task.WithArgs(context.Background(), body)
+		msg.Headers = map[string]string{WebhookEventHeader: event}
+		if opt.DeliveryIDHeader != "" {
+			msg.Name = req.Header.Get(opt.DeliveryIDHeader)
+		}
+
+		if err := q.Add(msg); err != nil {
+			writeAddError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func (opt *WebhookOptions) verify(signature string, body []byte) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	for _, secret := range opt.Secrets {
+		mac := hmac.New(sha256.New, secret)
+		_, _ = mac.Write(body)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeAddError(w http.ResponseWriter, err error) {
+	var quotaErr *QuotaExceededError
+	switch {
+	case errors.As(err, &quotaErr):
+		if quotaErr.RetryAfter > 0 {
+			secs := int(math.Ceil(quotaErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+		}
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrPolicyViolation):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrQueueFull):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
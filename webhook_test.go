@@ -0,0 +1,102 @@
+package taskq_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/taskq/v3"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	factory := newTestFactory(t)
+
+	bodies := make(chan string, 10)
+	task := factory.RegisterTask(&taskq.TaskOptions{
+		Name: "webhook",
+		Handler: func(ctx context.Context, body []byte) error {
+			if ctx.Err() != nil {
+				t.Errorf("got canceled context: %s", ctx.Err())
+			}
+			bodies <- string(body)
+			return nil
+		},
+	})
+	q := factory.RegisterQueue(&taskq.QueueOptions{
+		Name: "webhook-queue",
+	})
+
+	secret := []byte("secret")
+	h := taskq.NewWebhookHandler(q, &taskq.WebhookOptions{
+		Secrets:          [][]byte{[]byte("old"), secret},
+		Tasks:            map[string]*taskq.Task{"push": task},
+		DeliveryIDHeader: "X-Delivery-ID",
+		MaxBodySize:      64,
+	})
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	post := func(event, signature, id, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-Event-Type", event)
+		req.Header.Set("X-Signature-256", signature)
+		req.Header.Set("X-Delivery-ID", id)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name      string
+		event     string
+		signature string
+		body      string
+		wanted    int
+	}{
+		{"bad signature", "push", sign("other"), "payload", http.StatusUnauthorized},
+		{"no signature", "push", "", "payload", http.StatusUnauthorized},
+		{"unknown event", "pull", sign("payload"), "payload", http.StatusBadRequest},
+		{"too large", "push", sign(strings.Repeat("x", 65)), strings.Repeat("x", 65),
+			http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		if code := post(test.event, test.signature, "", test.body); code != test.wanted {
+			t.Fatalf("%s: got %d, wanted %d", test.name, code, test.wanted)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := post("push", sign("payload"), "delivery-1", "payload"); code != http.StatusAccepted {
+			t.Fatalf("got %d, wanted %d", code, http.StatusAccepted)
+		}
+	}
+
+	select {
+	case body := <-bodies:
+		if body != "payload" {
+			t.Fatalf("got %q, wanted %q", body, "payload")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not processed")
+	}
+	select {
+	case body := <-bodies:
+		t.Fatalf("redelivered webhook is processed twice: %q", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got %d, wanted %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}